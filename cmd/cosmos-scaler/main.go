@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/vippsas/go-cosmosdb/cmd/internal/cli"
+	"github.com/vippsas/go-cosmosdb/cosmosscaler"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"time"
+)
+
+var options struct {
+	connection cli.Connection
+
+	config         string
+	interval       time.Duration
+	once           bool
+	dryRun         bool
+	audit          string
+	scaleDownDelay time.Duration
+	timeZone       string
+}
+
+// config is the content of the -config file
+type config struct {
+	Targets []cosmosscaler.Target `json:"targets"`
+}
+
+// This tool changes the throughput of collections and databases following schedules, like
+// raising it during office hours. Every decision changing a throughput is written to the audit
+// log as a line of JSON.
+func main() {
+	os.Exit(run())
+}
+
+// run scales the targets of the config, and returns the exit code
+func run() int {
+	options.connection.AddFlags(flag.CommandLine)
+	flag.StringVar(&options.config, "config", "", `JSON file with the targets to scale, like {"targets": [{"databaseId": "db", "collectionId": "coll", "min": 400, "max": 4000, "schedule": [{"cron": "0 7 * * 1-5", "throughput": 4000}, {"cron": "0 18 * * *", "throughput": 400}]}]}`)
+	flag.DurationVar(&options.interval, "interval", 5*time.Minute, "How often to scale the targets")
+	flag.BoolVar(&options.once, "once", false, "Scale the targets once and exit, like when run by cron")
+	flag.BoolVar(&options.dryRun, "dryRun", false, "Only log the changes, without making them")
+	flag.StringVar(&options.audit, "audit", "", "File to append the audit log to, instead of Stdout")
+	flag.DurationVar(&options.scaleDownDelay, "scaleDownDelay", 0, "How long after changing the throughput of a target it is not decreased")
+	flag.StringVar(&options.timeZone, "timeZone", "UTC", "Time zone of the schedules, like Europe/Oslo")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), "Targets can only be scaled by schedule; scaling by usage needs the cosmosscaler package in the process of the clients.")
+		fmt.Fprintf(flag.CommandLine.Output(), "Exit codes: %d invalid parameters or config, %d authorization failed, %d scaling failed\n",
+			cli.ExitInvalid, cli.ExitAuth, cli.ExitFailed)
+	}
+
+	flag.Parse()
+
+	if err := validateParameters(); err != nil {
+		fmt.Println(err)
+		return cli.ExitInvalid
+	}
+	location, err := time.LoadLocation(options.timeZone)
+	if err != nil {
+		fmt.Printf("Invalid time zone '%s' -> %s\n", options.timeZone, err)
+		return cli.ExitInvalid
+	}
+	cfg, err := readConfig(options.config)
+	if err != nil {
+		fmt.Println(err)
+		return cli.ExitInvalid
+	}
+
+	credentials, err := options.connection.Credentials(os.LookupEnv)
+	if err != nil {
+		fmt.Println(err)
+		return cli.ExitAuth
+	}
+
+	var audit io.Writer = os.Stdout
+	if options.audit != "" {
+		f, openErr := os.OpenFile(options.audit, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if openErr != nil {
+			fmt.Printf("Could not open audit log -> %s\n", openErr)
+			return cli.ExitInvalid
+		}
+		defer f.Close()
+		audit = f
+	}
+
+	client, err := options.connection.NewClient(credentials, nil)
+	if err != nil {
+		fmt.Println(err)
+		return cli.ExitInvalid
+	}
+	scaler, err := cosmosscaler.New(client, cosmosscaler.Options{
+		Targets:        cfg.Targets,
+		DryRun:         options.dryRun,
+		ScaleDownDelay: options.scaleDownDelay,
+		Location:       location,
+		Audit:          audit,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return cli.ExitInvalid
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	if options.once {
+		var decisions []cosmosscaler.Decision
+		decisions, err = scaler.Scale(ctx, time.Now())
+		if err != nil {
+			fmt.Println(err)
+			return cli.FailureExitCode(err)
+		}
+		for _, decision := range decisions {
+			if decision.Action == cosmosscaler.ActionFailed {
+				return cli.ExitFailed
+			}
+		}
+		return 0
+	}
+	if err = scaler.Run(ctx, options.interval); err != nil && err != context.Canceled {
+		fmt.Println(err)
+		return cli.FailureExitCode(err)
+	}
+	return 0
+}
+
+func validateParameters() error {
+	if options.config == "" {
+		return fmt.Errorf("Missing parameters. Use -h to see usage")
+	}
+	if err := options.connection.Validate(); err != nil {
+		return err
+	}
+	if !options.once && options.interval <= 0 {
+		return fmt.Errorf("-interval must be positive")
+	}
+	return nil
+}
+
+func readConfig(path string) (config, error) {
+	var cfg config
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("Could not read config -> %s", err)
+	}
+	if err := json.Unmarshal(content, &cfg); err != nil {
+		return cfg, fmt.Errorf("Invalid config '%s' -> %s", path, err)
+	}
+	if len(cfg.Targets) == 0 {
+		return cfg, fmt.Errorf("Config '%s' has no targets", path)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/vippsas/go-cosmosdb/cmd/internal/cli"
+	"github.com/vippsas/go-cosmosdb/cosmosapply"
+	"log"
+	"os"
+	"strings"
+)
+
+// runExport writes the definitions of the databases of an account to files
+// that can be applied with cosmosdb-apply, and returns the exit code
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	options.connection.AddFlags(flags)
+	flags.StringVar(&options.outputDir, "outputDir", ".", "Directory to write the definition files and JavaScript sources to")
+	flags.StringVar(&options.databases, "databases", "", "Comma-separated list of databases to export. All databases if empty.")
+	flags.BoolVar(&options.verbose, "verbose", false, "Enable to get log statements sent to Stdout")
+	flags.Parse(args)
+
+	if options.verbose == true {
+		log.SetOutput(os.Stdout)
+	}
+	if err := options.connection.Validate(); err != nil {
+		fmt.Println(err)
+		return cli.ExitInvalid
+	}
+
+	credentials, err := options.connection.Credentials(os.LookupEnv)
+	if err != nil {
+		fmt.Println(err)
+		return cli.ExitAuth
+	}
+	client, err := newClient(credentials)
+	if err != nil {
+		fmt.Println(err)
+		return cli.ExitInvalid
+	}
+
+	var dbNames []string
+	if options.databases != "" {
+		dbNames = strings.Split(options.databases, ",")
+	}
+	written, err := cosmosapply.Export(context.Background(), client, options.outputDir, dbNames)
+	for _, path := range written {
+		fmt.Printf("Wrote '%s'\n", path)
+	}
+	if err != nil {
+		fmt.Printf("Export failed: %s\n", err)
+		return cli.FailureExitCode(err)
+	}
+	return 0
+}
@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/vippsas/go-cosmosdb/cmd/internal/cli"
 	"github.com/vippsas/go-cosmosdb/cosmosapi"
+	"github.com/vippsas/go-cosmosdb/cosmosapply"
 	"io"
 	"io/ioutil"
 	"log"
@@ -16,22 +18,54 @@ import (
 	"strings"
 )
 
+// Exit codes, besides those of cli: cli.ExitInvalid for invalid parameters or
+// definitions, and cli.ExitFailed for planning or applying failed
 const (
-	CosmosDbKeyEnvVarName = "COSMOSDB_KEY"
+	exitChanges = 2 // -plan found changes
 )
 
 var options struct {
-	instanceName string
-	filePaths    string
+	connection cli.Connection
+	filePaths  string
 
-	verbose bool
+	verbose          bool
+	plan             bool
+	prune            bool
+	pruneCollections bool
+	protect          string
+	failFast         bool
+
+	// export
+	outputDir string
+	databases string
 }
 
-// This tools allows the user to imperatively set up and configure collections in a pre-existing database
+// This tools allows the user to imperatively set up and configure collections in a pre-existing database.
+// With the export subcommand, it writes the definitions of the collections of an existing account instead.
+// The account is given by -instanceName, or by -endpoint for the emulator and other clouds.
 func main() {
-	flag.StringVar(&options.instanceName, "instanceName", "", "Name of the CosmosDB account/instance")
-	flag.StringVar(&options.filePaths, "filePaths", "", "Comma-separated list of files to import. Supports globbing.")
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:]))
+	}
+	os.Exit(run())
+}
+
+// run applies the definition files, and returns the exit code
+func run() int {
+	options.connection.AddFlags(flag.CommandLine)
+	flag.StringVar(&options.filePaths, "filePaths", "", "Comma-separated list of definition files to import, in JSON or YAML (.yaml, .yml), with ${NAME} in values replaced by environment variables. Supports globbing.")
 	flag.BoolVar(&options.verbose, "verbose", false, "Enable to get log statements sent to Stdout")
+	flag.BoolVar(&options.plan, "plan", false, "Only print the changes needed to apply the definitions, and exit with code 2 if there are any")
+	flag.BoolVar(&options.prune, "prune", false, "Delete triggers, stored procedures and user defined functions of the collections that are not in the definitions")
+	flag.BoolVar(&options.pruneCollections, "pruneCollections", false, "With -prune, also delete the collections of the databases that are not in the definitions")
+	flag.StringVar(&options.protect, "protect", "", "Comma-separated list of resources that -prune never deletes, like db/collection or db/collection/trigger. Supports globbing.")
+	flag.BoolVar(&options.failFast, "fail-fast", false, "Stop at the first failure instead of continuing with the changes that do not depend on it")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s [export]:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "Exit codes: %d invalid parameters or definitions, %d changes found with -plan, %d authorization failed, %d planning or applying failed\n",
+			cli.ExitInvalid, exitChanges, cli.ExitAuth, cli.ExitFailed)
+	}
 
 	flag.Parse()
 
@@ -39,80 +73,118 @@ func main() {
 		log.SetOutput(os.Stdout)
 	}
 
-	validateParameters()
+	if err := validateParameters(); err != nil {
+		fmt.Println(err)
+		return cli.ExitInvalid
+	}
 
 	// Parse file paths
-	paths := getPaths(options.filePaths)
+	paths, err := getPaths(options.filePaths)
+	if err != nil {
+		fmt.Println(err)
+		return cli.ExitInvalid
+	}
 	fmt.Printf("The following %d definition file(s) will be processed: %s\n", len(paths), paths)
 
-	masterKey := getCosmosDbMasterKey()
+	credentials, err := options.connection.Credentials(os.LookupEnv)
+	if err != nil {
+		fmt.Println(err)
+		return cli.ExitAuth
+	}
 
 	// --- Input is validated and we're ready to to more expensive tasks
 
 	// Parse all definition files
-	collectionDefinitions := getCollectionDefinitions(paths...)
-
-	client := newCosmosDbClient(masterKey)
+	collectionDefinitions, err := cosmosapply.LoadDefinitions(paths...)
+	if err != nil {
+		fmt.Printf("Invalid definitions:\n%s\n", err)
+		return cli.ExitInvalid
+	}
 
-	for i, def := range collectionDefinitions {
-		fmt.Printf("[%d/%d] Processing collection definition '%s'\n", i+1, len(collectionDefinitions), def.CollectionID)
-		handleCollectionDefinition(def, client)
-		fmt.Printf("[%d/%d] Finished processing collection definition '%s'\n", i+1, len(collectionDefinitions), def.CollectionID)
+	client, err := newClient(credentials)
+	if err != nil {
+		fmt.Println(err)
+		return cli.ExitInvalid
 	}
-}
+	ctx := context.Background()
 
-// --- General helper functions
+	prune := cosmosapply.PruneOptions{Enabled: options.prune, Collections: options.pruneCollections}
+	if options.protect != "" {
+		prune.Protected = strings.Split(options.protect, ",")
+	}
+	plans, err := cosmosapply.Plan(ctx, client, collectionDefinitions, prune)
+	if err != nil {
+		fmt.Printf("Planning failed: %s\n", err)
+		return cli.FailureExitCode(err)
+	}
+	changes := cosmosapply.PrintPlan(os.Stdout, plans)
+	invalid := false
+	for _, plan := range plans {
+		if err := plan.Err(); err != nil {
+			fmt.Println(err)
+			invalid = true
+		}
+	}
+	if invalid {
+		return cli.ExitFailed
+	}
+	if options.plan {
+		if changes {
+			return exitChanges
+		}
+		return 0
+	}
 
-// Panic with format
-func panicf(f string, a ...interface{}) {
-	panic(fmt.Sprintf(f, a...))
+	summary := cosmosapply.Apply(ctx, client, plans, cosmosapply.ApplyOptions{FailFast: options.failFast, Progress: os.Stdout})
+	fmt.Println()
+	cosmosapply.PrintSummary(os.Stdout, summary)
+	code := 0
+	for _, err := range summary.Errors() {
+		if code = cli.FailureExitCode(err); code == cli.ExitAuth {
+			break
+		}
+	}
+	return code
 }
 
-// Panic with error and format
-func panicef(f string, e error, a ...interface{}) {
-	panic(fmt.Sprintf(f+" -> "+e.Error(), a))
+// newClient returns a client of the account of the connection flags, logging
+// the requests with -verbose
+func newClient(credentials cosmosapi.Config) (*cosmosapi.Client, error) {
+	return options.connection.NewClient(credentials, func(rt http.RoundTripper) http.RoundTripper {
+		return logRoundTrip(rt)
+	})
 }
 
-func getCosmosDbMasterKey() string {
-	// Get key from env. vars.
-	masterKey, dbKeySet := os.LookupEnv(CosmosDbKeyEnvVarName)
+// --- General helper functions
 
-	if !dbKeySet {
-		panicf("Environment var. '%s' is not set", CosmosDbKeyEnvVarName)
+func validateParameters() error {
+	if options.filePaths == "" {
+		return fmt.Errorf("Missing parameters. Use -h to see usage")
 	}
-
-	return masterKey
-}
-
-// Will exit with code 1 if it doesn't validate
-func validateParameters() {
-	if options.instanceName == "" || options.filePaths == "" {
-		fmt.Println("Missing parameters. Use -h to see usage")
-		os.Exit(1)
+	if err := options.connection.Validate(); err != nil {
+		return err
+	}
+	if options.pruneCollections && !options.prune {
+		return fmt.Errorf("-pruneCollections requires -prune")
 	}
+	return nil
 }
 
 // Param 'filePaths' is a comma-separated string
-func getPaths(filePaths string) []string {
+func getPaths(filePaths string) ([]string, error) {
 	var paths []string
 	for _, path := range strings.Split(filePaths, ",") {
 		foundPaths, err := filepath.Glob(path)
 		if err != nil {
-			panicef("Error parsing file paths", err)
+			return nil, fmt.Errorf("Error parsing file path '%s' -> %s", path, err)
+		}
+		if len(foundPaths) == 0 {
+			return nil, fmt.Errorf("No definition files match '%s'", path)
 		}
 
 		paths = append(paths, foundPaths...)
 	}
-	return paths
-}
-
-func newCosmosDbClient(masterKey string) *cosmosapi.Client {
-	// Create CosmosDB client
-	cosmosCfg := cosmosapi.Config{
-		MasterKey: masterKey,
-	}
-	client := cosmosapi.New(fmt.Sprintf("https://%s.documents.azure.com:443", options.instanceName), cosmosCfg, &http.Client{Transport: logRoundTrip(nil)}, nil)
-	return client
+	return paths, nil
 }
 
 func logRoundTrip(rt http.RoundTripper) RoundTripFunc {
@@ -168,286 +240,3 @@ type RoundTripFunc func(req *http.Request) (*http.Response, error)
 func (r RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
 	return r(req)
 }
-
-// --- Database related
-
-func ensureDatabaseExists(client *cosmosapi.Client, def collectionDefinition) {
-	_, err := client.GetDatabase(context.Background(), def.DatabaseID, nil)
-	if err != nil {
-		log.Printf("Could not get database. Assuming database does not exist.\n")
-		_, err = client.CreateDatabase(context.Background(), def.DatabaseID, nil)
-
-		if err != nil {
-			panicef("Could not create a new database named '%s'", err, def.DatabaseID)
-		}
-
-		log.Printf("Database '%s' was created.\n", def.DatabaseID)
-	} else {
-		log.Printf("Database '%s' exists.\n", def.DatabaseID)
-	}
-}
-
-// --- Collection related
-
-func getCollectionDefinitions(filePaths ...string) []collectionDefinition {
-	colDefs := make([]collectionDefinition, 0, len(filePaths))
-
-	for i := 0; i < len(filePaths); i++ {
-		path := filePaths[i]
-
-		// Read file from FS
-		content, fileErr := ioutil.ReadFile(path)
-		if fileErr != nil {
-			panicf("Could not read file '%s' -> %s\n", path, fileErr.Error())
-		}
-
-		// Unmarshal JSON to struct
-		var colDef []collectionDefinition
-		if err := json.Unmarshal(content, &colDef); err != nil {
-			panic(err)
-		}
-
-		// Set file path for all definitions. Used when looking up source.
-		for i := 0; i < len(colDef); i++ {
-			colDef[i].FilePath = filepath.Dir(path)
-		}
-
-		colDefs = append(colDefs, colDef...)
-	}
-
-	return colDefs
-}
-
-func handleCollectionDefinition(def collectionDefinition, client *cosmosapi.Client) {
-	// We need to check three cases.
-	// 1: Added. In definition and not among existing collections.
-	// 2: Updated. In both places, but need to be replaced.
-	// (3. Removed. Not in definition, but among existing collections.)
-
-	ensureDatabaseExists(client, def)
-	if def.Count > 1 {
-		collectionIdBase := def.CollectionID
-		for i := 1; i <= def.Count; i++ {
-			def.CollectionID = fmt.Sprintf("%s-%d", collectionIdBase, i)
-			fmt.Println("Create or replace collection ", def.CollectionID)
-			createOrReplaceCollection(def, client)
-		}
-	}
-	createOrReplaceCollection(def, client)
-}
-
-func createOrReplaceCollection(def collectionDefinition, client *cosmosapi.Client) {
-	dbCol, colFound := getCollection(client, def)
-
-	if !colFound {
-		// Collection does not exist
-		// NOTE: Offers are created as a part of the collection
-		createCollection(def, client)
-	} else {
-		// Collection exists
-		replaceCollection(def, dbCol, client)
-		replaceOffers(def, dbCol, client)
-	}
-
-	// Check triggers
-
-	collectionTriggers, ltErr := client.ListTriggers(context.Background(), def.DatabaseID, def.CollectionID)
-	if ltErr != nil {
-		panicef("Could not list triggers for collection '%s' in DB '%s'", ltErr, def.CollectionID, def.DatabaseID)
-	}
-
-	for _, trigDef := range def.Triggers {
-		_, trigFound := triggerExists(collectionTriggers.Triggers, trigDef.ID)
-
-		if !trigFound {
-			createTrigger(trigDef, client, def)
-		} else {
-			replaceTrigger(trigDef, client, def)
-		}
-	}
-
-	// TODO: Do the same for UDF as for trigger
-	// TODO: Do the same for SPROC as for trigger
-}
-
-func getCollection(client *cosmosapi.Client, def collectionDefinition) (*cosmosapi.Collection, bool) {
-	dbName := def.DatabaseID
-	colName := def.CollectionID
-
-	dbCollection, err := client.GetCollection(context.Background(), dbName, colName)
-
-	if err != nil {
-		log.Printf("Could not get collection '%s' in database '%s' -> %s", colName, dbName, err.Error())
-		return nil, false
-	}
-
-	return dbCollection, true
-}
-
-func createCollection(def collectionDefinition, client *cosmosapi.Client) {
-	colCreateOpts := cosmosapi.CreateCollectionOptions{
-		Id:                def.CollectionID,
-		IndexingPolicy:    def.IndexingPolicy,
-		PartitionKey:      def.PartitionKey,
-		DefaultTimeToLive: def.DefaultTimeToLive,
-		OfferType:         cosmosapi.OfferType(def.Offer.Type),
-		OfferThroughput:   cosmosapi.OfferThroughput(def.Offer.Throughput),
-	}
-
-	_, err := client.CreateCollection(context.Background(), def.DatabaseID, colCreateOpts)
-	if err != nil {
-		panicef("Create collection '%s' failed", err, colCreateOpts.Id)
-	}
-
-	log.Printf("Collection created\n")
-}
-
-func replaceCollection(def collectionDefinition, existingCol *cosmosapi.Collection, client *cosmosapi.Client) {
-	colReplaceOpts := cosmosapi.CollectionReplaceOptions{
-		Id:                def.CollectionID,
-		IndexingPolicy:    def.IndexingPolicy,
-		PartitionKey:      existingCol.PartitionKey,
-		DefaultTimeToLive: def.DefaultTimeToLive,
-	}
-
-	updatedCol, err := client.ReplaceCollection(context.Background(), def.DatabaseID, colReplaceOpts)
-	if err != nil {
-		panicef("Could not replace collection '%s'", err, def.CollectionID)
-	}
-
-	log.Printf("Sucsefully updated collection '%s'\n", updatedCol.Id)
-}
-
-// --- Triggers related
-
-func triggerExists(triggers []cosmosapi.Trigger, triggerName string) (*cosmosapi.Trigger, bool) {
-	for _, c := range triggers {
-		if c.Id == triggerName {
-			return &c, true
-		}
-	}
-
-	return nil, false
-}
-
-func replaceTrigger(trigDef trigger, client *cosmosapi.Client, def collectionDefinition) {
-	opts := cosmosapi.TriggerReplaceOptions{
-		Id:        trigDef.ID,
-		Type:      cosmosapi.TriggerType(trigDef.TriggerType),
-		Operation: cosmosapi.TriggerOperation(trigDef.TriggerOperation),
-		Body:      getJavaScriptBody(trigDef.Body, def.FilePath),
-	}
-
-	_, trigErr := client.ReplaceTrigger(context.Background(), def.DatabaseID, def.CollectionID, opts)
-	if trigErr != nil {
-		panicef("Updating trigger '%s' on collection '%s' failed", trigErr, trigDef.ID, def.CollectionID)
-
-	}
-
-	log.Printf("Trigger '%s' was updated\n", trigDef.ID)
-}
-
-func createTrigger(trigDef trigger, client *cosmosapi.Client, def collectionDefinition) {
-	opts := cosmosapi.TriggerCreateOptions{
-		Id:        trigDef.ID,
-		Type:      cosmosapi.TriggerType(trigDef.TriggerType),
-		Operation: cosmosapi.TriggerOperation(trigDef.TriggerOperation),
-		Body:      getJavaScriptBody(trigDef.Body, def.FilePath),
-	}
-
-	_, trigErr := client.CreateTrigger(context.Background(), def.DatabaseID, def.CollectionID, opts)
-
-	if trigErr != nil {
-		panicef("Creating trigger '%s' on collection '%s' failed", trigErr, trigDef.ID, def.CollectionID)
-	}
-
-	log.Printf("Trigger '%s' was created\n", trigDef.ID)
-}
-
-func getJavaScriptBody(body triggerBody, directory string) string {
-	switch body.SourceLocation {
-
-	case "inline":
-		return body.InlineSource
-
-	case "file":
-
-		absFilePath, _ := filepath.Abs(directory)
-		filePath := filepath.Join(absFilePath, body.FileName)
-		source, err := ioutil.ReadFile(filePath)
-
-		if err != nil {
-			panicef("Could not read source file from '%s'", err, filePath)
-		}
-
-		return string(source)
-
-	default:
-		panicf("Unknown source location '%s' found in trigger definition", body.SourceLocation)
-		return ""
-	}
-}
-
-// --- Offers related
-
-func replaceOffers(def collectionDefinition, dbCol *cosmosapi.Collection, client *cosmosapi.Client) {
-	dbOffers, err := client.ListOffers(context.Background(), nil)
-	if err != nil {
-		panicef("Could not list offers in DB", err)
-	}
-	for _, off := range dbOffers.Offers {
-		if off.OfferResourceId == dbCol.Rid {
-			// offer applies to this resource
-
-			offReplOpts := cosmosapi.OfferReplaceOptions{
-				Rid:              off.Rid,
-				OfferResourceId:  off.OfferResourceId,
-				Id:               off.Id,
-				OfferVersion:     off.OfferVersion,
-				ResourceSelfLink: off.Self,
-				OfferType:        cosmosapi.OfferType(def.Offer.Type),
-				Content: cosmosapi.OfferThroughputContent{
-					Throughput: cosmosapi.OfferThroughput(def.Offer.Throughput),
-				},
-			}
-			_, err := client.ReplaceOffer(context.Background(), offReplOpts, nil)
-			if err != nil {
-				panicef("Could not update offer '%s'", err, off.Id)
-			}
-
-			fmt.Printf("Updated offer '%s'. Throughput=%d, Type=%v", off.Id, def.Offer.Throughput, def.Offer.Type)
-		}
-	}
-}
-
-// --- Inline types used to deserialize the input
-
-type collectionDefinition struct {
-	FilePath          string
-	DatabaseID        string `json:"databaseId"`
-	Count             int    `json:"count"`
-	CollectionID      string `json:"collectionId"`
-	DefaultTimeToLive int    `json:"defaultTtl"`
-	Offer             struct {
-		Throughput int    `json:"throughput"`
-		Type       string `json:"type"`
-	} `json:"offer"`
-	IndexingPolicy *cosmosapi.IndexingPolicy `json:"indexingPolicy,omitempty"`
-	PartitionKey   *cosmosapi.PartitionKey   `json:"partitionKey,omitempty"`
-	Triggers       []trigger                 `json:"triggers"`
-	Udfs           []interface{}             `json:"udfs"`
-	Sprocs         []interface{}             `json:"sprocs"`
-}
-
-type trigger struct {
-	ID               string      `json:"id"`
-	TriggerType      string      `json:"triggerType"`
-	TriggerOperation string      `json:"triggerOperation"`
-	Body             triggerBody `json:"body"`
-}
-
-type triggerBody struct {
-	SourceLocation string `json:"sourceLocation"`
-	InlineSource   string `json:"inlineSource,omitempty"`
-	FileName       string `json:"fileName,omitempty"`
-}
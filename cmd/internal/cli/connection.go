@@ -0,0 +1,144 @@
+// Package cli has what the commands have in common: the flags to connect to
+// an account with, and the exit codes.
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	CosmosDbKeyEnvVarName           = "COSMOSDB_KEY"
+	CosmosDbResourceTokenEnvVarName = "COSMOSDB_RESOURCE_TOKEN"
+)
+
+// Connection tells how to connect to an account, and the credentials to use
+type Connection struct {
+	instanceName string
+	endpoint     string
+
+	keyFile           string
+	resourceTokenFile string
+
+	// Like in cosmostest.Config, but tlsCertificate is the name of a file
+	tlsCertificate        string
+	tlsServerName         string
+	tlsInsecureSkipVerify bool
+}
+
+func (c *Connection) AddFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.instanceName, "instanceName", "", "Name of the CosmosDB account/instance")
+	flags.StringVar(&c.endpoint, "endpoint", "", "URL of the account instead of the one of -instanceName, like https://localhost:8081 for the emulator")
+	flags.StringVar(&c.keyFile, "keyFile", "", "File with the master key, instead of the environment var. "+CosmosDbKeyEnvVarName)
+	flags.StringVar(&c.resourceTokenFile, "resourceTokenFile", "", "File with a resource token to authorize with instead of a master key. Also read from the environment var. "+CosmosDbResourceTokenEnvVarName)
+	flags.StringVar(&c.tlsCertificate, "tlsCertificate", "", "File with the PEM encoded CA certificates to verify the server with, instead of the system ones")
+	flags.StringVar(&c.tlsServerName, "tlsServerName", "", "Server name to verify the certificate of the server with, if not the host of the endpoint")
+	flags.BoolVar(&c.tlsInsecureSkipVerify, "tlsInsecureSkipVerify", false, "Do not verify the certificate of the server")
+}
+
+func (c Connection) Validate() error {
+	if c.instanceName == "" && c.endpoint == "" {
+		return fmt.Errorf("Missing parameters. Either -instanceName or -endpoint is required. Use -h to see usage")
+	}
+	if c.instanceName != "" && c.endpoint != "" {
+		return fmt.Errorf("-instanceName and -endpoint can not be used together")
+	}
+	if c.keyFile != "" && c.resourceTokenFile != "" {
+		return fmt.Errorf("-keyFile and -resourceTokenFile can not be used together")
+	}
+	return nil
+}
+
+func (c Connection) URL() string {
+	if c.endpoint != "" {
+		return strings.TrimRight(c.endpoint, "/")
+	}
+	return fmt.Sprintf("https://%s.documents.azure.com:443", c.instanceName)
+}
+
+// Credentials returns the config with the master key or resource token from
+// the files of the flags, or else from the environment
+func (c Connection) Credentials(lookupEnv func(string) (string, bool)) (cosmosapi.Config, error) {
+	switch {
+	case c.keyFile != "":
+		key, err := readSecretFile(c.keyFile)
+		return cosmosapi.Config{MasterKey: key}, err
+	case c.resourceTokenFile != "":
+		token, err := readSecretFile(c.resourceTokenFile)
+		return cosmosapi.Config{ResourceToken: token}, err
+	}
+	if masterKey, ok := lookupEnv(CosmosDbKeyEnvVarName); ok {
+		return cosmosapi.Config{MasterKey: masterKey}, nil
+	}
+	if token, ok := lookupEnv(CosmosDbResourceTokenEnvVarName); ok {
+		return cosmosapi.Config{ResourceToken: token}, nil
+	}
+	return cosmosapi.Config{}, fmt.Errorf("Environment var. '%s' or '%s' is not set, and neither -keyFile nor -resourceTokenFile is given",
+		CosmosDbKeyEnvVarName, CosmosDbResourceTokenEnvVarName)
+}
+
+func readSecretFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Could not read '%s' -> %s", path, err)
+	}
+	secret := strings.TrimSpace(string(content))
+	if secret == "" {
+		return "", fmt.Errorf("'%s' is empty", path)
+	}
+	return secret, nil
+}
+
+// transport returns the transport with the TLS options of the connection
+func (c Connection) transport() (*http.Transport, error) {
+	var caRoots *x509.CertPool
+	if c.tlsCertificate != "" {
+		pem, err := ioutil.ReadFile(c.tlsCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read TLS certificate -> %s", err)
+		}
+		caRoots = x509.NewCertPool()
+		if !caRoots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("Failed to parse TLS certificate '%s'", c.tlsCertificate)
+		}
+	}
+	// Like http.DefaultTransport, which can not be cloned before Go 1.13
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig: &tls.Config{
+			RootCAs:            caRoots,
+			ServerName:         c.tlsServerName,
+			InsecureSkipVerify: c.tlsInsecureSkipVerify,
+		},
+	}, nil
+}
+
+// NewClient returns a client of the account, with the transport wrapped by
+// wrap if it is not nil
+func (c Connection) NewClient(cfg cosmosapi.Config, wrap func(http.RoundTripper) http.RoundTripper) (*cosmosapi.Client, error) {
+	transport, err := c.transport()
+	if err != nil {
+		return nil, err
+	}
+	var rt http.RoundTripper = transport
+	if wrap != nil {
+		rt = wrap(transport)
+	}
+	return cosmosapi.New(c.URL(), cfg, &http.Client{Transport: rt}, nil), nil
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"context"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConnectionCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cosmosdb-apply")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "key")
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("a2V5\n"), 0600))
+
+	env := map[string]string{CosmosDbResourceTokenEnvVarName: "type=resource&ver=1.0&sig=x"}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	cfg, err := Connection{keyFile: keyFile}.Credentials(lookupEnv)
+	require.NoError(t, err)
+	assert.Equal(t, cosmosapi.Config{MasterKey: "a2V5"}, cfg)
+
+	cfg, err = Connection{}.Credentials(lookupEnv)
+	require.NoError(t, err)
+	assert.Equal(t, cosmosapi.Config{ResourceToken: "type=resource&ver=1.0&sig=x"}, cfg)
+
+	env[CosmosDbKeyEnvVarName] = "a2V5"
+	cfg, err = Connection{}.Credentials(lookupEnv)
+	require.NoError(t, err)
+	assert.Equal(t, cosmosapi.Config{MasterKey: "a2V5"}, cfg, "master key first")
+
+	_, err = Connection{resourceTokenFile: filepath.Join(dir, "missing")}.Credentials(lookupEnv)
+	assert.Error(t, err)
+	_, err = Connection{}.Credentials(func(string) (string, bool) { return "", false })
+	assert.Error(t, err)
+
+	assert.Error(t, Connection{}.Validate())
+	assert.Error(t, Connection{instanceName: "a", endpoint: "https://localhost:8081"}.Validate())
+	assert.NoError(t, Connection{endpoint: "https://localhost:8081"}.Validate())
+	assert.Equal(t, "https://localhost:8081", Connection{endpoint: "https://localhost:8081/"}.URL())
+	assert.Equal(t, "https://a.documents.azure.com:443", Connection{instanceName: "a"}.URL())
+}
+
+func TestConnectionTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "type%3Dresource%26ver%3D1.0%26sig%3Dx", r.Header.Get(cosmosapi.HEADER_AUTH))
+		w.Write([]byte(`{"id": "db"}`))
+	}))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "cosmosdb-apply")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certificate := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(certificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0644))
+
+	cfg := cosmosapi.Config{ResourceToken: "type=resource&ver=1.0&sig=x"}
+	getDatabase := func(c Connection) error {
+		client, err := c.NewClient(cfg, nil)
+		require.NoError(t, err)
+		_, err = client.GetDatabase(context.Background(), "db", nil)
+		return err
+	}
+	assert.Error(t, getDatabase(Connection{endpoint: ts.URL}), "unknown certificate authority")
+	assert.NoError(t, getDatabase(Connection{endpoint: ts.URL, tlsCertificate: certificate}))
+	assert.NoError(t, getDatabase(Connection{endpoint: ts.URL, tlsCertificate: certificate, tlsServerName: "example.com"}))
+	assert.Error(t, getDatabase(Connection{endpoint: ts.URL, tlsCertificate: certificate, tlsServerName: "other.com"}))
+	assert.NoError(t, getDatabase(Connection{endpoint: ts.URL, tlsInsecureSkipVerify: true}))
+
+	_, err = Connection{endpoint: ts.URL, tlsCertificate: filepath.Join(dir, "missing.pem")}.NewClient(cfg, nil)
+	assert.Error(t, err)
+}
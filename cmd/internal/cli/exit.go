@@ -0,0 +1,20 @@
+package cli
+
+import (
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// Exit codes of the commands
+const (
+	ExitInvalid = 1 // invalid parameters or input
+	ExitAuth    = 3 // missing or rejected credentials
+	ExitFailed  = 4 // the operation failed
+)
+
+// FailureExitCode returns the exit code for an error from Cosmos
+func FailureExitCode(err error) int {
+	if cosmosapi.IsAuthError(err) {
+		return ExitAuth
+	}
+	return ExitFailed
+}
@@ -4,9 +4,13 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/vippsas/go-cosmosdb/cosmosapi"
+	"github.com/vippsas/go-cosmosdb/cosmosapi/querybuilder"
+	"github.com/vippsas/go-cosmosdb/tracing"
 )
 
 const (
@@ -15,11 +19,28 @@ const (
 )
 
 type Collection struct {
-	Client       Client
-	DbName       string
-	Name         string
+	// Client does the requests. The request charges of the methods that do not return a response, like StaleGet
+	// and RacingPut, are recorded by the Config.Metrics of a cosmosapi.Client.
+	Client Client
+	DbName string
+	Name   string
+	// PartitionKey is the path of the partition key, like "/customer/id", found in entities by following json
+	// names through nested and embedded structs. A plain json name like "userId" is a top-level field.
 	PartitionKey string
-	Context      context.Context
+	// PartitionKeyPaths are the paths of a hierarchical (MultiHash) partition key, e.g. "/tenant/id", "/userId".
+	// If set, PartitionKey is not used, and partition key values are cosmosapi.HierarchicalPartitionKey with one
+	// value per path.
+	PartitionKeyPaths []string
+	Context           context.Context
+	// Tracer, if set, gets a span for every Session.Transaction. Tracing of the
+	// individual Cosmos operations is configured on the cosmosapi.Client.
+	Tracer tracing.Tracer
+	// RateLimiter, if set, limits the request units spent by the requests done
+	// through the collection, in addition to any limiter on the client.
+	RateLimiter *cosmosapi.RateLimiter
+	// RoutingMaps caches the partition key ranges used by PartitionKeyRangeFor.
+	// Set by Init if nil; may be shared between collections.
+	RoutingMaps *cosmosapi.RoutingMapCache
 
 	sessionSlotIndex int
 }
@@ -32,6 +53,14 @@ func (c Collection) GetContext() context.Context {
 	}
 }
 
+// clientContext returns the context to pass to the client for requests done with ctx
+func (c Collection) clientContext(ctx context.Context) context.Context {
+	if c.RateLimiter != nil {
+		ctx = cosmosapi.WithRateLimiter(ctx, c.RateLimiter)
+	}
+	return ctx
+}
+
 func (c Collection) WithContext(ctx context.Context) Collection {
 	c.Context = ctx // note that c is not a pointer
 	return c
@@ -39,9 +68,13 @@ func (c Collection) WithContext(ctx context.Context) Collection {
 
 // Init the collection. Certain features requires this to be called on the collection, for backwards compatibility
 // many features can be used without initializing.
-// Currently only required if you want to store session state on the context (Collection.SessionContext())
+// Required if you want to store session state on the context (Collection.SessionContext()), and to cache
+// the partition key ranges used by PartitionKeyRangeFor.
 func (c Collection) Init() Collection {
 	initForContextSessions(&c)
+	if c.RoutingMaps == nil {
+		c.RoutingMaps = cosmosapi.NewRoutingMapCache(c.Client)
+	}
 	return c
 }
 
@@ -52,26 +85,26 @@ func (c Collection) get(ctx context.Context, partitionValue interface{}, id stri
 		c.initializeEmptyDoc(partitionValue, id, target)
 	}
 	if err == nil {
-		res, partitionValueField := c.getEntityInfo(target)
+		res, partitionKey := c.getEntityInfo(target)
 		if res.Id != id {
 			return docResp, errors.Errorf(fmtUnexpectedIdError, id, res.Id)
 		}
-		if partitionValueField.Interface() != partitionValue {
-			return docResp, errors.Errorf(fmtUnexpectedPartitionKeyValueError, partitionValue, partitionValueField.Interface())
+		if !partitionKey.matches(partitionValue) {
+			return docResp, errors.Errorf(fmtUnexpectedPartitionKeyValueError, partitionValue, partitionKey.value())
 		}
 	}
 	return docResp, err
 }
 
 func (c Collection) initializeEmptyDoc(partitionValue interface{}, id string, target Model) {
-	res, partitionValueField := c.getEntityInfo(target)
+	res, partitionKey := c.getEntityInfo(target)
 	// To be bullet-proof, make sure to zero out the target. It could e.g. be used for other purposes in a loop,
 	// it is nice to be able to rely on zeroing out on not-found
 	val := reflect.ValueOf(target).Elem()
 	zero := reflect.Zero(val.Type())
 	val.Set(zero)
 	// Then write the ID information so that Put() will work after populating the entity
-	partitionValueField.Set(reflect.ValueOf(partitionValue))
+	partitionKey.set(partitionValue)
 	res.Id = id
 }
 
@@ -81,7 +114,7 @@ func (c Collection) getExisting(ctx context.Context, partitionValue interface{},
 		ConsistencyLevel:  consistency,
 		SessionToken:      sessionToken,
 	}
-	docResp, err := c.Client.GetDocument(ctx, c.DbName, c.Name, id, opts, target)
+	docResp, err := c.Client.GetDocument(c.clientContext(ctx), c.DbName, c.Name, id, opts, target)
 	if err != nil {
 		return docResp, errors.Wrap(err, fmt.Sprintf("id='%s' partitionValue='%s'", id, partitionValue))
 	}
@@ -113,46 +146,38 @@ func (c Collection) StaleGetExisting(partitionValue interface{}, id string, targ
 // GetEntityInfo uses reflection to return information about the entity
 // without each entity having to implement getters. One should pass a pointer
 // to a struct that embeds "BaseModel" as well as a field having the partition field
-// name; failure to do so will panic.
+// name; failure to do so will panic. For hierarchical partition keys there must be
+// a field for every path, and partitionValue is a cosmosapi.HierarchicalPartitionKey.
 //
 // Note: GetEntityInfo will also always assert that the Model property is set to the declared
 // value
 func (c Collection) GetEntityInfo(entityPtr Model) (res BaseModel, partitionValue interface{}) {
-	resPtr, partitionValueField := c.getEntityInfo(entityPtr)
-	return *resPtr, partitionValueField.Interface()
+	resPtr, partitionKey := c.getEntityInfo(entityPtr)
+	return *resPtr, partitionKey.value()
 }
 
-func (c Collection) getEntityInfo(entityPtr Model) (res *BaseModel, partitionValueField reflect.Value) {
-	if c.PartitionKey == "" {
-		panic(errors.Errorf("Please initialize PartitionKey in your Collection struct"))
+// partitionKeyPaths returns the paths of the partition key; PartitionKey may be a path or a plain field name
+func (c Collection) partitionKeyPaths() []string {
+	if len(c.PartitionKeyPaths) > 0 {
+		return c.PartitionKeyPaths
 	}
-	defer func() {
-		if e := recover(); e != nil {
-			panic(errors.Errorf("Need to pass in a pointer to a struct with fields named 'BaseModel' and a tag 'json:\"%s\"', got: %s", c.PartitionKey, fmt.Sprintf("%v", entityPtr)))
-		}
-	}()
+	return []string{c.PartitionKey}
+}
 
-	v := reflect.ValueOf(entityPtr).Elem()
-	structT := v.Type()
-	res = v.FieldByName("BaseModel").Addr().Interface().(*BaseModel)
-	n := structT.NumField()
-	found := false
-	if c.PartitionKey == "id" {
-		partitionValueField = reflect.ValueOf(res).Elem().FieldByName("Id")
-		found = true
-	} else {
-		for i := 0; i != n; i++ {
-			field := structT.Field(i)
-			if field.Tag.Get("json") == c.PartitionKey {
-				partitionValueField = v.Field(i)
-				found = true
-				break
-			}
-		}
+func (c Collection) getEntityInfo(entityPtr Model) (res *BaseModel, partitionKey partitionKeyFields) {
+	if c.PartitionKey == "" && len(c.PartitionKeyPaths) == 0 {
+		panic(errors.Errorf("Please initialize PartitionKey in your Collection struct"))
+	}
+	if len(c.PartitionKeyPaths) > cosmosapi.MaxHierarchicalPartitionKeyPaths {
+		panic(errors.Errorf("A hierarchical partition key can have at most %d paths", cosmosapi.MaxHierarchicalPartitionKeyPaths))
 	}
-	if !found {
-		panic(errors.New(""))
+	plan, err := getEntityPlan(reflect.TypeOf(entityPtr), c.partitionKeyPaths())
+	if err != nil {
+		panic(errors.Wrapf(err, "Need to pass in a pointer to a struct with fields named 'BaseModel' and json tags for the partition key %s", strings.Join(c.partitionKeyPaths(), ", ")))
 	}
+	v := reflect.ValueOf(entityPtr).Elem()
+	res = v.FieldByIndex(plan.base).Addr().Interface().(*BaseModel)
+	partitionKey = partitionKeyFields{entity: v, fields: plan.partitionKey, hierarchical: len(c.PartitionKeyPaths) > 0}
 	return
 }
 
@@ -166,7 +191,7 @@ func (c Collection) put(ctx context.Context, entityPtr Model, base BaseModel, pa
 			PartitionKeyValue: partitionValue,
 			IsUpsert:          !consistent,
 		}
-		resource, response, err = c.Client.CreateDocument(ctx, c.DbName, c.Name, entityPtr, opts)
+		resource, response, err = c.Client.CreateDocument(c.clientContext(ctx), c.DbName, c.Name, entityPtr, opts)
 		if consistent && errors.Cause(err) == cosmosapi.ErrConflict {
 			// For consistent creation with Etag="" we translate ErrConflict on creation to ErrPreconditionFailed
 			err = errors.WithStack(cosmosapi.ErrPreconditionFailed)
@@ -176,7 +201,7 @@ func (c Collection) put(ctx context.Context, entityPtr Model, base BaseModel, pa
 			PartitionKeyValue: partitionValue,
 			IfMatch:           base.Etag,
 		}
-		resource, response, err = c.Client.ReplaceDocument(ctx, c.DbName, c.Name, base.Id, entityPtr, opts)
+		resource, response, err = c.Client.ReplaceDocument(c.clientContext(ctx), c.DbName, c.Name, base.Id, entityPtr, opts)
 	}
 	err = errors.WithStack(err)
 	return
@@ -195,15 +220,211 @@ func (c Collection) RacingPut(entityPtr Model) error {
 	return err
 }
 
+// Query runs a query without parameters or partition key, returning the first page of results as is. See
+// QueryWithOptions for more control.
 func (c Collection) Query(query string, entities interface{}) (cosmosapi.QueryDocumentsResponse, error) {
-	return c.Client.QueryDocuments(c.Context, c.DbName, c.Name, cosmosapi.Query{Query: query}, entities, cosmosapi.DefaultQueryDocumentOptions())
+	return c.Client.QueryDocuments(c.clientContext(c.GetContext()), c.DbName, c.Name, cosmosapi.Query{Query: query}, entities, cosmosapi.DefaultQueryDocumentOptions())
+}
+
+// QueryOptions are the options of QueryWithOptions.
+type QueryOptions struct {
+	// PartitionValue, if set, runs the query in that partition only. Otherwise it is a cross partition query.
+	PartitionValue interface{}
+	// MaxItemCount is the maximum number of results returned in one page; 0 means the default of Cosmos.
+	MaxItemCount int
+	// Continuation, from the response of the previous page, gets the next page.
+	Continuation string
+}
+
+// QueryWithOptions runs a parameterized query and returns a page of the results in entities, which must be a
+// pointer to a slice of entities (or pointers to entities). The PostGet hook is called on every result that is a
+// Model, with txn==nil. If response.Continuation is not empty there are more results, which are fetched by passing
+// it in opts.Continuation.
+func (c Collection) QueryWithOptions(query cosmosapi.Query, opts QueryOptions, entities interface{}) (cosmosapi.QueryDocumentsResponse, error) {
+	return c.query(c.GetContext(), query, opts, entities, "", "")
+}
+
+func (c Collection) query(ctx context.Context, query cosmosapi.Query, opts QueryOptions, entities interface{}, consistency cosmosapi.ConsistencyLevel, sessionToken string) (cosmosapi.QueryDocumentsResponse, error) {
+	ops := cosmosapi.DefaultQueryDocumentOptions()
+	ops.PartitionKeyValue = opts.PartitionValue
+	ops.EnableCrossPartition = opts.PartitionValue == nil
+	ops.MaxItemCount = opts.MaxItemCount
+	ops.Continuation = opts.Continuation
+	ops.ConsistencyLevel = consistency
+	ops.SessionToken = sessionToken
+	response, err := c.Client.QueryDocuments(c.clientContext(ctx), c.DbName, c.Name, query, entities, ops)
+	if err != nil {
+		return response, errors.WithMessage(err, "Query failed")
+	}
+	return response, postGetAll(entities)
+}
+
+// postGetAll calls the PostGet hook of every Model in the slice pointed to by entities
+func postGetAll(entities interface{}) error {
+	slice := reflect.ValueOf(entities)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return errors.Errorf("Need to pass in a pointer to a slice, got %T", entities)
+	}
+	slice = slice.Elem()
+	for i := 0; i != slice.Len(); i++ {
+		elem := slice.Index(i)
+		if elem.Kind() != reflect.Ptr {
+			elem = elem.Addr()
+		} else if elem.IsNil() {
+			continue
+		}
+		if entity, ok := elem.Interface().(Model); ok {
+			if err := postGet(entity, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReadMany reads the entities identified by items into entities, which must be a pointer to a slice of entities (or
+// pointers to entities). The slice gets one entity per item, in the same order. Like with StaleGet, entities that do
+// not exist are zero, except for their id and partition key, and response.Found tells which ones do. The PostGet hook
+// is called on every entity, with txn==nil.
+func (c Collection) ReadMany(items []cosmosapi.ReadManyItem, entities interface{}) (cosmosapi.ReadManyResponse, error) {
+	response, err := c.readMany(c.GetContext(), items, entities, "", "")
+	if err != nil {
+		return response, err
+	}
+	return response, postGetAll(entities)
+}
+
+func (c Collection) readMany(ctx context.Context, items []cosmosapi.ReadManyItem, entities interface{}, consistency cosmosapi.ConsistencyLevel, sessionToken string) (cosmosapi.ReadManyResponse, error) {
+	ops := cosmosapi.ReadManyOptions{
+		ConsistencyLevel: consistency,
+		SessionToken:     sessionToken,
+		RoutingMaps:      c.RoutingMaps,
+	}
+	var response cosmosapi.ReadManyResponse
+	var err error
+	if client, ok := c.Client.(ReadManyClient); ok {
+		response, err = client.ReadMany(c.clientContext(ctx), c.DbName, c.Name, items, entities, ops)
+	} else {
+		response, err = c.getEach(ctx, items, entities, ops)
+	}
+	if err != nil {
+		return response, err
+	}
+	slice := reflect.ValueOf(entities).Elem()
+	for i, item := range items {
+		if response.Found[i] {
+			continue
+		}
+		entity, err := modelAt(slice, i)
+		if err != nil {
+			return response, err
+		}
+		c.initializeEmptyDoc(item.PartitionKeyValue, item.Id, entity)
+	}
+	return response, nil
+}
+
+// getEach reads the entities identified by items one at a time, for clients that are not a ReadManyClient
+func (c Collection) getEach(ctx context.Context, items []cosmosapi.ReadManyItem, entities interface{}, ops cosmosapi.ReadManyOptions) (cosmosapi.ReadManyResponse, error) {
+	response := cosmosapi.ReadManyResponse{Found: make([]bool, len(items))}
+	ptr := reflect.ValueOf(entities)
+	if ptr.Kind() != reflect.Ptr || ptr.Elem().Kind() != reflect.Slice {
+		return response, errors.Errorf("Need to pass in a pointer to a slice, got %T", entities)
+	}
+	slice := reflect.MakeSlice(ptr.Elem().Type(), len(items), len(items))
+	ptr.Elem().Set(slice)
+	sessionTokens := map[string]bool{}
+	for i, item := range items {
+		entity, err := modelAt(slice, i)
+		if err != nil {
+			return response, err
+		}
+		opts := cosmosapi.GetDocumentOptions{
+			PartitionKeyValue: item.PartitionKeyValue,
+			ConsistencyLevel:  ops.ConsistencyLevel,
+			SessionToken:      ops.SessionToken,
+		}
+		docResp, err := c.Client.GetDocument(c.clientContext(ctx), c.DbName, c.Name, item.Id, opts, entity)
+		response.RequestCharge += docResp.RUs
+		if docResp.SessionToken != "" {
+			sessionTokens[docResp.SessionToken] = true
+		}
+		if errors.Cause(err) == cosmosapi.ErrNotFound {
+			continue
+		} else if err != nil {
+			return response, err
+		}
+		response.Found[i] = true
+	}
+	var tokens []string
+	for token := range sessionTokens {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	response.SessionToken = strings.Join(tokens, ",")
+	return response, nil
+}
+
+// modelAt returns the entity at index i of a slice of entities or pointers to entities, allocating nil pointers
+func modelAt(slice reflect.Value, i int) (Model, error) {
+	elem := slice.Index(i)
+	if elem.Kind() != reflect.Ptr {
+		elem = elem.Addr()
+	} else if elem.IsNil() {
+		elem.Set(reflect.New(elem.Type().Elem()))
+	}
+	entity, ok := elem.Interface().(Model)
+	if !ok {
+		return nil, errors.Errorf("%s does not implement Model", elem.Type())
+	}
+	return entity, nil
+}
+
+// Find runs a query built with querybuilder, like QueryWithOptions. If the query requires the partition key to be
+// equal to a value (through a querybuilder.Eq condition on the partition key that is not within Or or Not), it is run
+// in that partition only, otherwise it is a cross partition query. A partition key required to be null also gives a
+// cross partition query, which is still filtered by the condition.
+func (c Collection) Find(b *querybuilder.Builder, entities interface{}) (cosmosapi.QueryDocumentsResponse, error) {
+	query, err := b.Build()
+	if err != nil {
+		return cosmosapi.QueryDocumentsResponse{}, err
+	}
+	var opts QueryOptions
+	if values, ok := b.PartitionKeyValues(c.partitionKeyPaths()...); ok && !containsNil(values) {
+		if len(c.PartitionKeyPaths) > 0 {
+			opts.PartitionValue = cosmosapi.HierarchicalPartitionKey(values)
+		} else {
+			opts.PartitionValue = values[0]
+		}
+	}
+	return c.query(c.GetContext(), query, opts, entities, "", "")
+}
+
+func containsNil(values []interface{}) bool {
+	for _, value := range values {
+		if value == nil {
+			return true
+		}
+	}
+	return false
 }
 
 // Execute a StoredProcedure on the collection
 func (c Collection) ExecuteSproc(sprocName string, partitionKeyValue interface{}, ret interface{}, args ...interface{}) error {
+	_, err := c.ExecuteSprocWithResponse(sprocName, partitionKeyValue, ret, args...)
+	return err
+}
+
+// ExecuteSprocWithResponse is like ExecuteSproc, but also returns the response with the request charge, if the
+// Client is a StoredProcedureResponseClient.
+func (c Collection) ExecuteSprocWithResponse(sprocName string, partitionKeyValue interface{}, ret interface{}, args ...interface{}) (cosmosapi.ExecuteStoredProcedureResponse, error) {
 	opts := cosmosapi.ExecuteStoredProcedureOptions{PartitionKeyValue: partitionKeyValue}
-	return c.Client.ExecuteStoredProcedure(
-		c.GetContext(), c.DbName, c.Name, sprocName, opts, ret, args...)
+	ctx := c.clientContext(c.GetContext())
+	if client, ok := c.Client.(StoredProcedureResponseClient); ok {
+		return client.ExecuteStoredProcedureWithResponse(ctx, c.DbName, c.Name, sprocName, opts, ret, args...)
+	}
+	err := c.Client.ExecuteStoredProcedure(ctx, c.DbName, c.Name, sprocName, opts, ret, args...)
+	return cosmosapi.ExecuteStoredProcedureResponse{}, err
 }
 
 // Retrieve <maxItems> documents that have changed within the partition key range since <etag>. Note that according to
@@ -221,12 +442,69 @@ func (c Collection) ReadFeed(etag, partitionKeyRangeId string, maxItems int, doc
 		PartitionKeyRangeId: partitionKeyRangeId,
 		IfNoneMatch:         etag,
 	}
-	response, err := c.Client.ListDocuments(c.GetContext(), c.DbName, c.Name, &ops, documents)
+	response, err := c.Client.ListDocuments(c.clientContext(c.GetContext()), c.DbName, c.Name, &ops, documents)
+	if c.RoutingMaps != nil {
+		// The partition key range has been split
+		err = c.RoutingMaps.InvalidateOnGone(c.DbName, c.Name, err)
+	}
 	return response, err
 }
 
 func (c Collection) GetPartitionKeyRanges() ([]cosmosapi.PartitionKeyRange, error) {
 	ops := cosmosapi.GetPartitionKeyRangesOptions{}
-	response, err := c.Client.GetPartitionKeyRanges(c.GetContext(), c.DbName, c.Name, &ops)
+	response, err := c.Client.GetPartitionKeyRanges(c.clientContext(c.GetContext()), c.DbName, c.Name, &ops)
 	return response.PartitionKeyRanges, err
 }
+
+// PartitionKeyRangeFor returns the partition key range that documents with the given partition key value belong
+// to, e.g. to read its feed with ReadFeed. The routing map is cached if the collection has been initialized with
+// Init, otherwise the partition key ranges are fetched on every call.
+func (c Collection) PartitionKeyRangeFor(partitionValue interface{}) (cosmosapi.PartitionKeyRange, error) {
+	routingMaps := c.RoutingMaps
+	if routingMaps == nil {
+		routingMaps = cosmosapi.NewRoutingMapCache(c.Client)
+	}
+	return routingMaps.PartitionKeyRangeFor(c.clientContext(c.GetContext()), c.DbName, c.Name, partitionValue)
+}
+
+// PartitionKeyRangesForPrefix returns the partition key ranges holding the documents with the given prefix of the
+// values of a hierarchical partition key.
+func (c Collection) PartitionKeyRangesForPrefix(prefix ...interface{}) ([]cosmosapi.PartitionKeyRange, error) {
+	routingMaps := c.RoutingMaps
+	if routingMaps == nil {
+		routingMaps = cosmosapi.NewRoutingMapCache(c.Client)
+	}
+	return routingMaps.PartitionKeyRangesForPrefix(c.clientContext(c.GetContext()), c.DbName, c.Name, prefix...)
+}
+
+// QueryPrefix runs a query on the partition key ranges holding the documents with the given prefix of the values of
+// a hierarchical partition key, and appends all the results to entities, which must be a pointer to a slice. The
+// query itself must still filter on the prefix, as the ranges may hold other documents too.
+func (c Collection) QueryPrefix(query cosmosapi.Query, entities interface{}, prefix ...interface{}) error {
+	ranges, err := c.PartitionKeyRangesForPrefix(prefix...)
+	if err != nil {
+		return err
+	}
+	slice := reflect.ValueOf(entities).Elem()
+	for _, r := range ranges {
+		ops := cosmosapi.DefaultQueryDocumentOptions()
+		ops.EnableCrossPartition = true
+		ops.PartitionKeyRangeId = r.Id
+		for {
+			page := reflect.New(slice.Type())
+			response, err := c.Client.QueryDocuments(c.clientContext(c.GetContext()), c.DbName, c.Name, query, page.Interface(), ops)
+			if err != nil {
+				if c.RoutingMaps != nil {
+					err = c.RoutingMaps.InvalidateOnGone(c.DbName, c.Name, err)
+				}
+				return err
+			}
+			slice.Set(reflect.AppendSlice(slice, page.Elem()))
+			if response.Continuation == "" {
+				break
+			}
+			ops.Continuation = response.Continuation
+		}
+	}
+	return nil
+}
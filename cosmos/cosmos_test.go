@@ -13,6 +13,9 @@ import (
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"github.com/vippsas/go-cosmosdb/cosmosapi"
+	"github.com/vippsas/go-cosmosdb/cosmosapi/querybuilder"
+	"github.com/vippsas/go-cosmosdb/tracing"
+	"strings"
 )
 
 //
@@ -59,6 +62,12 @@ type mockCosmos struct {
 	GotUpsert       bool
 	GotX            int
 	GotSession      string
+	GotQuery        cosmosapi.Query
+	GotQueryOps     cosmosapi.QueryDocumentsOptions
+	ReturnDocuments string            // JSON array returned by QueryDocuments
+	ReadManyDocs    map[string]string // JSON documents by id returned by ReadMany
+	GotItems        []cosmosapi.ReadManyItem
+	GotReadManyOps  cosmosapi.ReadManyOptions
 }
 
 func (mock *mockCosmos) reset() {
@@ -123,6 +132,39 @@ func (mock *mockCosmos) ReplaceDocument(ctx context.Context,
 	return &newBase, cosmosapi.DocumentResponse{SessionToken: mock.ReturnSession}, mock.ReturnError
 }
 
+func (mock *mockCosmos) QueryDocuments(ctx context.Context,
+	dbName, collName string, qry cosmosapi.Query, docs interface{}, ops cosmosapi.QueryDocumentsOptions) (cosmosapi.QueryDocumentsResponse, error) {
+	mock.GotMethod = "query"
+	mock.GotQuery = qry
+	mock.GotQueryOps = ops
+	if mock.ReturnError != nil {
+		return cosmosapi.QueryDocumentsResponse{}, mock.ReturnError
+	}
+	if mock.ReturnDocuments != "" {
+		if err := json.Unmarshal([]byte(mock.ReturnDocuments), docs); err != nil {
+			return cosmosapi.QueryDocumentsResponse{}, err
+		}
+	}
+	return cosmosapi.QueryDocumentsResponse{SessionToken: mock.ReturnSession, Continuation: "next"}, nil
+}
+
+func (mock *mockCosmos) ReadMany(ctx context.Context,
+	dbName, collName string, items []cosmosapi.ReadManyItem, docs interface{}, ops cosmosapi.ReadManyOptions) (cosmosapi.ReadManyResponse, error) {
+	mock.GotMethod = "readmany"
+	mock.GotItems = items
+	mock.GotReadManyOps = ops
+	response := cosmosapi.ReadManyResponse{Found: make([]bool, len(items)), RequestCharge: float64(len(items)), SessionToken: mock.ReturnSession}
+	documents := make([]string, len(items))
+	for i, item := range items {
+		documents[i] = "null"
+		if doc, ok := mock.ReadManyDocs[item.Id]; ok {
+			documents[i] = doc
+			response.Found[i] = true
+		}
+	}
+	return response, json.Unmarshal([]byte("["+strings.Join(documents, ",")+"]"), docs)
+}
+
 func (mock *mockCosmos) ListDocuments(
 	ctx context.Context,
 	databaseName, collectionName string,
@@ -140,6 +182,28 @@ func (mock *mockCosmos) GetPartitionKeyRanges(
 	panic("implement me")
 }
 
+func (mock *mockCosmos) ExecuteStoredProcedureWithResponse(ctx context.Context,
+	dbName, colName, sprocName string, ops cosmosapi.ExecuteStoredProcedureOptions, ret interface{}, args ...interface{}) (cosmosapi.ExecuteStoredProcedureResponse, error) {
+	mock.GotMethod = "sproc"
+	mock.GotPartitionKey = ops.PartitionKeyValue
+	response := cosmosapi.ExecuteStoredProcedureResponse{SessionToken: mock.ReturnSession}
+	response.RequestCharge = 4.5
+	return response, mock.ReturnError
+}
+
+// mockCosmosSprocError only implements ExecuteStoredProcedure of the stored procedure methods
+type mockCosmosSprocError struct {
+	Client
+}
+
+func (mock *mockCosmosSprocError) ExecuteStoredProcedure(ctx context.Context,
+	dbName, colName, sprocName string, ops cosmosapi.ExecuteStoredProcedureOptions, ret interface{}, args ...interface{}) error {
+	return cosmosapi.ErrNotFound
+}
+
+var _ ReadManyClient = &cosmosapi.Client{}
+var _ StoredProcedureResponseClient = &cosmosapi.Client{}
+
 type mockCosmosNotFound struct {
 	mockCosmos
 }
@@ -165,6 +229,288 @@ func TestGetEntityInfo(t *testing.T) {
 	require.Equal(t, "Alice", pkey)
 }
 
+type Tenant struct {
+	Id string `json:"id"`
+}
+
+type MyHierarchicalModel struct {
+	BaseModel
+	Tenant Tenant `json:"tenant"`
+	UserId int    `json:"userId"`
+}
+
+func (e *MyHierarchicalModel) PrePut(txn *Transaction) error  { return nil }
+func (e *MyHierarchicalModel) PostGet(txn *Transaction) error { return nil }
+
+func TestGetEntityInfoHierarchical(t *testing.T) {
+	c := Collection{
+		Client:            &mockCosmosNotFound{},
+		DbName:            "mydb",
+		Name:              "mycollection",
+		PartitionKeyPaths: []string{"/tenant/id", "/userId"}}
+	e := MyHierarchicalModel{BaseModel: BaseModel{Id: "id1"}, Tenant: Tenant{Id: "acme"}, UserId: 42}
+	res, pkey := c.GetEntityInfo(&e)
+	require.Equal(t, "id1", res.Id)
+	require.Equal(t, cosmosapi.HierarchicalPartitionKey{"acme", 42}, pkey)
+
+	// Not found fills in the partition key fields
+	var target MyHierarchicalModel
+	require.NoError(t, c.StaleGet(cosmosapi.HierarchicalPartitionKey{"acme", 42}, "id1", &target))
+	require.Equal(t, e, target)
+
+	c.PartitionKeyPaths = []string{"/tenant/name"}
+	require.Panics(t, func() { c.GetEntityInfo(&e) })
+}
+
+func TestGetEntityInfoNestedPath(t *testing.T) {
+	c := Collection{
+		Client:       &mockCosmosNotFound{},
+		DbName:       "mydb",
+		Name:         "mycollection",
+		PartitionKey: "/tenant/id"}
+	e := MyHierarchicalModel{BaseModel: BaseModel{Id: "id1"}, Tenant: Tenant{Id: "acme"}}
+	_, pkey := c.GetEntityInfo(&e)
+	require.Equal(t, "acme", pkey)
+}
+
+func TestQueryWithOptions(t *testing.T) {
+	mock := mockCosmos{ReturnDocuments: `[{"id": "id1", "userId": "alice", "x": 1}, {"id": "id2", "userId": "alice", "x": 2}]`}
+	c := Collection{
+		Client:       &mock,
+		DbName:       "mydb",
+		Name:         "mycollection",
+		PartitionKey: "userId"}
+	query := cosmosapi.Query{
+		Query:  "SELECT * FROM c WHERE c.userId = @userId",
+		Params: []cosmosapi.QueryParam{{Name: "@userId", Value: "alice"}},
+	}
+
+	var entities []MyModel
+	response, err := c.QueryWithOptions(query, QueryOptions{PartitionValue: "alice", MaxItemCount: 2, Continuation: "page2"}, &entities)
+	require.NoError(t, err)
+	require.Equal(t, "next", response.Continuation)
+	require.Equal(t, query, mock.GotQuery)
+	require.Equal(t, "alice", mock.GotQueryOps.PartitionKeyValue)
+	require.False(t, mock.GotQueryOps.EnableCrossPartition)
+	require.Equal(t, 2, mock.GotQueryOps.MaxItemCount)
+	require.Equal(t, "page2", mock.GotQueryOps.Continuation)
+	require.Equal(t, cosmosapi.ConsistencyLevel(""), mock.GotQueryOps.ConsistencyLevel)
+	require.Len(t, entities, 2)
+	for _, e := range entities {
+		require.Equal(t, e.X+1, e.XPlusOne) // PostGetHook called
+		require.Equal(t, "MyModel/1", e.Model)
+	}
+
+	var pointers []*MyModel
+	_, err = c.QueryWithOptions(query, QueryOptions{}, &pointers)
+	require.NoError(t, err)
+	require.True(t, mock.GotQueryOps.EnableCrossPartition)
+	require.Nil(t, mock.GotQueryOps.PartitionKeyValue)
+	require.Equal(t, 3, pointers[1].XPlusOne)
+
+	// Results that are not models are left alone
+	var xs []struct{ X int }
+	_, err = c.QueryWithOptions(query, QueryOptions{}, &xs)
+	require.NoError(t, err)
+	require.Equal(t, 2, xs[1].X)
+
+	_, err = c.QueryWithOptions(query, QueryOptions{}, entities)
+	require.Error(t, err)
+
+	mock.ReturnError = cosmosapi.ErrTooManyRequests
+	_, err = c.QueryWithOptions(query, QueryOptions{}, &entities)
+	require.Equal(t, cosmosapi.ErrTooManyRequests, errors.Cause(err))
+}
+
+func TestSessionQueryWithOptions(t *testing.T) {
+	mock := mockCosmos{ReturnDocuments: `[{"id": "id1", "userId": "alice", "x": 1}]`, ReturnSession: "token2"}
+	c := Collection{
+		Client:       &mock,
+		DbName:       "mydb",
+		Name:         "mycollection",
+		PartitionKey: "userId"}
+	session := c.ResumeSession("token1")
+
+	var entities []MyModel
+	_, err := session.QueryWithOptions(cosmosapi.Query{Query: "SELECT * FROM c"}, QueryOptions{PartitionValue: "alice"}, &entities)
+	require.NoError(t, err)
+	require.Equal(t, cosmosapi.ConsistencyLevelSession, mock.GotQueryOps.ConsistencyLevel)
+	require.Equal(t, "token1", mock.GotQueryOps.SessionToken)
+	require.Equal(t, "token2", session.Token())
+	require.Equal(t, 2, entities[0].XPlusOne)
+}
+
+func TestCollectionReadMany(t *testing.T) {
+	mock := mockCosmos{ReadManyDocs: map[string]string{
+		"id1": `{"id": "id1", "userId": "alice", "x": 1, "_etag": "etag1"}`,
+		"id3": `{"id": "id3", "userId": "bob", "x": 3, "_etag": "etag3"}`,
+	}}
+	c := Collection{
+		Client:       &mock,
+		DbName:       "mydb",
+		Name:         "mycollection",
+		PartitionKey: "userId"}.Init()
+	items := []cosmosapi.ReadManyItem{{Id: "id1", PartitionKeyValue: "alice"}, {Id: "id2", PartitionKeyValue: "alice"}, {Id: "id3", PartitionKeyValue: "bob"}}
+
+	var entities []MyModel
+	response, err := c.ReadMany(items, &entities)
+	require.NoError(t, err)
+	require.Equal(t, c.RoutingMaps, mock.GotReadManyOps.RoutingMaps)
+	require.Equal(t, []bool{true, false, true}, response.Found)
+	require.Equal(t, 3.0, response.RequestCharge)
+	require.Len(t, entities, 3)
+	require.Equal(t, 3, entities[2].X)
+	require.Equal(t, 4, entities[2].XPlusOne) // PostGetHook called
+	require.Equal(t, "id2", entities[1].Id)   // initialized like by StaleGet
+	require.Equal(t, "alice", entities[1].UserId)
+	require.True(t, entities[1].IsNew())
+	require.Equal(t, 1, entities[1].PostGetCounter)
+
+	var pointers []*MyModel
+	_, err = c.ReadMany(items, &pointers)
+	require.NoError(t, err)
+	require.NotNil(t, pointers[1])
+	require.Equal(t, "id2", pointers[1].Id)
+	require.Equal(t, 2, pointers[0].XPlusOne)
+}
+
+// mockCosmosGetOnly is a Client that is not a ReadManyClient, with the X of the documents of alice by id
+type mockCosmosGetOnly struct {
+	Client
+	docs map[string]int
+}
+
+func (mock *mockCosmosGetOnly) GetDocument(ctx context.Context,
+	dbName, colName, id string, ops cosmosapi.GetDocumentOptions, out interface{}) (cosmosapi.DocumentResponse, error) {
+	x, ok := mock.docs[id]
+	if !ok {
+		return cosmosapi.DocumentResponse{RUs: 1, SessionToken: "0:1#1"}, cosmosapi.ErrNotFound
+	}
+	*out.(*MyModel) = MyModel{BaseModel: BaseModel{Id: id, Etag: "etag"}, UserId: "alice", X: x}
+	return cosmosapi.DocumentResponse{RUs: 1, SessionToken: "0:1#2"}, nil
+}
+
+func TestCollectionReadManyGetEach(t *testing.T) {
+	c := Collection{
+		Client:       &mockCosmosGetOnly{docs: map[string]int{"id1": 1}},
+		DbName:       "mydb",
+		Name:         "mycollection",
+		PartitionKey: "userId"}
+	items := []cosmosapi.ReadManyItem{{Id: "id1", PartitionKeyValue: "alice"}, {Id: "id2", PartitionKeyValue: "alice"}}
+
+	var entities []MyModel
+	response, err := c.ReadMany(items, &entities)
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, false}, response.Found)
+	require.Equal(t, 2.0, response.RequestCharge)
+	require.Equal(t, "0:1#1,0:1#2", response.SessionToken)
+	require.Len(t, entities, 2)
+	require.Equal(t, 2, entities[0].XPlusOne)
+	require.Equal(t, "id2", entities[1].Id)
+	require.Equal(t, "alice", entities[1].UserId)
+	require.True(t, entities[1].IsNew())
+}
+
+func TestSessionReadMany(t *testing.T) {
+	mock := mockCosmos{
+		ReadManyDocs: map[string]string{
+			"id1": `{"id": "id1", "userId": "alice", "x": 1, "_etag": "etag1"}`,
+			"id3": `{"id": "id3", "userId": "bob", "x": 3, "_etag": "etag3"}`,
+		},
+		ReturnSession: "token2",
+	}
+	c := Collection{
+		Client:       &mock,
+		DbName:       "mydb",
+		Name:         "mycollection",
+		PartitionKey: "userId"}
+	session := c.ResumeSession("token1")
+
+	var entities []MyModel
+	response, err := session.ReadMany([]cosmosapi.ReadManyItem{{Id: "id1", PartitionKeyValue: "alice"}, {Id: "id2", PartitionKeyValue: "alice"}}, &entities)
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, false}, response.Found)
+	require.Equal(t, cosmosapi.ConsistencyLevelSession, mock.GotReadManyOps.ConsistencyLevel)
+	require.Equal(t, "token1", mock.GotReadManyOps.SessionToken)
+	require.Equal(t, "token2", session.Token())
+	require.Equal(t, 2, entities[0].XPlusOne)
+
+	// id1 and id2 are cached, also the fact that id2 does not exist
+	mock.ReadManyDocs["id2"] = `{"id": "id2", "userId": "alice", "x": 2, "_etag": "etag2"}`
+	response, err = session.ReadMany([]cosmosapi.ReadManyItem{{Id: "id3", PartitionKeyValue: "bob"}, {Id: "id2", PartitionKeyValue: "alice"}, {Id: "id1", PartitionKeyValue: "alice"}}, &entities)
+	require.NoError(t, err)
+	require.Equal(t, []cosmosapi.ReadManyItem{{Id: "id3", PartitionKeyValue: "bob"}}, mock.GotItems)
+	require.Equal(t, []bool{true, false, true}, response.Found)
+	require.Equal(t, 1.0, response.RequestCharge)
+	require.Len(t, entities, 3)
+	require.Equal(t, 3, entities[0].X)
+	require.True(t, entities[1].IsNew())
+	require.Equal(t, "id2", entities[1].Id)
+	require.Equal(t, 1, entities[2].X)
+	require.Equal(t, 2, entities[2].XPlusOne)
+
+	// Transaction.Get uses the cache populated by ReadMany
+	mock.reset()
+	var entity MyModel
+	require.NoError(t, session.Get("bob", "id3", &entity))
+	require.Equal(t, "", mock.GotMethod)
+	require.Equal(t, 3, entity.X)
+}
+
+func TestCollectionFind(t *testing.T) {
+	mock := mockCosmos{}
+	c := Collection{
+		Client:       &mock,
+		DbName:       "mydb",
+		Name:         "mycollection",
+		PartitionKey: "userId"}
+	var entities []MyModel
+
+	_, err := c.Find(querybuilder.Select().Where(querybuilder.Eq("userId", "Alice"), querybuilder.Gt("x", 1)), &entities)
+	require.NoError(t, err)
+	require.Equal(t, "SELECT * FROM c WHERE c.userId = @p0 AND c.x > @p1", mock.GotQuery.Query)
+	require.Equal(t, "Alice", mock.GotQueryOps.PartitionKeyValue)
+	require.False(t, mock.GotQueryOps.EnableCrossPartition)
+
+	_, err = c.Find(querybuilder.Select().Where(querybuilder.Gt("x", 1)), &entities)
+	require.NoError(t, err)
+	require.Nil(t, mock.GotQueryOps.PartitionKeyValue)
+	require.True(t, mock.GotQueryOps.EnableCrossPartition)
+
+	c.PartitionKeyPaths = []string{"/tenant/id", "/userId"}
+	_, err = c.Find(querybuilder.Select().Where(querybuilder.Eq("userId", 42), querybuilder.Eq("tenant.id", "acme")), &entities)
+	require.NoError(t, err)
+	require.Equal(t, cosmosapi.HierarchicalPartitionKey{"acme", 42}, mock.GotQueryOps.PartitionKeyValue)
+
+	c.PartitionKeyPaths = nil
+	_, err = c.Find(querybuilder.Select().Where(querybuilder.Eq("userId", nil)), &entities)
+	require.NoError(t, err)
+	require.Nil(t, mock.GotQueryOps.PartitionKeyValue)
+	require.True(t, mock.GotQueryOps.EnableCrossPartition, "null partition key")
+
+	mock.ReturnDocuments = `[{"id": "1", "userId": "Alice", "x": 1}]`
+	_, err = c.Find(querybuilder.Select().Where(querybuilder.Eq("userId", "Alice")), &entities)
+	require.NoError(t, err)
+	require.Len(t, entities, 1)
+	require.Equal(t, 1, entities[0].PostGetCounter)
+}
+
+func TestCollectionExecuteSproc(t *testing.T) {
+	mock := &mockCosmos{ReturnSession: "session"}
+	c := Collection{Client: mock, DbName: "db", Name: "coll", PartitionKey: "userId"}
+	response, err := c.ExecuteSprocWithResponse("sproc", "alice", nil)
+	require.NoError(t, err)
+	require.Equal(t, "sproc", mock.GotMethod)
+	require.Equal(t, "alice", mock.GotPartitionKey)
+	require.Equal(t, 4.5, response.RequestCharge)
+	require.Equal(t, "session", response.SessionToken)
+
+	c.Client = &mockCosmosSprocError{}
+	response, err = c.ExecuteSprocWithResponse("sproc", "alice", nil)
+	require.Equal(t, cosmosapi.ErrNotFound, err)
+	require.Equal(t, cosmosapi.ExecuteStoredProcedureResponse{}, response)
+}
+
 func TestCheckModel(t *testing.T) {
 	e := MyModel{Model: "MyModel/1"}
 	require.Equal(t, "MyModel/1", CheckModel(&e))
@@ -624,3 +970,51 @@ func TestTransaction_IgnoreErrorOnGetThenPut(t *testing.T) {
 		t.Errorf("Expected error %v", PutWithoutGetError)
 	}
 }
+
+type countingTracer struct {
+	started, ended int
+	attributes     map[string]interface{}
+}
+
+func (t *countingTracer) Start(ctx context.Context, name string) (context.Context, tracing.Span) {
+	t.started++
+	t.attributes = map[string]interface{}{"name": name}
+	return ctx, t
+}
+
+func (t *countingTracer) SetAttribute(key string, value interface{}) { t.attributes[key] = value }
+func (t *countingTracer) RecordError(err error)                      { t.attributes["error"] = err }
+func (t *countingTracer) End()                                       { t.ended++ }
+
+func TestTransactionTracing(t *testing.T) {
+	mock := mockCosmos{}
+	tracer := &countingTracer{}
+	c := Collection{
+		Client:       &mock,
+		DbName:       "mydb",
+		Name:         "mycollection",
+		PartitionKey: "userId",
+		Tracer:       tracer,
+	}
+
+	attempt := 0
+	require.NoError(t, c.Session().Transaction(func(txn *Transaction) error {
+		var entity MyModel
+		mock.ReturnError = cosmosapi.ErrNotFound
+		require.NoError(t, txn.Get("partitionvalue", "idvalue", &entity))
+		if attempt == 0 {
+			mock.ReturnError = cosmosapi.ErrPreconditionFailed
+		} else {
+			mock.ReturnError = nil
+		}
+		attempt++
+		txn.Put(&entity)
+		return nil
+	}))
+	require.Equal(t, 1, tracer.started)
+	require.Equal(t, 1, tracer.ended)
+	require.Equal(t, "Transaction", tracer.attributes["name"])
+	require.Equal(t, "mycollection", tracer.attributes[tracing.DBCollection])
+	require.Equal(t, 1, tracer.attributes[tracing.ConflictRetries])
+	require.Nil(t, tracer.attributes["error"])
+}
@@ -41,3 +41,18 @@ type Client interface {
 	ListOffers(ctx context.Context, ops *cosmosapi.RequestOptions) (*cosmosapi.Offers, error)
 	ReplaceOffer(ctx context.Context, offerOps cosmosapi.OfferReplaceOptions, ops *cosmosapi.RequestOptions) (*cosmosapi.Offer, error)
 }
+
+// ReadManyClient is implemented by clients that read many documents at once, like cosmosapi.Client. It is not part
+// of Client so that other implementations of Client keep working; without it, Collection.ReadMany reads the
+// documents one at a time with GetDocument.
+type ReadManyClient interface {
+	ReadMany(ctx context.Context, dbName, collName string, items []cosmosapi.ReadManyItem, docs interface{}, ops cosmosapi.ReadManyOptions) (cosmosapi.ReadManyResponse, error)
+}
+
+// StoredProcedureResponseClient is implemented by clients that return the
+// response of stored procedures, like cosmosapi.Client. It is not part of
+// Client so that other implementations of Client keep working; without it,
+// Collection.ExecuteSprocWithResponse returns an empty response.
+type StoredProcedureResponseClient interface {
+	ExecuteStoredProcedureWithResponse(ctx context.Context, dbName, colName, sprocName string, ops cosmosapi.ExecuteStoredProcedureOptions, ret interface{}, args ...interface{}) (cosmosapi.ExecuteStoredProcedureResponse, error)
+}
@@ -26,6 +26,14 @@
 //
 // Collection is simply a read-config struct and therefore thread-safe.
 //
+// For collections with a hierarchical (MultiHash) partition key, set
+// PartitionKeyPaths instead of PartitionKey and use
+// cosmosapi.HierarchicalPartitionKey values:
+//
+//  collection.PartitionKeyPaths = []string{"/tenant/id", "/userId"}
+//  err = collection.StaleGet(cosmosapi.HierarchicalPartitionKey{tenantId, userId}, id, &entity)
+//  err = collection.QueryPrefix(query, &entities, tenantId)  // all documents of a tenant
+//
 // Session
 //
 // Use a Session to enable Cosmos' session-level consistency. The
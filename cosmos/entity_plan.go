@@ -0,0 +1,284 @@
+package cosmos
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// entityPlan says where to find the BaseModel and the partition key fields of an entity type. It is computed and
+// validated once per entity type and partition key, and cached in entityPlans.
+type entityPlan struct {
+	base         []int
+	partitionKey []fieldPath
+}
+
+// fieldPath locates a field of an entity, by field indexes through nested and embedded structs and pointers to
+// them.
+type fieldPath struct {
+	path      string
+	index     []int
+	omitEmpty bool
+}
+
+type entityPlanKey struct {
+	entityType reflect.Type
+	paths      string
+}
+
+var entityPlans sync.Map // entityPlanKey -> *entityPlan
+
+var baseModelType = reflect.TypeOf(BaseModel{})
+
+func getEntityPlan(entityType reflect.Type, paths []string) (*entityPlan, error) {
+	key := entityPlanKey{entityType, strings.Join(paths, ",")}
+	if plan, ok := entityPlans.Load(key); ok {
+		return plan.(*entityPlan), nil
+	}
+	plan, err := newEntityPlan(entityType, paths)
+	if err != nil {
+		return nil, err
+	}
+	entityPlans.Store(key, plan)
+	return plan, nil
+}
+
+func newEntityPlan(entityType reflect.Type, paths []string) (*entityPlan, error) {
+	if entityType.Kind() != reflect.Ptr || entityType.Elem().Kind() != reflect.Struct {
+		return nil, errors.Errorf("Need to pass in a pointer to a struct, got %s", entityType)
+	}
+	structT := entityType.Elem()
+	base, ok := structT.FieldByName("BaseModel")
+	if !ok || base.Type != baseModelType {
+		return nil, errors.Errorf("%s has no field named 'BaseModel'", structT)
+	}
+	plan := &entityPlan{base: base.Index}
+	for _, path := range paths {
+		var field fieldPath
+		if strings.TrimPrefix(path, "/") == "id" {
+			// Ids are never empty, even if BaseModel says omitempty
+			idField, _ := baseModelType.FieldByName("Id")
+			field = fieldPath{path: path, index: append(append([]int{}, base.Index...), idField.Index...)}
+		} else {
+			var err error
+			if field, err = findFieldPath(structT, path); err != nil {
+				return nil, err
+			}
+		}
+		plan.partitionKey = append(plan.partitionKey, field)
+	}
+	return plan, nil
+}
+
+// findFieldPath finds the field at a partition key path like "/customer/id" by following json names the way
+// encoding/json does.
+func findFieldPath(structT reflect.Type, path string) (fieldPath, error) {
+	field := fieldPath{path: path}
+	t := structT
+	for _, name := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return field, errors.Errorf("Partition key path '%s' of %s goes through %s, which is not a struct", path, structT, t)
+		}
+		index, omitEmpty, ok := findJsonField(t, name)
+		if !ok {
+			return field, errors.Errorf("%s has no field with json name '%s' for partition key path '%s'", structT, name, path)
+		}
+		field.index = append(field.index, index...)
+		field.omitEmpty = omitEmpty
+		t = t.FieldByIndex(index).Type
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Interface,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return field, errors.Errorf("Partition key path '%s' of %s is a %s, which is not a valid partition key type", path, structT, t)
+	}
+	return field, nil
+}
+
+// findJsonField returns the index of the field of t that is marshalled with the given json name. Fields directly
+// on t take precedence over fields of embedded structs.
+func findJsonField(t reflect.Type, name string) (index []int, omitEmpty bool, ok bool) {
+	var embedded []int
+	for i := 0; i != t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		tagName, options := tag, ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			tagName, options = tag[:comma], tag[comma:]
+		}
+		if f.Anonymous && tagName == "" {
+			embedded = append(embedded, i)
+			continue
+		}
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if tagName == "" {
+			tagName = f.Name
+		}
+		if tagName == name {
+			return []int{i}, strings.Contains(options, ",omitempty"), true
+		}
+	}
+	for _, i := range embedded {
+		ft := t.Field(i).Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() != reflect.Struct {
+			continue
+		}
+		if sub, omitEmpty, ok := findJsonField(ft, name); ok {
+			return append([]int{i}, sub...), omitEmpty, true
+		}
+	}
+	return nil, false, false
+}
+
+// partitionKeyFields are the fields of an entity holding its partition key value, one per path
+type partitionKeyFields struct {
+	entity       reflect.Value
+	fields       []fieldPath
+	hierarchical bool
+}
+
+func (f partitionKeyFields) value() interface{} {
+	if !f.hierarchical {
+		return f.fieldValue(f.fields[0])
+	}
+	values := make(cosmosapi.HierarchicalPartitionKey, len(f.fields))
+	for i, field := range f.fields {
+		values[i] = f.fieldValue(field)
+	}
+	return values
+}
+
+// matches returns whether partitionValue is the partition key value of the entity. Values that are left out of the
+// JSON of omitempty fields, like "", are the same as cosmosapi.UndefinedPartitionKey.
+func (f partitionKeyFields) matches(partitionValue interface{}) bool {
+	if !f.hierarchical {
+		return reflect.DeepEqual(f.value(), f.normalize(f.fields[0], partitionValue))
+	}
+	values, ok := partitionValue.(cosmosapi.HierarchicalPartitionKey)
+	if !ok || len(values) != len(f.fields) {
+		return false
+	}
+	normalized := make(cosmosapi.HierarchicalPartitionKey, len(values))
+	for i, field := range f.fields {
+		normalized[i] = f.normalize(field, values[i])
+	}
+	return reflect.DeepEqual(f.value(), normalized)
+}
+
+// normalize returns cosmosapi.UndefinedPartitionKey for a value that is left out of the JSON if set on field
+func (f partitionKeyFields) normalize(field fieldPath, value interface{}) interface{} {
+	if !field.omitEmpty || value == cosmosapi.UndefinedPartitionKey {
+		return value
+	}
+	if value == nil {
+		return cosmosapi.UndefinedPartitionKey
+	}
+	switch f.entity.Type().FieldByIndex(field.index).Type.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		// Only nil is left out
+		return value
+	}
+	if isEmptyValue(reflect.ValueOf(value)) {
+		return cosmosapi.UndefinedPartitionKey
+	}
+	return value
+}
+
+// fieldValue returns the value of field as stored in Cosmos; fields that are left out of the JSON (omitempty, or
+// below a nil pointer) are cosmosapi.UndefinedPartitionKey
+func (f partitionKeyFields) fieldValue(field fieldPath) interface{} {
+	v, ok := fieldByIndex(f.entity, field.index, false)
+	if !ok {
+		return cosmosapi.UndefinedPartitionKey
+	}
+	if field.omitEmpty && isEmptyValue(v) {
+		return cosmosapi.UndefinedPartitionKey
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	return v.Interface()
+}
+
+func (f partitionKeyFields) set(partitionValue interface{}) {
+	if !f.hierarchical {
+		f.setField(f.fields[0], partitionValue)
+		return
+	}
+	values, ok := partitionValue.(cosmosapi.HierarchicalPartitionKey)
+	if !ok || len(values) != len(f.fields) {
+		panic(errors.Errorf("Expected a cosmosapi.HierarchicalPartitionKey with %d values, got: %v", len(f.fields), partitionValue))
+	}
+	for i, field := range f.fields {
+		f.setField(field, values[i])
+	}
+}
+
+func (f partitionKeyFields) setField(field fieldPath, value interface{}) {
+	v, _ := fieldByIndex(f.entity, field.index, true)
+	if value == cosmosapi.UndefinedPartitionKey || value == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+	if v.Kind() == reflect.Ptr {
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+	v.Set(reflect.ValueOf(value))
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex, but allocates nil pointers to structs on the way if alloc is
+// set, and otherwise returns false when it encounters one
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmptyValue is the definition of empty used by encoding/json for omitempty
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return v.Len() == 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
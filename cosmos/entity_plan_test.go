@@ -0,0 +1,137 @@
+package cosmos
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+type Customer struct {
+	Id   *string `json:"id,omitempty"`
+	Name string  `json:"name"`
+}
+
+type audit struct {
+	Region string `json:"region,omitempty"`
+}
+
+type NestedModel struct {
+	BaseModel
+	audit
+	Customer *Customer `json:"customer,omitempty"`
+	Pk       string    `json:"pk,omitempty"`
+	Ignored  string    `json:"-"`
+	Plain    int
+}
+
+func (e *NestedModel) PrePut(txn *Transaction) error  { return nil }
+func (e *NestedModel) PostGet(txn *Transaction) error { return nil }
+
+func nestedCollection(paths ...string) Collection {
+	if len(paths) == 1 {
+		return Collection{Client: &mockCosmosNotFound{}, DbName: "db", Name: "coll", PartitionKey: paths[0]}
+	}
+	return Collection{Client: &mockCosmosNotFound{}, DbName: "db", Name: "coll", PartitionKeyPaths: paths}
+}
+
+func TestEntityPlanPaths(t *testing.T) {
+	customerId := "c1"
+	e := NestedModel{
+		BaseModel: BaseModel{Id: "id1"},
+		audit:     audit{Region: "norway"},
+		Customer:  &Customer{Id: &customerId},
+		Pk:        "pk1",
+		Plain:     3,
+	}
+	for path, expected := range map[string]interface{}{
+		"pk":           "pk1",
+		"/pk":          "pk1",
+		"/customer/id": "c1",
+		"/region":      "norway",
+		"Plain":        3,
+		"id":           "id1",
+	} {
+		_, value := nestedCollection(path).GetEntityInfo(&e)
+		assert.Equal(t, expected, value, path)
+	}
+
+	_, value := nestedCollection("/region", "/customer/id").GetEntityInfo(&e)
+	assert.Equal(t, cosmosapi.HierarchicalPartitionKey{"norway", "c1"}, value)
+}
+
+func TestEntityPlanEmptyValues(t *testing.T) {
+	// omitempty fields and fields below nil pointers are not in the document at all
+	var e NestedModel
+	_, value := nestedCollection("/pk").GetEntityInfo(&e)
+	assert.Equal(t, cosmosapi.UndefinedPartitionKey, value)
+	_, value = nestedCollection("/customer/id").GetEntityInfo(&e)
+	assert.Equal(t, cosmosapi.UndefinedPartitionKey, value)
+	e.Customer = &Customer{}
+	_, value = nestedCollection("/customer/id").GetEntityInfo(&e)
+	assert.Equal(t, cosmosapi.UndefinedPartitionKey, value)
+	_, value = nestedCollection("/customer/name").GetEntityInfo(&e)
+	assert.Equal(t, "", value)
+}
+
+// mockCosmosNestedModel returns a NestedModel with an empty Pk
+type mockCosmosNestedModel struct {
+	mockCosmos
+}
+
+func (mock *mockCosmosNestedModel) GetDocument(ctx context.Context,
+	dbName, colName, id string, ops cosmosapi.GetDocumentOptions, out interface{}) (cosmosapi.DocumentResponse, error) {
+	*out.(*NestedModel) = NestedModel{BaseModel: BaseModel{Id: id, Etag: "etag"}}
+	return cosmosapi.DocumentResponse{}, nil
+}
+
+func TestEntityPlanGetEmptyValues(t *testing.T) {
+	// "" is the same partition key value as undefined for omitempty fields, as they are left out of the document
+	var target NestedModel
+	c := nestedCollection("/pk")
+	require.NoError(t, c.StaleGet("", "id1", &target))
+	require.NoError(t, c.StaleGet(cosmosapi.UndefinedPartitionKey, "id1", &target))
+	require.NoError(t, nestedCollection("/pk", "/customer/name").StaleGet(cosmosapi.HierarchicalPartitionKey{"", ""}, "id1", &target))
+
+	c.Client = &mockCosmosNestedModel{}
+	require.NoError(t, c.StaleGet("", "id1", &target))
+	assert.Equal(t, "etag", target.Etag)
+	assert.Error(t, c.StaleGet("pk1", "id1", &target))
+
+	// A pointer to "" is not left out
+	c = nestedCollection("/customer/id")
+	require.NoError(t, c.StaleGet("", "id1", &target))
+	require.NotNil(t, target.Customer.Id)
+	c.Client = &mockCosmosNestedModel{}
+	assert.Error(t, c.StaleGet("", "id1", &target))
+}
+
+func TestEntityPlanSet(t *testing.T) {
+	// Nil pointers on the way are allocated when setting the partition key
+	var target NestedModel
+	require.NoError(t, nestedCollection("/customer/id").StaleGet("c1", "id1", &target))
+	require.NotNil(t, target.Customer)
+	require.NotNil(t, target.Customer.Id)
+	assert.Equal(t, "c1", *target.Customer.Id)
+	assert.Equal(t, "id1", target.Id)
+}
+
+func TestEntityPlanInvalid(t *testing.T) {
+	var e NestedModel
+	for _, path := range []string{"/ignored", "/Ignored", "/customer", "/customer/missing", "/pk/id", "/audit"} {
+		assert.Panics(t, func() { nestedCollection(path).GetEntityInfo(&e) }, path)
+	}
+}
+
+func TestEntityPlanCached(t *testing.T) {
+	var e NestedModel
+	nestedCollection("/customer/name").GetEntityInfo(&e)
+	plan, ok := entityPlans.Load(entityPlanKey{reflect.TypeOf(&e), "/customer/name"})
+	require.True(t, ok)
+	cached, err := getEntityPlan(reflect.TypeOf(&e), []string{"/customer/name"})
+	require.NoError(t, err)
+	assert.True(t, plan == cached)
+}
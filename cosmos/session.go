@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+	"reflect"
 	"sync"
 )
 
@@ -88,6 +90,78 @@ func (session Session) Get(partitionValue interface{}, id string, target Model)
 	})
 }
 
+// QueryWithOptions is like Collection.QueryWithOptions, but with session consistency, so that the results include
+// the writes done in the session. The results are not added to the session cache. Like Get, it must not be called
+// from within a Transaction.
+func (session Session) QueryWithOptions(query cosmosapi.Query, opts QueryOptions, entities interface{}) (cosmosapi.QueryDocumentsResponse, error) {
+	response, err := session.Collection.query(session.Context, query, opts, entities, cosmosapi.ConsistencyLevelSession, session.Token())
+	if response.SessionToken != "" {
+		session.state.mu.Lock()
+		session.state.sessionToken = response.SessionToken
+		session.state.mu.Unlock()
+	}
+	return response, err
+}
+
+// ReadMany is like Collection.ReadMany, but within the session: entities in the session cache are taken from there,
+// the others are read with session consistency and added to the cache. Like Get, it must not be called from within a
+// Transaction.
+func (session Session) ReadMany(items []cosmosapi.ReadManyItem, entities interface{}) (cosmosapi.ReadManyResponse, error) {
+	session.state.mu.Lock()
+	defer session.state.mu.Unlock()
+	response := cosmosapi.ReadManyResponse{Found: make([]bool, len(items))}
+	ptr := reflect.ValueOf(entities)
+	if ptr.Kind() != reflect.Ptr || ptr.Elem().Kind() != reflect.Slice {
+		return response, errors.Errorf("Need to pass in a pointer to a slice, got %T", entities)
+	}
+	slice := reflect.MakeSlice(ptr.Elem().Type(), len(items), len(items))
+	ptr.Elem().Set(slice)
+
+	var missing []int
+	var missingItems []cosmosapi.ReadManyItem
+	for i, item := range items {
+		entity, err := modelAt(slice, i)
+		if err != nil {
+			return response, err
+		}
+		found, err := session.cacheGet(item.PartitionKeyValue, item.Id, entity)
+		if err != nil {
+			return response, err
+		}
+		if found {
+			response.Found[i] = !entity.IsNew()
+		} else {
+			missing = append(missing, i)
+			missingItems = append(missingItems, item)
+		}
+	}
+
+	if len(missing) > 0 {
+		fetched := reflect.New(slice.Type())
+		fetchResponse, err := session.Collection.readMany(session.Context, missingItems, fetched.Interface(), cosmosapi.ConsistencyLevelSession, session.Token())
+		if err != nil {
+			return response, err
+		}
+		if fetchResponse.SessionToken != "" {
+			session.state.sessionToken = fetchResponse.SessionToken
+		}
+		response.RequestCharge = fetchResponse.RequestCharge
+		response.SessionToken = fetchResponse.SessionToken
+		for j, i := range missing {
+			slice.Index(i).Set(fetched.Elem().Index(j))
+			entity, err := modelAt(slice, i)
+			if err != nil {
+				return response, err
+			}
+			if err := session.cacheSet(items[i].PartitionKeyValue, items[i].Id, entity); err != nil {
+				return response, err
+			}
+			response.Found[i] = fetchResponse.Found[j]
+		}
+	}
+	return response, postGetAll(entities)
+}
+
 func (session Session) cacheSet(partitionValue interface{}, id string, entity Model) error {
 	key, err := newUniqueKey(partitionValue, id)
 	if err != nil {
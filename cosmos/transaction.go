@@ -6,6 +6,7 @@ import (
 
 	"github.com/pkg/errors"
 	cosmosapi "github.com/vippsas/go-cosmosdb/cosmosapi"
+	"github.com/vippsas/go-cosmosdb/tracing"
 )
 
 // Transaction is simply a wrapper around Session which unlocks some of
@@ -28,13 +29,21 @@ func Rollback() error {
 
 // Transaction <todo rest of docs>. Note: On commit, the Etag is updated on all relevant
 // entities (but normally these should never be used outside)
-func (session Session) Transaction(closure func(*Transaction) error) error {
+func (session Session) Transaction(closure func(*Transaction) error) (err error) {
 	session.state.mu.Lock()
 	defer session.state.mu.Unlock()
 	if session.ConflictRetries == 0 {
 		return errors.Errorf("Number of retries set to 0")
 	}
+	// The span covers all the contention retries, with the Cosmos operations of each attempt as children
+	var span tracing.Span
+	session.Context, span = tracing.OrNoop(session.Collection.Tracer).Start(session.Context, "Transaction")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttribute(tracing.DBSystem, tracing.DBSystemCosmosDB)
+	span.SetAttribute(tracing.DBName, session.Collection.DbName)
+	span.SetAttribute(tracing.DBCollection, session.Collection.Name)
 	for i := 0; i != session.ConflictRetries; i++ {
+		span.SetAttribute(tracing.ConflictRetries, i)
 		txn := Transaction{session: session}
 
 		closureErr := closure(&txn)
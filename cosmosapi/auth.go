@@ -56,6 +56,15 @@ func authHeader(sPayload string) string {
 	)
 }
 
+// resourceTokenAuthHeader returns the authorization header of a resource
+// token, which is escaped unless it is already
+func resourceTokenAuthHeader(token string) string {
+	if strings.Contains(token, "&") {
+		return url.QueryEscape(token)
+	}
+	return token
+}
+
 func sign(str, key string) (string, error) {
 	var ret string
 	enc := base64.StdEncoding
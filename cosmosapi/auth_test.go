@@ -33,3 +33,13 @@ func TestMakeAuthHeader(t *testing.T) {
 		})
 	}
 }
+
+func TestResourceTokenAuthHeader(t *testing.T) {
+	h, err := defaultHeaders("GET", "dbs/db/colls/coll", Config{MasterKey: TestKey, ResourceToken: "type=resource&ver=1.0&sig=a+b/c"})
+	require.NoError(t, err)
+	assert.Equal(t, "type%3Dresource%26ver%3D1.0%26sig%3Da%2Bb%2Fc", h[HEADER_AUTH])
+
+	h, err = defaultHeaders("GET", "dbs/db/colls/coll", Config{ResourceToken: "type%3Dresource%26ver%3D1.0%26sig%3Da"})
+	require.NoError(t, err)
+	assert.Equal(t, "type%3Dresource%26ver%3D1.0%26sig%3Da", h[HEADER_AUTH], "already escaped")
+}
@@ -0,0 +1,446 @@
+package cosmosapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BulkOperationType is the type of a BulkOperation.
+type BulkOperationType string
+
+const (
+	BulkCreate  = BulkOperationType("Create")
+	BulkUpsert  = BulkOperationType("Upsert")
+	BulkReplace = BulkOperationType("Replace")
+	BulkDelete  = BulkOperationType("Delete")
+	BulkPatch   = BulkOperationType("Patch")
+)
+
+const (
+	// DefaultBulkBatchSize is the maximum number of operations Cosmos DB
+	// accepts in a batch request
+	DefaultBulkBatchSize      = 100
+	DefaultBulkMaxConcurrency = 16
+	DefaultBulkMaxRetries     = 10
+)
+
+// BulkOperation is a single operation of Bulk.
+type BulkOperation struct {
+	Type              BulkOperationType
+	PartitionKeyValue interface{}
+	// Id of the document, for BulkReplace, BulkDelete and BulkPatch
+	Id string
+	// Document to write, for BulkCreate, BulkUpsert and BulkReplace
+	Document interface{}
+	// IfMatch, if set, makes a BulkReplace, BulkDelete or BulkPatch fail with
+	// ErrPreconditionFailed if the document has another etag
+	IfMatch string
+	// PatchOperations, for BulkPatch
+	PatchOperations []PatchOperation
+}
+
+// BulkResult is the result of a BulkOperation.
+type BulkResult struct {
+	// StatusCode is the HTTP status code of the last attempt, or 0 if the
+	// operation was never sent
+	StatusCode int
+	// Err is nil if the operation succeeded
+	Err           error
+	Etag          string
+	RequestCharge float64
+	Attempts      int
+	// Document is the document as written, for operations other than BulkDelete
+	Document json.RawMessage
+}
+
+// BulkOptions are the options of Bulk.
+type BulkOptions struct {
+	// MaxConcurrency is the maximum number of batch requests in flight;
+	// DefaultBulkMaxConcurrency if 0. The concurrency starts at
+	// InitialConcurrency (1 if 0), increases while requests succeed, and is
+	// halved when Cosmos DB throttles.
+	MaxConcurrency     int
+	InitialConcurrency int
+	// MaxBatchSize is the maximum number of operations per batch request;
+	// DefaultBulkBatchSize if 0.
+	MaxBatchSize int
+	// MaxRetries is the number of times an operation is retried when it is
+	// throttled or its partition key range has been split;
+	// DefaultBulkMaxRetries if 0.
+	MaxRetries int
+	// RoutingMaps, if set, is used to find the partition key ranges of the
+	// operations. Otherwise they are fetched on every call.
+	RoutingMaps *RoutingMapCache
+}
+
+// BulkResponse is the response of Bulk.
+type BulkResponse struct {
+	// Results has the result of every operation, in the same order
+	Results       []BulkResult
+	Succeeded     int
+	Failed        int
+	RequestCharge float64
+	Duration      time.Duration
+	// Throttled is the number of times an operation or batch request was
+	// throttled
+	Throttled int
+	// Concurrency is the number of requests allowed in flight at the end
+	Concurrency int
+}
+
+// OperationsPerSecond returns the throughput of the operations.
+func (r BulkResponse) OperationsPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(len(r.Results)) / r.Duration.Seconds()
+}
+
+// RequestUnitsPerSecond returns the request units spent per second.
+func (r BulkResponse) RequestUnitsPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return r.RequestCharge / r.Duration.Seconds()
+}
+
+// bulkOperationBody is an operation of a batch request
+type bulkOperationBody struct {
+	OperationType BulkOperationType `json:"operationType"`
+	Id            string            `json:"id,omitempty"`
+	PartitionKey  string            `json:"partitionKey,omitempty"`
+	IfMatch       string            `json:"ifMatch,omitempty"`
+	ResourceBody  interface{}       `json:"resourceBody,omitempty"`
+}
+
+// bulkOperationResult is the result of an operation in the response of a batch request
+type bulkOperationResult struct {
+	StatusCode             int             `json:"statusCode"`
+	RequestCharge          float64         `json:"requestCharge"`
+	ETag                   string          `json:"eTag"`
+	ResourceBody           json.RawMessage `json:"resourceBody"`
+	RetryAfterMilliseconds int             `json:"retryAfterMilliseconds"`
+}
+
+func (op BulkOperation) body() (bulkOperationBody, error) {
+	body := bulkOperationBody{OperationType: op.Type, Id: op.Id, IfMatch: op.IfMatch}
+	switch op.Type {
+	case BulkCreate, BulkUpsert, BulkReplace:
+		body.ResourceBody = op.Document
+	case BulkDelete:
+	case BulkPatch:
+		body.ResourceBody = patchBody{Operations: op.PatchOperations}
+	default:
+		return body, errors.Errorf("Unknown bulk operation type '%s'", op.Type)
+	}
+	if op.Type != BulkCreate && op.Type != BulkUpsert && op.Id == "" {
+		return body, errors.Errorf("Bulk operation %s requires an Id", op.Type)
+	}
+	if op.Type != BulkDelete && op.Type != BulkPatch && op.Document == nil {
+		return body, errors.Errorf("Bulk operation %s requires a Document", op.Type)
+	}
+	if op.Type == BulkPatch && len(op.PatchOperations) == 0 {
+		return body, errors.New("Bulk operation Patch requires PatchOperations")
+	}
+	if op.PartitionKeyValue != nil {
+		pk, err := MarshalPartitionKeyHeader(op.PartitionKeyValue)
+		if err != nil {
+			return body, err
+		}
+		body.PartitionKey = pk
+	}
+	return body, nil
+}
+
+// Bulk executes many operations on documents of a collection. The operations
+// are grouped by partition key range and sent in batch requests, which are
+// executed concurrently. Operations that are throttled or hit a partition key
+// range that has been split are retried. The order of operations on the same
+// document is not guaranteed.
+//
+// The result of every operation is in BulkResponse.Results; the returned
+// error is only set if the whole bulk failed, e.g. because ctx was done.
+func (c *Client) Bulk(ctx context.Context, dbName, collName string, operations []BulkOperation, ops BulkOptions) (response BulkResponse, err error) {
+	start := time.Now()
+	response.Results = make([]BulkResult, len(operations))
+	defer func() {
+		response.Duration = time.Since(start)
+	}()
+	routingMaps := ops.RoutingMaps
+	if routingMaps == nil {
+		routingMaps = NewRoutingMapCache(c)
+	}
+	maxRetries := ops.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = DefaultBulkMaxRetries
+	}
+
+	bodies := make([]bulkOperationBody, len(operations))
+	var pending []int
+	for i, op := range operations {
+		body, err := op.body()
+		if err != nil {
+			response.Results[i].Err = err
+			continue
+		}
+		bodies[i] = body
+		pending = append(pending, i)
+	}
+
+	limit := newAdaptiveLimit(ops.InitialConcurrency, ops.MaxConcurrency)
+	var mu sync.Mutex
+	ctx = withThrottleObserver(ctx, func() {
+		limit.decrease()
+		mu.Lock()
+		response.Throttled++
+		mu.Unlock()
+	})
+	for attempt := 1; len(pending) > 0; attempt++ {
+		batches, err := bulkBatches(ctx, routingMaps, dbName, collName, operations, pending, ops.MaxBatchSize)
+		if err != nil {
+			return response, err
+		}
+		var retry []int
+		var retryAfter time.Duration
+		var wg sync.WaitGroup
+		for _, batch := range batches {
+			if err := limit.acquire(ctx); err != nil {
+				wg.Wait()
+				return response, err
+			}
+			wg.Add(1)
+			go func(batch bulkBatch) {
+				defer wg.Done()
+				batchBodies := make([]bulkOperationBody, len(batch.indexes))
+				for j, i := range batch.indexes {
+					batchBodies[j] = bodies[i]
+				}
+				results, err := c.executeBulkBatch(ctx, dbName, collName, batch.rangeId, batchBodies)
+				mu.Lock()
+				defer mu.Unlock()
+				throttled := false
+				for j, i := range batch.indexes {
+					result := &response.Results[i]
+					result.Attempts++
+					var r bulkOperationResult
+					if err == nil && j < len(results) {
+						r = results[j]
+					} else if err == nil {
+						// Not executed; should not happen with continue on error
+						r.StatusCode = StatusRetryWith
+					}
+					result.StatusCode = r.StatusCode
+					result.RequestCharge += r.RequestCharge
+					response.RequestCharge += r.RequestCharge
+					if result.Err = err; err == nil {
+						result.Err = statusError(r.StatusCode)
+					}
+					switch errors.Cause(result.Err) {
+					case nil:
+						result.Etag = r.ETag
+						result.Document = r.ResourceBody
+					case ErrTooManyRequests, ErrMaxRetriesExceeded:
+						if err == nil {
+							// The throttled requests of a batch failing as a
+							// whole have already decreased the limit
+							response.Throttled++
+							throttled = true
+						}
+						retry = append(retry, i)
+						if d := time.Duration(r.RetryAfterMilliseconds) * time.Millisecond; d > retryAfter {
+							retryAfter = d
+						}
+					case ErrGone:
+						// The partition key range has been split
+						routingMaps.Invalidate(dbName, collName)
+						retry = append(retry, i)
+					case ErrRetryWith, ErrUnavailable, ErrTimeout:
+						retry = append(retry, i)
+					}
+				}
+				limit.release(throttled)
+			}(batch)
+		}
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return response, err
+		}
+		if len(retry) == 0 || attempt > maxRetries {
+			break
+		}
+		if retryAfter == 0 {
+			retryAfter = backoffDelay(attempt)
+		}
+		t := time.NewTimer(retryAfter)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return response, ctx.Err()
+		case <-t.C:
+		}
+		sort.Ints(retry)
+		pending = retry
+	}
+
+	for _, result := range response.Results {
+		if result.Err == nil {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+	response.Concurrency = limit.current()
+	return response, nil
+}
+
+// statusError returns the error of an operation with the given status code
+func statusError(code int) error {
+	if err, ok := CosmosHTTPErrors[code]; ok {
+		return err
+	}
+	return errUnexpectedHTTPStatus
+}
+
+// bulkBatch is a group of operations in the same partition key range
+type bulkBatch struct {
+	rangeId string
+	indexes []int
+}
+
+// bulkBatches groups the pending operations by partition key range, in batches of at most batchSize
+func bulkBatches(ctx context.Context, routingMaps *RoutingMapCache, dbName, collName string, operations []BulkOperation, pending []int, batchSize int) ([]bulkBatch, error) {
+	if batchSize <= 0 || batchSize > DefaultBulkBatchSize {
+		batchSize = DefaultBulkBatchSize
+	}
+	var batches []bulkBatch
+	open := map[string]int{} // range id -> index in batches of the batch being filled
+	for _, i := range pending {
+		r, err := routingMaps.PartitionKeyRangeFor(ctx, dbName, collName, operations[i].PartitionKeyValue)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := open[r.Id]
+		if !ok || len(batches[b].indexes) == batchSize {
+			b = len(batches)
+			batches = append(batches, bulkBatch{rangeId: r.Id})
+			open[r.Id] = b
+		}
+		batches[b].indexes = append(batches[b].indexes, i)
+	}
+	return batches, nil
+}
+
+// executeBulkBatch sends a non-atomic batch request with the operations
+func (c *Client) executeBulkBatch(ctx context.Context, dbName, collName, rangeId string, bodies []bulkOperationBody) ([]bulkOperationResult, error) {
+	headers := map[string]string{
+		HEADER_IS_BATCH_REQUEST:       "true",
+		HEADER_BATCH_ATOMIC:           "false",
+		HEADER_BATCH_CONTINUE_ON_ERR:  "true",
+		HEADER_PARTITION_KEY_RANGE_ID: rangeId,
+		HEADER_CONTYPE:                "application/json",
+	}
+	var results bulkBatchResults
+	_, err := c.create(ctx, createDocsLink(dbName, collName), bodies, &results, headers)
+	return results, err
+}
+
+// bulkBatchResults are the results of a batch request, which returns 207 Multi
+// Status when some of its operations failed
+type bulkBatchResults []bulkOperationResult
+
+func (r *bulkBatchResults) acceptsStatus(code int) bool {
+	return code == http.StatusMultiStatus
+}
+
+// adaptiveLimit limits the number of requests in flight. The limit increases
+// additively while requests succeed, and is halved when they are throttled.
+type adaptiveLimit struct {
+	mu       sync.Mutex
+	limit    float64
+	max      float64
+	inFlight int
+	changed  chan struct{}
+}
+
+func newAdaptiveLimit(initial, max int) *adaptiveLimit {
+	if max <= 0 {
+		max = DefaultBulkMaxConcurrency
+	}
+	if initial <= 0 {
+		initial = 1
+	}
+	if initial > max {
+		initial = max
+	}
+	return &adaptiveLimit{limit: float64(initial), max: float64(max), changed: make(chan struct{})}
+}
+
+func (l *adaptiveLimit) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.inFlight < int(l.limit) {
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *adaptiveLimit) release(throttled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	if throttled {
+		l.halve()
+	} else if l.limit += 1 / l.limit; l.limit > l.max {
+		l.limit = l.max
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *adaptiveLimit) decrease() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.halve()
+}
+
+// halve must be called with mu held
+func (l *adaptiveLimit) halve() {
+	if l.limit /= 2; l.limit < 1 {
+		l.limit = 1
+	}
+}
+
+func (l *adaptiveLimit) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+type throttleObserverKey struct{}
+
+// withThrottleObserver returns a context making the client call f whenever a
+// request done with it is throttled, before it is retried
+func withThrottleObserver(ctx context.Context, f func()) context.Context {
+	return context.WithValue(ctx, throttleObserverKey{}, f)
+}
+
+func notifyThrottled(ctx context.Context) {
+	if f, ok := ctx.Value(throttleObserverKey{}).(func()); ok {
+		f()
+	}
+}
@@ -0,0 +1,260 @@
+package cosmosapi
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bulkServer serves a collection partitioned by /userId with two partition key ranges, and executes batch requests
+type bulkServer struct {
+	t     *testing.T
+	pk    PartitionKey
+	split string
+
+	mu             sync.Mutex
+	throttleBatch  int // number of batch requests to throttle as a whole
+	throttled      map[string]bool
+	batches        []string
+	operationTypes []BulkOperationType
+}
+
+func newBulkServer(t *testing.T) *bulkServer {
+	s := &bulkServer{t: t, pk: PartitionKey{Paths: []string{"/userId"}, Kind: PartitionKindHash}, throttled: map[string]bool{}}
+	s.split = s.epk(`["m"]`)
+	return s
+}
+
+func (s *bulkServer) epk(header string) string {
+	var values []interface{}
+	require.NoError(s.t, json.Unmarshal([]byte(header), &values))
+	epk, err := EffectivePartitionKey(s.pk, values...)
+	require.NoError(s.t, err)
+	return epk
+}
+
+func (s *bulkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/colls/coll"):
+		json.NewEncoder(w).Encode(Collection{PartitionKey: &s.pk})
+		return
+	case strings.HasSuffix(r.URL.Path, "/pkranges"):
+		json.NewEncoder(w).Encode(GetPartitionKeyRangesResponse{PartitionKeyRanges: []PartitionKeyRange{
+			{Id: "0", MinInclusive: "", MaxExclusive: s.split},
+			{Id: "1", MinInclusive: s.split, MaxExclusive: "FF"},
+		}})
+		return
+	}
+	assert.Equal(s.t, "true", r.Header.Get(HEADER_IS_BATCH_REQUEST))
+	assert.Equal(s.t, "false", r.Header.Get(HEADER_BATCH_ATOMIC))
+	rangeId := r.Header.Get(HEADER_PARTITION_KEY_RANGE_ID)
+	if s.throttleBatch > 0 {
+		s.throttleBatch--
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	var operations []bulkOperationBody
+	require.NoError(s.t, json.NewDecoder(r.Body).Decode(&operations))
+	s.batches = append(s.batches, rangeId)
+
+	var results []bulkOperationResult
+	for _, op := range operations {
+		assert.Equal(s.t, rangeId == "0", s.epk(op.PartitionKey) < s.split, "operation sent to its partition key range")
+		s.operationTypes = append(s.operationTypes, op.OperationType)
+		result := bulkOperationResult{StatusCode: http.StatusOK, RequestCharge: 1, ETag: `"etag"`}
+		document, _ := json.Marshal(op.ResourceBody)
+		var fields map[string]interface{}
+		json.Unmarshal(document, &fields)
+		id := op.Id
+		if id == "" {
+			id, _ = fields["id"].(string)
+		}
+		switch {
+		case strings.HasPrefix(id, "throttled") && !s.throttled[id]:
+			s.throttled[id] = true
+			result = bulkOperationResult{StatusCode: http.StatusTooManyRequests, RetryAfterMilliseconds: 5}
+		case op.IfMatch == `"stale"`:
+			result = bulkOperationResult{StatusCode: http.StatusPreconditionFailed, RequestCharge: 0.5}
+		case op.OperationType == BulkCreate && id == "exists":
+			result = bulkOperationResult{StatusCode: http.StatusConflict, RequestCharge: 0.5}
+		case op.OperationType == BulkCreate:
+			result.StatusCode = http.StatusCreated
+			result.ResourceBody = document
+		case op.OperationType == BulkDelete:
+			result.StatusCode = http.StatusNoContent
+		default:
+			result.ResourceBody = document
+		}
+		results = append(results, result)
+	}
+	w.Header().Set(HEADER_REQUEST_CHARGE, "0")
+	w.WriteHeader(http.StatusMultiStatus)
+	json.NewEncoder(w).Encode(results)
+}
+
+type bulkDoc struct {
+	Id     string `json:"id"`
+	UserId string `json:"userId"`
+	X      int    `json:"x"`
+}
+
+func TestBulk(t *testing.T) {
+	s := newBulkServer(t)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	c := New(ts.URL, Config{MasterKey: TestKey}, nil, nil)
+
+	var operations []BulkOperation
+	for _, user := range []string{"a", "b", "c", "x", "y", "z"} {
+		operations = append(operations, BulkOperation{Type: BulkUpsert, PartitionKeyValue: user, Document: bulkDoc{Id: user, UserId: user}})
+	}
+	operations = append(operations,
+		BulkOperation{Type: BulkCreate, PartitionKeyValue: "a", Document: bulkDoc{Id: "new", UserId: "a"}},
+		BulkOperation{Type: BulkCreate, PartitionKeyValue: "a", Document: bulkDoc{Id: "exists", UserId: "a"}},
+		BulkOperation{Type: BulkReplace, PartitionKeyValue: "b", Id: "b", Document: bulkDoc{Id: "b", UserId: "b", X: 1}, IfMatch: `"etag"`},
+		BulkOperation{Type: BulkReplace, PartitionKeyValue: "c", Id: "c", Document: bulkDoc{Id: "c", UserId: "c"}, IfMatch: `"stale"`},
+		BulkOperation{Type: BulkDelete, PartitionKeyValue: "x", Id: "x"},
+		BulkOperation{Type: BulkPatch, PartitionKeyValue: "y", Id: "y", PatchOperations: []PatchOperation{{Op: PatchIncrement, Path: "/x", Value: 1}}},
+		BulkOperation{Type: BulkUpsert, PartitionKeyValue: "z", Document: bulkDoc{Id: "throttled", UserId: "z"}},
+		BulkOperation{Type: BulkReplace, PartitionKeyValue: "z", Document: bulkDoc{Id: "z", UserId: "z"}},
+		BulkOperation{Type: "Read", PartitionKeyValue: "z", Id: "z"},
+	)
+
+	response, err := c.Bulk(context.Background(), "db", "coll", operations, BulkOptions{MaxBatchSize: 4, InitialConcurrency: 2})
+	require.NoError(t, err)
+	require.Len(t, response.Results, len(operations))
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, response.Results[i].Err)
+		assert.Equal(t, http.StatusOK, response.Results[i].StatusCode)
+		assert.Equal(t, `"etag"`, response.Results[i].Etag)
+		assert.Equal(t, 1, response.Results[i].Attempts)
+	}
+	var doc bulkDoc
+	require.NoError(t, json.Unmarshal(response.Results[0].Document, &doc))
+	assert.Equal(t, bulkDoc{Id: "a", UserId: "a"}, doc)
+
+	assert.Equal(t, http.StatusCreated, response.Results[6].StatusCode)
+	assert.Equal(t, ErrConflict, response.Results[7].Err)
+	assert.NoError(t, response.Results[8].Err)
+	assert.Equal(t, ErrPreconditionFailed, response.Results[9].Err)
+	assert.Equal(t, http.StatusNoContent, response.Results[10].StatusCode)
+	assert.NoError(t, response.Results[11].Err)
+
+	throttled := response.Results[12]
+	assert.NoError(t, throttled.Err)
+	assert.Equal(t, 2, throttled.Attempts)
+
+	// Invalid operations are not sent
+	assert.Error(t, response.Results[13].Err, "replace without id")
+	assert.Equal(t, 0, response.Results[13].Attempts)
+	assert.Error(t, response.Results[14].Err, "unknown operation type")
+
+	assert.Equal(t, 11, response.Succeeded)
+	assert.Equal(t, 4, response.Failed)
+	assert.Equal(t, 12.0, response.RequestCharge)
+	assert.Equal(t, 1, response.Throttled)
+	assert.True(t, response.Duration > 0)
+	assert.True(t, response.OperationsPerSecond() > 0)
+	assert.Contains(t, s.operationTypes, BulkPatch)
+	assert.Contains(t, s.batches, "0")
+	assert.Contains(t, s.batches, "1")
+	for _, batch := range s.batches {
+		assert.Contains(t, []string{"0", "1"}, batch)
+	}
+}
+
+func TestBulkThrottledBatch(t *testing.T) {
+	s := newBulkServer(t)
+	s.throttleBatch = 1
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	c := New(ts.URL, Config{MasterKey: TestKey, MaxRetries: 1}, nil, nil)
+
+	operations := []BulkOperation{{Type: BulkUpsert, PartitionKeyValue: "a", Document: bulkDoc{Id: "a", UserId: "a"}}}
+	response, err := c.Bulk(context.Background(), "db", "coll", operations, BulkOptions{InitialConcurrency: 8})
+	require.NoError(t, err)
+	assert.NoError(t, response.Results[0].Err)
+	assert.Equal(t, 1, response.Throttled)
+	assert.True(t, response.Concurrency < 8, "concurrency is decreased when throttled")
+
+	// A batch throttled until the retries are exceeded halves the limit once
+	// per throttled request, and not again when it is released
+	s.throttleBatch = 2
+	response, err = c.Bulk(context.Background(), "db", "coll", operations, BulkOptions{InitialConcurrency: 8})
+	require.NoError(t, err)
+	assert.NoError(t, response.Results[0].Err)
+	assert.Equal(t, 2, response.Results[0].Attempts)
+	assert.Equal(t, 2, response.Concurrency)
+
+	// The context ends the bulk
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.Bulk(ctx, "db", "coll", operations, BulkOptions{})
+	assert.Error(t, err)
+}
+
+func TestAdaptiveLimit(t *testing.T) {
+	l := newAdaptiveLimit(2, 3)
+	require.NoError(t, l.acquire(context.Background()))
+	require.NoError(t, l.acquire(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, l.acquire(ctx), "limit reached")
+
+	acquired := make(chan error)
+	go func() { acquired <- l.acquire(context.Background()) }()
+	l.release(false)
+	assert.NoError(t, <-acquired)
+	assert.Equal(t, 2, l.current())
+
+	l.release(false)
+	l.release(false)
+	assert.Equal(t, 3, l.current())
+	l.decrease()
+	assert.Equal(t, 1, l.current())
+	l.decrease()
+	assert.Equal(t, 1, l.current(), "never below 1")
+	l.release(true)
+	assert.Equal(t, 1, l.current())
+}
+
+func TestPatchDocument(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPatch, r.Method)
+		assert.True(t, strings.HasSuffix(r.URL.Path, "/dbs/db/colls/coll/docs/doc"))
+		assert.Equal(t, PATCH_CONTENT_TYPE, r.Header.Get(HEADER_CONTYPE))
+		assert.Equal(t, `["pk"]`, r.Header.Get(HEADER_PARTITIONKEY))
+		assert.Equal(t, `"etag"`, r.Header.Get(HEADER_IF_MATCH))
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.JSONEq(t, `{"condition": "from c where c.open", "operations": [
+			{"op": "set", "path": "/x", "value": 0},
+			{"op": "remove", "path": "/y"},
+			{"op": "move", "path": "/b", "from": "/a"}]}`, string(body))
+		w.Header().Set(HEADER_REQUEST_CHARGE, "10")
+		w.Write([]byte(`{"id": "doc", "_etag": "\"etag2\""}`))
+	}))
+	defer ts.Close()
+	c := New(ts.URL, Config{MasterKey: TestKey}, nil, nil)
+
+	resource, response, err := c.PatchDocument(context.Background(), "db", "coll", "doc", []PatchOperation{
+		{Op: PatchSet, Path: "/x", Value: 0},
+		{Op: PatchRemove, Path: "/y"},
+		{Op: PatchMove, Path: "/b", From: "/a"},
+	}, PatchDocumentOptions{PartitionKeyValue: "pk", IfMatch: `"etag"`, Condition: "from c where c.open"})
+	require.NoError(t, err)
+	assert.Equal(t, "doc", resource.Id)
+	assert.Equal(t, `"etag2"`, resource.Etag)
+	assert.Equal(t, 10.0, response.RUs)
+}
@@ -0,0 +1,144 @@
+package cosmosapi
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/logging"
+)
+
+var ErrCircuitOpen = errors.New("Circuit breaker is open; the endpoint failed too many times in a row")
+
+// CircuitBreakerConfig configures a CircuitBreaker.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures (503 Service
+	// Unavailable, 408 Request Timeout or no response) that opens the circuit.
+	// Defaults to 5.
+	FailureThreshold int
+	// OpenDuration is how long the circuit stays open before a single probe
+	// request is let through. Defaults to 30 seconds.
+	OpenDuration time.Duration
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitClosed:
+		return "closed"
+	case circuitOpen:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+type endpointCircuit struct {
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// CircuitBreaker stops sending requests to an endpoint (host) that has failed
+// too many times in a row, failing them with ErrCircuitOpen instead of
+// retrying. After OpenDuration a single probe request is let through: if it
+// succeeds the circuit is closed again, otherwise it is re-opened. Set it on
+// Config.CircuitBreaker; it may be shared between clients.
+type CircuitBreaker struct {
+	cfg       CircuitBreakerConfig
+	mu        sync.Mutex
+	endpoints map[string]*endpointCircuit
+}
+
+func NewCircuitBreaker(cfg CircuitBreakerConfig) *CircuitBreaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 5
+	}
+	if cfg.OpenDuration <= 0 {
+		cfg.OpenDuration = 30 * time.Second
+	}
+	return &CircuitBreaker{cfg: cfg, endpoints: make(map[string]*endpointCircuit)}
+}
+
+func (b *CircuitBreaker) circuit(endpoint string) *endpointCircuit {
+	c, ok := b.endpoints[endpoint]
+	if !ok {
+		c = &endpointCircuit{}
+		b.endpoints[endpoint] = c
+	}
+	return c
+}
+
+func (b *CircuitBreaker) setState(endpoint string, c *endpointCircuit, state circuitState, log logging.ExtendedLogger) {
+	if state == circuitOpen {
+		log.Warnf("Cosmos circuit breaker for %s: %s -> %s after %d consecutive failures\n", endpoint, c.state, state, c.failures)
+	} else {
+		log.Infof("Cosmos circuit breaker for %s: %s -> %s\n", endpoint, c.state, state)
+	}
+	c.state = state
+}
+
+// allow returns ErrCircuitOpen if no request should be sent to endpoint.
+func (b *CircuitBreaker) allow(endpoint string, log logging.ExtendedLogger) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuit(endpoint)
+	switch c.state {
+	case circuitOpen:
+		if time.Since(c.openedAt) < b.cfg.OpenDuration {
+			return ErrCircuitOpen
+		}
+		b.setState(endpoint, c, circuitHalfOpen, log)
+		c.probing = true
+		return nil
+	case circuitHalfOpen:
+		if c.probing {
+			return ErrCircuitOpen
+		}
+		c.probing = true
+		return nil
+	}
+	return nil
+}
+
+// record updates the circuit of endpoint with the outcome of an attempt.
+func (b *CircuitBreaker) record(ctx context.Context, endpoint string, response *Response, err error, log logging.ExtendedLogger) {
+	if err != nil && ctx.Err() != nil {
+		// Cancelled by the caller, which says nothing about the endpoint. Let
+		// another probe through if this was one.
+		b.mu.Lock()
+		b.circuit(endpoint).probing = false
+		b.mu.Unlock()
+		return
+	}
+	failed := err != nil ||
+		response.HTTPResponse.StatusCode == http.StatusServiceUnavailable ||
+		response.HTTPResponse.StatusCode == http.StatusRequestTimeout
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuit(endpoint)
+	c.probing = false
+	if !failed {
+		c.failures = 0
+		if c.state != circuitClosed {
+			b.setState(endpoint, c, circuitClosed, log)
+		}
+		return
+	}
+	c.failures++
+	if c.state == circuitHalfOpen || (c.state == circuitClosed && c.failures >= b.cfg.FailureThreshold) {
+		b.setState(endpoint, c, circuitOpen, log)
+		c.openedAt = time.Now()
+	}
+}
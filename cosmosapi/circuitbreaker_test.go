@@ -0,0 +1,68 @@
+package cosmosapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/go-cosmosdb/logging"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.Adapt(log.New(&buf, "", 0))
+	ctx := context.Background()
+	b := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 2, OpenDuration: 20 * time.Millisecond})
+	unavailable := chargedResponse(http.StatusServiceUnavailable, 0)
+	ok := chargedResponse(http.StatusOK, 0)
+
+	require.NoError(t, b.allow("host", logger))
+	b.record(ctx, "host", unavailable, nil, logger)
+	require.NoError(t, b.allow("host", logger))
+	b.record(ctx, "host", nil, errors.New("timeout"), logger)
+	assert.Equal(t, ErrCircuitOpen, b.allow("host", logger))
+	assert.Contains(t, buf.String(), "closed -> open")
+
+	// Other endpoints are not affected
+	require.NoError(t, b.allow("other", logger))
+
+	// A single probe is let through after OpenDuration
+	time.Sleep(25 * time.Millisecond)
+	require.NoError(t, b.allow("host", logger))
+	assert.Equal(t, ErrCircuitOpen, b.allow("host", logger))
+	b.record(ctx, "host", unavailable, nil, logger)
+	assert.Equal(t, ErrCircuitOpen, b.allow("host", logger))
+
+	time.Sleep(25 * time.Millisecond)
+	require.NoError(t, b.allow("host", logger))
+	b.record(ctx, "host", ok, nil, logger)
+	require.NoError(t, b.allow("host", logger))
+	require.NoError(t, b.allow("host", logger))
+	assert.Contains(t, buf.String(), "half-open -> closed")
+}
+
+func TestCircuitBreakerStopsRetries(t *testing.T) {
+	requests := 0
+	c := New("http://cosmos.invalid", Config{
+		MasterKey:      TestKey,
+		MaxRetries:     5,
+		CircuitBreaker: NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1}),
+		Middlewares: []Middleware{func(next Handler) Handler {
+			return func(ctx context.Context, req *Request) (*Response, error) {
+				requests++
+				return nil, errors.New("connection refused")
+			}
+		}},
+	}, nil, nil)
+	_, err := c.GetDatabase(context.Background(), "db", nil)
+	assert.Error(t, err)
+	_, err = c.GetDatabase(context.Background(), "db", nil)
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, 1, requests)
+}
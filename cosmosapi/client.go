@@ -9,10 +9,13 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/vippsas/go-cosmosdb/logging"
+	"github.com/vippsas/go-cosmosdb/metrics"
+	"github.com/vippsas/go-cosmosdb/tracing"
 )
 
 const (
@@ -20,10 +23,6 @@ const (
 )
 
 var (
-	// TODO: useful?
-	IgnoreContext bool
-	// TODO: check thread safety
-	ResponseHook            func(ctx context.Context, method string, headers map[string][]string)
 	errUnexpectedHTTPStatus = errors.New("Unexpected HTTP return status")
 )
 
@@ -48,6 +47,26 @@ func parseHttpResponse(httpResponse *http.Response) (ResponseBase, error) {
 type Config struct {
 	MasterKey  string
 	MaxRetries int
+	// ResourceToken, if set, authorizes requests instead of MasterKey. It is
+	// the token of a permission, like "type=resource&ver=1.0&sig=...".
+	ResourceToken string
+	// Middlewares wrap every attempt of every request sent by the client. The
+	// first middleware is the outermost one.
+	Middlewares []Middleware
+	// Tracer, if set, gets a span for every operation and a child span for
+	// every attempt of it.
+	Tracer tracing.Tracer
+	// Metrics, if set, gets an observation for every operation and every
+	// attempt of it.
+	Metrics metrics.Metrics
+	// RateLimiter, if set, limits the request units spent by all requests of
+	// the client. See also WithRateLimiter.
+	RateLimiter *RateLimiter
+	// CircuitBreaker, if set, fails requests to an endpoint that keeps failing
+	// with ErrCircuitOpen instead of retrying them.
+	CircuitBreaker *CircuitBreaker
+	// Hedging, if set, sends a second request for slow reads of documents.
+	Hedging *Hedging
 }
 
 type Client struct {
@@ -55,6 +74,10 @@ type Client struct {
 	Config Config
 	Client *http.Client
 	Log    logging.ExtendedLogger
+
+	// routingMaps is the cache of ReadMany when none is given
+	routingMapsOnce sync.Once
+	routingMaps     *RoutingMapCache
 }
 
 // New makes a new client to communicate to a cosmosdb instance.
@@ -77,6 +100,14 @@ func New(url string, cfg Config, cl *http.Client, log logging.StdLogger) *Client
 	return client
 }
 
+// defaultRoutingMaps returns the routing map cache of the client
+func (c *Client) defaultRoutingMaps() *RoutingMapCache {
+	c.routingMapsOnce.Do(func() {
+		c.routingMaps = NewRoutingMapCache(c)
+	})
+	return c.routingMaps
+}
+
 func (c *Client) get(ctx context.Context, link string, ret interface{}, headers map[string]string) (*http.Response, error) {
 	return c.method(ctx, "GET", link, ret, nil, headers)
 }
@@ -115,7 +146,7 @@ func (c *Client) method(ctx context.Context, method, link string, ret interface{
 		c.Log.Errorln(err)
 		return nil, err
 	}
-	defaultHeaders, err := defaultHeaders(method, link, c.Config.MasterKey)
+	defaultHeaders, err := defaultHeaders(method, link, c.Config)
 	if err != nil {
 		return nil, errors.WithMessage(err, "Failed to create request headers")
 	}
@@ -129,7 +160,7 @@ func (c *Client) method(ctx context.Context, method, link string, ret interface{
 	for k, v := range headers {
 		req.Header.Add(k, v)
 	}
-	return c.do(ctx, req, ret)
+	return c.do(ctx, newRequest(method, link, headers, req), ret)
 }
 
 func retriable(code int) bool {
@@ -159,29 +190,32 @@ func (c *Client) checkResponse(resp *http.Response) error {
 }
 
 // Private Do function, DRY
-func (c *Client) do(ctx context.Context, r *http.Request, data interface{}) (*http.Response, error) {
-	cli := c.Client
-	if cli == nil {
-		cli = http.DefaultClient
-	}
-	if !IgnoreContext {
-		r = r.WithContext(ctx)
-	}
+func (c *Client) do(ctx context.Context, req *Request, data interface{}) (resp *http.Response, err error) {
+	ctx, span := tracing.OrNoop(c.Config.Tracer).Start(ctx, req.OperationName())
+	defer func() { tracing.End(span, err) }()
+	setRequestAttributes(span, req)
+	observation := metrics.Operation{Labels: metricsLabels(req)}
+	start := time.Now()
+	defer func() {
+		observation.Latency = time.Since(start)
+		metrics.OrNoop(c.Config.Metrics).ObserveOperation(observation)
+	}()
+
+	r := req.HTTPRequest
 	// save body to be able to retry the request
 	b := []byte{}
 	if r.Body != nil {
-		var err error
 		b, err = ioutil.ReadAll(r.Body)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	var resp *http.Response
+	send := chain(c.send, c.Config.Middlewares)
 	for retryCount := 0; retryCount <= c.Config.MaxRetries; retryCount++ {
-		var err error
+		var delay time.Duration
 		if retryCount > 0 {
-			delay := backoffDelay(retryCount)
+			delay = backoffDelay(retryCount)
 			t := time.NewTimer(delay)
 			select {
 			case <-ctx.Done():
@@ -192,14 +226,28 @@ func (c *Client) do(ctx context.Context, r *http.Request, data interface{}) (*ht
 		}
 
 		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(b)), nil
+		}
+		req.Attempt = retryCount + 1
 		c.Log.Debugf("Cosmos request: %s %s (headers: %s) (attempt: %d/%d)\n", r.Method, r.URL, r.Header, retryCount+1, c.Config.MaxRetries)
-		resp, err = cli.Do(r)
+		var response *Response
+		response, err = c.attempt(ctx, send, req, delay)
+		observation.Attempts = req.Attempt
 		if err != nil {
 			return nil, err
 		}
+		resp = response.HTTPResponse
+		observation.StatusCode = resp.StatusCode
+		observation.RequestCharge += response.RequestCharge
+		setResponseAttributes(span, resp, observation.RequestCharge)
+		span.SetAttribute(tracing.Attempt, req.Attempt)
 		c.Log.Debugf("Cosmos response: %s (headers: %s)", resp.Status, resp.Header)
-		err = c.handleResponse(ctx, r, resp, data)
+		err = c.handleResponse(resp, data)
 		if err == errRetry {
+			if resp.StatusCode == http.StatusTooManyRequests {
+				notifyThrottled(ctx)
+			}
 			continue
 		}
 		return resp, err
@@ -207,12 +255,102 @@ func (c *Client) do(ctx context.Context, r *http.Request, data interface{}) (*ht
 	return resp, ErrMaxRetriesExceeded
 }
 
-func (c *Client) handleResponse(ctx context.Context, req *http.Request, resp *http.Response, ret interface{}) error {
+// attempt sends a single attempt of req through the middleware chain, in its
+// own span.
+func (c *Client) attempt(ctx context.Context, send Handler, req *Request, delay time.Duration) (response *Response, err error) {
+	ctx, span := tracing.OrNoop(c.Config.Tracer).Start(ctx, req.OperationName()+" attempt")
+	defer func() { tracing.End(span, err) }()
+	setRequestAttributes(span, req)
+	span.SetAttribute(tracing.Attempt, req.Attempt)
+	span.SetAttribute(tracing.RetryDelay, int64(delay/time.Millisecond))
+	endpoint := req.HTTPRequest.URL.Host
+	if breaker := c.Config.CircuitBreaker; breaker != nil {
+		if err = breaker.allow(endpoint, c.Log); err != nil {
+			return nil, err
+		}
+		defer func() { breaker.record(ctx, endpoint, response, err, c.Log) }()
+	}
+	for _, limiter := range c.rateLimiters(ctx) {
+		var reservation *rateLimiterReservation
+		reservation, err = limiter.reserve(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { reservation.reconcile(response) }()
+	}
+	observation := metrics.Attempt{Labels: metricsLabels(req), Attempt: req.Attempt}
+	start := time.Now()
+	response, err = c.hedgedSend(ctx, send, req)
+	observation.Latency = time.Since(start)
+	if err == nil {
+		setResponseAttributes(span, response.HTTPResponse, response.RequestCharge)
+		observation.StatusCode = response.HTTPResponse.StatusCode
+		observation.RequestCharge = response.RequestCharge
+	}
+	metrics.OrNoop(c.Config.Metrics).ObserveAttempt(observation)
+	return response, err
+}
+
+func metricsLabels(req *Request) metrics.Labels {
+	return metrics.Labels{
+		Database:   req.DatabaseName(),
+		Collection: req.CollectionName(),
+		Operation:  req.OperationName(),
+	}
+}
+
+func setRequestAttributes(span tracing.Span, req *Request) {
+	span.SetAttribute(tracing.DBSystem, tracing.DBSystemCosmosDB)
+	span.SetAttribute(tracing.DBOperation, req.OperationName())
+	if dbName := req.DatabaseName(); dbName != "" {
+		span.SetAttribute(tracing.DBName, dbName)
+	}
+	if collName := req.CollectionName(); collName != "" {
+		span.SetAttribute(tracing.DBCollection, collName)
+	}
+}
+
+func setResponseAttributes(span tracing.Span, resp *http.Response, requestCharge float64) {
+	span.SetAttribute(tracing.StatusCode, resp.StatusCode)
+	if subStatus := resp.Header.Get(HEADER_SUBSTATUS); subStatus != "" {
+		span.SetAttribute(tracing.SubStatusCode, subStatus)
+	}
+	if activityId := resp.Header.Get(HEADER_ACTIVITY_ID); activityId != "" {
+		span.SetAttribute(tracing.ActivityId, activityId)
+	}
+	span.SetAttribute(tracing.RequestCharge, requestCharge)
+}
+
+// send is the innermost Handler of the middleware chain, doing the actual
+// HTTP round trip.
+func (c *Client) send(ctx context.Context, req *Request) (*Response, error) {
+	cli := c.Client
+	if cli == nil {
+		cli = http.DefaultClient
+	}
+	httpResponse, err := cli.Do(req.HTTPRequest.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	response := &Response{HTTPResponse: httpResponse}
+	// A malformed charge header should not fail the request
+	responseBase, _ := parseHttpResponse(httpResponse)
+	response.RequestCharge = responseBase.RequestCharge
+	return response, nil
+}
+
+// statusAccepter is implemented by return values of requests for which a
+// status code that is an error for other requests is a success
+type statusAccepter interface {
+	acceptsStatus(code int) bool
+}
+
+func (c *Client) handleResponse(resp *http.Response, ret interface{}) error {
 	defer resp.Body.Close()
-	if ResponseHook != nil {
-		ResponseHook(ctx, req.Method, resp.Header)
+	var err error
+	if accepter, ok := ret.(statusAccepter); !ok || !accepter.acceptsStatus(resp.StatusCode) {
+		err = c.checkResponse(resp)
 	}
-	err := c.checkResponse(resp)
 
 	if err != nil {
 		b, readErr := ioutil.ReadAll(resp.Body)
@@ -228,7 +366,11 @@ func (c *Client) handleResponse(ctx context.Context, req *http.Request, resp *ht
 	if resp.ContentLength == 0 {
 		return nil
 	}
-	err = readJson(resp.Body, ret)
+	if decoder, ok := ret.(bodyDecoder); ok {
+		err = decoder.decodeBody(resp.Body)
+	} else {
+		err = readJson(resp.Body, ret)
+	}
 	// even if JSON parsing failed, we still want to consume all bytes from Body
 	// in order to reuse the connection.
 	io.Copy(ioutil.Discard, resp.Body)
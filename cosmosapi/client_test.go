@@ -7,6 +7,9 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/go-cosmosdb/metrics"
+	"github.com/vippsas/go-cosmosdb/tracing"
 )
 
 func TestOne(t *testing.T) {
@@ -29,3 +32,119 @@ func TestOne(t *testing.T) {
 	_, err := c.GetDatabase(context.Background(), "ToDoList", nil)
 	assert.NotNil(t, err)
 }
+
+type recordedSpan struct {
+	name       string
+	parent     *recordedSpan
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) { s.attributes[key] = value }
+func (s *recordedSpan) RecordError(err error)                      { s.err = err }
+func (s *recordedSpan) End()                                       { s.ended = true }
+
+type spanKey struct{}
+
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, tracing.Span) {
+	parent, _ := ctx.Value(spanKey{}).(*recordedSpan)
+	span := &recordedSpan{name: name, parent: parent, attributes: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func TestTracing(t *testing.T) {
+	attempt := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt++
+		w.Header().Set(HEADER_REQUEST_CHARGE, "1.5")
+		w.Header().Set(HEADER_ACTIVITY_ID, "activity")
+		if attempt == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": "doc"}`))
+	}))
+	defer ts.Close()
+
+	tracer := &recordingTracer{}
+	c := New(ts.URL, Config{MasterKey: TestKey, MaxRetries: 1, Tracer: tracer}, nil, nil)
+	_, _, err := c.CreateDocument(context.Background(), "db", "coll", Document{}, CreateDocumentOptions{})
+	require.NoError(t, err)
+
+	require.Len(t, tracer.spans, 3)
+	op := tracer.spans[0]
+	assert.Equal(t, "CreateDocument", op.name)
+	assert.Nil(t, op.parent)
+	assert.True(t, op.ended)
+	assert.Equal(t, "db", op.attributes[tracing.DBName])
+	assert.Equal(t, "coll", op.attributes[tracing.DBCollection])
+	assert.Equal(t, http.StatusCreated, op.attributes[tracing.StatusCode])
+	assert.Equal(t, 3.0, op.attributes[tracing.RequestCharge])
+	assert.Equal(t, 2, op.attributes[tracing.Attempt])
+
+	for i, span := range tracer.spans[1:] {
+		assert.Equal(t, "CreateDocument attempt", span.name)
+		assert.Equal(t, op, span.parent)
+		assert.True(t, span.ended)
+		assert.Equal(t, i+1, span.attributes[tracing.Attempt])
+		assert.Equal(t, "activity", span.attributes[tracing.ActivityId])
+		assert.Equal(t, 1.5, span.attributes[tracing.RequestCharge])
+	}
+	assert.Equal(t, http.StatusTooManyRequests, tracer.spans[1].attributes[tracing.StatusCode])
+	assert.Equal(t, int64(0), tracer.spans[1].attributes[tracing.RetryDelay])
+	assert.NotEqual(t, int64(0), tracer.spans[2].attributes[tracing.RetryDelay])
+}
+
+type recordingMetrics struct {
+	attempts   []metrics.Attempt
+	operations []metrics.Operation
+}
+
+func (m *recordingMetrics) ObserveAttempt(a metrics.Attempt) { m.attempts = append(m.attempts, a) }
+func (m *recordingMetrics) ObserveOperation(o metrics.Operation) {
+	m.operations = append(m.operations, o)
+}
+
+func TestMetrics(t *testing.T) {
+	attempt := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt++
+		w.Header().Set(HEADER_REQUEST_CHARGE, "2")
+		if attempt == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`[]`))
+	}))
+	defer ts.Close()
+
+	m := &recordingMetrics{}
+	c := New(ts.URL, Config{MasterKey: TestKey, MaxRetries: 1, Metrics: m}, nil, nil)
+	response, err := c.ExecuteStoredProcedureWithResponse(context.Background(), "db", "coll", "sproc", ExecuteStoredProcedureOptions{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, response.RequestCharge)
+
+	labels := metrics.Labels{Database: "db", Collection: "coll", Operation: "ExecuteStoredProcedure"}
+	require.Len(t, m.attempts, 2)
+	assert.Equal(t, labels, m.attempts[0].Labels)
+	assert.True(t, m.attempts[0].Throttled())
+	assert.Equal(t, 2, m.attempts[1].Attempt)
+	assert.False(t, m.attempts[1].Throttled())
+	assert.Equal(t, 2.0, m.attempts[1].RequestCharge)
+
+	require.Len(t, m.operations, 1)
+	op := m.operations[0]
+	assert.Equal(t, labels, op.Labels)
+	assert.Equal(t, 1, op.Retries())
+	assert.Equal(t, http.StatusOK, op.StatusCode)
+	assert.Equal(t, 4.0, op.RequestCharge)
+	assert.True(t, op.Latency >= m.attempts[0].Latency+m.attempts[1].Latency)
+}
@@ -2,12 +2,14 @@ package cosmosapi
 
 import (
 	"context"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
 var (
 	ErrThroughputRequiresPartitionKey = errors.New("Must specify PartitionKey when OfferThroughput is >= 10000")
+	ErrThroughputAndAutoscale         = errors.New("Can not specify both OfferThroughput and AutoscaleMaxThroughput")
 )
 
 type Collection struct {
@@ -19,6 +21,17 @@ type Collection struct {
 	Triggers       string          `json:"_triggers,omitempty"`
 	Conflicts      string          `json:"_conflicts,omitempty"`
 	PartitionKey   *PartitionKey   `json:"partitionKey,omitempty"`
+	// DefaultTimeToLive is 0 if documents never expire, -1 if they do not
+	// expire unless they have a ttl, and the default ttl in seconds otherwise
+	DefaultTimeToLive int `json:"defaultTtl,omitempty"`
+	// UniqueKeyPolicy can only be set when the collection is created
+	UniqueKeyPolicy *UniqueKeyPolicy `json:"uniqueKeyPolicy,omitempty"`
+	// AnalyticalStorageTimeToLive is 0 if the analytical store is disabled, -1
+	// if its data never expires, and the ttl in seconds otherwise. It can not
+	// be disabled once enabled.
+	AnalyticalStorageTimeToLive int                `json:"analyticalStorageTtl,omitempty"`
+	ComputedProperties          []ComputedProperty `json:"computedProperties,omitempty"`
+	GeospatialConfig            *GeospatialConfig  `json:"geospatialConfig,omitempty"`
 }
 
 type DocumentCollection struct {
@@ -37,6 +50,39 @@ type IndexingPolicy struct {
 
 type IndexingMode string
 
+// UniqueKeyPolicy makes the combined values of the paths of each unique key
+// unique within a logical partition
+type UniqueKeyPolicy struct {
+	UniqueKeys []UniqueKey `json:"uniqueKeys"`
+}
+
+type UniqueKey struct {
+	Paths []string `json:"paths"`
+}
+
+// ComputedProperty is a property of every document computed by a query, like
+// "SELECT VALUE LOWER(c.name) FROM c". It can be indexed and queried like
+// other properties.
+type ComputedProperty struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// GeospatialConfig tells how spatial data is indexed
+type GeospatialConfig struct {
+	Type GeospatialType `json:"type"`
+}
+
+type GeospatialType string
+
+const (
+	// GeospatialGeography is round-earth coordinates, and the default
+	GeospatialGeography = GeospatialType("Geography")
+	// GeospatialGeometry is a flat coordinate system, and requires bounding
+	// boxes in the spatial indexes of the indexing policy
+	GeospatialGeometry = GeospatialType("Geometry")
+)
+
 //const (
 //	Consistent = IndexingMode("Consistent")
 //	Lazy       = IndexingMode("Lazy")
@@ -50,15 +96,53 @@ type IndexingMode string
 
 type PartitionKey struct {
 	Paths []string `json:"paths"`
-	Kind  string   `json:"kind"`
+	// Kind is "Hash", which is the default if empty, or "MultiHash" for
+	// hierarchical partition keys with up to MaxHierarchicalPartitionKeyPaths
+	// paths
+	Kind string `json:"kind"`
+	// Version of the hash function; 1 (the default) or 2 (required for
+	// partition key values longer than 100 bytes, and for MultiHash)
+	Version int `json:"version,omitempty"`
+}
+
+// Validate checks that the partition key definition is one Cosmos accepts
+func (pk PartitionKey) Validate() error {
+	switch pk.Kind {
+	case PartitionKindHash, "":
+		if len(pk.Paths) != 1 {
+			return errors.Errorf("Partition key of kind %s must have exactly one path, got %d", PartitionKindHash, len(pk.Paths))
+		}
+	case PartitionKindMultiHash:
+		if len(pk.Paths) == 0 || len(pk.Paths) > MaxHierarchicalPartitionKeyPaths {
+			return errors.Errorf("Partition key of kind %s must have 1 to %d paths, got %d", pk.Kind, MaxHierarchicalPartitionKeyPaths, len(pk.Paths))
+		}
+		if pk.Version != 2 {
+			return errors.Errorf("Partition key of kind %s must have version 2", pk.Kind)
+		}
+	default:
+		return errors.Errorf("Unknown partition key kind '%s'", pk.Kind)
+	}
+	for _, path := range pk.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return errors.Errorf("Partition key path '%s' must start with /", path)
+		}
+	}
+	return nil
 }
 
+// CollectionReplaceOptions are the new properties of a collection. The
+// partition key and unique key policy can not be changed, but must be given
+// if the collection has them.
 type CollectionReplaceOptions struct {
 	Resource
-	Id                string          `json:"id"`
-	IndexingPolicy    *IndexingPolicy `json:"indexingPolicy,omitempty"`
-	PartitionKey      *PartitionKey   `json:"partitionKey,omitempty"`
-	DefaultTimeToLive int             `json:"defaultTtl,omitempty"`
+	Id                          string             `json:"id"`
+	IndexingPolicy              *IndexingPolicy    `json:"indexingPolicy,omitempty"`
+	PartitionKey                *PartitionKey      `json:"partitionKey,omitempty"`
+	DefaultTimeToLive           int                `json:"defaultTtl,omitempty"`
+	UniqueKeyPolicy             *UniqueKeyPolicy   `json:"uniqueKeyPolicy,omitempty"`
+	AnalyticalStorageTimeToLive int                `json:"analyticalStorageTtl,omitempty"`
+	ComputedProperties          []ComputedProperty `json:"computedProperties,omitempty"`
+	GeospatialConfig            *GeospatialConfig  `json:"geospatialConfig,omitempty"`
 }
 
 func (c *Client) GetCollection(ctx context.Context, dbName, colName string) (*Collection, error) {
@@ -0,0 +1,59 @@
+package cosmosapi
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectionProperties(t *testing.T) {
+	const properties = `{
+		"id": "coll",
+		"partitionKey": {"paths": ["/tenant"], "kind": "Hash"},
+		"defaultTtl": -1,
+		"uniqueKeyPolicy": {"uniqueKeys": [{"paths": ["/email"]}, {"paths": ["/first", "/last"]}]},
+		"analyticalStorageTtl": -1,
+		"computedProperties": [{"name": "lowerName", "query": "SELECT VALUE LOWER(c.name) FROM c"}],
+		"geospatialConfig": {"type": "Geometry"}
+	}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			body, _ := ioutil.ReadAll(r.Body)
+			assert.JSONEq(t, properties, string(body))
+			w.WriteHeader(http.StatusCreated)
+		}
+		w.Write([]byte(properties))
+	}))
+	defer ts.Close()
+	c := New(ts.URL, Config{MasterKey: TestKey}, nil, nil)
+
+	expected := Collection{
+		Resource:                    Resource{Id: "coll"},
+		PartitionKey:                &PartitionKey{Paths: []string{"/tenant"}, Kind: PartitionKindHash},
+		DefaultTimeToLive:           -1,
+		UniqueKeyPolicy:             &UniqueKeyPolicy{UniqueKeys: []UniqueKey{{Paths: []string{"/email"}}, {Paths: []string{"/first", "/last"}}}},
+		AnalyticalStorageTimeToLive: -1,
+		ComputedProperties:          []ComputedProperty{{Name: "lowerName", Query: "SELECT VALUE LOWER(c.name) FROM c"}},
+		GeospatialConfig:            &GeospatialConfig{Type: GeospatialGeometry},
+	}
+	response, err := c.CreateCollection(context.Background(), "db", CreateCollectionOptions{
+		Id:                          expected.Id,
+		PartitionKey:                expected.PartitionKey,
+		DefaultTimeToLive:           expected.DefaultTimeToLive,
+		UniqueKeyPolicy:             expected.UniqueKeyPolicy,
+		AnalyticalStorageTimeToLive: expected.AnalyticalStorageTimeToLive,
+		ComputedProperties:          expected.ComputedProperties,
+		GeospatialConfig:            expected.GeospatialConfig,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, expected, response.Collection)
+
+	collection, err := c.GetCollection(context.Background(), "db", "coll")
+	require.NoError(t, err)
+	assert.Equal(t, expected, *collection)
+}
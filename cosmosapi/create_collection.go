@@ -14,9 +14,20 @@ type CreateCollectionOptions struct {
 
 	// RTUs [400 - 250000]. Do not use in combination with OfferType
 	OfferThroughput OfferThroughput `json:"offerThroughput,omitempty"`
+	// AutoscaleMaxThroughput provisions autoscale throughput, which scales
+	// between 10% of the max and the max. Do not use in combination with
+	// OfferThroughput
+	AutoscaleMaxThroughput OfferThroughput `json:"-"`
 	// S1,S2,S3. Do not use in combination with OfferThroughput
 	OfferType         OfferType `json:"offerType,omitempty"`
 	DefaultTimeToLive int       `json:"defaultTtl,omitempty"`
+
+	UniqueKeyPolicy *UniqueKeyPolicy `json:"uniqueKeyPolicy,omitempty"`
+	// AnalyticalStorageTimeToLive enables the analytical store if not 0. -1
+	// keeps its data forever.
+	AnalyticalStorageTimeToLive int                `json:"analyticalStorageTtl,omitempty"`
+	ComputedProperties          []ComputedProperty `json:"computedProperties,omitempty"`
+	GeospatialConfig            *GeospatialConfig  `json:"geospatialConfig,omitempty"`
 }
 
 type CreateCollectionResponse struct {
@@ -31,7 +42,14 @@ func (colOps CreateCollectionOptions) asHeaders() (map[string]string, error) {
 		headers[HEADER_OFFER_THROUGHPUT] = fmt.Sprintf("%d", colOps.OfferThroughput)
 	}
 
-	if colOps.OfferThroughput >= 10000 && colOps.PartitionKey == nil {
+	if colOps.AutoscaleMaxThroughput > 0 {
+		if colOps.OfferThroughput > 0 {
+			return nil, ErrThroughputAndAutoscale
+		}
+		headers[HEADER_OFFER_AUTOPILOT] = AutoscaleSettings(colOps.AutoscaleMaxThroughput)
+	}
+
+	if (colOps.OfferThroughput >= 10000 || colOps.AutoscaleMaxThroughput >= 10000) && colOps.PartitionKey == nil {
 		return nil, ErrThroughputRequiresPartitionKey
 	}
 
@@ -39,6 +57,12 @@ func (colOps CreateCollectionOptions) asHeaders() (map[string]string, error) {
 		headers[HEADER_OFFER_TYPE] = fmt.Sprintf("%s", colOps.OfferType)
 	}
 
+	if colOps.PartitionKey != nil {
+		if err := colOps.PartitionKey.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	return headers, nil
 }
 
@@ -21,9 +21,20 @@ func createDatabaseLink(dbName string) string {
 
 // https://docs.microsoft.com/en-us/rest/api/cosmos-db/create-a-database
 func (c *Client) CreateDatabase(ctx context.Context, dbName string, ops *RequestOptions) (*Database, error) {
+	// add optional headers, like ReqOpOfferThroughput or
+	// ReqOpOfferAutopilotSettings to provision throughput shared by the
+	// collections of the database
+	headers := map[string]string{}
+
+	if ops != nil {
+		for k, v := range *ops {
+			headers[string(k)] = v
+		}
+	}
+
 	db := &Database{}
 
-	_, err := c.create(ctx, createDatabaseLink(""), CreateDatabaseOptions{dbName}, db, nil)
+	_, err := c.create(ctx, createDatabaseLink(""), CreateDatabaseOptions{dbName}, db, headers)
 	if err != nil {
 		return nil, err
 	}
@@ -31,8 +42,31 @@ func (c *Client) CreateDatabase(ctx context.Context, dbName string, ops *Request
 	return db, nil
 }
 
+type databases struct {
+	Rid       string     `json:"_rid,omitempty"`
+	Count     int32      `json:"_count,omitempty"`
+	Databases []Database `json:"Databases"`
+}
+
+// https://docs.microsoft.com/en-us/rest/api/cosmos-db/list-databases
 func (c *Client) ListDatabases(ctx context.Context, ops *RequestOptions) ([]Database, error) {
-	return nil, ErrorNotImplemented
+	// add optional headers
+	headers := map[string]string{}
+
+	if ops != nil {
+		for k, v := range *ops {
+			headers[string(k)] = v
+		}
+	}
+
+	dbs := &databases{}
+
+	_, err := c.get(ctx, createDatabaseLink(""), dbs, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return dbs.Databases, nil
 }
 
 func (c *Client) GetDatabase(ctx context.Context, dbName string, ops *RequestOptions) (*Database, error) {
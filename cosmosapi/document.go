@@ -1,7 +1,9 @@
 package cosmosapi
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"net/http"
 	"strconv"
 	"strings"
@@ -147,6 +149,10 @@ func (c *Client) GetDocument(ctx context.Context, dbName, colName, id string,
 
 	resp, err := c.get(ctx, link, out, headers)
 	if err != nil {
+		if resp != nil {
+			// Failed reads are charged too, like those of missing documents
+			return parseDocumentResponse(resp), err
+		}
 		return DocumentResponse{}, err
 	}
 	return parseDocumentResponse(resp), nil
@@ -269,3 +275,112 @@ func (c *Client) DeleteDocument(ctx context.Context, dbName, colName, id string,
 
 	return parseDocumentResponse(resp), nil
 }
+
+const PATCH_CONTENT_TYPE = "application/json_patch+json"
+
+// PatchOperationType is the type of a PatchOperation.
+type PatchOperationType string
+
+const (
+	PatchAdd       = PatchOperationType("add")
+	PatchSet       = PatchOperationType("set")
+	PatchReplace   = PatchOperationType("replace")
+	PatchRemove    = PatchOperationType("remove")
+	PatchIncrement = PatchOperationType("incr")
+	PatchMove      = PatchOperationType("move")
+)
+
+// PatchOperation is a single change of a document by PatchDocument.
+type PatchOperation struct {
+	Op PatchOperationType `json:"op"`
+	// Path of the property to change, like "/customer/name"
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+	// From is the path to move from, for PatchMove
+	From string `json:"from,omitempty"`
+}
+
+// MarshalJSON leaves out the value of operations that take none, while
+// keeping zero values like 0 or false of those that do.
+func (op PatchOperation) MarshalJSON() ([]byte, error) {
+	type patchOperation PatchOperation
+	if op.Op == PatchRemove || op.Op == PatchMove {
+		return json.Marshal(struct {
+			Op   PatchOperationType `json:"op"`
+			Path string             `json:"path"`
+			From string             `json:"from,omitempty"`
+		}{op.Op, op.Path, op.From})
+	}
+	return json.Marshal(patchOperation(op))
+}
+
+// PatchDocumentOptions contains all options that can be used for patching
+// documents.
+type PatchDocumentOptions struct {
+	PartitionKeyValue interface{}
+	IfMatch           string
+	// Condition is a filter predicate like "from c where c.status = 'open'"
+	// that the document must satisfy for the patch to be applied.
+	Condition           string
+	PreTriggersInclude  []string
+	PostTriggersInclude []string
+}
+
+func (ops PatchDocumentOptions) AsHeaders() (map[string]string, error) {
+	headers := map[string]string{}
+
+	if ops.PartitionKeyValue != nil {
+		v, err := MarshalPartitionKeyHeader(ops.PartitionKeyValue)
+		if err != nil {
+			return nil, err
+		}
+		headers[HEADER_PARTITIONKEY] = v
+	}
+
+	headers[HEADER_CONTYPE] = PATCH_CONTENT_TYPE
+
+	if ops.IfMatch != "" {
+		headers[HEADER_IF_MATCH] = ops.IfMatch
+	}
+
+	if len(ops.PreTriggersInclude) > 0 {
+		headers[HEADER_TRIGGER_PRE_INCLUDE] = strings.Join(ops.PreTriggersInclude, ",")
+	}
+
+	if len(ops.PostTriggersInclude) > 0 {
+		headers[HEADER_TRIGGER_POST_INCLUDE] = strings.Join(ops.PostTriggersInclude, ",")
+	}
+
+	return headers, nil
+}
+
+// patchBody is the body of a patch request
+type patchBody struct {
+	Condition  string           `json:"condition,omitempty"`
+	Operations []PatchOperation `json:"operations"`
+}
+
+// PatchDocument applies the operations to a document, without having to
+// read and replace the whole document.
+func (c *Client) PatchDocument(ctx context.Context, dbName, colName, id string,
+	operations []PatchOperation, ops PatchDocumentOptions) (*Resource, DocumentResponse, error) {
+
+	headers, err := ops.AsHeaders()
+	if err != nil {
+		return nil, DocumentResponse{}, err
+	}
+	data, err := stringify(patchBody{Condition: ops.Condition, Operations: operations})
+	if err != nil {
+		return nil, DocumentResponse{}, err
+	}
+
+	link := createDocLink(dbName, colName, id)
+	resource := &Resource{}
+
+	response, err := c.method(ctx, http.MethodPatch, link, resource, bytes.NewBuffer(data), headers)
+	if err != nil {
+		return nil, DocumentResponse{}, err
+	}
+
+	return resource, parseDocumentResponse(response), nil
+}
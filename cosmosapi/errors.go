@@ -56,3 +56,10 @@ var (
 		http.StatusServiceUnavailable:    ErrUnavailable,
 	}
 )
+
+// IsAuthError returns whether an error is caused by a missing or invalid
+// authorization for the request
+func IsAuthError(err error) bool {
+	cause := errors.Cause(err)
+	return cause == ErrUnautorized || cause == ErrForbidden
+}
@@ -0,0 +1,251 @@
+package cosmosapi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HedgingConfig configures Hedging.
+type HedgingConfig struct {
+	// Delay is how long to wait for a response before sending the hedged
+	// request. If zero, the observed Percentile latency of the operation is
+	// used.
+	Delay time.Duration
+	// Percentile of the observed latencies to use as delay. Defaults to 0.99.
+	Percentile float64
+	// MinSamples is the number of latencies to observe for an operation before
+	// hedging it when Delay is zero. Defaults to 100.
+	MinSamples int
+	// WindowSize is the number of latest latencies kept per operation.
+	// Defaults to 1000.
+	WindowSize int
+}
+
+// Hedging sends a second, hedged request for idempotent reads of documents
+// (GetDocument, QueryDocuments and ListDocuments) that have not completed
+// within a latency threshold, uses the response that arrives first and
+// cancels the other request. Set it on Config.Hedging.
+//
+// The hedged request is part of the attempt of the request it hedges: it is
+// not reserved separately from the rate limiters nor allowed separately by
+// the circuit breaker, and only the charge of the response used is reconciled
+// and observed.
+type Hedging struct {
+	cfg     HedgingConfig
+	mu      sync.Mutex
+	windows map[string]*latencyWindow
+}
+
+func NewHedging(cfg HedgingConfig) *Hedging {
+	if cfg.Percentile <= 0 || cfg.Percentile >= 1 {
+		cfg.Percentile = 0.99
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = 100
+	}
+	if cfg.WindowSize < cfg.MinSamples {
+		cfg.WindowSize = 1000
+		if cfg.WindowSize < cfg.MinSamples {
+			cfg.WindowSize = cfg.MinSamples
+		}
+	}
+	return &Hedging{cfg: cfg, windows: make(map[string]*latencyWindow)}
+}
+
+// latencyWindow is a ring buffer of the latest latencies of an operation
+type latencyWindow struct {
+	latencies []time.Duration
+	next      int
+	// percentile is recomputed when used after recomputeEvery observations
+	percentile      time.Duration
+	sinceRecomputed int
+}
+
+const recomputeEvery = 10
+
+func (w *latencyWindow) observe(d time.Duration, size int) {
+	if len(w.latencies) < size {
+		w.latencies = append(w.latencies, d)
+	} else {
+		w.latencies[w.next] = d
+		w.next = (w.next + 1) % size
+	}
+	w.sinceRecomputed++
+}
+
+func (w *latencyWindow) getPercentile(percentile float64) time.Duration {
+	if w.percentile == 0 || w.sinceRecomputed >= recomputeEvery {
+		sorted := append([]time.Duration(nil), w.latencies...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		w.percentile = sorted[int(float64(len(sorted)-1)*percentile)]
+		w.sinceRecomputed = 0
+	}
+	return w.percentile
+}
+
+func (h *Hedging) observe(operation string, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w, ok := h.windows[operation]
+	if !ok {
+		w = &latencyWindow{}
+		h.windows[operation] = w
+	}
+	w.observe(d, h.cfg.WindowSize)
+}
+
+// delay returns how long to wait before hedging operation, or false if it
+// should not be hedged.
+func (h *Hedging) delay(operation string) (time.Duration, bool) {
+	if h.cfg.Delay > 0 {
+		return h.cfg.Delay, true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w, ok := h.windows[operation]
+	if !ok || len(w.latencies) < h.cfg.MinSamples {
+		return 0, false
+	}
+	return w.getPercentile(h.cfg.Percentile), true
+}
+
+func hedgeable(req *Request) bool {
+	if req.ResourceType != "docs" {
+		return false
+	}
+	switch req.OperationType {
+	case OperationRead, OperationQuery, OperationReadFeed:
+		return true
+	}
+	return false
+}
+
+// cancelOnClose cancels the context of a request when its response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+type hedgeResult struct {
+	index    int
+	response *Response
+	err      error
+}
+
+// hedgeWins returns whether a result can be used without waiting for the other
+// request, which is when it is neither an error nor throttled or unavailable,
+// as the other request may still succeed.
+func hedgeWins(result hedgeResult) bool {
+	return result.err == nil && !retriable(result.response.HTTPResponse.StatusCode)
+}
+
+// cloneRequest returns a copy of r with ctx that can be sent at the same time
+// as r
+func cloneRequest(ctx context.Context, r *http.Request) *http.Request {
+	clone := r.WithContext(ctx)
+	clone.Header = make(http.Header, len(r.Header))
+	for name, values := range r.Header {
+		clone.Header[name] = append([]string(nil), values...)
+	}
+	if r.GetBody != nil {
+		clone.Body, _ = r.GetBody()
+	}
+	return clone
+}
+
+// hedgedSend sends req, and if it is hedgeable and no response has arrived
+// within the hedging delay, a copy of it. The first response that wins is
+// returned, and the other request cancelled. If neither wins, the last one to
+// arrive is returned.
+func (c *Client) hedgedSend(ctx context.Context, send Handler, req *Request) (*Response, error) {
+	h := c.Config.Hedging
+	if h == nil || !hedgeable(req) {
+		return send(ctx, req)
+	}
+	operation := req.OperationName()
+	start := time.Now()
+	delay, ok := h.delay(operation)
+	if !ok {
+		response, err := send(ctx, req)
+		if err == nil {
+			h.observe(operation, time.Since(start))
+		}
+		return response, err
+	}
+
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+	launch := func(r *Request) {
+		reqCtx, cancel := context.WithCancel(ctx)
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			response, err := send(reqCtx, r)
+			results <- hedgeResult{index: index, response: response, err: err}
+		}()
+	}
+	launch(req)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending := 1
+	var result hedgeResult
+	for {
+		select {
+		case <-timer.C:
+			c.Log.Debugf("Cosmos request %s %s not completed after %s, sending hedged request\n", operation, req.ResourceLink, delay)
+			hedge := *req
+			hedge.HTTPRequest = cloneRequest(ctx, req.HTTPRequest)
+			hedge.Hedged = true
+			launch(&hedge)
+			pending++
+			continue
+		case result = <-results:
+			pending--
+		}
+		if hedgeWins(result) || pending == 0 {
+			break
+		}
+		// Failed, but the other request may still succeed
+		if result.err == nil {
+			result.response.HTTPResponse.Body.Close()
+		}
+		cancels[result.index]()
+	}
+
+	// Cancel the loser, and make sure its response body is closed
+	for i, cancel := range cancels {
+		if i != result.index {
+			cancel()
+		}
+	}
+	if pending > 0 {
+		go func() {
+			loser := <-results
+			if loser.err == nil {
+				loser.response.HTTPResponse.Body.Close()
+			}
+		}()
+	}
+
+	if result.err != nil {
+		cancels[result.index]()
+		return nil, result.err
+	}
+	if hedgeWins(result) {
+		h.observe(operation, time.Since(start))
+	}
+	// The winner's context must stay alive until its body has been read
+	result.response.HTTPResponse.Body = cancelOnClose{result.response.HTTPResponse.Body, cancels[result.index]}
+	return result.response, nil
+}
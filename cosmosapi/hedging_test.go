@@ -0,0 +1,110 @@
+package cosmosapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHedging(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	cancelled := make(chan struct{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		first := requests == 1
+		mu.Unlock()
+		if first {
+			// Hangs until the hedged request has won
+			select {
+			case <-r.Context().Done():
+				cancelled <- struct{}{}
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"id": "doc"}`))
+	}))
+	defer ts.Close()
+
+	var hedged []bool
+	c := New(ts.URL, Config{
+		MasterKey: TestKey,
+		Hedging:   NewHedging(HedgingConfig{Delay: 20 * time.Millisecond}),
+		Middlewares: []Middleware{func(next Handler) Handler {
+			return func(ctx context.Context, req *Request) (*Response, error) {
+				mu.Lock()
+				hedged = append(hedged, req.Hedged)
+				mu.Unlock()
+				return next(ctx, req)
+			}
+		}},
+	}, nil, nil)
+
+	var doc Document
+	start := time.Now()
+	_, err := c.GetDocument(context.Background(), "db", "coll", "doc", GetDocumentOptions{}, &doc)
+	require.NoError(t, err)
+	assert.Equal(t, "doc", doc.Id)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, []bool{false, true}, hedged)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("Expected the slow request to be cancelled")
+	}
+}
+
+func TestHedgingThrottledHedge(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		first := requests == 1
+		mu.Unlock()
+		if !first {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(`{"id": "doc"}`))
+	}))
+	defer ts.Close()
+	c := New(ts.URL, Config{MasterKey: TestKey, Hedging: NewHedging(HedgingConfig{Delay: 20 * time.Millisecond})}, nil, nil)
+
+	var doc Document
+	_, err := c.GetDocument(context.Background(), "db", "coll", "doc", GetDocumentOptions{}, &doc)
+	require.NoError(t, err, "the throttled hedge does not win over the request that succeeds")
+	assert.Equal(t, "doc", doc.Id)
+	assert.Equal(t, 2, requests)
+}
+
+func TestHedgingOnlyIdempotentReads(t *testing.T) {
+	assert.True(t, hedgeable(&Request{OperationType: OperationRead, ResourceType: "docs"}))
+	assert.True(t, hedgeable(&Request{OperationType: OperationQuery, ResourceType: "docs"}))
+	assert.True(t, hedgeable(&Request{OperationType: OperationReadFeed, ResourceType: "docs"}))
+	assert.False(t, hedgeable(&Request{OperationType: OperationCreate, ResourceType: "docs"}))
+	assert.False(t, hedgeable(&Request{OperationType: OperationRead, ResourceType: "colls"}))
+}
+
+func TestHedgingDelayFromPercentile(t *testing.T) {
+	h := NewHedging(HedgingConfig{MinSamples: 10, Percentile: 0.9})
+	for i := 1; i <= 9; i++ {
+		h.observe("GetDocument", time.Duration(i)*time.Millisecond)
+	}
+	_, ok := h.delay("GetDocument")
+	assert.False(t, ok)
+	h.observe("GetDocument", 100*time.Millisecond)
+	d, ok := h.delay("GetDocument")
+	assert.True(t, ok)
+	assert.Equal(t, 9*time.Millisecond, d)
+}
@@ -2,8 +2,6 @@ package cosmosapi
 
 import (
 	"context"
-	"encoding/json"
-	"github.com/pkg/errors"
 	"net/http"
 	"strconv"
 )
@@ -16,7 +14,8 @@ func (c *Client) ListDocuments(
 	documentList interface{},
 ) (response ListDocumentsResponse, err error) {
 	link := createDocsLink(databaseName, collectionName)
-	var responseBody listDocumentsResponseBody
+	// Decode the documents directly into documentList, without buffering them
+	responseBody := listDocumentsResponseBody{Documents: documentList}
 	headers, err := options.AsHeaders()
 	if err != nil {
 		return response, err
@@ -26,24 +25,15 @@ func (c *Client) ListDocuments(
 		return response, err
 	} else if httpResponse.StatusCode == http.StatusNotModified {
 		return response, err
-	} else if err = unmarshalDocuments(responseBody.Documents, documentList); err != nil {
-		return response, err
 	}
 	r, err := response.parse(httpResponse)
 	return *r, err
 }
 
-func unmarshalDocuments(bytes []byte, documentList interface{}) error {
-	if len(bytes) == 0 {
-		return nil
-	}
-	return errors.Wrapf(json.Unmarshal(bytes, documentList), "Error unmarshaling <%s>", string(bytes))
-}
-
 type listDocumentsResponseBody struct {
-	Rid       string          `json:"_rid"`
-	Count     int             `json:"_count"`
-	Documents json.RawMessage `json:"Documents"`
+	Rid       string      `json:"_rid"`
+	Count     int         `json:"_count"`
+	Documents interface{} `json:"Documents"`
 }
 
 type ListDocumentsOptions struct {
@@ -0,0 +1,173 @@
+package cosmosapi
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// OperationType classifies a request sent to Cosmos DB, independent of the
+// resource type it operates on.
+type OperationType string
+
+const (
+	OperationCreate   = OperationType("Create")
+	OperationUpsert   = OperationType("Upsert")
+	OperationRead     = OperationType("Read")
+	OperationReadFeed = OperationType("ReadFeed")
+	OperationReplace  = OperationType("Replace")
+	OperationDelete   = OperationType("Delete")
+	OperationQuery    = OperationType("Query")
+	OperationExecute  = OperationType("Execute")
+	OperationPatch    = OperationType("Patch")
+	OperationBatch    = OperationType("Batch")
+)
+
+// Request describes a single attempt of a request to Cosmos DB as seen by
+// the middlewares configured on the client.
+type Request struct {
+	OperationType OperationType
+	// ResourceType is the resource type part of the link, e.g. "docs", "colls" or "sprocs"
+	ResourceType string
+	// ResourceLink is the link the request is sent to, e.g. "dbs/mydb/colls/mycoll/docs/myid"
+	ResourceLink string
+	// PartitionKey is the JSON encoded partition key header, or empty if not set
+	PartitionKey string
+	// Attempt starts at 1 and is incremented for every retry
+	Attempt int
+	// Hedged is true for the second request sent for an attempt, see Hedging
+	Hedged      bool
+	HTTPRequest *http.Request
+}
+
+// Response is the result of a single attempt of a request to Cosmos DB. The
+// body of HTTPResponse is read and closed by the client after the middleware
+// chain has returned, so middlewares should only inspect status and headers.
+type Response struct {
+	HTTPResponse  *http.Response
+	RequestCharge float64
+}
+
+// DatabaseName returns the name of the database the request targets, if any.
+func (r *Request) DatabaseName() string {
+	return linkPart(r.ResourceLink, "dbs")
+}
+
+// CollectionName returns the name of the collection the request targets, if any.
+func (r *Request) CollectionName() string {
+	return linkPart(r.ResourceLink, "colls")
+}
+
+var resourceNames = map[string]string{
+	"dbs":      "Database",
+	"colls":    "Collection",
+	"docs":     "Document",
+	"sprocs":   "StoredProcedure",
+	"triggers": "Trigger",
+	"udfs":     "UserDefinedFunction",
+	"offers":   "Offer",
+	"pkranges": "PartitionKeyRange",
+}
+
+// methodVerbs are the verbs of the method names of Client for the operation
+// types where they differ
+var methodVerbs = map[OperationType]string{
+	OperationRead:     "Get",
+	OperationReadFeed: "List",
+}
+
+// OperationName returns a name for the logical operation, which is the name of
+// the method of Client doing it, e.g. "GetDocument", "ListDocuments",
+// "QueryDocuments" or "ExecuteStoredProcedure".
+func (r *Request) OperationName() string {
+	switch {
+	case r.OperationType == OperationBatch:
+		return "Bulk"
+	case r.ResourceType == "pkranges":
+		return "GetPartitionKeyRanges"
+	}
+	verb, ok := methodVerbs[r.OperationType]
+	if !ok {
+		verb = string(r.OperationType)
+	}
+	name, ok := resourceNames[r.ResourceType]
+	if !ok {
+		name = r.ResourceType
+	}
+	if r.OperationType == OperationQuery || r.OperationType == OperationReadFeed {
+		name += "s"
+	}
+	return verb + name
+}
+
+func linkPart(link, resourceType string) string {
+	parts := strings.Split(strings.Trim(link, "/"), "/")
+	for i := 0; i+1 < len(parts); i += 2 {
+		if parts[i] == resourceType {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// Handler sends a single attempt of a request to Cosmos DB.
+type Handler func(ctx context.Context, req *Request) (*Response, error)
+
+// Middleware wraps a Handler to add behaviour around every attempt, e.g.
+// tracing, metrics, logging or fault injection. A middleware may return a
+// Response without calling next.
+type Middleware func(next Handler) Handler
+
+// chain wraps h in the middlewares so that the first middleware is the
+// outermost one.
+func chain(h Handler, middlewares []Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+func newRequest(method, link string, headers map[string]string, httpRequest *http.Request) *Request {
+	_, rType := resourceTypeFromLink(link)
+	return &Request{
+		OperationType: operationType(method, link, headers),
+		ResourceType:  rType,
+		ResourceLink:  link,
+		PartitionKey:  headers[HEADER_PARTITIONKEY],
+		HTTPRequest:   httpRequest,
+	}
+}
+
+func operationType(method, link string, headers map[string]string) OperationType {
+	// A link with an even number of parts points to a specific resource, e.g.
+	// dbs/myDb/colls/myColl, otherwise it points to a feed, e.g. dbs/myDb/colls
+	toResource := len(strings.Split(strings.Trim(link, "/"), "/"))%2 == 0
+	switch method {
+	case http.MethodGet:
+		if toResource {
+			return OperationRead
+		}
+		return OperationReadFeed
+	case http.MethodPost:
+		if toResource {
+			return OperationExecute
+		}
+		if headers[HEADER_IS_QUERY] == "true" {
+			return OperationQuery
+		}
+		if headers[HEADER_IS_BATCH_REQUEST] == "true" {
+			return OperationBatch
+		}
+		if headers[HEADER_UPSERT] == "true" {
+			return OperationUpsert
+		}
+		return OperationCreate
+	case http.MethodPut:
+		return OperationReplace
+	case http.MethodDelete:
+		return OperationDelete
+	case http.MethodPatch:
+		return OperationPatch
+	}
+	return OperationType(method)
+}
@@ -0,0 +1,108 @@
+package cosmosapi
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HEADER_REQUEST_CHARGE, "2.5")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"id": "doc"}`))
+	}))
+	defer ts.Close()
+
+	var calls []string
+	var requests []Request
+	record := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, req *Request) (*Response, error) {
+				calls = append(calls, name+">")
+				requests = append(requests, *req)
+				resp, err := next(ctx, req)
+				calls = append(calls, "<"+name)
+				return resp, err
+			}
+		}
+	}
+	// Fails the first attempt with a retriable status without reaching the server
+	failFirst := func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			if req.Attempt == 1 {
+				return &Response{HTTPResponse: &http.Response{
+					Status:     "503 Service Unavailable",
+					StatusCode: http.StatusServiceUnavailable,
+					Body:       ioutil.NopCloser(strings.NewReader("")),
+				}}, nil
+			}
+			return next(ctx, req)
+		}
+	}
+	var charge float64
+	getCharge := func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			resp, err := next(ctx, req)
+			if err == nil {
+				charge = resp.RequestCharge
+			}
+			return resp, err
+		}
+	}
+
+	c := New(ts.URL, Config{
+		MasterKey:   TestKey,
+		MaxRetries:  1,
+		Middlewares: []Middleware{record("a"), failFirst, getCharge},
+	}, nil, nil)
+	var doc Document
+	_, err := c.GetDocument(context.Background(), "db", "coll", "doc", GetDocumentOptions{PartitionKeyValue: "pk"}, &doc)
+	require.NoError(t, err)
+
+	assert.Equal(t, "doc", doc.Id)
+	assert.Equal(t, []string{"a>", "<a", "a>", "<a"}, calls)
+	require.Len(t, requests, 2)
+	assert.Equal(t, 1, requests[0].Attempt)
+	assert.Equal(t, 2, requests[1].Attempt)
+	assert.Equal(t, OperationRead, requests[0].OperationType)
+	assert.Equal(t, "docs", requests[0].ResourceType)
+	assert.Equal(t, "dbs/db/colls/coll/docs/doc", requests[0].ResourceLink)
+	assert.Equal(t, `["pk"]`, requests[0].PartitionKey)
+	assert.Equal(t, 2.5, charge)
+}
+
+func TestOperationType(t *testing.T) {
+	cases := []struct {
+		method  string
+		link    string
+		headers map[string]string
+		op      OperationType
+		name    string
+	}{
+		{"GET", "dbs/db/colls/coll/docs/doc", nil, OperationRead, "GetDocument"},
+		{"GET", "dbs/db/colls/coll/docs", nil, OperationReadFeed, "ListDocuments"},
+		{"GET", "dbs/db/colls/coll/pkranges", nil, OperationReadFeed, "GetPartitionKeyRanges"},
+		{"POST", "dbs/db/colls/coll/docs", map[string]string{HEADER_UPSERT: "false"}, OperationCreate, "CreateDocument"},
+		{"POST", "dbs/db/colls/coll/docs", map[string]string{HEADER_UPSERT: "true"}, OperationUpsert, "UpsertDocument"},
+		{"POST", "dbs/db/colls/coll/docs", map[string]string{HEADER_IS_QUERY: "true"}, OperationQuery, "QueryDocuments"},
+		{"POST", "dbs/db/colls/coll/docs", map[string]string{HEADER_IS_BATCH_REQUEST: "true"}, OperationBatch, "Bulk"},
+		{"POST", "dbs/db/colls/coll/sprocs/sproc", nil, OperationExecute, "ExecuteStoredProcedure"},
+		{"POST", "dbs/", nil, OperationCreate, "CreateDatabase"},
+		{"PUT", "offers/abc", nil, OperationReplace, "ReplaceOffer"},
+		{"DELETE", "dbs/db", nil, OperationDelete, "DeleteDatabase"},
+		{"PATCH", "dbs/db/colls/coll/docs/doc", nil, OperationPatch, "PatchDocument"},
+	}
+	for _, c := range cases {
+		t.Run(c.method+" "+c.link, func(t *testing.T) {
+			assert.Equal(t, c.op, operationType(c.method, c.link, c.headers))
+			assert.Equal(t, c.name, newRequest(c.method, c.link, c.headers, nil).OperationName())
+		})
+	}
+}
@@ -2,6 +2,8 @@ package cosmosapi
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
 )
 
 type Offer struct {
@@ -10,13 +12,71 @@ type Offer struct {
 	OfferType       OfferType              `json:"offerType"`
 	Content         OfferThroughputContent `json:"content,omitempty"`
 	OfferResourceId string                 `json:"offerResourceId"`
+	// ReplacePending is set by GetOffer and ReplaceOffer while a change of
+	// the throughput is still being carried out by Cosmos. It is not set on
+	// the offers returned by ListOffers.
+	ReplacePending bool `json:"-"`
+}
+
+// IsAutoscale returns whether the throughput of the offer scales automatically
+func (o Offer) IsAutoscale() bool {
+	return o.Content.OfferAutopilotSettings != nil && o.Content.OfferAutopilotSettings.MaxThroughput > 0
 }
 
 type OfferThroughput int32
 type OfferType string
 
+// OfferThroughputContent holds the throughput of an offer. With autoscale,
+// Throughput is the throughput currently provisioned, between 10% of the max
+// throughput and the max throughput.
 type OfferThroughputContent struct {
-	Throughput OfferThroughput `json:"offerThroughput"`
+	Throughput             OfferThroughput         `json:"offerThroughput,omitempty"`
+	OfferAutopilotSettings *OfferAutopilotSettings `json:"offerAutopilotSettings,omitempty"`
+	// OfferMinimumThroughputParameters is returned by Cosmos, and ignored
+	// when replacing the offer
+	OfferMinimumThroughputParameters *OfferMinimumThroughputParameters `json:"offerMinimumThroughputParameters,omitempty"`
+}
+
+// OfferAutopilotSettings are the settings of autoscale throughput
+type OfferAutopilotSettings struct {
+	MaxThroughput OfferThroughput `json:"maxThroughput"`
+}
+
+// OfferMinimumThroughputParameters are what limits how far the throughput of
+// an offer can be decreased
+type OfferMinimumThroughputParameters struct {
+	MaxThroughputEverProvisioned OfferThroughput `json:"maxThroughputEverProvisioned"`
+	MaxConsumedStorageEverInKB   int64           `json:"maxConsumedStorageEverInKB"`
+}
+
+// MinThroughput returns the lowest throughput, or max throughput with
+// autoscale, the offer can be replaced with. Cosmos requires at least 1% of
+// the highest throughput ever provisioned and 1 RU/s per GB stored, or 10%
+// and 10 RU/s per GB with autoscale.
+func (o Offer) MinThroughput() OfferThroughput {
+	min, step, percent, perGB := OfferThroughput(400), OfferThroughput(100), OfferThroughput(1), int64(1)
+	if o.IsAutoscale() {
+		min, step, percent, perGB = 1000, 1000, 10, 10
+	}
+	if params := o.Content.OfferMinimumThroughputParameters; params != nil {
+		if t := params.MaxThroughputEverProvisioned * percent / 100; t > min {
+			min = t
+		}
+		const kbPerGB = 1024 * 1024
+		if t := OfferThroughput((params.MaxConsumedStorageEverInKB + kbPerGB - 1) / kbPerGB * perGB); t > min {
+			min = t
+		}
+	}
+	// Round up to a throughput that can be provisioned
+	return (min + step - 1) / step * step
+}
+
+// AutoscaleSettings returns the value of the autopilot settings header that
+// provisions autoscale throughput when creating a database or collection,
+// for use with ReqOpOfferAutopilotSettings
+func AutoscaleSettings(maxThroughput OfferThroughput) string {
+	b, _ := json.Marshal(OfferAutopilotSettings{MaxThroughput: maxThroughput})
+	return string(b)
 }
 
 type Offers struct {
@@ -43,11 +103,12 @@ func createOfferLink(offerId string) string {
 // https://docs.microsoft.com/en-us/rest/api/cosmos-db/get-an-offer
 func (c *Client) GetOffer(ctx context.Context, offerId string, ops *RequestOptions) (*Offer, error) {
 	offer := &Offer{}
-	_, err := c.get(ctx, createOfferLink(offerId), offer, nil)
+	httpResponse, err := c.get(ctx, createOfferLink(offerId), offer, nil)
 
 	if err != nil {
 		return nil, err
 	}
+	offer.ReplacePending = replacePending(httpResponse)
 	return offer, nil
 }
 
@@ -68,14 +129,46 @@ func (c *Client) ListOffers(ctx context.Context, ops *RequestOptions) (*Offers,
 // https://docs.microsoft.com/en-us/rest/api/cosmos-db/replace-an-offer
 func (c *Client) ReplaceOffer(ctx context.Context, offerOps OfferReplaceOptions, ops *RequestOptions) (*Offer, error) {
 
+	return c.replaceOffer(ctx, offerOps, nil)
+}
+
+// MigrateOfferToAutoscale changes an offer with manual throughput to
+// autoscale. Cosmos picks the max throughput from the current throughput;
+// replace the offer afterwards to change it.
+func (c *Client) MigrateOfferToAutoscale(ctx context.Context, offer Offer) (*Offer, error) {
+	return c.replaceOffer(ctx, migrateOfferOptions(offer), map[string]string{HEADER_MIGRATE_OFFER_TO_AUTOPILOT: "true"})
+}
+
+// MigrateOfferToManual changes an offer with autoscale throughput to manual
+// throughput. Cosmos picks the throughput from the max throughput; replace the
+// offer afterwards to change it.
+func (c *Client) MigrateOfferToManual(ctx context.Context, offer Offer) (*Offer, error) {
+	return c.replaceOffer(ctx, migrateOfferOptions(offer), map[string]string{HEADER_MIGRATE_OFFER_TO_MANUAL: "true"})
+}
+
+func migrateOfferOptions(offer Offer) OfferReplaceOptions {
+	return OfferReplaceOptions{
+		OfferVersion:     offer.OfferVersion,
+		OfferType:        offer.OfferType,
+		ResourceSelfLink: offer.Self,
+		OfferResourceId:  offer.OfferResourceId,
+		Id:               offer.Id,
+		Rid:              offer.Rid,
+	}
+}
+
+func (c *Client) replaceOffer(ctx context.Context, offerOps OfferReplaceOptions, headers map[string]string) (*Offer, error) {
 	offer := &Offer{}
 	link := createOfferLink(offerOps.Rid)
 
-	_, err := c.replace(ctx, link, offerOps, offer, nil)
+	httpResponse, err := c.replace(ctx, link, offerOps, offer, headers)
 	if err != nil {
 		return nil, err
 	}
-
+	offer.ReplacePending = replacePending(httpResponse)
 	return offer, nil
+}
 
+func replacePending(httpResponse *http.Response) bool {
+	return httpResponse != nil && httpResponse.Header.Get(HEADER_OFFER_REPLACE_PENDING) == "true"
 }
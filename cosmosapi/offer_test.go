@@ -0,0 +1,96 @@
+package cosmosapi
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoscaleOffer(t *testing.T) {
+	const autoscaleOffer = `{
+		"id": "offer", "_rid": "offer", "_self": "offers/offer/", "offerVersion": "V2", "offerType": "Invalid", "offerResourceId": "coll",
+		"content": {"offerThroughput": 400, "offerAutopilotSettings": {"maxThroughput": 4000}}
+	}`
+	var requests []*http.Request
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests, bodies = append(requests, r), append(bodies, string(body))
+		switch {
+		case r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": "created"}`))
+		case r.Method == http.MethodPut:
+			w.Header().Set(HEADER_OFFER_REPLACE_PENDING, "true")
+			w.Write([]byte(autoscaleOffer))
+		default:
+			w.Write([]byte(autoscaleOffer))
+		}
+	}))
+	defer ts.Close()
+	c := New(ts.URL, Config{MasterKey: TestKey}, nil, nil)
+	ctx := context.Background()
+
+	_, err := c.CreateCollection(ctx, "db", CreateCollectionOptions{Id: "coll", AutoscaleMaxThroughput: 4000})
+	require.NoError(t, err)
+	assert.Equal(t, `{"maxThroughput":4000}`, requests[0].Header.Get(HEADER_OFFER_AUTOPILOT))
+	assert.Empty(t, requests[0].Header.Get(HEADER_OFFER_THROUGHPUT))
+	_, err = c.CreateCollection(ctx, "db", CreateCollectionOptions{Id: "coll", AutoscaleMaxThroughput: 4000, OfferThroughput: 400})
+	assert.Equal(t, ErrThroughputAndAutoscale, err)
+
+	_, err = c.CreateDatabase(ctx, "db", &RequestOptions{ReqOpOfferAutopilotSettings: AutoscaleSettings(1000)})
+	require.NoError(t, err)
+	assert.Equal(t, `{"maxThroughput":1000}`, requests[1].Header.Get(HEADER_OFFER_AUTOPILOT))
+	_, err = c.CreateDatabase(ctx, "db", &RequestOptions{ReqOpOfferThroughput: "400"})
+	require.NoError(t, err)
+	assert.Equal(t, "400", requests[2].Header.Get(HEADER_OFFER_THROUGHPUT))
+
+	offer, err := c.GetOffer(ctx, "offer", nil)
+	require.NoError(t, err)
+	assert.True(t, offer.IsAutoscale())
+	assert.Equal(t, OfferThroughput(4000), offer.Content.OfferAutopilotSettings.MaxThroughput)
+	assert.False(t, offer.ReplacePending)
+
+	replaced, err := c.MigrateOfferToManual(ctx, *offer)
+	require.NoError(t, err)
+	assert.True(t, replaced.ReplacePending)
+	assert.Equal(t, "offers/offer", requests[4].URL.Path[1:])
+	assert.Equal(t, "true", requests[4].Header.Get(HEADER_MIGRATE_OFFER_TO_MANUAL))
+	assert.JSONEq(t, `{"offerVersion": "V2", "offerType": "Invalid", "content": {}, "resource": "offers/offer/",
+		"offerResourceId": "coll", "id": "offer", "_rid": "offer"}`, bodies[4])
+
+	_, err = c.MigrateOfferToAutoscale(ctx, *offer)
+	require.NoError(t, err)
+	assert.Equal(t, "true", requests[5].Header.Get(HEADER_MIGRATE_OFFER_TO_AUTOPILOT))
+
+	_, err = c.ReplaceOffer(ctx, OfferReplaceOptions{Rid: "offer", Content: OfferThroughputContent{
+		OfferAutopilotSettings: &OfferAutopilotSettings{MaxThroughput: 6000},
+	}}, nil)
+	require.NoError(t, err)
+	assert.Contains(t, bodies[6], `"content":{"offerAutopilotSettings":{"maxThroughput":6000}}`)
+}
+
+func TestOfferMinThroughput(t *testing.T) {
+	const gb = 1024 * 1024
+	manual := func(maxEver OfferThroughput, storageKB int64) Offer {
+		return Offer{Content: OfferThroughputContent{Throughput: 400, OfferMinimumThroughputParameters: &OfferMinimumThroughputParameters{
+			MaxThroughputEverProvisioned: maxEver, MaxConsumedStorageEverInKB: storageKB,
+		}}}
+	}
+	assert.Equal(t, OfferThroughput(400), Offer{}.MinThroughput())
+	assert.Equal(t, OfferThroughput(400), manual(10000, gb).MinThroughput())
+	assert.Equal(t, OfferThroughput(1000), manual(100000, gb).MinThroughput())
+	assert.Equal(t, OfferThroughput(600), manual(400, 500*gb+1).MinThroughput())
+	assert.Equal(t, OfferThroughput(400), manual(400, 50*gb).MinThroughput())
+
+	autoscale := manual(100000, gb)
+	autoscale.Content.OfferAutopilotSettings = &OfferAutopilotSettings{MaxThroughput: 100000}
+	assert.Equal(t, OfferThroughput(10000), autoscale.MinThroughput())
+	autoscale.Content.OfferMinimumThroughputParameters = &OfferMinimumThroughputParameters{MaxThroughputEverProvisioned: 4000, MaxConsumedStorageEverInKB: 150 * gb}
+	assert.Equal(t, OfferThroughput(2000), autoscale.MinThroughput())
+}
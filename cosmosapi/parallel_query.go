@@ -0,0 +1,254 @@
+package cosmosapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var ErrContinuationSplit = errors.New("A partition key range of the continuation has been split; the query must be restarted")
+
+// ParallelQueryOptions are the options of QueryDocumentsParallel.
+type ParallelQueryOptions struct {
+	// QueryDocumentsOptions are used for the query of every partition key
+	// range. PartitionKeyValue, PartitionKeyRangeId, EnableCrossPartition and
+	// Continuation are set by QueryDocumentsParallel.
+	QueryDocumentsOptions
+	// MaxDegreeOfParallelism is the maximum number of requests in flight. 0
+	// means one per partition key range.
+	MaxDegreeOfParallelism int
+	// MaxBufferedPages is the number of pages fetched ahead of the consumer.
+	// 0 means MaxDegreeOfParallelism.
+	MaxBufferedPages int
+	// Continuation from ParallelQueryIterator.Continuation resumes a query.
+	Continuation string
+}
+
+// parallelQueryRange is the state of the query of a partition key range, as
+// stored in the continuation
+type parallelQueryRange struct {
+	Id           string `json:"id"`
+	MinInclusive string `json:"min"`
+	MaxExclusive string `json:"max"`
+	Token        string `json:"token,omitempty"`
+	Done         bool   `json:"done,omitempty"`
+}
+
+type parallelQueryPage struct {
+	index     int
+	response  QueryDocumentsResponse
+	documents []json.RawMessage
+	err       error
+}
+
+// ParallelQueryIterator returns the pages of a query run in parallel on every
+// partition key range of a collection. The pages of a partition key range come
+// in order, but are interleaved with the pages of other ranges as they arrive,
+// so results are not ordered across ranges. Close must be called when done
+// with the iterator, unless it has been read to the end.
+type ParallelQueryIterator struct {
+	client   *Client
+	dbName   string
+	collName string
+	query    Query
+	ops      QueryDocumentsOptions
+
+	ranges    []parallelQueryRange
+	remaining int
+	pages     chan parallelQueryPage
+	requests  chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	err       error
+	queryStats
+}
+
+// QueryDocumentsParallel runs a query on every partition key range of the
+// collection concurrently. It is faster than a cross partition query for
+// queries returning many documents, e.g. exports, but does not support
+// ORDER BY, aggregates, DISTINCT or OFFSET LIMIT across ranges.
+//
+// If a partition key range is split while the query runs, Next returns
+// ErrGone, and the query can be resumed from the last Continuation. The
+// ranges of that continuation which have been split then have to be restarted,
+// so QueryDocumentsParallel returns ErrContinuationSplit if they had started.
+func (c *Client) QueryDocumentsParallel(ctx context.Context, dbName, collName string, qry Query, ops ParallelQueryOptions) (*ParallelQueryIterator, error) {
+	if ops.PartitionKeyValue != nil {
+		return nil, errors.New("A parallel query runs in all partitions, and can not have a PartitionKeyValue")
+	}
+	response, err := c.GetPartitionKeyRanges(ctx, dbName, collName, &GetPartitionKeyRangesOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var ranges []parallelQueryRange
+	for _, r := range NewRoutingMap(PartitionKey{}, response.PartitionKeyRanges).Ranges {
+		ranges = append(ranges, parallelQueryRange{Id: r.Id, MinInclusive: r.MinInclusive, MaxExclusive: r.MaxExclusive})
+	}
+	if ops.Continuation != "" {
+		if ranges, err = resumeParallelQuery(ranges, ops.Continuation); err != nil {
+			return nil, err
+		}
+	}
+
+	parallelism := ops.MaxDegreeOfParallelism
+	if parallelism <= 0 {
+		parallelism = len(ranges)
+	}
+	buffered := ops.MaxBufferedPages
+	if buffered <= 0 {
+		buffered = parallelism
+	}
+	it := &ParallelQueryIterator{
+		client:   c,
+		dbName:   dbName,
+		collName: collName,
+		query:    qry,
+		ops:      ops.QueryDocumentsOptions,
+		ranges:   ranges,
+		pages:    make(chan parallelQueryPage, buffered),
+		requests: make(chan struct{}, parallelism),
+	}
+	it.ctx, it.cancel = context.WithCancel(ctx)
+	for i, r := range ranges {
+		if !r.Done {
+			it.remaining++
+			it.wg.Add(1)
+			go it.queryRange(i, r)
+		}
+	}
+	return it, nil
+}
+
+// resumeParallelQuery returns the state of the current ranges from the continuation
+func resumeParallelQuery(ranges []parallelQueryRange, continuation string) ([]parallelQueryRange, error) {
+	var saved []parallelQueryRange
+	if err := json.Unmarshal([]byte(continuation), &saved); err != nil {
+		return nil, errors.Wrap(err, "Invalid continuation")
+	}
+	resumed := make([]parallelQueryRange, len(ranges))
+	for i, r := range ranges {
+		found := false
+		for _, s := range saved {
+			if s.Id == r.Id || (s.MinInclusive <= r.MinInclusive && r.MaxExclusive <= s.MaxExclusive) {
+				if s.Id != r.Id && s.Token != "" {
+					return nil, ErrContinuationSplit
+				}
+				found = true
+				r.Token, r.Done = s.Token, s.Done
+				break
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("Partition key range %s is not covered by the continuation", r.Id)
+		}
+		resumed[i] = r
+	}
+	return resumed, nil
+}
+
+// queryRange fetches the pages of a range, and sends them to the consumer
+func (it *ParallelQueryIterator) queryRange(index int, r parallelQueryRange) {
+	defer it.wg.Done()
+	ops := it.ops
+	ops.EnableCrossPartition = true
+	ops.PartitionKeyRangeId = r.Id
+	ops.Continuation = r.Token
+	for {
+		select {
+		case it.requests <- struct{}{}:
+		case <-it.ctx.Done():
+			return
+		}
+		var documents []json.RawMessage
+		response, err := it.client.QueryDocuments(it.ctx, it.dbName, it.collName, it.query, &documents, ops)
+		<-it.requests
+		select {
+		case it.pages <- parallelQueryPage{index: index, response: response, documents: documents, err: err}:
+		case <-it.ctx.Done():
+			return
+		}
+		if err != nil || response.Continuation == "" {
+			return
+		}
+		ops.Continuation = response.Continuation
+	}
+}
+
+// HasMore returns whether there are more pages to read.
+func (it *ParallelQueryIterator) HasMore() bool {
+	return it.remaining > 0 && it.err == nil
+}
+
+// Next waits for the next page from any partition key range and decodes its
+// documents into docs, which should be a pointer to a slice. The response is
+// that of the query of the range. The first error ends the iteration.
+func (it *ParallelQueryIterator) Next(docs interface{}) (QueryDocumentsResponse, error) {
+	if it.err != nil {
+		return QueryDocumentsResponse{}, it.err
+	}
+	if it.remaining == 0 {
+		return QueryDocumentsResponse{}, ErrNoMorePages
+	}
+	var page parallelQueryPage
+	select {
+	case page = <-it.pages:
+	case <-it.ctx.Done():
+		page.err = it.ctx.Err()
+	}
+	if page.err != nil {
+		it.err = page.err
+		it.Close()
+		return page.response, page.err
+	}
+	r := &it.ranges[page.index]
+	r.Token = page.response.Continuation
+	if r.Token == "" {
+		r.Done = true
+		if it.remaining--; it.remaining == 0 {
+			it.cancel()
+		}
+	}
+	it.add(page.response)
+	if err := json.Unmarshal(joinDocuments(page.documents), docs); err != nil {
+		return page.response, errors.WithStack(err)
+	}
+	return page.response, nil
+}
+
+func joinDocuments(documents []json.RawMessage) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, document := range documents {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(document)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+// Continuation returns a token to resume the query after the pages read so
+// far with ParallelQueryOptions.Continuation, or "" if all pages have been
+// read. Pages that have been fetched but not read are fetched again when
+// resuming.
+func (it *ParallelQueryIterator) Continuation() string {
+	if it.remaining == 0 {
+		return ""
+	}
+	continuation, err := json.Marshal(it.ranges)
+	if err != nil {
+		panic(err)
+	}
+	return string(continuation)
+}
+
+// Close stops the queries of the iterator, and waits for them to return.
+func (it *ParallelQueryIterator) Close() {
+	it.cancel()
+	it.wg.Wait()
+}
@@ -0,0 +1,182 @@
+package cosmosapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parallelQueryServer serves a collection with the given partition key ranges, each holding two pages of two
+// documents
+type parallelQueryServer struct {
+	ranges   []PartitionKeyRange
+	inFlight int32
+	maxSeen  int32
+	failOn   string
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *parallelQueryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/pkranges") {
+		json.NewEncoder(w).Encode(GetPartitionKeyRangesResponse{PartitionKeyRanges: s.ranges})
+		return
+	}
+	inFlight := atomic.AddInt32(&s.inFlight, 1)
+	defer atomic.AddInt32(&s.inFlight, -1)
+	for {
+		max := atomic.LoadInt32(&s.maxSeen)
+		if inFlight <= max || atomic.CompareAndSwapInt32(&s.maxSeen, max, inFlight) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	id := r.Header.Get(HEADER_PARTITION_KEY_RANGE_ID)
+	continuation := r.Header.Get(HEADER_CONTINUATION)
+	s.mu.Lock()
+	s.requests = append(s.requests, id+":"+continuation)
+	s.mu.Unlock()
+	if id == s.failOn {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	page := "1"
+	if continuation == "" {
+		w.Header().Set(HEADER_CONTINUATION, "page2")
+	} else {
+		page = "2"
+	}
+	w.Header().Set(HEADER_REQUEST_CHARGE, "1")
+	fmt.Fprintf(w, `{"Documents": [{"id": "%s-%s-a"}, {"id": "%s-%s-b"}], "_count": 2}`, id, page, id, page)
+}
+
+func newParallelQueryServer() *parallelQueryServer {
+	return &parallelQueryServer{ranges: []PartitionKeyRange{
+		{Id: "0", MinInclusive: "", MaxExclusive: "FF"},
+		{Id: "1", MinInclusive: "", MaxExclusive: "40", Parents: []string{"0"}},
+		{Id: "2", MinInclusive: "40", MaxExclusive: "80", Parents: []string{"0"}},
+		{Id: "3", MinInclusive: "80", MaxExclusive: "C0", Parents: []string{"0"}},
+		{Id: "4", MinInclusive: "C0", MaxExclusive: "FF", Parents: []string{"0"}},
+	}}
+}
+
+func readAll(t *testing.T, it *ParallelQueryIterator, maxPages int) []string {
+	var ids []string
+	for pages := 0; it.HasMore() && pages != maxPages; pages++ {
+		var docs []Document
+		_, err := it.Next(&docs)
+		require.NoError(t, err)
+		for _, doc := range docs {
+			ids = append(ids, doc.Id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestQueryDocumentsParallel(t *testing.T) {
+	s := newParallelQueryServer()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	c := New(ts.URL, Config{MasterKey: TestKey}, nil, nil)
+
+	it, err := c.QueryDocumentsParallel(context.Background(), "db", "coll", Query{Query: "SELECT * FROM c"},
+		ParallelQueryOptions{QueryDocumentsOptions: DefaultQueryDocumentOptions(), MaxDegreeOfParallelism: 2, MaxBufferedPages: 1})
+	require.NoError(t, err)
+	ids := readAll(t, it, -1)
+	assert.Len(t, ids, 16)
+	for _, id := range []string{"1", "2", "3", "4"} {
+		assert.Contains(t, ids, id+"-1-a")
+		assert.Contains(t, ids, id+"-2-b")
+	}
+	assert.NotContains(t, s.requests, "0:", "split parent range is not queried")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&s.maxSeen))
+	assert.Equal(t, 8.0, it.RequestCharge())
+	assert.Equal(t, "", it.Continuation())
+
+	var docs []Document
+	_, err = it.Next(&docs)
+	assert.Equal(t, ErrNoMorePages, err)
+}
+
+func TestQueryDocumentsParallelContinuation(t *testing.T) {
+	s := newParallelQueryServer()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	c := New(ts.URL, Config{MasterKey: TestKey}, nil, nil)
+	ops := ParallelQueryOptions{QueryDocumentsOptions: DefaultQueryDocumentOptions(), MaxDegreeOfParallelism: 1, MaxBufferedPages: 1}
+
+	it, err := c.QueryDocumentsParallel(context.Background(), "db", "coll", Query{Query: "SELECT * FROM c"}, ops)
+	require.NoError(t, err)
+	first := readAll(t, it, 3)
+	ops.Continuation = it.Continuation()
+	it.Close()
+	require.NotEqual(t, "", ops.Continuation)
+
+	it, err = c.QueryDocumentsParallel(context.Background(), "db", "coll", Query{Query: "SELECT * FROM c"}, ops)
+	require.NoError(t, err)
+	rest := readAll(t, it, -1)
+	all := append(first, rest...)
+	sort.Strings(all)
+	assert.Len(t, all, 16)
+	for i := 1; i < len(all); i++ {
+		assert.NotEqual(t, all[i-1], all[i], "no document is returned twice")
+	}
+
+	// Range 4 is split after a continuation where it had started
+	continuation := `[{"id":"1","min":"","max":"40","done":true},{"id":"2","min":"40","max":"80"},` +
+		`{"id":"3","min":"80","max":"C0"},{"id":"4","min":"C0","max":"FF","token":"page2"}]`
+	s.ranges = append(s.ranges,
+		PartitionKeyRange{Id: "5", MinInclusive: "C0", MaxExclusive: "E0", Parents: []string{"0", "4"}},
+		PartitionKeyRange{Id: "6", MinInclusive: "E0", MaxExclusive: "FF", Parents: []string{"0", "4"}})
+	ops.Continuation = continuation
+	_, err = c.QueryDocumentsParallel(context.Background(), "db", "coll", Query{Query: "SELECT * FROM c"}, ops)
+	assert.Equal(t, ErrContinuationSplit, err)
+
+	// Not if it had not started
+	ops.Continuation = strings.Replace(continuation, `,"token":"page2"`, "", 1)
+	it, err = c.QueryDocumentsParallel(context.Background(), "db", "coll", Query{Query: "SELECT * FROM c"}, ops)
+	require.NoError(t, err)
+	assert.Len(t, readAll(t, it, -1), 16)
+
+	ops.Continuation = "garbage"
+	_, err = c.QueryDocumentsParallel(context.Background(), "db", "coll", Query{Query: "SELECT * FROM c"}, ops)
+	assert.Error(t, err)
+}
+
+func TestQueryDocumentsParallelError(t *testing.T) {
+	s := newParallelQueryServer()
+	s.failOn = "3"
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	c := New(ts.URL, Config{MasterKey: TestKey}, nil, nil)
+
+	it, err := c.QueryDocumentsParallel(context.Background(), "db", "coll", Query{Query: "SELECT * FROM c"},
+		ParallelQueryOptions{QueryDocumentsOptions: DefaultQueryDocumentOptions()})
+	require.NoError(t, err)
+	for err == nil {
+		var docs []Document
+		_, err = it.Next(&docs)
+	}
+	assert.Equal(t, ErrGone, errors.Cause(err))
+	assert.False(t, it.HasMore())
+
+	ops := ParallelQueryOptions{QueryDocumentsOptions: DefaultQueryDocumentOptions()}
+	ops.PartitionKeyValue = "a"
+	_, err = c.QueryDocumentsParallel(context.Background(), "db", "coll", Query{Query: "SELECT * FROM c"}, ops)
+	assert.Error(t, err)
+}
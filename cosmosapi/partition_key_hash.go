@@ -0,0 +1,290 @@
+package cosmosapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// MinEffectivePartitionKey is the MinInclusive of the first partition key range
+	MinEffectivePartitionKey = ""
+	// MaxEffectivePartitionKey is the MaxExclusive of the last partition key range
+	MaxEffectivePartitionKey = "FF"
+
+	PartitionKindHash      = "Hash"
+	PartitionKindMultiHash = "MultiHash"
+
+	// MaxHierarchicalPartitionKeyPaths is the maximum number of paths of a
+	// MultiHash partition key
+	MaxHierarchicalPartitionKeyPaths = 3
+)
+
+var (
+	ErrUnsupportedPartitionKeyKind    = errors.New("Effective partition keys can only be computed for partition keys of kind Hash and MultiHash")
+	ErrUnsupportedPartitionKeyVersion = errors.New("Unsupported partition key hash version")
+)
+
+type undefinedPartitionKey struct{}
+
+// UndefinedPartitionKey is the partition key value of documents that do not
+// have the partition key path at all, as opposed to having it set to null.
+var UndefinedPartitionKey = undefinedPartitionKey{}
+
+// Types of the components of a partition key, in the order Cosmos sorts them
+const (
+	pkComponentUndefined byte = 0x00
+	pkComponentNull      byte = 0x01
+	pkComponentFalse     byte = 0x02
+	pkComponentTrue      byte = 0x03
+	pkComponentNumber    byte = 0x05
+	pkComponentString    byte = 0x08
+)
+
+const (
+	// Strings are truncated to this many (UTF-16) characters before hashing with version 1
+	maxStringCharsV1 = 100
+	// Strings are truncated to this many bytes in the binary encoding
+	maxStringBytesToAppend = 100
+)
+
+// pkComponent is a single value of a partition key
+type pkComponent struct {
+	kind   byte
+	number float64
+	str    string
+}
+
+func newPKComponent(value interface{}) (pkComponent, error) {
+	switch v := value.(type) {
+	case nil:
+		return pkComponent{kind: pkComponentNull}, nil
+	case undefinedPartitionKey:
+		return pkComponent{kind: pkComponentUndefined}, nil
+	case bool:
+		if v {
+			return pkComponent{kind: pkComponentTrue}, nil
+		}
+		return pkComponent{kind: pkComponentFalse}, nil
+	case string:
+		return pkComponent{kind: pkComponentString, str: v}, nil
+	case int:
+		return numberComponent(float64(v)), nil
+	case int8:
+		return numberComponent(float64(v)), nil
+	case int16:
+		return numberComponent(float64(v)), nil
+	case int32:
+		return numberComponent(float64(v)), nil
+	case int64:
+		return numberComponent(float64(v)), nil
+	case uint:
+		return numberComponent(float64(v)), nil
+	case uint8:
+		return numberComponent(float64(v)), nil
+	case uint16:
+		return numberComponent(float64(v)), nil
+	case uint32:
+		return numberComponent(float64(v)), nil
+	case uint64:
+		return numberComponent(float64(v)), nil
+	case float32:
+		return numberComponent(float64(v)), nil
+	case float64:
+		return numberComponent(v), nil
+	}
+	return pkComponent{}, ErrInvalidPartitionKeyType
+}
+
+func numberComponent(v float64) pkComponent {
+	return pkComponent{kind: pkComponentNumber, number: v}
+}
+
+// truncated returns the component as hashed by version 1, which only uses the
+// first maxStringCharsV1 characters of strings.
+func (p pkComponent) truncated() pkComponent {
+	if p.kind == pkComponentString {
+		chars := utf16.Encode([]rune(p.str))
+		if len(chars) > maxStringCharsV1 {
+			p.str = string(utf16.Decode(chars[:maxStringCharsV1]))
+		}
+	}
+	return p
+}
+
+// writeForHashing writes the component as input to the hash function.
+// Strings are terminated with stringSuffix, which differs between the
+// versions.
+func (p pkComponent) writeForHashing(buf *bytes.Buffer, stringSuffix byte) {
+	buf.WriteByte(p.kind)
+	switch p.kind {
+	case pkComponentNumber:
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(p.number))
+		buf.Write(b[:])
+	case pkComponentString:
+		buf.WriteString(p.str)
+		buf.WriteByte(stringSuffix)
+	}
+}
+
+// writeForBinaryEncoding writes the component in the order preserving
+// encoding used by version 1 effective partition keys.
+func (p pkComponent) writeForBinaryEncoding(buf *bytes.Buffer) {
+	buf.WriteByte(p.kind)
+	switch p.kind {
+	case pkComponentNumber:
+		payload := encodeDoubleAsUint64(p.number)
+		// The first byte holds 8 bits of the payload, the following ones 7 bits
+		// followed by a 1 bit, except for the last one which ends with a 0 bit
+		buf.WriteByte(byte(payload >> 56))
+		payload <<= 8
+		var b byte
+		first := true
+		for {
+			if !first {
+				buf.WriteByte(b)
+			}
+			first = false
+			b = byte(payload>>56) | 0x01
+			payload <<= 7
+			if payload == 0 {
+				break
+			}
+		}
+		buf.WriteByte(b & 0xFE)
+	case pkComponentString:
+		utf8 := []byte(p.str)
+		short := len(utf8) <= maxStringBytesToAppend
+		if !short {
+			utf8 = utf8[:maxStringBytesToAppend+1]
+		}
+		for _, c := range utf8 {
+			if c < 0xFF {
+				c++
+			}
+			buf.WriteByte(c)
+		}
+		if short {
+			buf.WriteByte(0x00)
+		}
+	}
+}
+
+// encodeDoubleAsUint64 maps v to an uint64 with the same ordering
+func encodeDoubleAsUint64(v float64) uint64 {
+	const mask = 0x8000000000000000
+	u := math.Float64bits(v)
+	if u < mask {
+		return u ^ mask
+	}
+	return ^u + 1
+}
+
+// EffectivePartitionKey returns the effective partition key of a document with
+// the given partition key values in a collection partitioned by pk, i.e. the
+// hash that decides which partition key range it belongs to. It can be
+// compared with the MinInclusive and MaxExclusive of a PartitionKeyRange.
+//
+// The values may be nil, UndefinedPartitionKey, bools, numbers or strings.
+// There is one value per path of a MultiHash partition key; passing fewer
+// values gives the effective partition key of the prefix, which is the start
+// of the range of all the documents with that prefix. A single
+// HierarchicalPartitionKey is taken as its values.
+func EffectivePartitionKey(pk PartitionKey, values ...interface{}) (string, error) {
+	if len(values) == 1 {
+		if hierarchical, ok := values[0].(HierarchicalPartitionKey); ok {
+			values = hierarchical
+		}
+	}
+	if pk.Kind == PartitionKindMultiHash {
+		if len(values) > len(pk.Paths) {
+			return "", errors.Errorf("Got %d partition key values for a partition key with %d paths", len(values), len(pk.Paths))
+		}
+	} else if pk.Kind != PartitionKindHash && pk.Kind != "" {
+		return "", ErrUnsupportedPartitionKeyKind
+	}
+	if len(values) == 0 {
+		return MinEffectivePartitionKey, nil
+	}
+	components := make([]pkComponent, len(values))
+	for i, value := range values {
+		var err error
+		if components[i], err = newPKComponent(value); err != nil {
+			return "", err
+		}
+	}
+	if pk.Kind == PartitionKindMultiHash {
+		if pk.Version != 2 {
+			return "", ErrUnsupportedPartitionKeyVersion
+		}
+		// Every value is hashed on its own, so that documents sharing a prefix
+		// are in a contiguous range
+		var epk strings.Builder
+		for _, c := range components {
+			epk.WriteString(effectivePartitionKeyV2([]pkComponent{c}))
+		}
+		return epk.String(), nil
+	}
+	switch pk.Version {
+	case 0, 1:
+		return effectivePartitionKeyV1(components), nil
+	case 2:
+		return effectivePartitionKeyV2(components), nil
+	}
+	return "", ErrUnsupportedPartitionKeyVersion
+}
+
+// EffectivePartitionKeyRange returns the range [min, max) of effective
+// partition keys of the documents with the given prefix of the values of a
+// MultiHash partition key. For a complete partition key it is the range of the
+// single effective partition key.
+func EffectivePartitionKeyRange(pk PartitionKey, prefix ...interface{}) (min, max string, err error) {
+	min, err = EffectivePartitionKey(pk, prefix...)
+	if err != nil {
+		return "", "", err
+	}
+	if min == MinEffectivePartitionKey {
+		return MinEffectivePartitionKey, MaxEffectivePartitionKey, nil
+	}
+	return min, min + MaxEffectivePartitionKey, nil
+}
+
+// effectivePartitionKeyV1 is the binary encoding of the 32 bit hash of the
+// components followed by the components themselves.
+func effectivePartitionKeyV1(components []pkComponent) string {
+	var buf bytes.Buffer
+	truncated := make([]pkComponent, len(components))
+	for i, c := range components {
+		truncated[i] = c.truncated()
+		truncated[i].writeForHashing(&buf, 0x00)
+	}
+	hash := murmurHash3_32(buf.Bytes(), 0)
+
+	buf.Reset()
+	numberComponent(float64(hash)).writeForBinaryEncoding(&buf)
+	for _, c := range truncated {
+		c.writeForBinaryEncoding(&buf)
+	}
+	return strings.ToUpper(hex.EncodeToString(buf.Bytes()))
+}
+
+// effectivePartitionKeyV2 is the 128 bit hash of the components
+func effectivePartitionKeyV2(components []pkComponent) string {
+	var buf bytes.Buffer
+	for _, c := range components {
+		c.writeForHashing(&buf, 0xFF)
+	}
+	h1, h2 := murmurHash3_128(buf.Bytes(), 0)
+	var hash [16]byte
+	binary.BigEndian.PutUint64(hash[:8], h2)
+	binary.BigEndian.PutUint64(hash[8:], h1)
+	// Clear the two most significant bits to stay below MaxEffectivePartitionKey
+	hash[0] &= 0x3F
+	return strings.ToUpper(hex.EncodeToString(hash[:]))
+}
@@ -0,0 +1,95 @@
+package cosmosapi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMurmurHash3(t *testing.T) {
+	assert.Equal(t, uint32(0), murmurHash3_32(nil, 0))
+	assert.Equal(t, uint32(0x248bfa47), murmurHash3_32([]byte("hello"), 0))
+	h1, h2 := murmurHash3_128([]byte("hello"), 0)
+	assert.Equal(t, uint64(0xcbd8a7b341bd9b02), h1)
+	assert.Equal(t, uint64(0x5b1e906a48ae1d19), h2)
+}
+
+// Known values from the Cosmos DB SDKs
+var effectivePartitionKeyVectors = []struct {
+	value  interface{}
+	v1, v2 string
+}{
+	{"", "05C1CF33970FF80800", "32E9366E637A71B4E710384B2F4970A0"},
+	{"partitionKey", "05C1E1B3D9CD2608716273756A756A706F4C667A00", "013AEFCF77FA271571CF665A58C933F1"},
+	{strings.Repeat("a", 1024), "05C1EB5921F70608" + strings.Repeat("62", 100) + "00", "332BDF5512AE49615F32C7D98C2DB86C"},
+	{nil, "05C1ED45D7475601", "378867E4430E67857ACE5C908374FE16"},
+	{true, "05C1D7C5A903D803", "0E711127C5B5A8E4726AC6DD306A3E59"},
+	{false, "05C1DB857D857C02", "2FE1BE91E90A3439635E0E9E37361EF2"},
+	{UndefinedPartitionKey, "05C1D529E345DC00", "11622DAA78F835834610ABE56EFF5CB5"},
+	{5.0, "05C1D9C1C5517C05C014", "19C08621B135968252FB34B4CF66F811"},
+	{5.12312419050912359123, "05C1CD6757FB7805C0153F858949735550", "0EF2E2D82460884AF0F6440BE4F726A8"},
+}
+
+func TestEffectivePartitionKey(t *testing.T) {
+	for _, vector := range effectivePartitionKeyVectors {
+		v1, err := EffectivePartitionKey(PartitionKey{Kind: PartitionKindHash}, vector.value)
+		require.NoError(t, err)
+		assert.Equal(t, vector.v1, v1, "V1 of %v", vector.value)
+		v2, err := EffectivePartitionKey(PartitionKey{Kind: PartitionKindHash, Version: 2}, vector.value)
+		require.NoError(t, err)
+		assert.Equal(t, vector.v2, v2, "V2 of %v", vector.value)
+	}
+
+	// Integers hash like the corresponding float
+	v, err := EffectivePartitionKey(PartitionKey{Version: 2}, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "19C08621B135968252FB34B4CF66F811", v)
+
+	v, err = EffectivePartitionKey(PartitionKey{})
+	require.NoError(t, err)
+	assert.Equal(t, MinEffectivePartitionKey, v)
+
+	_, err = EffectivePartitionKey(PartitionKey{Kind: "Range"}, "a")
+	assert.Equal(t, ErrUnsupportedPartitionKeyKind, err)
+	_, err = EffectivePartitionKey(PartitionKey{Version: 3}, "a")
+	assert.Equal(t, ErrUnsupportedPartitionKeyVersion, err)
+	_, err = EffectivePartitionKey(PartitionKey{}, []string{"a"})
+	assert.Equal(t, ErrInvalidPartitionKeyType, err)
+}
+
+func TestEffectivePartitionKeyMultiHash(t *testing.T) {
+	pk := PartitionKey{Paths: []string{"/tenant", "/user", "/session"}, Kind: PartitionKindMultiHash, Version: 2}
+	epk, err := EffectivePartitionKey(pk, "partitionKey", "")
+	require.NoError(t, err)
+	// Every value is hashed on its own
+	assert.Equal(t, "013AEFCF77FA271571CF665A58C933F1"+"32E9366E637A71B4E710384B2F4970A0", epk)
+
+	hierarchical, err := EffectivePartitionKey(pk, HierarchicalPartitionKey{"partitionKey", ""})
+	require.NoError(t, err)
+	assert.Equal(t, epk, hierarchical)
+
+	min, max, err := EffectivePartitionKeyRange(pk, "partitionKey")
+	require.NoError(t, err)
+	assert.Equal(t, "013AEFCF77FA271571CF665A58C933F1", min)
+	assert.Equal(t, "013AEFCF77FA271571CF665A58C933F1FF", max)
+	assert.True(t, min <= epk && epk < max)
+
+	_, err = EffectivePartitionKey(pk, "a", "b", "c", "d")
+	assert.Error(t, err)
+	_, err = EffectivePartitionKey(PartitionKey{Paths: []string{"/a"}, Kind: PartitionKindMultiHash}, "a")
+	assert.Equal(t, ErrUnsupportedPartitionKeyVersion, err)
+}
+
+func TestPartitionKeyValidate(t *testing.T) {
+	assert.NoError(t, PartitionKey{Paths: []string{"/id"}, Kind: PartitionKindHash}.Validate())
+	assert.NoError(t, PartitionKey{Paths: []string{"/id"}}.Validate(), "Hash by default")
+	assert.Error(t, PartitionKey{Paths: []string{"/a", "/b"}}.Validate())
+	assert.NoError(t, PartitionKey{Paths: []string{"/tenant/id", "/userId"}, Kind: PartitionKindMultiHash, Version: 2}.Validate())
+	assert.Error(t, PartitionKey{Paths: []string{"/a", "/b"}, Kind: PartitionKindHash}.Validate())
+	assert.Error(t, PartitionKey{Paths: []string{"/a", "/b"}, Kind: PartitionKindMultiHash}.Validate())
+	assert.Error(t, PartitionKey{Paths: []string{"/a", "/b", "/c", "/d"}, Kind: PartitionKindMultiHash, Version: 2}.Validate())
+	assert.Error(t, PartitionKey{Paths: []string{"id"}, Kind: PartitionKindHash}.Validate())
+	assert.Error(t, PartitionKey{Paths: []string{"/id"}, Kind: "Range"}.Validate())
+}
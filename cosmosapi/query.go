@@ -4,6 +4,8 @@ import (
 	"context"
 	"net/http"
 	"strconv"
+
+	"github.com/vippsas/go-cosmosdb/logging"
 )
 
 type Query struct {
@@ -23,6 +25,11 @@ type QueryDocumentsResponse struct {
 	Documents    interface{}
 	Count        int `json:"_count"`
 	Continuation string
+	SessionToken string
+	// QueryMetrics is set if QueryDocumentsOptions.PopulateQueryMetrics was
+	QueryMetrics *QueryMetrics
+	// IndexMetrics is set if QueryDocumentsOptions.PopulateIndexMetrics was
+	IndexMetrics *IndexMetrics
 }
 
 // QueryDocumentsOptions bundles all options supported by Cosmos DB when
@@ -36,6 +43,14 @@ type QueryDocumentsOptions struct {
 	EnableCrossPartition bool
 	ConsistencyLevel     ConsistencyLevel
 	SessionToken         string
+	// PartitionKeyRangeId restricts a cross partition query to a single
+	// partition key range
+	PartitionKeyRangeId string
+	// PopulateQueryMetrics requests QueryDocumentsResponse.QueryMetrics
+	PopulateQueryMetrics bool
+	// PopulateIndexMetrics requests QueryDocumentsResponse.IndexMetrics. It
+	// adds to the cost of the query, so it should only be used for diagnostics.
+	PopulateIndexMetrics bool
 }
 
 const QUERY_CONTENT_TYPE = "application/query+json"
@@ -55,7 +70,7 @@ func (c *Client) QueryDocuments(ctx context.Context, dbName, collName string, qr
 	if err != nil {
 		return response, err
 	}
-	return response.parse(httpResponse)
+	return response.parse(httpResponse, c.Log)
 }
 
 // DefaultQueryDocumentOptions returns QueryDocumentsOptions populated with
@@ -110,12 +125,45 @@ func (ops QueryDocumentsOptions) asHeaders() (map[string]string, error) {
 		headers[HEADER_SESSION_TOKEN] = ops.SessionToken
 	}
 
+	if ops.PartitionKeyRangeId != "" {
+		headers[HEADER_PARTITION_KEY_RANGE_ID] = ops.PartitionKeyRangeId
+	}
+
+	if ops.PopulateQueryMetrics {
+		headers[HEADER_POPULATE_QUERY_METRICS] = "true"
+	}
+
+	if ops.PopulateIndexMetrics {
+		headers[HEADER_POPULATE_INDEX_METRICS] = "true"
+	}
+
 	return headers, nil
 }
 
-func (r QueryDocumentsResponse) parse(httpResponse *http.Response) (QueryDocumentsResponse, error) {
+// parse returns the response with the headers of httpResponse. Diagnostics
+// that can not be parsed are logged and left nil, as they should not fail a
+// query that succeeded.
+func (r QueryDocumentsResponse) parse(httpResponse *http.Response, log logging.ExtendedLogger) (QueryDocumentsResponse, error) {
 	responseBase, err := parseHttpResponse(httpResponse)
 	r.ResponseBase = responseBase
 	r.Continuation = httpResponse.Header.Get(HEADER_CONTINUATION)
-	return r, err
+	r.SessionToken = httpResponse.Header.Get(HEADER_SESSION_TOKEN)
+	if err != nil {
+		return r, err
+	}
+	if header := httpResponse.Header.Get(HEADER_QUERY_METRICS); header != "" {
+		if metrics, err := ParseQueryMetrics(header); err != nil {
+			log.Warnf("Could not parse %s: %s\n", HEADER_QUERY_METRICS, err)
+		} else {
+			r.QueryMetrics = &metrics
+		}
+	}
+	if header := httpResponse.Header.Get(HEADER_INDEX_METRICS); header != "" {
+		if metrics, err := ParseIndexMetrics(header); err != nil {
+			log.Warnf("Could not parse %s: %s\n", HEADER_INDEX_METRICS, err)
+		} else {
+			r.IndexMetrics = &metrics
+		}
+	}
+	return r, nil
 }
@@ -0,0 +1,204 @@
+package cosmosapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// QueryMetrics tells where the time of a query went, returned when
+// QueryDocumentsOptions.PopulateQueryMetrics is set. See
+// https://docs.microsoft.com/en-us/azure/cosmos-db/sql/query-metrics
+type QueryMetrics struct {
+	TotalExecutionTime         time.Duration
+	QueryCompileTime           time.Duration
+	QueryLogicalPlanBuildTime  time.Duration
+	QueryPhysicalPlanBuildTime time.Duration
+	QueryOptimizationTime      time.Duration
+	IndexLookupTime            time.Duration
+	DocumentLoadTime           time.Duration
+	VMExecutionTime            time.Duration
+	SystemFunctionExecuteTime  time.Duration
+	UserFunctionExecuteTime    time.Duration
+	DocumentWriteTime          time.Duration
+
+	// RetrievedDocumentCount is the number of documents loaded to evaluate the
+	// query; if it is much larger than OutputDocumentCount, the query is not
+	// served well by the index.
+	RetrievedDocumentCount int64
+	RetrievedDocumentSize  int64
+	OutputDocumentCount    int64
+	OutputDocumentSize     int64
+	// IndexHitRatio is the fraction of the retrieved documents that matched
+	// the filters of the query through the index.
+	IndexHitRatio float64
+}
+
+// ParseQueryMetrics parses the semicolon-separated name=value pairs of the
+// x-ms-documentdb-query-metrics header. Unknown names are ignored.
+func ParseQueryMetrics(header string) (QueryMetrics, error) {
+	var m QueryMetrics
+	durations := map[string]*time.Duration{
+		"totalExecutionTimeInMs":         &m.TotalExecutionTime,
+		"queryCompileTimeInMs":           &m.QueryCompileTime,
+		"queryLogicalPlanBuildTimeInMs":  &m.QueryLogicalPlanBuildTime,
+		"queryPhysicalPlanBuildTimeInMs": &m.QueryPhysicalPlanBuildTime,
+		"queryOptimizationTimeInMs":      &m.QueryOptimizationTime,
+		"indexLookupTimeInMs":            &m.IndexLookupTime,
+		"documentLoadTimeInMs":           &m.DocumentLoadTime,
+		"VMExecutionTimeInMs":            &m.VMExecutionTime,
+		"systemFunctionExecuteTimeInMs":  &m.SystemFunctionExecuteTime,
+		"userFunctionExecuteTimeInMs":    &m.UserFunctionExecuteTime,
+		"writeOutputTimeInMs":            &m.DocumentWriteTime,
+	}
+	counts := map[string]*int64{
+		"retrievedDocumentCount": &m.RetrievedDocumentCount,
+		"retrievedDocumentSize":  &m.RetrievedDocumentSize,
+		"outputDocumentCount":    &m.OutputDocumentCount,
+		"outputDocumentSize":     &m.OutputDocumentSize,
+	}
+	for _, pair := range strings.Split(header, ";") {
+		if pair == "" {
+			continue
+		}
+		eq := strings.Index(pair, "=")
+		if eq < 0 {
+			return m, errors.Errorf("Invalid query metrics '%s'", pair)
+		}
+		name, value := pair[:eq], pair[eq+1:]
+		if d, ok := durations[name]; ok {
+			ms, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return m, errors.Wrapf(err, "Invalid query metrics '%s'", pair)
+			}
+			*d = time.Duration(ms * float64(time.Millisecond))
+		} else if c, ok := counts[name]; ok {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return m, errors.Wrapf(err, "Invalid query metrics '%s'", pair)
+			}
+			*c = n
+		} else if name == "indexUtilizationRatio" {
+			ratio, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return m, errors.Wrapf(err, "Invalid query metrics '%s'", pair)
+			}
+			m.IndexHitRatio = ratio
+		}
+	}
+	return m, nil
+}
+
+// Add returns the sum of the metrics of two executions, e.g. two pages of a
+// query. The IndexHitRatio is weighted by the retrieved documents.
+func (m QueryMetrics) Add(other QueryMetrics) QueryMetrics {
+	hits := m.IndexHitRatio*float64(m.RetrievedDocumentCount) + other.IndexHitRatio*float64(other.RetrievedDocumentCount)
+	m.TotalExecutionTime += other.TotalExecutionTime
+	m.QueryCompileTime += other.QueryCompileTime
+	m.QueryLogicalPlanBuildTime += other.QueryLogicalPlanBuildTime
+	m.QueryPhysicalPlanBuildTime += other.QueryPhysicalPlanBuildTime
+	m.QueryOptimizationTime += other.QueryOptimizationTime
+	m.IndexLookupTime += other.IndexLookupTime
+	m.DocumentLoadTime += other.DocumentLoadTime
+	m.VMExecutionTime += other.VMExecutionTime
+	m.SystemFunctionExecuteTime += other.SystemFunctionExecuteTime
+	m.UserFunctionExecuteTime += other.UserFunctionExecuteTime
+	m.DocumentWriteTime += other.DocumentWriteTime
+	m.RetrievedDocumentCount += other.RetrievedDocumentCount
+	m.RetrievedDocumentSize += other.RetrievedDocumentSize
+	m.OutputDocumentCount += other.OutputDocumentCount
+	m.OutputDocumentSize += other.OutputDocumentSize
+	if m.RetrievedDocumentCount > 0 {
+		m.IndexHitRatio = hits / float64(m.RetrievedDocumentCount)
+	}
+	return m
+}
+
+// IndexMetrics tells which indexes a query used, and which indexes could make
+// it cheaper, returned when QueryDocumentsOptions.PopulateIndexMetrics is set.
+type IndexMetrics struct {
+	UtilizedSingleIndexes     []SingleIndexMetrics    `json:"UtilizedSingleIndexes"`
+	PotentialSingleIndexes    []SingleIndexMetrics    `json:"PotentialSingleIndexes"`
+	UtilizedCompositeIndexes  []CompositeIndexMetrics `json:"UtilizedCompositeIndexes"`
+	PotentialCompositeIndexes []CompositeIndexMetrics `json:"PotentialCompositeIndexes"`
+}
+
+type SingleIndexMetrics struct {
+	FilterExpression string `json:"FilterExpression"`
+	// IndexSpec is an index path, like "/name/?"
+	IndexSpec        string `json:"IndexSpec"`
+	FilterPreciseSet bool   `json:"FilterPreciseSet"`
+	IndexPreciseSet  bool   `json:"IndexPreciseSet"`
+	// IndexImpactScore is "High" or "Low"
+	IndexImpactScore string `json:"IndexImpactScore"`
+}
+
+type CompositeIndexMetrics struct {
+	// IndexSpecs are the paths with order, like "/name ASC"
+	IndexSpecs       []string `json:"IndexSpecs"`
+	IndexPreciseSet  bool     `json:"IndexPreciseSet"`
+	IndexImpactScore string   `json:"IndexImpactScore"`
+}
+
+// ParseIndexMetrics parses the base64 encoded JSON of the
+// x-ms-cosmos-index-utilization header.
+func ParseIndexMetrics(header string) (IndexMetrics, error) {
+	var m IndexMetrics
+	data, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return m, errors.Wrap(err, "Invalid index metrics")
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, errors.Wrap(err, "Invalid index metrics")
+	}
+	return m, nil
+}
+
+// Merge returns the indexes of both m and other, without duplicates.
+func (m IndexMetrics) Merge(other IndexMetrics) IndexMetrics {
+	return IndexMetrics{
+		UtilizedSingleIndexes:     mergeSingleIndexes(m.UtilizedSingleIndexes, other.UtilizedSingleIndexes),
+		PotentialSingleIndexes:    mergeSingleIndexes(m.PotentialSingleIndexes, other.PotentialSingleIndexes),
+		UtilizedCompositeIndexes:  mergeCompositeIndexes(m.UtilizedCompositeIndexes, other.UtilizedCompositeIndexes),
+		PotentialCompositeIndexes: mergeCompositeIndexes(m.PotentialCompositeIndexes, other.PotentialCompositeIndexes),
+	}
+}
+
+func mergeSingleIndexes(a, b []SingleIndexMetrics) []SingleIndexMetrics {
+	merged := append([]SingleIndexMetrics{}, a...)
+	for _, x := range b {
+		found := false
+		for _, y := range a {
+			if x == y {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, x)
+		}
+	}
+	return merged
+}
+
+func mergeCompositeIndexes(a, b []CompositeIndexMetrics) []CompositeIndexMetrics {
+	merged := append([]CompositeIndexMetrics{}, a...)
+	for _, x := range b {
+		found := false
+		for _, y := range a {
+			if strings.Join(x.IndexSpecs, ",") == strings.Join(y.IndexSpecs, ",") &&
+				x.IndexPreciseSet == y.IndexPreciseSet && x.IndexImpactScore == y.IndexImpactScore {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, x)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,141 @@
+package cosmosapi
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testQueryMetrics = "totalExecutionTimeInMs=33.67;queryCompileTimeInMs=0.06;queryLogicalPlanBuildTimeInMs=0.02;" +
+	"queryPhysicalPlanBuildTimeInMs=0.10;queryOptimizationTimeInMs=0.00;VMExecutionTimeInMs=32.56;indexLookupTimeInMs=0.36;" +
+	"documentLoadTimeInMs=9.58;systemFunctionExecuteTimeInMs=0.00;userFunctionExecuteTimeInMs=0.00;retrievedDocumentCount=2000;" +
+	"retrievedDocumentSize=1125600;outputDocumentCount=500;outputDocumentSize=281400;writeOutputTimeInMs=18.10;indexUtilizationRatio=0.25"
+
+const testIndexMetrics = `{"UtilizedSingleIndexes":[{"FilterExpression":"","IndexSpec":"/userId/?","FilterPreciseSet":true,"IndexPreciseSet":true,"IndexImpactScore":"High"}],` +
+	`"PotentialSingleIndexes":[],"UtilizedCompositeIndexes":[],` +
+	`"PotentialCompositeIndexes":[{"IndexSpecs":["/userId ASC","/x DESC"],"IndexPreciseSet":false,"IndexImpactScore":"High"}]}`
+
+func TestParseQueryMetrics(t *testing.T) {
+	m, err := ParseQueryMetrics(testQueryMetrics)
+	require.NoError(t, err)
+	assert.Equal(t, 33670*time.Microsecond, m.TotalExecutionTime)
+	assert.Equal(t, 32560*time.Microsecond, m.VMExecutionTime)
+	assert.Equal(t, 360*time.Microsecond, m.IndexLookupTime)
+	assert.Equal(t, 18100*time.Microsecond, m.DocumentWriteTime)
+	assert.Equal(t, int64(2000), m.RetrievedDocumentCount)
+	assert.Equal(t, int64(1125600), m.RetrievedDocumentSize)
+	assert.Equal(t, int64(500), m.OutputDocumentCount)
+	assert.Equal(t, 0.25, m.IndexHitRatio)
+
+	m, err = ParseQueryMetrics("someFutureMetric=1;retrievedDocumentCount=3")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), m.RetrievedDocumentCount)
+
+	for _, invalid := range []string{"retrievedDocumentCount", "retrievedDocumentCount=1.5", "VMExecutionTimeInMs=fast"} {
+		_, err = ParseQueryMetrics(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestQueryMetricsAdd(t *testing.T) {
+	a := QueryMetrics{TotalExecutionTime: time.Second, RetrievedDocumentCount: 100, OutputDocumentCount: 10, IndexHitRatio: 1}
+	b := QueryMetrics{TotalExecutionTime: time.Second, RetrievedDocumentCount: 300, OutputDocumentCount: 20}
+	sum := a.Add(b)
+	assert.Equal(t, 2*time.Second, sum.TotalExecutionTime)
+	assert.Equal(t, int64(400), sum.RetrievedDocumentCount)
+	assert.Equal(t, int64(30), sum.OutputDocumentCount)
+	assert.Equal(t, 0.25, sum.IndexHitRatio)
+	assert.Equal(t, QueryMetrics{}, QueryMetrics{}.Add(QueryMetrics{}))
+}
+
+func TestParseIndexMetrics(t *testing.T) {
+	m, err := ParseIndexMetrics(base64.StdEncoding.EncodeToString([]byte(testIndexMetrics)))
+	require.NoError(t, err)
+	require.Len(t, m.UtilizedSingleIndexes, 1)
+	assert.Equal(t, "/userId/?", m.UtilizedSingleIndexes[0].IndexSpec)
+	assert.Equal(t, "High", m.UtilizedSingleIndexes[0].IndexImpactScore)
+	require.Len(t, m.PotentialCompositeIndexes, 1)
+	assert.Equal(t, []string{"/userId ASC", "/x DESC"}, m.PotentialCompositeIndexes[0].IndexSpecs)
+
+	merged := m.Merge(IndexMetrics{
+		UtilizedSingleIndexes: []SingleIndexMetrics{m.UtilizedSingleIndexes[0], {IndexSpec: "/x/?"}},
+	})
+	assert.Len(t, merged.UtilizedSingleIndexes, 2)
+	assert.Len(t, merged.PotentialCompositeIndexes, 1)
+
+	_, err = ParseIndexMetrics("not base64!")
+	assert.Error(t, err)
+	_, err = ParseIndexMetrics(base64.StdEncoding.EncodeToString([]byte("not json")))
+	assert.Error(t, err)
+}
+
+func TestQueryPaginator(t *testing.T) {
+	var requests []*http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		w.Header().Set(HEADER_REQUEST_CHARGE, "2.5")
+		w.Header().Set(HEADER_QUERY_METRICS, "retrievedDocumentCount=10;outputDocumentCount=2;indexUtilizationRatio=0.5")
+		w.Header().Set(HEADER_INDEX_METRICS, base64.StdEncoding.EncodeToString([]byte(testIndexMetrics)))
+		if r.Header.Get(HEADER_CONTINUATION) == "" {
+			w.Header().Set(HEADER_CONTINUATION, "page2")
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(testPage))
+	}))
+	defer ts.Close()
+	c := New(ts.URL, Config{MasterKey: TestKey}, nil, nil)
+
+	ops := DefaultQueryDocumentOptions()
+	ops.PopulateQueryMetrics = true
+	ops.PopulateIndexMetrics = true
+	p := c.NewQueryPaginator("db", "coll", Query{Query: "SELECT * FROM c"}, ops)
+	var pages int
+	for p.HasMore() {
+		var docs []Document
+		response, err := p.Next(context.Background(), &docs)
+		require.NoError(t, err)
+		require.Len(t, docs, 2)
+		require.NotNil(t, response.QueryMetrics)
+		assert.Equal(t, int64(10), response.QueryMetrics.RetrievedDocumentCount)
+		pages++
+	}
+	assert.Equal(t, 2, pages)
+	require.Len(t, requests, 2)
+	assert.Equal(t, "true", requests[0].Header.Get(HEADER_POPULATE_QUERY_METRICS))
+	assert.Equal(t, "true", requests[0].Header.Get(HEADER_POPULATE_INDEX_METRICS))
+	assert.Equal(t, "page2", requests[1].Header.Get(HEADER_CONTINUATION))
+
+	assert.Equal(t, 5.0, p.RequestCharge())
+	assert.Equal(t, "", p.Continuation())
+	assert.Equal(t, &QueryMetrics{RetrievedDocumentCount: 20, OutputDocumentCount: 4, IndexHitRatio: 0.5}, p.QueryMetrics())
+	require.NotNil(t, p.IndexMetrics())
+	assert.Len(t, p.IndexMetrics().UtilizedSingleIndexes, 1)
+
+	var docs []Document
+	_, err := p.Next(context.Background(), &docs)
+	assert.Equal(t, ErrNoMorePages, errors.Cause(err))
+}
+
+func TestQueryInvalidMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HEADER_QUERY_METRICS, "retrievedDocumentCount=ten")
+		w.Header().Set(HEADER_INDEX_METRICS, "not base64")
+		w.Write([]byte(testPage))
+	}))
+	defer ts.Close()
+	c := New(ts.URL, Config{MasterKey: TestKey}, nil, nil)
+
+	var docs []Document
+	response, err := c.QueryDocuments(context.Background(), "db", "coll", Query{Query: "SELECT * FROM c"}, &docs, DefaultQueryDocumentOptions())
+	require.NoError(t, err, "diagnostics do not fail the query")
+	assert.Len(t, docs, 2)
+	assert.Nil(t, response.QueryMetrics)
+	assert.Nil(t, response.IndexMetrics)
+}
@@ -0,0 +1,109 @@
+package cosmosapi
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+var ErrNoMorePages = errors.New("The query has no more pages")
+
+// QueryPaginator fetches the pages of a query one at a time by following the
+// continuation tokens, and sums up the request charge and the diagnostics of
+// the pages fetched so far.
+//
+//	p := client.NewQueryPaginator(db, coll, query, ops)
+//	for p.HasMore() {
+//	  var page []Entity
+//	  if _, err := p.Next(ctx, &page); err != nil {
+//	    return err
+//	  }
+//	  ...
+//	}
+//	log.Printf("Query cost %f RUs: %+v", p.RequestCharge(), p.QueryMetrics())
+type QueryPaginator struct {
+	client   *Client
+	dbName   string
+	collName string
+	query    Query
+	ops      QueryDocumentsOptions
+	started  bool
+	queryStats
+}
+
+// NewQueryPaginator returns a paginator of the query, starting at
+// ops.Continuation.
+func (c *Client) NewQueryPaginator(dbName, collName string, qry Query, ops QueryDocumentsOptions) *QueryPaginator {
+	return &QueryPaginator{client: c, dbName: dbName, collName: collName, query: qry, ops: ops}
+}
+
+// HasMore returns whether there are more pages to fetch.
+func (p *QueryPaginator) HasMore() bool {
+	return !p.started || p.ops.Continuation != ""
+}
+
+// Next fetches the next page into docs, which should be a pointer to a slice.
+func (p *QueryPaginator) Next(ctx context.Context, docs interface{}) (QueryDocumentsResponse, error) {
+	if !p.HasMore() {
+		return QueryDocumentsResponse{}, ErrNoMorePages
+	}
+	response, err := p.client.QueryDocuments(ctx, p.dbName, p.collName, p.query, docs, p.ops)
+	if err != nil {
+		return response, err
+	}
+	p.started = true
+	p.ops.Continuation = response.Continuation
+	p.add(response)
+	return response, nil
+}
+
+// Continuation returns the token to resume the query with after the pages
+// fetched so far.
+func (p *QueryPaginator) Continuation() string {
+	return p.ops.Continuation
+}
+
+// queryStats sums up the request charge and diagnostics of the pages of a
+// query
+type queryStats struct {
+	requestCharge float64
+	queryMetrics  *QueryMetrics
+	indexMetrics  *IndexMetrics
+}
+
+func (s *queryStats) add(response QueryDocumentsResponse) {
+	s.requestCharge += response.RequestCharge
+	if response.QueryMetrics != nil {
+		metrics := *response.QueryMetrics
+		if s.queryMetrics != nil {
+			metrics = s.queryMetrics.Add(metrics)
+		}
+		s.queryMetrics = &metrics
+	}
+	if response.IndexMetrics != nil {
+		metrics := *response.IndexMetrics
+		if s.indexMetrics != nil {
+			metrics = s.indexMetrics.Merge(metrics)
+		}
+		s.indexMetrics = &metrics
+	}
+}
+
+// RequestCharge returns the request units spent on the pages fetched so far.
+func (s *queryStats) RequestCharge() float64 {
+	return s.requestCharge
+}
+
+// QueryMetrics returns the sum of the query metrics of the pages fetched so
+// far, or nil if they were not requested with
+// QueryDocumentsOptions.PopulateQueryMetrics.
+func (s *queryStats) QueryMetrics() *QueryMetrics {
+	return s.queryMetrics
+}
+
+// IndexMetrics returns the indexes used and suggested for the pages fetched so
+// far, or nil if they were not requested with
+// QueryDocumentsOptions.PopulateIndexMetrics.
+func (s *queryStats) IndexMetrics() *IndexMetrics {
+	return s.indexMetrics
+}
@@ -0,0 +1,141 @@
+package querybuilder
+
+import (
+	"strings"
+)
+
+// Condition is a boolean expression for Builder.Where.
+type Condition interface {
+	build(q *query) string
+}
+
+type comparison struct {
+	field string
+	op    string
+	value interface{}
+}
+
+func (c comparison) build(q *query) string {
+	return q.field(c.field) + " " + c.op + " " + q.bind(c.value)
+}
+
+// Eq is true if field equals value.
+func Eq(field string, value interface{}) Condition { return comparison{field, "=", value} }
+
+// Ne is true if field does not equal value.
+func Ne(field string, value interface{}) Condition { return comparison{field, "!=", value} }
+
+// Lt is true if field is less than value.
+func Lt(field string, value interface{}) Condition { return comparison{field, "<", value} }
+
+// Le is true if field is less than or equal to value.
+func Le(field string, value interface{}) Condition { return comparison{field, "<=", value} }
+
+// Gt is true if field is greater than value.
+func Gt(field string, value interface{}) Condition { return comparison{field, ">", value} }
+
+// Ge is true if field is greater than or equal to value.
+func Ge(field string, value interface{}) Condition { return comparison{field, ">=", value} }
+
+type between struct {
+	field     string
+	low, high interface{}
+}
+
+func (c between) build(q *query) string {
+	return "(" + q.field(c.field) + " BETWEEN " + q.bind(c.low) + " AND " + q.bind(c.high) + ")"
+}
+
+// Between is true if field is in the range [low, high].
+func Between(field string, low, high interface{}) Condition { return between{field, low, high} }
+
+type in struct {
+	field  string
+	values []interface{}
+}
+
+func (c in) build(q *query) string {
+	if len(c.values) == 0 {
+		return "false"
+	}
+	names := make([]string, len(c.values))
+	for i, v := range c.values {
+		names[i] = q.bind(v)
+	}
+	return q.field(c.field) + " IN (" + strings.Join(names, ", ") + ")"
+}
+
+// In is true if field equals one of the values.
+func In(field string, values ...interface{}) Condition { return in{field, values} }
+
+type function struct {
+	name  string
+	field string
+	args  []interface{}
+	flag  string
+}
+
+func (c function) build(q *query) string {
+	args := []string{q.field(c.field)}
+	for _, a := range c.args {
+		args = append(args, q.bind(a))
+	}
+	if c.flag != "" {
+		args = append(args, c.flag)
+	}
+	return c.name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// ArrayContains is true if the array field contains value.
+func ArrayContains(field string, value interface{}) Condition {
+	return function{name: "ARRAY_CONTAINS", field: field, args: []interface{}{value}}
+}
+
+// ArrayContainsPartial is true if the array field contains an object having
+// (at least) the properties of value.
+func ArrayContainsPartial(field string, value interface{}) Condition {
+	return function{name: "ARRAY_CONTAINS", field: field, args: []interface{}{value}, flag: "true"}
+}
+
+// StartsWith is true if the string field starts with prefix.
+func StartsWith(field string, prefix string) Condition {
+	return function{name: "STARTSWITH", field: field, args: []interface{}{prefix}}
+}
+
+// IsDefined is true if the document has field.
+func IsDefined(field string) Condition {
+	return function{name: "IS_DEFINED", field: field}
+}
+
+type logical struct {
+	op         string
+	conditions []Condition
+}
+
+func (c logical) build(q *query) string {
+	if len(c.conditions) == 0 {
+		// The neutral element
+		if c.op == "AND" {
+			return "true"
+		}
+		return "false"
+	}
+	return "(" + q.join(c.conditions, " "+c.op+" ") + ")"
+}
+
+// And is true if all the conditions are true.
+func And(conditions ...Condition) Condition { return logical{"AND", conditions} }
+
+// Or is true if any of the conditions are true.
+func Or(conditions ...Condition) Condition { return logical{"OR", conditions} }
+
+type not struct {
+	condition Condition
+}
+
+func (c not) build(q *query) string {
+	return "NOT (" + c.condition.build(q) + ")"
+}
+
+// Not is true if the condition is false.
+func Not(condition Condition) Condition { return not{condition} }
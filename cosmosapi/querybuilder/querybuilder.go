@@ -0,0 +1,304 @@
+// Package querybuilder builds parameterized Cosmos DB SQL queries.
+//
+// Values are never formatted into the query text; they are bound to
+// parameters named @p0, @p1, ... in the order they appear. Fields are paths
+// relative to the document, with dots between the property names, like
+// "customer.id". A field starting with the alias of a JOIN refers to the
+// joined array element instead. Property names that are not plain identifiers
+// are quoted, so neither values nor field names can alter the query.
+//
+//	query, err := querybuilder.Select("id", "name").
+//	  Where(querybuilder.Eq("userId", userId), querybuilder.StartsWith("name", "A")).
+//	  OrderBy("name").
+//	  Build()
+package querybuilder
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+const defaultAlias = "c"
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type join struct {
+	alias string
+	field string
+}
+
+type orderBy struct {
+	field      string
+	descending bool
+}
+
+// Builder builds a query. Start with Select, SelectValue or SelectCount; the
+// methods modify and return the Builder so that calls can be chained.
+type Builder struct {
+	alias      string
+	fields     []string
+	value      bool
+	count      bool
+	distinct   bool
+	top        int
+	joins      []join
+	conditions []Condition
+	orderBy    []orderBy
+	offset     int
+	limit      int
+	paged      bool
+}
+
+// Select selects the given fields of the documents, or the whole documents
+// if no fields are given.
+func Select(fields ...string) *Builder {
+	return &Builder{alias: defaultAlias, fields: fields}
+}
+
+// SelectValue selects the value of a single field, instead of an object
+// holding it.
+func SelectValue(field string) *Builder {
+	return &Builder{alias: defaultAlias, fields: []string{field}, value: true}
+}
+
+// SelectCount selects the number of matching documents.
+func SelectCount() *Builder {
+	return &Builder{alias: defaultAlias, count: true}
+}
+
+// From sets the alias of the documents in the query, "c" by default.
+func (b *Builder) From(alias string) *Builder {
+	b.alias = alias
+	return b
+}
+
+// Distinct removes duplicates from the results.
+func (b *Builder) Distinct() *Builder {
+	b.distinct = true
+	return b
+}
+
+// Top limits the number of results. It can not be combined with OffsetLimit.
+func (b *Builder) Top(n int) *Builder {
+	b.top = n
+	return b
+}
+
+// Join joins every document with each element of the array field, which can
+// be referred to with alias in the rest of the query.
+func (b *Builder) Join(alias, field string) *Builder {
+	b.joins = append(b.joins, join{alias: alias, field: field})
+	return b
+}
+
+// Where adds conditions that must all be true.
+func (b *Builder) Where(conditions ...Condition) *Builder {
+	b.conditions = append(b.conditions, conditions...)
+	return b
+}
+
+// OrderBy sorts the results by field in ascending order. Sorting by more
+// than one field requires a composite index.
+func (b *Builder) OrderBy(field string) *Builder {
+	b.orderBy = append(b.orderBy, orderBy{field: field})
+	return b
+}
+
+// OrderByDesc sorts the results by field in descending order.
+func (b *Builder) OrderByDesc(field string) *Builder {
+	b.orderBy = append(b.orderBy, orderBy{field: field, descending: true})
+	return b
+}
+
+// OffsetLimit skips the first offset results, and returns at most limit.
+func (b *Builder) OffsetLimit(offset, limit int) *Builder {
+	b.offset, b.limit, b.paged = offset, limit, true
+	return b
+}
+
+// Build returns the query text and its parameters.
+func (b *Builder) Build() (cosmosapi.Query, error) {
+	q := &query{aliases: map[string]bool{b.alias: true}, from: b.alias}
+	if !identifier.MatchString(b.alias) {
+		return cosmosapi.Query{}, errors.Errorf("Invalid alias '%s'", b.alias)
+	}
+	var sql strings.Builder
+	sql.WriteString("SELECT ")
+	if b.distinct {
+		sql.WriteString("DISTINCT ")
+	}
+	if b.top < 0 || (b.top > 0 && b.paged) {
+		return cosmosapi.Query{}, errors.New("TOP must be positive, and can not be combined with OFFSET LIMIT")
+	}
+	if b.top > 0 {
+		sql.WriteString("TOP " + strconv.Itoa(b.top) + " ")
+	}
+
+	// The join aliases must be known before the projection is rendered
+	var joins []string
+	for _, j := range b.joins {
+		if !identifier.MatchString(j.alias) || q.aliases[j.alias] {
+			return cosmosapi.Query{}, errors.Errorf("Invalid or duplicate alias '%s'", j.alias)
+		}
+		joins = append(joins, "JOIN "+j.alias+" IN "+q.field(j.field))
+		q.aliases[j.alias] = true
+	}
+
+	switch {
+	case b.count:
+		sql.WriteString("VALUE COUNT(1)")
+	case len(b.fields) == 0:
+		sql.WriteString("*")
+	default:
+		if b.value {
+			sql.WriteString("VALUE ")
+		}
+		for i, field := range b.fields {
+			if i > 0 {
+				sql.WriteString(", ")
+			}
+			sql.WriteString(q.field(field))
+		}
+	}
+	sql.WriteString(" FROM " + b.alias)
+	for _, j := range joins {
+		sql.WriteString(" " + j)
+	}
+
+	if len(b.conditions) > 0 {
+		sql.WriteString(" WHERE ")
+		sql.WriteString(q.join(b.conditions, " AND "))
+	}
+	if len(b.orderBy) > 0 {
+		sql.WriteString(" ORDER BY ")
+		for i, o := range b.orderBy {
+			if i > 0 {
+				sql.WriteString(", ")
+			}
+			sql.WriteString(q.field(o.field))
+			if o.descending {
+				sql.WriteString(" DESC")
+			} else {
+				sql.WriteString(" ASC")
+			}
+		}
+	}
+	if b.paged {
+		if b.offset < 0 || b.limit < 0 {
+			return cosmosapi.Query{}, errors.New("OFFSET and LIMIT must not be negative")
+		}
+		sql.WriteString(" OFFSET " + strconv.Itoa(b.offset) + " LIMIT " + strconv.Itoa(b.limit))
+	}
+	if q.err != nil {
+		return cosmosapi.Query{}, q.err
+	}
+	return cosmosapi.Query{Query: sql.String(), Params: q.params}, nil
+}
+
+// PartitionKeyValues returns the values the query requires the fields at
+// the given partition key paths (like "/customer/id" or "userId") to be
+// equal to, if it has such a condition for every path at the top level
+// (i.e. not within Or or Not). If so, the query only needs to run in the
+// partition with those values.
+func (b *Builder) PartitionKeyValues(paths ...string) ([]interface{}, bool) {
+	if len(paths) == 0 {
+		return nil, false
+	}
+	equal := map[string]interface{}{}
+	b.collectEqualities(b.conditions, equal)
+	values := make([]interface{}, len(paths))
+	for i, path := range paths {
+		value, ok := equal[normalizeField(path)]
+		if !ok {
+			return nil, false
+		}
+		values[i] = value
+	}
+	return values, true
+}
+
+func (b *Builder) collectEqualities(conditions []Condition, equal map[string]interface{}) {
+	for _, c := range conditions {
+		switch c := c.(type) {
+		case comparison:
+			if c.op == "=" {
+				equal[strings.TrimPrefix(c.field, b.alias+".")] = c.value
+			}
+		case logical:
+			if c.op == "AND" {
+				b.collectEqualities(c.conditions, equal)
+			}
+		}
+	}
+}
+
+// normalizeField turns a path like "/customer/id" into a field like "customer.id"
+func normalizeField(path string) string {
+	return strings.Replace(strings.TrimPrefix(path, "/"), "/", ".", -1)
+}
+
+// query holds the state of a query being built
+type query struct {
+	from    string
+	aliases map[string]bool
+	params  []cosmosapi.QueryParam
+	err     error
+}
+
+// bind adds a parameter with the value and returns its name
+func (q *query) bind(value interface{}) string {
+	name := "@p" + strconv.Itoa(len(q.params))
+	q.params = append(q.params, cosmosapi.QueryParam{Name: name, Value: value})
+	return name
+}
+
+// field renders a field, relative to the document unless it starts with the
+// alias of a JOIN
+func (q *query) field(field string) string {
+	names := strings.Split(field, ".")
+	var sql strings.Builder
+	if len(names) > 1 && q.aliases[names[0]] {
+		sql.WriteString(names[0])
+		names = names[1:]
+	} else {
+		sql.WriteString(q.from)
+	}
+	for _, name := range names {
+		switch {
+		case name == "":
+			q.fail(errors.Errorf("Invalid field '%s'", field))
+		case identifier.MatchString(name):
+			sql.WriteString("." + name)
+		case isIndex(name):
+			sql.WriteString("[" + name + "]")
+		default:
+			quoted, _ := json.Marshal(name)
+			sql.WriteString("[" + string(quoted) + "]")
+		}
+	}
+	return sql.String()
+}
+
+func isIndex(name string) bool {
+	_, err := strconv.ParseUint(name, 10, 32)
+	return err == nil
+}
+
+func (q *query) join(conditions []Condition, op string) string {
+	parts := make([]string, len(conditions))
+	for i, c := range conditions {
+		parts[i] = c.build(q)
+	}
+	return strings.Join(parts, op)
+}
+
+func (q *query) fail(err error) {
+	if q.err == nil {
+		q.err = err
+	}
+}
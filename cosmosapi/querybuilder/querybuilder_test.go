@@ -0,0 +1,90 @@
+package querybuilder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+func params(values ...interface{}) []cosmosapi.QueryParam {
+	var params []cosmosapi.QueryParam
+	for i, v := range values {
+		params = append(params, cosmosapi.QueryParam{Name: "@p" + string(rune('0'+i)), Value: v})
+	}
+	return params
+}
+
+func TestBuild(t *testing.T) {
+	for _, test := range []struct {
+		builder *Builder
+		query   string
+		params  []cosmosapi.QueryParam
+	}{
+		{Select(), "SELECT * FROM c", nil},
+		{Select("id", "customer.name").Where(Eq("userId", "u1")),
+			"SELECT c.id, c.customer.name FROM c WHERE c.userId = @p0", params("u1")},
+		{SelectValue("id").Distinct().Top(10),
+			"SELECT DISTINCT TOP 10 VALUE c.id FROM c", nil},
+		{SelectCount().Where(Gt("_ts", 100), Le("x", 5.5)),
+			"SELECT VALUE COUNT(1) FROM c WHERE c._ts > @p0 AND c.x <= @p1", params(100, 5.5)},
+		{Select().Where(Or(Eq("a", 1), And(Ne("b", 2), Not(Lt("c", 3)))), Ge("d", 4)),
+			"SELECT * FROM c WHERE (c.a = @p0 OR (c.b != @p1 AND NOT (c.c < @p2))) AND c.d >= @p3", params(1, 2, 3, 4)},
+		{Select().Where(In("state", "new", "open"), In("empty")),
+			"SELECT * FROM c WHERE c.state IN (@p0, @p1) AND false", params("new", "open")},
+		{Select().Where(ArrayContains("tags", "x"), ArrayContainsPartial("items", map[string]int{"n": 1}), StartsWith("name", "A"), IsDefined("deleted")),
+			"SELECT * FROM c WHERE ARRAY_CONTAINS(c.tags, @p0) AND ARRAY_CONTAINS(c.items, @p1, true) AND STARTSWITH(c.name, @p2) AND IS_DEFINED(c.deleted)",
+			params("x", map[string]int{"n": 1}, "A")},
+		{Select().Where(Between("age", 18, 67)),
+			"SELECT * FROM c WHERE (c.age BETWEEN @p0 AND @p1)", params(18, 67)},
+		{Select("t.name").From("doc").Join("t", "tags").Where(Eq("t.name", "x"), Eq("doc.userId", 1)),
+			"SELECT t.name FROM doc JOIN t IN doc.tags WHERE t.name = @p0 AND doc.userId = @p1", params("x", 1)},
+		{Select().OrderBy("name").OrderByDesc("_ts").OffsetLimit(20, 10),
+			"SELECT * FROM c ORDER BY c.name ASC, c._ts DESC OFFSET 20 LIMIT 10", nil},
+		// Property names that are not identifiers are quoted
+		{Select("items.0.price").Where(Eq(`my-field"] OR 1=1 --`, 1)),
+			`SELECT c.items[0].price FROM c WHERE c["my-field\"] OR 1=1 --"] = @p0`, params(1)},
+		{Select().Where(And(), Or()), "SELECT * FROM c WHERE true AND false", nil},
+	} {
+		query, err := test.builder.Build()
+		require.NoError(t, err)
+		assert.Equal(t, test.query, query.Query)
+		assert.Equal(t, test.params, query.Params)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	for _, b := range []*Builder{
+		Select().From("c; DROP"),
+		Select().Join("c", "tags"),
+		Select().Join("t t", "tags"),
+		Select("a..b"),
+		Select().Top(5).OffsetLimit(0, 5),
+		Select().OffsetLimit(-1, 5),
+	} {
+		_, err := b.Build()
+		assert.Error(t, err)
+	}
+}
+
+func TestPartitionKeyValues(t *testing.T) {
+	b := Select().Where(Eq("customer.id", "c1"), And(Eq("userId", 2), Gt("x", 1)), Or(Eq("region", "no")))
+	values, ok := b.PartitionKeyValues("/customer/id")
+	assert.True(t, ok)
+	assert.Equal(t, []interface{}{"c1"}, values)
+	values, ok = b.PartitionKeyValues("/customer/id", "userId")
+	assert.True(t, ok)
+	assert.Equal(t, []interface{}{"c1", 2}, values)
+
+	_, ok = b.PartitionKeyValues("/region")
+	assert.False(t, ok)
+	_, ok = b.PartitionKeyValues("/x")
+	assert.False(t, ok)
+	_, ok = b.PartitionKeyValues()
+	assert.False(t, ok)
+
+	values, ok = Select().Where(Eq("c.userId", 1)).PartitionKeyValues("userId")
+	assert.True(t, ok)
+	assert.Equal(t, []interface{}{1}, values)
+}
@@ -0,0 +1,259 @@
+package cosmosapi
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Priority of a request when waiting for a RateLimiter. Requests with
+// PriorityHigh are let through before any waiting PriorityLow request.
+type Priority int
+
+const (
+	// PriorityHigh is the default, meant for interactive requests
+	PriorityHigh Priority = iota
+	// PriorityLow is meant for background jobs, e.g. batch imports
+	PriorityLow
+)
+
+// RateLimiterConfig configures a RateLimiter. Rates are in request units
+// per second.
+type RateLimiterConfig struct {
+	// MaxRate is the rate the limiter allows when not throttled by Cosmos DB
+	MaxRate float64
+	// MinRate is the lowest rate the limiter adapts down to when throttled.
+	// Defaults to MaxRate/10.
+	MinRate float64
+	// Burst is the number of request units that can be spent at once after
+	// being idle. Defaults to MaxRate, i.e. one second worth of requests.
+	Burst float64
+	// DefaultEstimate is the estimated charge of an operation before any
+	// request for it has completed. Defaults to 1.
+	DefaultEstimate float64
+	// ThrottleFactor is multiplied with the current rate when a request is
+	// throttled with 429. Defaults to 0.5.
+	ThrottleFactor float64
+	// Recovery is added to the current rate, up to MaxRate, for every second
+	// without throttling. Defaults to MaxRate/10.
+	Recovery float64
+}
+
+// RateLimiter is a token bucket denominated in request units (RUs). Before
+// every attempt it reserves the estimated charge of the operation, and
+// reconciles with the actual x-ms-request-charge afterwards. The estimate is
+// a moving average of the charges seen per operation and collection. When
+// Cosmos DB throttles a request the rate is decreased, and it then recovers
+// linearly towards MaxRate.
+//
+// Attach a RateLimiter to all requests of a client with Config.RateLimiter,
+// or to single requests with WithRateLimiter. Set the priority of requests
+// with WithPriority.
+type RateLimiter struct {
+	cfg RateLimiterConfig
+
+	mu           sync.Mutex
+	rate         float64
+	tokens       float64
+	last         time.Time
+	lastThrottle time.Time
+	// highPending is the sum of the estimates of waiting high priority requests
+	highPending float64
+	estimates   map[estimateKey]float64
+}
+
+type estimateKey struct {
+	operation  string
+	database   string
+	collection string
+}
+
+// weight of a new charge in the moving average estimate
+const estimateWeight = 0.2
+
+func NewRateLimiter(cfg RateLimiterConfig) *RateLimiter {
+	if cfg.MaxRate <= 0 {
+		panic("RateLimiterConfig.MaxRate must be positive")
+	}
+	if cfg.MinRate <= 0 {
+		cfg.MinRate = cfg.MaxRate / 10
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = cfg.MaxRate
+	}
+	if cfg.DefaultEstimate <= 0 {
+		cfg.DefaultEstimate = 1
+	}
+	if cfg.ThrottleFactor <= 0 || cfg.ThrottleFactor >= 1 {
+		cfg.ThrottleFactor = 0.5
+	}
+	if cfg.Recovery <= 0 {
+		cfg.Recovery = cfg.MaxRate / 10
+	}
+	now := time.Now()
+	return &RateLimiter{
+		cfg:       cfg,
+		rate:      cfg.MaxRate,
+		tokens:    cfg.Burst,
+		last:      now,
+		estimates: make(map[estimateKey]float64),
+	}
+}
+
+// Rate returns the current rate in request units per second.
+func (l *RateLimiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	return l.rate
+}
+
+type rateLimiterReservation struct {
+	limiter  *RateLimiter
+	key      estimateKey
+	reserved float64
+}
+
+// reserve waits until the estimated charge of req is available in the bucket
+// and takes it.
+func (l *RateLimiter) reserve(ctx context.Context, req *Request) (*rateLimiterReservation, error) {
+	key := estimateKey{operation: req.OperationName(), database: req.DatabaseName(), collection: req.CollectionName()}
+	priority := priorityFromContext(ctx)
+
+	l.mu.Lock()
+	estimate, ok := l.estimates[key]
+	if !ok {
+		estimate = l.cfg.DefaultEstimate
+	}
+	// Never wait for more than the bucket can hold
+	if estimate > l.cfg.Burst {
+		estimate = l.cfg.Burst
+	}
+	if priority == PriorityHigh {
+		l.highPending += estimate
+	}
+	for {
+		l.refill(time.Now())
+		needed := estimate
+		if priority == PriorityLow {
+			// Leave the tokens to waiting high priority requests
+			needed += l.highPending
+		}
+		if l.tokens >= needed {
+			l.tokens -= estimate
+			if priority == PriorityHigh {
+				l.highPending -= estimate
+			}
+			l.mu.Unlock()
+			return &rateLimiterReservation{limiter: l, key: key, reserved: estimate}, nil
+		}
+		wait := time.Duration((needed - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			if priority == PriorityHigh {
+				l.mu.Lock()
+				l.highPending -= estimate
+				l.mu.Unlock()
+			}
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+		l.mu.Lock()
+	}
+}
+
+// reconcile returns the difference between the reserved and the actual
+// charge to the bucket, and updates the estimate for the operation. If the
+// attempt failed without a response, the reservation is returned in full.
+func (r *rateLimiterReservation) reconcile(response *Response) {
+	l := r.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.refill(now)
+	if response == nil {
+		l.tokens += r.reserved
+		return
+	}
+	// The bucket may go negative, which makes following requests wait
+	l.tokens += r.reserved - response.RequestCharge
+	if l.tokens > l.cfg.Burst {
+		l.tokens = l.cfg.Burst
+	}
+	if response.RequestCharge > 0 {
+		if estimate, ok := l.estimates[r.key]; ok {
+			l.estimates[r.key] = estimate + estimateWeight*(response.RequestCharge-estimate)
+		} else {
+			l.estimates[r.key] = response.RequestCharge
+		}
+	}
+	if response.HTTPResponse != nil && response.HTTPResponse.StatusCode == 429 {
+		l.rate *= l.cfg.ThrottleFactor
+		if l.rate < l.cfg.MinRate {
+			l.rate = l.cfg.MinRate
+		}
+		l.lastThrottle = now
+	}
+}
+
+// refill adds tokens for the time passed since the last refill, and lets
+// the rate recover towards MaxRate. Must be called with mu held.
+func (l *RateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	l.last = now
+	l.tokens += elapsed * l.rate
+	if l.tokens > l.cfg.Burst {
+		l.tokens = l.cfg.Burst
+	}
+	if l.rate < l.cfg.MaxRate && now.Sub(l.lastThrottle) > time.Second {
+		l.rate += elapsed * l.cfg.Recovery
+		if l.rate > l.cfg.MaxRate {
+			l.rate = l.cfg.MaxRate
+		}
+	}
+}
+
+type rateLimiterContextKey int
+
+const (
+	ckRateLimiter rateLimiterContextKey = iota + 1
+	ckPriority
+)
+
+// WithRateLimiter returns a context making the requests done with it wait for
+// l, in addition to any RateLimiter configured on the client.
+func WithRateLimiter(ctx context.Context, l *RateLimiter) context.Context {
+	return context.WithValue(ctx, ckRateLimiter, l)
+}
+
+// WithPriority returns a context giving the requests done with it the given
+// priority in rate limiters.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, ckPriority, p)
+}
+
+func priorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(ckPriority).(Priority); ok {
+		return p
+	}
+	return PriorityHigh
+}
+
+// rateLimiters returns the rate limiters that apply to a request done with ctx
+func (c *Client) rateLimiters(ctx context.Context) []*RateLimiter {
+	var limiters []*RateLimiter
+	if c.Config.RateLimiter != nil {
+		limiters = append(limiters, c.Config.RateLimiter)
+	}
+	if l, ok := ctx.Value(ckRateLimiter).(*RateLimiter); ok && l != c.Config.RateLimiter {
+		limiters = append(limiters, l)
+	}
+	return limiters
+}
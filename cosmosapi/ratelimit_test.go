@@ -0,0 +1,97 @@
+package cosmosapi
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRequest() *Request {
+	return &Request{OperationType: OperationRead, ResourceType: "docs", ResourceLink: "dbs/db/colls/coll/docs/doc"}
+}
+
+func chargedResponse(status int, charge float64) *Response {
+	return &Response{HTTPResponse: &http.Response{StatusCode: status}, RequestCharge: charge}
+}
+
+func TestRateLimiterEstimates(t *testing.T) {
+	l := NewRateLimiter(RateLimiterConfig{MaxRate: 100, DefaultEstimate: 2})
+	r, err := l.reserve(context.Background(), testRequest())
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, r.reserved)
+	r.reconcile(chargedResponse(http.StatusOK, 10))
+
+	// The first charge replaces the default estimate, later ones are averaged in
+	r, err = l.reserve(context.Background(), testRequest())
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, r.reserved)
+	r.reconcile(chargedResponse(http.StatusOK, 20))
+	assert.Equal(t, 12.0, l.estimates[r.key])
+}
+
+func TestRateLimiterWaitsForTokens(t *testing.T) {
+	l := NewRateLimiter(RateLimiterConfig{MaxRate: 100, Burst: 10, DefaultEstimate: 10})
+	r, err := l.reserve(context.Background(), testRequest())
+	require.NoError(t, err)
+	r.reconcile(chargedResponse(http.StatusOK, 10))
+
+	// The bucket is empty and refills with 10 RUs in 100ms
+	start := time.Now()
+	_, err = l.reserve(context.Background(), testRequest())
+	require.NoError(t, err)
+	assert.True(t, time.Since(start) >= 90*time.Millisecond)
+
+	// Cancelled while waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.reserve(ctx, testRequest())
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 0.0, l.highPending)
+}
+
+func TestRateLimiterAdaptsToThrottling(t *testing.T) {
+	l := NewRateLimiter(RateLimiterConfig{MaxRate: 100, MinRate: 30})
+	r, err := l.reserve(context.Background(), testRequest())
+	require.NoError(t, err)
+	r.reconcile(chargedResponse(http.StatusTooManyRequests, 0))
+	assert.Equal(t, 50.0, l.rate)
+	r.reconcile(chargedResponse(http.StatusTooManyRequests, 0))
+	assert.Equal(t, 30.0, l.rate)
+
+	// Recovers after a second without throttling
+	l.mu.Lock()
+	l.lastThrottle = time.Now().Add(-2 * time.Second)
+	l.last = time.Now().Add(-time.Second)
+	l.mu.Unlock()
+	assert.InDelta(t, 40.0, l.Rate(), 0.5)
+}
+
+func TestRateLimiterPriority(t *testing.T) {
+	l := NewRateLimiter(RateLimiterConfig{MaxRate: 100, Burst: 5, DefaultEstimate: 5})
+	r, err := l.reserve(context.Background(), testRequest())
+	require.NoError(t, err)
+	r.reconcile(chargedResponse(http.StatusOK, 5))
+
+	var mu sync.Mutex
+	var order []Priority
+	var wg sync.WaitGroup
+	reserve := func(p Priority) {
+		defer wg.Done()
+		_, err := l.reserve(WithPriority(context.Background(), p), testRequest())
+		require.NoError(t, err)
+		mu.Lock()
+		order = append(order, p)
+		mu.Unlock()
+	}
+	wg.Add(2)
+	go reserve(PriorityLow)
+	time.Sleep(10 * time.Millisecond)
+	go reserve(PriorityHigh)
+	wg.Wait()
+	assert.Equal(t, []Priority{PriorityHigh, PriorityLow}, order)
+}
@@ -0,0 +1,362 @@
+package cosmosapi
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultReadManyBatchSize is the default maximum number of documents read by
+// a single query of ReadMany
+const DefaultReadManyBatchSize = 100
+
+// ReadManyItem identifies a document to read with ReadMany.
+type ReadManyItem struct {
+	Id                string
+	PartitionKeyValue interface{}
+}
+
+// ReadManyOptions are the options of ReadMany.
+type ReadManyOptions struct {
+	// MaxDegreeOfParallelism is the maximum number of requests in flight. 0
+	// means no limit.
+	MaxDegreeOfParallelism int
+	// MaxBatchSize is the maximum number of documents read by a single query;
+	// DefaultReadManyBatchSize if 0.
+	MaxBatchSize     int
+	ConsistencyLevel ConsistencyLevel
+	SessionToken     string
+	// RoutingMaps, if set, is used to find the partition key ranges of the
+	// items. Otherwise a cache held by the Client is used.
+	RoutingMaps *RoutingMapCache
+}
+
+// ReadManyResponse is the response of ReadMany.
+type ReadManyResponse struct {
+	// Found tells, for every item, whether the document exists
+	Found []bool
+	// RequestCharge is the sum of the request charges of all the requests
+	RequestCharge float64
+	// SessionToken holds the session tokens of the partition key ranges read
+	SessionToken string
+}
+
+// readManyBatch is a group of items in the same partition key range
+type readManyBatch struct {
+	rangeId string
+	indexes []int
+}
+
+// ReadMany reads the documents identified by items into docs, which must be a
+// pointer to a slice. The slice gets one element per item, in the same order;
+// elements of documents that do not exist are left zero, and their Found is
+// false. Items are grouped by partition key range, and read with a point read
+// if they are alone in their range, otherwise with queries in batches of
+// MaxBatchSize. The requests are done in parallel.
+func (c *Client) ReadMany(ctx context.Context, dbName, collName string, items []ReadManyItem, docs interface{}, ops ReadManyOptions) (ReadManyResponse, error) {
+	response := ReadManyResponse{Found: make([]bool, len(items))}
+	if len(items) == 0 {
+		return response, errors.WithStack(json.Unmarshal([]byte("[]"), docs))
+	}
+	routingMaps := ops.RoutingMaps
+	if routingMaps == nil {
+		routingMaps = c.defaultRoutingMaps()
+	}
+	m, err := routingMaps.Get(ctx, dbName, collName)
+	if err != nil {
+		return response, err
+	}
+	batchSize := ops.MaxBatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultReadManyBatchSize
+	}
+
+	// Group the items by range, and split the groups in batches
+	byRange := map[string][]int{}
+	for i, item := range items {
+		r, err := routingMaps.PartitionKeyRangeFor(ctx, dbName, collName, item.PartitionKeyValue)
+		if err != nil {
+			return response, err
+		}
+		byRange[r.Id] = append(byRange[r.Id], i)
+	}
+	var batches []readManyBatch
+	for rangeId, indexes := range byRange {
+		for len(indexes) > 0 {
+			n := batchSize
+			if n > len(indexes) {
+				n = len(indexes)
+			}
+			batches = append(batches, readManyBatch{rangeId: rangeId, indexes: indexes[:n]})
+			indexes = indexes[n:]
+		}
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].indexes[0] < batches[j].indexes[0] })
+
+	documents := make([]json.RawMessage, len(items))
+	sessionTokens := map[string]bool{}
+	var mu sync.Mutex
+	var firstErr error
+	parallelism := ops.MaxDegreeOfParallelism
+	if parallelism <= 0 {
+		parallelism = len(batches)
+	}
+	// The batches left are cancelled when one fails
+	batchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	requests := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for _, batch := range batches {
+		requests <- struct{}{}
+		if batchCtx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(batch readManyBatch) {
+			defer wg.Done()
+			defer func() { <-requests }()
+			found, requestCharge, sessionToken, err := c.readManyBatch(batchCtx, dbName, collName, m.PartitionKey, items, batch, ops)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = routingMaps.InvalidateOnGone(dbName, collName, err)
+					cancel()
+				}
+				return
+			}
+			response.RequestCharge += requestCharge
+			if sessionToken != "" {
+				sessionTokens[sessionToken] = true
+			}
+			for i, document := range found {
+				documents[i] = document
+				response.Found[i] = true
+			}
+		}(batch)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return response, firstErr
+	}
+
+	var tokens []string
+	for token := range sessionTokens {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	response.SessionToken = strings.Join(tokens, ",")
+
+	for i := range documents {
+		if documents[i] == nil {
+			documents[i] = json.RawMessage("null")
+		}
+	}
+	if err := json.Unmarshal(joinDocuments(documents), docs); err != nil {
+		return response, errors.WithStack(err)
+	}
+	return response, nil
+}
+
+// readManyBatch reads the documents of a batch, returning them by the index of their item
+func (c *Client) readManyBatch(ctx context.Context, dbName, collName string, pk PartitionKey, items []ReadManyItem, batch readManyBatch, ops ReadManyOptions) (
+	found map[int]json.RawMessage, requestCharge float64, sessionToken string, err error) {
+
+	found = map[int]json.RawMessage{}
+	if len(batch.indexes) == 1 {
+		i := batch.indexes[0]
+		var document json.RawMessage
+		response, err := c.GetDocument(ctx, dbName, collName, items[i].Id, GetDocumentOptions{
+			PartitionKeyValue: items[i].PartitionKeyValue,
+			ConsistencyLevel:  ops.ConsistencyLevel,
+			SessionToken:      ops.SessionToken,
+		}, &document)
+		if errors.Cause(err) == ErrNotFound {
+			// Reading a missing document is charged too
+			return found, response.RUs, response.SessionToken, nil
+		} else if err != nil {
+			return nil, 0, "", err
+		}
+		found[i] = document
+		return found, response.RUs, response.SessionToken, nil
+	}
+
+	qry, err := readManyQuery(pk, items, batch.indexes)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	// Documents with the same id may exist with other partition keys in the range
+	wanted := map[string][]int{}
+	for _, i := range batch.indexes {
+		epk := ""
+		if len(pk.Paths) > 0 {
+			if epk, err = EffectivePartitionKey(pk, items[i].PartitionKeyValue); err != nil {
+				return nil, 0, "", err
+			}
+		}
+		key := items[i].Id + "\x00" + epk
+		wanted[key] = append(wanted[key], i)
+	}
+	queryOps := DefaultQueryDocumentOptions()
+	queryOps.EnableCrossPartition = true
+	queryOps.PartitionKeyRangeId = batch.rangeId
+	queryOps.ConsistencyLevel = ops.ConsistencyLevel
+	queryOps.SessionToken = ops.SessionToken
+	p := c.NewQueryPaginator(dbName, collName, qry, queryOps)
+	for p.HasMore() {
+		var documents []json.RawMessage
+		response, err := p.Next(ctx, &documents)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		if response.SessionToken != "" {
+			sessionToken = response.SessionToken
+		}
+		for _, document := range documents {
+			id, epk, err := documentIdentity(pk, document)
+			if err != nil {
+				// Not a valid partition key, so not one of the items
+				continue
+			}
+			for _, i := range wanted[id+"\x00"+epk] {
+				found[i] = document
+			}
+		}
+	}
+	return found, p.RequestCharge(), sessionToken, nil
+}
+
+// readManyQuery returns a query for the documents of the items with the given
+// indexes, with an IN condition on the ids of each partition key value
+func readManyQuery(pk PartitionKey, items []ReadManyItem, indexes []int) (Query, error) {
+	qry := Query{}
+	param := func(value interface{}) string {
+		name := "@p" + strconv.Itoa(len(qry.Params))
+		qry.Params = append(qry.Params, QueryParam{Name: name, Value: value})
+		return name
+	}
+	// The ids by partition key value, in the order of the items
+	var keys []string
+	ids := map[string][]string{}
+	values := map[string][]interface{}{}
+	for _, i := range indexes {
+		var value []interface{}
+		if len(pk.Paths) > 0 {
+			value = []interface{}{items[i].PartitionKeyValue}
+			if hierarchical, ok := items[i].PartitionKeyValue.(HierarchicalPartitionKey); ok {
+				value = hierarchical
+			}
+			if len(value) > len(pk.Paths) {
+				return Query{}, errors.Errorf("Got %d partition key values for a partition key with %d paths", len(value), len(pk.Paths))
+			}
+		}
+		key := partitionKeyGroup(value)
+		if _, ok := ids[key]; !ok {
+			keys = append(keys, key)
+			values[key] = value
+		}
+		if !containsString(ids[key], items[i].Id) {
+			ids[key] = append(ids[key], items[i].Id)
+		}
+	}
+
+	var conditions []string
+	for _, key := range keys {
+		var names []string
+		for _, id := range ids[key] {
+			names = append(names, param(id))
+		}
+		condition := []string{"c.id IN (" + strings.Join(names, ", ") + ")"}
+		for j, value := range values[key] {
+			field := "c" + pathSelector(pk.Paths[j])
+			switch value.(type) {
+			case undefinedPartitionKey:
+				condition = append(condition, "NOT IS_DEFINED("+field+")")
+			case nil:
+				condition = append(condition, "IS_NULL("+field+")")
+			default:
+				condition = append(condition, field+" = "+param(value))
+			}
+		}
+		if len(keys) == 1 && len(condition) == 1 {
+			conditions = append(conditions, condition[0])
+		} else {
+			conditions = append(conditions, "("+strings.Join(condition, " AND ")+")")
+		}
+	}
+	qry.Query = "SELECT * FROM c WHERE " + strings.Join(conditions, " OR ")
+	return qry, nil
+}
+
+// partitionKeyGroup returns a string that is the same for equal partition key values
+func partitionKeyGroup(values []interface{}) string {
+	var group strings.Builder
+	for _, value := range values {
+		if _, ok := value.(undefinedPartitionKey); ok {
+			group.WriteString("undefined")
+		} else {
+			b, _ := json.Marshal(value)
+			group.Write(b)
+		}
+		group.WriteString("\x00")
+	}
+	return group.String()
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// pathSelector turns a path like "/customer/id" into `["customer"]["id"]`
+func pathSelector(path string) string {
+	var selector strings.Builder
+	for _, name := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		quoted, _ := json.Marshal(name)
+		selector.WriteString("[" + string(quoted) + "]")
+	}
+	return selector.String()
+}
+
+// documentIdentity returns the id and effective partition key of a document
+func documentIdentity(pk PartitionKey, document json.RawMessage) (id, epk string, err error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(document, &fields); err != nil {
+		return "", "", errors.WithStack(err)
+	}
+	id, _ = fields["id"].(string)
+	if len(pk.Paths) == 0 {
+		return id, "", nil
+	}
+	values := make([]interface{}, len(pk.Paths))
+	for i, path := range pk.Paths {
+		values[i] = UndefinedPartitionKey
+		var value interface{} = fields
+		found := true
+		for _, name := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				found = false
+				break
+			}
+			if value, ok = object[name]; !ok {
+				found = false
+				break
+			}
+		}
+		if found {
+			values[i] = value
+		}
+	}
+	epk, err = EffectivePartitionKey(pk, values...)
+	return id, epk, err
+}
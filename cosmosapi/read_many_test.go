@@ -0,0 +1,205 @@
+package cosmosapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type readManyDoc struct {
+	Id     string `json:"id"`
+	UserId string `json:"userId"`
+	X      int    `json:"x"`
+}
+
+// readManyServer serves a collection partitioned by /userId with two partition key ranges
+type readManyServer struct {
+	pk     PartitionKey
+	split  string
+	docs   []readManyDoc
+	mu     sync.Mutex
+	reads  []string
+	ranges []string
+	fail   bool
+	// routingReads counts the requests for the routing map
+	routingReads int
+}
+
+func newReadManyServer(t *testing.T, docs []readManyDoc) *readManyServer {
+	s := &readManyServer{pk: PartitionKey{Paths: []string{"/userId"}, Kind: PartitionKindHash}, docs: docs}
+	var epks []string
+	for _, doc := range docs {
+		epks = append(epks, s.epk(t, doc.UserId))
+	}
+	sort.Strings(epks)
+	s.split = epks[len(epks)/2]
+	return s
+}
+
+func (s *readManyServer) epk(t *testing.T, userId string) string {
+	epk, err := EffectivePartitionKey(s.pk, userId)
+	require.NoError(t, err)
+	return epk
+}
+
+func (s *readManyServer) handler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/colls/coll"):
+			s.routingReads++
+			json.NewEncoder(w).Encode(Collection{PartitionKey: &s.pk})
+		case strings.HasSuffix(r.URL.Path, "/pkranges"):
+			s.routingReads++
+			json.NewEncoder(w).Encode(GetPartitionKeyRangesResponse{PartitionKeyRanges: []PartitionKeyRange{
+				{Id: "0", MinInclusive: "", MaxExclusive: s.split},
+				{Id: "1", MinInclusive: s.split, MaxExclusive: "FF"},
+			}})
+		case r.Method == http.MethodGet:
+			id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+			var pk []string
+			require.NoError(t, json.Unmarshal([]byte(r.Header.Get(HEADER_PARTITIONKEY)), &pk))
+			s.reads = append(s.reads, id)
+			w.Header().Set(HEADER_REQUEST_CHARGE, "1")
+			w.Header().Set(HEADER_SESSION_TOKEN, "0:1")
+			for _, doc := range s.docs {
+				if doc.Id == id && doc.UserId == pk[0] {
+					json.NewEncoder(w).Encode(doc)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			// A query; return all documents of the range and let the client pick
+			rangeId := r.Header.Get(HEADER_PARTITION_KEY_RANGE_ID)
+			s.ranges = append(s.ranges, rangeId)
+			if s.fail {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			var docs []readManyDoc
+			for _, doc := range s.docs {
+				if (s.epk(t, doc.UserId) < s.split) == (rangeId == "0") {
+					docs = append(docs, doc)
+				}
+			}
+			w.Header().Set(HEADER_REQUEST_CHARGE, "2.5")
+			w.Header().Set(HEADER_SESSION_TOKEN, rangeId+":2")
+			json.NewEncoder(w).Encode(map[string]interface{}{"Documents": docs, "_count": len(docs)})
+		}
+	})
+}
+
+func TestReadMany(t *testing.T) {
+	users := []string{"alice", "bob", "carol", "dave", "erin", "frank"}
+	var docs []readManyDoc
+	for i, user := range users {
+		docs = append(docs, readManyDoc{Id: "doc", UserId: user, X: i}, readManyDoc{Id: user, UserId: user, X: 10 + i})
+	}
+	s := newReadManyServer(t, docs)
+	ts := httptest.NewServer(s.handler(t))
+	defer ts.Close()
+	c := New(ts.URL, Config{MasterKey: TestKey}, nil, nil)
+
+	items := []ReadManyItem{
+		{Id: "doc", PartitionKeyValue: "carol"},
+		{Id: "alice", PartitionKeyValue: "alice"},
+		{Id: "missing", PartitionKeyValue: "bob"},
+		{Id: "doc", PartitionKeyValue: "alice"},
+		{Id: "doc", PartitionKeyValue: "carol"},
+	}
+	for _, user := range users {
+		items = append(items, ReadManyItem{Id: user, PartitionKeyValue: user})
+	}
+	var result []readManyDoc
+	response, err := c.ReadMany(context.Background(), "db", "coll", items, &result, ReadManyOptions{MaxDegreeOfParallelism: 2})
+	require.NoError(t, err)
+	require.Len(t, result, len(items))
+	require.Len(t, response.Found, len(items))
+	assert.Equal(t, readManyDoc{Id: "doc", UserId: "carol", X: 2}, result[0])
+	assert.Equal(t, readManyDoc{Id: "alice", UserId: "alice", X: 10}, result[1])
+	assert.False(t, response.Found[2])
+	assert.Equal(t, readManyDoc{}, result[2])
+	assert.Equal(t, readManyDoc{Id: "doc", UserId: "alice", X: 0}, result[3])
+	assert.Equal(t, result[0], result[4])
+	for i, user := range users {
+		assert.True(t, response.Found[5+i])
+		assert.Equal(t, readManyDoc{Id: user, UserId: user, X: 10 + i}, result[5+i])
+	}
+	sort.Strings(s.ranges)
+	assert.Equal(t, []string{"0", "1"}, s.ranges)
+	assert.Empty(t, s.reads)
+	assert.Equal(t, 5.0, response.RequestCharge)
+	assert.Equal(t, "0:2,1:2", response.SessionToken)
+
+	// Small batches, and a point read for a single item
+	s.ranges = nil
+	var pointers []*readManyDoc
+	response, err = c.ReadMany(context.Background(), "db", "coll", items[:4], &pointers, ReadManyOptions{MaxBatchSize: 1})
+	require.NoError(t, err)
+	assert.Empty(t, s.ranges)
+	assert.Len(t, s.reads, 4)
+	assert.Equal(t, []bool{true, true, false, true}, response.Found)
+	assert.Nil(t, pointers[2])
+	assert.Equal(t, "alice", pointers[1].Id)
+	assert.Equal(t, 4.0, response.RequestCharge, "the missing document is charged")
+	assert.Equal(t, 2, s.routingReads, "the routing map is cached by the client")
+
+	// Nothing is requested for no items
+	s.routingReads = 0
+	c = New(ts.URL, Config{MasterKey: TestKey}, nil, nil)
+	response, err = c.ReadMany(context.Background(), "db", "coll", nil, &result, ReadManyOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, result)
+	assert.Empty(t, response.Found)
+	assert.Zero(t, s.routingReads)
+	c = New(ts.URL, Config{MasterKey: TestKey}, nil, nil)
+
+	// The batches left are not read when one fails
+	s.ranges, s.fail = nil, true
+	_, err = c.ReadMany(context.Background(), "db", "coll", items, &result, ReadManyOptions{MaxDegreeOfParallelism: 1})
+	assert.Equal(t, ErrInternalError, errors.Cause(err))
+	assert.Len(t, s.ranges, 1)
+}
+
+func TestReadManyQuery(t *testing.T) {
+	pk := PartitionKey{Paths: []string{"/tenant/id", "/user"}, Kind: PartitionKindMultiHash, Version: 2}
+	items := []ReadManyItem{
+		{Id: "a", PartitionKeyValue: HierarchicalPartitionKey{"acme", "alice"}},
+		{Id: "b", PartitionKeyValue: HierarchicalPartitionKey{nil, UndefinedPartitionKey}},
+		{Id: "c", PartitionKeyValue: HierarchicalPartitionKey{"acme", "alice"}},
+		{Id: "a", PartitionKeyValue: HierarchicalPartitionKey{"acme", "alice"}},
+	}
+	qry, err := readManyQuery(pk, items, []int{0, 1, 2, 3})
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT * FROM c WHERE (c.id IN (@p0, @p1) AND c["tenant"]["id"] = @p2 AND c["user"] = @p3) OR `+
+		`(c.id IN (@p4) AND IS_NULL(c["tenant"]["id"]) AND NOT IS_DEFINED(c["user"]))`, qry.Query)
+	assert.Equal(t, []QueryParam{{"@p0", "a"}, {"@p1", "c"}, {"@p2", "acme"}, {"@p3", "alice"}, {"@p4", "b"}}, qry.Params)
+
+	qry, err = readManyQuery(PartitionKey{}, items, []int{0, 1, 3})
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT * FROM c WHERE c.id IN (@p0, @p1)`, qry.Query, "not partitioned")
+
+	id, epk, err := documentIdentity(pk, json.RawMessage(`{"id": "a", "tenant": {"id": "acme"}, "user": "alice"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "a", id)
+	expected, err := EffectivePartitionKey(pk, items[0].PartitionKeyValue)
+	require.NoError(t, err)
+	assert.Equal(t, expected, epk)
+
+	_, epk, err = documentIdentity(pk, json.RawMessage(`{"id": "b", "tenant": {"id": null}}`))
+	require.NoError(t, err)
+	expected, err = EffectivePartitionKey(pk, items[1].PartitionKeyValue)
+	require.NoError(t, err)
+	assert.Equal(t, expected, epk)
+}
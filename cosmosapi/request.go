@@ -33,6 +33,16 @@ const (
 	HEADER_TRIGGER_PRE_EXCLUDE    = "x-ms-documentdb-pre-trigger-exclude"
 	HEADER_TRIGGER_POST_INCLUDE   = "x-ms-documentdb-post-trigger-include"
 	HEADER_TRIGGER_POST_EXCLUDE   = "x-ms-documentdb-post-trigger-exclude"
+	HEADER_POPULATE_QUERY_METRICS = "x-ms-documentdb-populatequerymetrics"
+	HEADER_POPULATE_INDEX_METRICS = "x-ms-cosmos-populateindexmetrics"
+	HEADER_IS_BATCH_REQUEST       = "x-ms-cosmos-is-batch-request"
+	HEADER_BATCH_ATOMIC           = "x-ms-cosmos-batch-atomic"
+	HEADER_BATCH_CONTINUE_ON_ERR  = "x-ms-cosmos-batch-continue-on-error"
+
+	// Request headers of offers
+	HEADER_OFFER_AUTOPILOT            = "x-ms-cosmos-offer-autopilot-settings"
+	HEADER_MIGRATE_OFFER_TO_AUTOPILOT = "x-ms-cosmos-migrate-offer-to-autopilot"
+	HEADER_MIGRATE_OFFER_TO_MANUAL    = "x-ms-cosmos-migrate-offer-to-manual-throughput"
 
 	// Both request and response
 	HEADER_SESSION_TOKEN = "x-ms-session-token"
@@ -41,6 +51,13 @@ const (
 	// Response headers
 	HEADER_REQUEST_CHARGE = "x-ms-request-charge"
 	HEADER_ETAG           = "etag"
+	HEADER_ACTIVITY_ID    = "x-ms-activity-id"
+	HEADER_SUBSTATUS      = "x-ms-substatus"
+	HEADER_QUERY_METRICS  = "x-ms-documentdb-query-metrics"
+	HEADER_INDEX_METRICS  = "x-ms-cosmos-index-utilization"
+
+	// Response headers of offers
+	HEADER_OFFER_REPLACE_PENDING = "x-ms-offer-replace-pending"
 )
 
 type RequestOptions map[RequestOption]string
@@ -50,16 +67,27 @@ type RequestOption string
 var (
 	ReqOpAllowCrossPartition = RequestOption("x-ms-documentdb-query-enablecrosspartition")
 	ReqOpPartitionKey        = RequestOption(HEADER_PARTITIONKEY)
+
+	// ReqOpOfferThroughput provisions manual throughput when creating a database
+	ReqOpOfferThroughput = RequestOption(HEADER_OFFER_THROUGHPUT)
+	// ReqOpOfferAutopilotSettings provisions autoscale throughput when creating
+	// a database. The value is given by AutoscaleSettings.
+	ReqOpOfferAutopilotSettings = RequestOption(HEADER_OFFER_AUTOPILOT)
 )
 
 // defaultHeaders returns a map containing the default headers required
 // for all requests to the cosmos db api.
-func defaultHeaders(method, link, key string) (map[string]string, error) {
+func defaultHeaders(method, link string, cfg Config) (map[string]string, error) {
 	h := map[string]string{}
 	h[HEADER_XDATE] = time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
 	h[HEADER_VER] = apiVersion
 
-	sign, err := signedPayload(method, link, h[HEADER_XDATE], key)
+	if cfg.ResourceToken != "" {
+		h[HEADER_AUTH] = resourceTokenAuthHeader(cfg.ResourceToken)
+		return h, nil
+	}
+
+	sign, err := signedPayload(method, link, h[HEADER_XDATE], cfg.MasterKey)
 	if err != nil {
 		return h, err
 	}
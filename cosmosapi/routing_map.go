@@ -0,0 +1,210 @@
+package cosmosapi
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// RoutingMap maps effective partition keys to the partition key ranges of a
+// collection.
+type RoutingMap struct {
+	PartitionKey PartitionKey
+	// Ranges sorted by MinInclusive
+	Ranges []PartitionKeyRange
+}
+
+// NewRoutingMap returns a RoutingMap for a collection partitioned by pk with
+// the given ranges. Ranges that have been split (i.e. are the parent of
+// another range) are left out.
+func NewRoutingMap(pk PartitionKey, ranges []PartitionKeyRange) *RoutingMap {
+	parents := map[string]bool{}
+	for _, r := range ranges {
+		for _, parent := range r.Parents {
+			parents[parent] = true
+		}
+	}
+	m := &RoutingMap{PartitionKey: pk}
+	for _, r := range ranges {
+		if !parents[r.Id] {
+			m.Ranges = append(m.Ranges, r)
+		}
+	}
+	sort.Slice(m.Ranges, func(i, j int) bool { return m.Ranges[i].MinInclusive < m.Ranges[j].MinInclusive })
+	return m
+}
+
+// RangeForEffectivePartitionKey returns the range containing epk.
+func (m *RoutingMap) RangeForEffectivePartitionKey(epk string) (PartitionKeyRange, bool) {
+	i := sort.Search(len(m.Ranges), func(i int) bool { return m.Ranges[i].MaxExclusive > epk })
+	if i == len(m.Ranges) || m.Ranges[i].MinInclusive > epk {
+		return PartitionKeyRange{}, false
+	}
+	return m.Ranges[i], true
+}
+
+// RangeFor returns the range that documents with the given partition key
+// values belong to.
+func (m *RoutingMap) RangeFor(values ...interface{}) (PartitionKeyRange, bool, error) {
+	epk, err := EffectivePartitionKey(m.PartitionKey, values...)
+	if err != nil {
+		return PartitionKeyRange{}, false, err
+	}
+	r, ok := m.RangeForEffectivePartitionKey(epk)
+	return r, ok, nil
+}
+
+// RangesForPrefix returns the ranges holding documents with the given prefix
+// of the values of a MultiHash partition key. As a prefix may span a split,
+// there can be more than one.
+func (m *RoutingMap) RangesForPrefix(prefix ...interface{}) ([]PartitionKeyRange, error) {
+	min, max, err := EffectivePartitionKeyRange(m.PartitionKey, prefix...)
+	if err != nil {
+		return nil, err
+	}
+	return m.overlapping(min, max), nil
+}
+
+// overlapping returns the ranges overlapping [min, max)
+func (m *RoutingMap) overlapping(min, max string) []PartitionKeyRange {
+	var ranges []PartitionKeyRange
+	for _, r := range m.Ranges {
+		if r.MaxExclusive > min && r.MinInclusive < max {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// covers returns whether the ranges cover [min, max) without gaps
+func covers(ranges []PartitionKeyRange, min, max string) bool {
+	if len(ranges) == 0 || ranges[0].MinInclusive > min {
+		return false
+	}
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i].MinInclusive != ranges[i-1].MaxExclusive {
+			return false
+		}
+	}
+	return ranges[len(ranges)-1].MaxExclusive >= max
+}
+
+// RoutingMapSource is the part of the Client API used by RoutingMapCache.
+type RoutingMapSource interface {
+	GetCollection(ctx context.Context, dbName, colName string) (*Collection, error)
+	GetPartitionKeyRanges(ctx context.Context, databaseName, collectionName string, options *GetPartitionKeyRangesOptions) (GetPartitionKeyRangesResponse, error)
+}
+
+// RoutingMapCache caches the routing maps of collections. The routing map of
+// a collection is refreshed when it does not cover a partition key, and
+// should be invalidated when a request targeting a partition key range fails
+// with ErrGone, which means that the range has been split. It is safe for
+// concurrent use.
+type RoutingMapCache struct {
+	source RoutingMapSource
+	mu     sync.Mutex
+	maps   map[string]*RoutingMap
+}
+
+func NewRoutingMapCache(source RoutingMapSource) *RoutingMapCache {
+	return &RoutingMapCache{source: source, maps: make(map[string]*RoutingMap)}
+}
+
+// Get returns the cached routing map of the collection, fetching it if
+// necessary.
+func (c *RoutingMapCache) Get(ctx context.Context, dbName, collName string) (*RoutingMap, error) {
+	c.mu.Lock()
+	m, ok := c.maps[CreateCollLink(dbName, collName)]
+	c.mu.Unlock()
+	if ok {
+		return m, nil
+	}
+	return c.Refresh(ctx, dbName, collName)
+}
+
+// Refresh fetches the routing map of the collection.
+func (c *RoutingMapCache) Refresh(ctx context.Context, dbName, collName string) (*RoutingMap, error) {
+	collection, err := c.source.GetCollection(ctx, dbName, collName)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to get collection for routing map")
+	}
+	var pk PartitionKey
+	if collection.PartitionKey != nil {
+		pk = *collection.PartitionKey
+	}
+	response, err := c.source.GetPartitionKeyRanges(ctx, dbName, collName, &GetPartitionKeyRangesOptions{})
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to get partition key ranges for routing map")
+	}
+	m := NewRoutingMap(pk, response.PartitionKeyRanges)
+	c.mu.Lock()
+	c.maps[CreateCollLink(dbName, collName)] = m
+	c.mu.Unlock()
+	return m, nil
+}
+
+// Invalidate removes the routing map of the collection from the cache, so
+// that it is fetched again on next use.
+func (c *RoutingMapCache) Invalidate(dbName, collName string) {
+	c.mu.Lock()
+	delete(c.maps, CreateCollLink(dbName, collName))
+	c.mu.Unlock()
+}
+
+// InvalidateOnGone invalidates the routing map of the collection if err is
+// ErrGone, and returns err.
+func (c *RoutingMapCache) InvalidateOnGone(dbName, collName string, err error) error {
+	if errors.Cause(err) == ErrGone {
+		c.Invalidate(dbName, collName)
+	}
+	return err
+}
+
+// PartitionKeyRangeFor returns the range of the collection that documents
+// with the given partition key values belong to.
+func (c *RoutingMapCache) PartitionKeyRangeFor(ctx context.Context, dbName, collName string, values ...interface{}) (PartitionKeyRange, error) {
+	m, err := c.Get(ctx, dbName, collName)
+	if err != nil {
+		return PartitionKeyRange{}, err
+	}
+	r, ok, err := m.RangeFor(values...)
+	if err != nil || ok {
+		return r, err
+	}
+	// The cached map may be from before a split
+	if m, err = c.Refresh(ctx, dbName, collName); err != nil {
+		return PartitionKeyRange{}, err
+	}
+	r, ok, err = m.RangeFor(values...)
+	if err == nil && !ok {
+		err = errors.Errorf("No partition key range found for partition key %v", values)
+	}
+	return r, err
+}
+
+// PartitionKeyRangesForPrefix returns the ranges of the collection holding
+// documents with the given prefix of the values of a MultiHash partition key.
+func (c *RoutingMapCache) PartitionKeyRangesForPrefix(ctx context.Context, dbName, collName string, prefix ...interface{}) ([]PartitionKeyRange, error) {
+	m, err := c.Get(ctx, dbName, collName)
+	if err != nil {
+		return nil, err
+	}
+	min, max, err := EffectivePartitionKeyRange(m.PartitionKey, prefix...)
+	if err != nil {
+		return nil, err
+	}
+	if ranges := m.overlapping(min, max); covers(ranges, min, max) {
+		return ranges, nil
+	}
+	// The cached map may be from before a split
+	if m, err = c.Refresh(ctx, dbName, collName); err != nil {
+		return nil, err
+	}
+	ranges := m.overlapping(min, max)
+	if !covers(ranges, min, max) {
+		return nil, errors.Errorf("No partition key ranges found for partition key prefix %v", prefix)
+	}
+	return ranges, nil
+}
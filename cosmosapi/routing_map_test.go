@@ -0,0 +1,114 @@
+package cosmosapi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRoutingMapSource struct {
+	ranges []PartitionKeyRange
+	calls  int
+}
+
+func (s *fakeRoutingMapSource) GetCollection(ctx context.Context, dbName, colName string) (*Collection, error) {
+	return &Collection{PartitionKey: &PartitionKey{Paths: []string{"/id"}, Kind: PartitionKindHash, Version: 2}}, nil
+}
+
+func (s *fakeRoutingMapSource) GetPartitionKeyRanges(ctx context.Context, databaseName, collectionName string, options *GetPartitionKeyRangesOptions) (GetPartitionKeyRangesResponse, error) {
+	s.calls++
+	return GetPartitionKeyRangesResponse{PartitionKeyRanges: s.ranges}, nil
+}
+
+func TestRoutingMap(t *testing.T) {
+	m := NewRoutingMap(PartitionKey{Version: 2}, []PartitionKeyRange{
+		{Id: "2", MinInclusive: "20", MaxExclusive: "FF", Parents: []string{"0"}},
+		{Id: "0", MinInclusive: "", MaxExclusive: "FF"},
+		{Id: "1", MinInclusive: "", MaxExclusive: "20", Parents: []string{"0"}},
+	})
+	require.Len(t, m.Ranges, 2)
+	assert.Equal(t, "1", m.Ranges[0].Id)
+
+	r, ok := m.RangeForEffectivePartitionKey("")
+	assert.True(t, ok)
+	assert.Equal(t, "1", r.Id)
+	r, ok = m.RangeForEffectivePartitionKey("1FFF")
+	assert.True(t, ok)
+	assert.Equal(t, "1", r.Id)
+	r, ok = m.RangeForEffectivePartitionKey("20")
+	assert.True(t, ok)
+	assert.Equal(t, "2", r.Id)
+	_, ok = m.RangeForEffectivePartitionKey("FF")
+	assert.False(t, ok)
+
+	// "partitionKey" hashes to 013A..., "" to 32E9...
+	r, ok, err := m.RangeFor("partitionKey")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "1", r.Id)
+	r, _, err = m.RangeFor("")
+	require.NoError(t, err)
+	assert.Equal(t, "2", r.Id)
+}
+
+func TestRoutingMapCache(t *testing.T) {
+	ctx := context.Background()
+	source := &fakeRoutingMapSource{ranges: []PartitionKeyRange{{Id: "1", MinInclusive: "", MaxExclusive: "20"}}}
+	cache := NewRoutingMapCache(source)
+
+	r, err := cache.PartitionKeyRangeFor(ctx, "db", "coll", "partitionKey")
+	require.NoError(t, err)
+	assert.Equal(t, "1", r.Id)
+	_, err = cache.PartitionKeyRangeFor(ctx, "db", "coll", "partitionKey")
+	require.NoError(t, err)
+	assert.Equal(t, 1, source.calls)
+
+	// A partition key not covered by the cached map triggers a refresh
+	source.ranges = append(source.ranges, PartitionKeyRange{Id: "2", MinInclusive: "20", MaxExclusive: "FF"})
+	r, err = cache.PartitionKeyRangeFor(ctx, "db", "coll", "")
+	require.NoError(t, err)
+	assert.Equal(t, "2", r.Id)
+	assert.Equal(t, 2, source.calls)
+
+	// ErrGone invalidates the map
+	assert.Equal(t, ErrGone, cache.InvalidateOnGone("db", "coll", ErrGone))
+	_, err = cache.Get(ctx, "db", "coll")
+	require.NoError(t, err)
+	assert.Equal(t, 3, source.calls)
+	assert.NoError(t, cache.InvalidateOnGone("db", "coll", nil))
+	_, err = cache.Get(ctx, "db", "coll")
+	require.NoError(t, err)
+	assert.Equal(t, 3, source.calls)
+}
+
+func TestRoutingMapPrefix(t *testing.T) {
+	pk := PartitionKey{Paths: []string{"/tenant", "/user"}, Kind: PartitionKindMultiHash, Version: 2}
+	// The documents of tenant "partitionKey" (013AEF...) are split between range 1 and 2
+	m := NewRoutingMap(pk, []PartitionKeyRange{
+		{Id: "1", MinInclusive: "", MaxExclusive: "013AEFCF77FA271571CF665A58C933F120"},
+		{Id: "2", MinInclusive: "013AEFCF77FA271571CF665A58C933F120", MaxExclusive: "20"},
+		{Id: "3", MinInclusive: "20", MaxExclusive: "FF"},
+	})
+	ranges, err := m.RangesForPrefix("partitionKey")
+	require.NoError(t, err)
+	require.Len(t, ranges, 2)
+	assert.Equal(t, "1", ranges[0].Id)
+	assert.Equal(t, "2", ranges[1].Id)
+
+	ranges, err = m.RangesForPrefix("")
+	require.NoError(t, err)
+	require.Len(t, ranges, 1)
+	assert.Equal(t, "3", ranges[0].Id)
+
+	ranges, err = m.RangesForPrefix()
+	require.NoError(t, err)
+	assert.Len(t, ranges, 3)
+
+	// The full key is in a single range; "" hashes to 32E9...
+	r, ok, err := m.RangeFor(HierarchicalPartitionKey{"partitionKey", ""})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "2", r.Id)
+}
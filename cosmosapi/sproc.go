@@ -95,11 +95,34 @@ func (c *Client) ExecuteStoredProcedure(
 	ops ExecuteStoredProcedureOptions,
 	ret interface{}, args ...interface{},
 ) error {
+	_, err := c.ExecuteStoredProcedureWithResponse(ctx, dbName, colName, sprocName, ops, ret, args...)
+	return err
+}
+
+type ExecuteStoredProcedureResponse struct {
+	ResponseBase
+	SessionToken string
+}
+
+// ExecuteStoredProcedureWithResponse is like ExecuteStoredProcedure, but also
+// returns the request charge, which is set also when the stored procedure
+// fails, as it is charged too
+func (c *Client) ExecuteStoredProcedureWithResponse(
+	ctx context.Context, dbName, colName, sprocName string,
+	ops ExecuteStoredProcedureOptions,
+	ret interface{}, args ...interface{},
+) (ExecuteStoredProcedureResponse, error) {
+	response := ExecuteStoredProcedureResponse{}
 	headers, err := ops.AsHeaders()
 	if err != nil {
-		return err
+		return response, err
 	}
 	link := createSprocLink(dbName, colName, sprocName)
-	_, err = c.create(ctx, link, args, ret, headers)
-	return err
+	httpResponse, err := c.create(ctx, link, args, ret, headers)
+	if httpResponse != nil {
+		// A malformed charge header should not fail the request
+		response.ResponseBase, _ = parseHttpResponse(httpResponse)
+		response.SessionToken = httpResponse.Header.Get(HEADER_SESSION_TOKEN)
+	}
+	return response, err
 }
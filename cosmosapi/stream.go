@@ -0,0 +1,130 @@
+package cosmosapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// DocumentHandler is called with every document of a page by the streaming
+// functions, in the order they are returned by Cosmos. Returning an error
+// stops the stream, and the error is returned by the streaming function.
+type DocumentHandler func(document json.RawMessage) error
+
+// bodyDecoder is implemented by return values that decode the response body
+// themselves instead of handleResponse decoding it in one go.
+type bodyDecoder interface {
+	decodeBody(r io.Reader) error
+}
+
+// documentStream decodes a page of documents ({"_rid": ..., "Documents": [...],
+// "_count": ...}) token by token, handing one document at a time to handle so
+// that the page is never held in memory as a whole.
+type documentStream struct {
+	handle DocumentHandler
+	count  int
+}
+
+func (s *documentStream) decodeBody(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		switch token {
+		case "Documents":
+			if err := s.decodeDocuments(dec); err != nil {
+				return err
+			}
+		case "_count":
+			if err := dec.Decode(&s.count); err != nil {
+				return errors.WithStack(err)
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func (s *documentStream) decodeDocuments(dec *json.Decoder) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	for dec.More() {
+		var document json.RawMessage
+		if err := dec.Decode(&document); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := s.handle(document); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, ']')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if token != delim {
+		return errors.Errorf("Unexpected JSON token in response: expected %s, got %v", delim, token)
+	}
+	return nil
+}
+
+// QueryDocumentsStream is like QueryDocuments, but passes the documents of
+// the page to handle one at a time as they are read from the response body,
+// instead of unmarshalling the whole page into a slice. Use the returned
+// Continuation to fetch the next page.
+func (c *Client) QueryDocumentsStream(ctx context.Context, dbName, collName string, qry Query, ops QueryDocumentsOptions, handle DocumentHandler) (QueryDocumentsResponse, error) {
+	response := QueryDocumentsResponse{}
+	headers, err := ops.asHeaders()
+	if err != nil {
+		return response, err
+	}
+	link := createDocsLink(dbName, collName)
+	stream := &documentStream{handle: handle}
+	httpResponse, err := c.query(ctx, link, qry, stream, headers)
+	if err != nil {
+		return response, err
+	}
+	response.Count = stream.count
+	return response.parse(httpResponse, c.Log)
+}
+
+// ListDocumentsStream is like ListDocuments, but passes the documents of the
+// page to handle one at a time as they are read from the response body,
+// instead of unmarshalling the whole page into a slice. Use the returned
+// Continuation (or Etag for the change feed) to fetch the next page.
+func (c *Client) ListDocumentsStream(
+	ctx context.Context,
+	databaseName, collectionName string,
+	options *ListDocumentsOptions,
+	handle DocumentHandler,
+) (response ListDocumentsResponse, err error) {
+	link := createDocsLink(databaseName, collectionName)
+	headers, err := options.AsHeaders()
+	if err != nil {
+		return response, err
+	}
+	httpResponse, err := c.get(ctx, link, &documentStream{handle: handle}, headers)
+	if err != nil {
+		return response, err
+	} else if httpResponse.StatusCode == http.StatusNotModified {
+		return response, err
+	}
+	r, err := response.parse(httpResponse)
+	return *r, err
+}
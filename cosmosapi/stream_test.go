@@ -0,0 +1,93 @@
+package cosmosapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPage = `{"_rid": "rid", "Documents": [{"id": "a", "n": [1, 2]}, {"id": "b", "nested": {"x": "]"}}], "_count": 2}`
+
+func pageServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HEADER_REQUEST_CHARGE, "3.5")
+		w.Header().Set(HEADER_CONTINUATION, "next")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(testPage))
+	}))
+}
+
+func TestQueryDocumentsStream(t *testing.T) {
+	ts := pageServer()
+	defer ts.Close()
+	c := New(ts.URL, Config{MasterKey: TestKey}, nil, nil)
+
+	var ids []string
+	response, err := c.QueryDocumentsStream(context.Background(), "db", "coll", Query{Query: "SELECT * FROM c"}, DefaultQueryDocumentOptions(),
+		func(document json.RawMessage) error {
+			var doc Document
+			require.NoError(t, json.Unmarshal(document, &doc))
+			ids = append(ids, doc.Id)
+			return nil
+		})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, ids)
+	assert.Equal(t, 2, response.Count)
+	assert.Equal(t, 3.5, response.RequestCharge)
+	assert.Equal(t, "next", response.Continuation)
+}
+
+func TestListDocumentsStream(t *testing.T) {
+	ts := pageServer()
+	defer ts.Close()
+	c := New(ts.URL, Config{MasterKey: TestKey}, nil, nil)
+
+	var documents []string
+	response, err := c.ListDocumentsStream(context.Background(), "db", "coll", &ListDocumentsOptions{},
+		func(document json.RawMessage) error {
+			documents = append(documents, string(document))
+			return nil
+		})
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{"id": "a", "n": [1, 2]}`, `{"id": "b", "nested": {"x": "]"}}`}, documents)
+	assert.Equal(t, 3.5, response.RequestCharge)
+	assert.Equal(t, "next", response.Continuation)
+
+	// An error from the handler stops the stream
+	stop := errors.New("stop")
+	calls := 0
+	_, err = c.ListDocumentsStream(context.Background(), "db", "coll", &ListDocumentsOptions{},
+		func(document json.RawMessage) error {
+			calls++
+			return stop
+		})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestListDocuments(t *testing.T) {
+	ts := pageServer()
+	defer ts.Close()
+	c := New(ts.URL, Config{MasterKey: TestKey}, nil, nil)
+
+	var docs []Document
+	response, err := c.ListDocuments(context.Background(), "db", "coll", &ListDocumentsOptions{}, &docs)
+	require.NoError(t, err)
+	require.Len(t, docs, 2)
+	assert.Equal(t, "b", docs[1].Id)
+	assert.Equal(t, "next", response.Continuation)
+}
+
+func TestDocumentStreamMalformed(t *testing.T) {
+	s := &documentStream{handle: func(json.RawMessage) error { return nil }}
+	assert.Error(t, s.decodeBody(bytes.NewReader([]byte(`[]`))))
+	assert.Error(t, s.decodeBody(bytes.NewReader([]byte(`{"Documents": {}}`))))
+	assert.Error(t, s.decodeBody(bytes.NewReader([]byte(`{"Documents": [{"id": "a"}`))))
+}
@@ -76,8 +76,10 @@ func (c *Client) ListTriggers(ctx context.Context, dbName string, colName string
 	return colTrigs, nil
 }
 
-func (c *Client) DeleteTrigger(ctx context.Context, dbName, colName string) error {
-	return ErrorNotImplemented
+// https://docs.microsoft.com/en-us/rest/api/cosmos-db/delete-a-trigger
+func (c *Client) DeleteTrigger(ctx context.Context, dbName, colName, triggerName string) error {
+	_, err := c.delete(ctx, CreateTriggerLink(dbName, colName, triggerName), nil)
+	return err
 }
 
 // https://docs.microsoft.com/en-us/rest/api/cosmos-db/replace-a-trigger
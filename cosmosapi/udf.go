@@ -0,0 +1,74 @@
+package cosmosapi
+
+import (
+	"context"
+)
+
+type UserDefinedFunctions struct {
+	Resource
+	UserDefinedFunctions []UDF `json:"UserDefinedFunctions"`
+	Count                int   `json:"_count,omitempty"`
+}
+
+func newUDF(name, body string) *UDF {
+	return &UDF{
+		Resource{Id: name},
+		body,
+	}
+}
+
+// https://docs.microsoft.com/en-us/rest/api/cosmos-db/create-a-user-defined-function
+func (c *Client) CreateUserDefinedFunction(
+	ctx context.Context, dbName, colName, udfName, body string,
+) (*UDF, error) {
+	ret := &UDF{}
+	link := createUdfsLink(dbName, colName)
+
+	_, err := c.create(ctx, link, newUDF(udfName, body), ret, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// https://docs.microsoft.com/en-us/rest/api/cosmos-db/replace-a-user-defined-function
+func (c *Client) ReplaceUserDefinedFunction(
+	ctx context.Context, dbName, colName, udfName, body string) (*UDF, error) {
+	ret := &UDF{}
+	link := createUdfLink(dbName, colName, udfName)
+
+	_, err := c.replace(ctx, link, newUDF(udfName, body), ret, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// https://docs.microsoft.com/en-us/rest/api/cosmos-db/delete-a-user-defined-function
+func (c *Client) DeleteUserDefinedFunction(ctx context.Context, dbName, colName, udfName string) error {
+	_, err := c.delete(ctx, createUdfLink(dbName, colName, udfName), nil)
+	return err
+}
+
+func (c *Client) GetUserDefinedFunction(ctx context.Context, dbName, colName, udfName string) (*UDF, error) {
+	ret := &UDF{}
+	link := createUdfLink(dbName, colName, udfName)
+
+	_, err := c.get(ctx, link, ret, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// https://docs.microsoft.com/en-us/rest/api/cosmos-db/list-user-defined-functions
+func (c *Client) ListUserDefinedFunctions(ctx context.Context, dbName, colName string) (*UserDefinedFunctions, error) {
+	ret := &UserDefinedFunctions{}
+	link := createUdfsLink(dbName, colName)
+
+	_, err := c.get(ctx, link, ret, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -4,14 +4,28 @@ import (
 	"encoding/json"
 )
 
+// HierarchicalPartitionKey is the value of a hierarchical (MultiHash)
+// partition key, with one value per path of the partition key definition.
+// Pass it wherever a partition key value is expected.
+type HierarchicalPartitionKey []interface{}
+
 func MarshalPartitionKeyHeader(partitionKeyValue interface{}) (string, error) {
-	switch partitionKeyValue.(type) {
-	// for now we disallow float, as using floats as keys is conceptually flawed (floats are not exact values)
-	case nil, string, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
-	default:
-		return "", ErrInvalidPartitionKeyType
+	values := []interface{}{partitionKeyValue}
+	if hierarchical, ok := partitionKeyValue.(HierarchicalPartitionKey); ok {
+		if len(hierarchical) == 0 || len(hierarchical) > MaxHierarchicalPartitionKeyPaths {
+			return "", ErrInvalidPartitionKeyType
+		}
+		values = hierarchical
+	}
+	for _, value := range values {
+		switch value.(type) {
+		// for now we disallow float, as using floats as keys is conceptually flawed (floats are not exact values)
+		case nil, undefinedPartitionKey, string, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		default:
+			return "", ErrInvalidPartitionKeyType
+		}
 	}
-	res, err := json.Marshal([]interface{}{partitionKeyValue})
+	res, err := json.Marshal(values)
 	if err != nil {
 		return "", err
 	}
@@ -24,4 +24,10 @@ func TestMarshalPartitionKeyHeader(t *testing.T) {
 
 	checkMarshal(1234.0, ErrInvalidPartitionKeyType)
 	checkMarshal(struct{}{}, ErrInvalidPartitionKeyType)
+
+	checkMarshal(UndefinedPartitionKey, `[{}]`)
+	checkMarshal(HierarchicalPartitionKey{"tenant", 1, nil}, `["tenant",1,null]`)
+	checkMarshal(HierarchicalPartitionKey{}, ErrInvalidPartitionKeyType)
+	checkMarshal(HierarchicalPartitionKey{"a", "b", "c", "d"}, ErrInvalidPartitionKeyType)
+	checkMarshal(HierarchicalPartitionKey{"a", 1.5}, ErrInvalidPartitionKeyType)
 }
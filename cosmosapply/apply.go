@@ -0,0 +1,415 @@
+// Package cosmosapply creates and updates Cosmos collections, with their
+// offers, triggers, stored procedures and user defined functions, from
+// definition files. It is the library behind cmd/cosmosdb-apply.
+//
+// Definitions are loaded with LoadDefinitions, compared with the current state
+// of an account with Plan, and the changes applied with Apply.
+package cosmosapply
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+// --- Apply: the calls for the changes of the plans
+
+type ResultStatus string
+
+const (
+	StatusCreated   = ResultStatus("created")
+	StatusUpdated   = ResultStatus("updated")
+	StatusDeleted   = ResultStatus("deleted")
+	StatusUnchanged = ResultStatus("unchanged")
+	StatusFailed    = ResultStatus("failed")
+	// StatusSkipped is the status of changes not applied because of an
+	// earlier failure, like those of a collection that could not be created
+	StatusSkipped = ResultStatus("skipped")
+)
+
+var appliedStatuses = map[ChangeAction]ResultStatus{
+	ActionCreate:    StatusCreated,
+	ActionUpdate:    StatusUpdated,
+	ActionDelete:    StatusDeleted,
+	ActionUnchanged: StatusUnchanged,
+}
+
+// Result is the outcome of a change of a plan
+type Result struct {
+	Change ResourceChange
+	Status ResultStatus
+	// Err is set if the status is StatusFailed
+	Err error
+}
+
+// Summary holds the results of all the changes of the applied plans
+type Summary struct {
+	Results []Result
+}
+
+// Count returns the number of results with the given status
+func (s Summary) Count(status ResultStatus) int {
+	n := 0
+	for _, result := range s.Results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Errors returns the errors of the failed changes
+func (s Summary) Errors() []error {
+	var errs []error
+	for _, result := range s.Results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+	return errs
+}
+
+type ApplyOptions struct {
+	// FailFast stops at the first failure, skipping the remaining changes.
+	// Otherwise only the changes depending on a failed change are skipped.
+	// Authorization errors always stop, since every other call would fail too.
+	FailFast bool
+	// Progress, if set, gets a line for every collection processed
+	Progress io.Writer
+}
+
+// Apply issues the calls needed for the changes of the plans, in order
+func Apply(ctx context.Context, client *cosmosapi.Client, plans []CollectionPlan, ops ApplyOptions) Summary {
+	a := &applier{ctx: ctx, client: client, ops: ops}
+	for i, plan := range plans {
+		if ops.Progress != nil {
+			fmt.Fprintf(ops.Progress, "[%d/%d] Processing collection '%s'\n", i+1, len(plans), plan.Collection.Name)
+		}
+		a.applyPlan(plan)
+	}
+	return a.summary
+}
+
+type applier struct {
+	ctx     context.Context
+	client  *cosmosapi.Client
+	ops     ApplyOptions
+	summary Summary
+	stopped bool
+}
+
+// apply makes a change with the call f unless it is unchanged, and returns
+// whether the resource is as in the plan afterwards. The change is skipped if
+// applying has stopped or skip is set.
+func (a *applier) apply(change ResourceChange, skip bool, f func() error) bool {
+	if a.stopped || skip {
+		a.summary.Results = append(a.summary.Results, Result{Change: change, Status: StatusSkipped})
+		return false
+	}
+	if change.Action == ActionUnchanged {
+		a.summary.Results = append(a.summary.Results, Result{Change: change, Status: StatusUnchanged})
+		return true
+	}
+	if err := f(); err != nil {
+		a.summary.Results = append(a.summary.Results, Result{Change: change, Status: StatusFailed, Err: err})
+		a.stopped = a.ops.FailFast || cosmosapi.IsAuthError(err)
+		return false
+	}
+	a.summary.Results = append(a.summary.Results, Result{Change: change, Status: appliedStatuses[change.Action]})
+	return true
+}
+
+// applyPlan only issues the calls needed for the changes of the plan. The
+// changes of a collection are skipped if its database or itself could not be
+// created or deleted.
+func (a *applier) applyPlan(plan CollectionPlan) {
+	def := plan.Definition
+	skip := false
+	if plan.Database != nil {
+		skip = !a.apply(*plan.Database, false, func() error { return a.createDatabase(def) })
+	}
+	if plan.DatabaseOffer != nil {
+		a.apply(*plan.DatabaseOffer, false, func() error { return a.replaceOffer(*def.DatabaseOffer, *plan.existingDatabaseOffer) })
+	}
+	if plan.Collection.Action == ActionDelete {
+		a.apply(plan.Collection, skip, func() error { return a.deleteCollection(def) })
+		return
+	}
+
+	skip = !a.apply(plan.Collection, skip, func() error {
+		if err := plan.Err(); err != nil {
+			return err
+		}
+		switch plan.Collection.Action {
+		case ActionCreate:
+			// NOTE: Offers are created as a part of the collection
+			return a.createCollection(def)
+		default:
+			return a.replaceCollection(def, plan.existingCollection)
+		}
+	})
+	if plan.Offer != nil {
+		a.apply(*plan.Offer, skip, func() error { return a.replaceOffer(def.Offer, *plan.existingOffer) })
+	}
+
+	// Deletions come after the changes of the resources in the definition
+	for i, change := range plan.Triggers {
+		a.apply(change, skip, func() error {
+			switch change.Action {
+			case ActionCreate:
+				return a.createTrigger(def.Triggers[i], def)
+			case ActionUpdate:
+				return a.replaceTrigger(def.Triggers[i], def)
+			default:
+				return a.deleteTrigger(change.ID, def)
+			}
+		})
+	}
+
+	for i, change := range plan.Sprocs {
+		a.apply(change, skip, func() error {
+			switch change.Action {
+			case ActionCreate:
+				return a.createSproc(def.Sprocs[i], def)
+			case ActionUpdate:
+				return a.replaceSproc(def.Sprocs[i], def)
+			default:
+				return a.deleteSproc(change.ID, def)
+			}
+		})
+	}
+
+	for i, change := range plan.Udfs {
+		a.apply(change, skip, func() error {
+			switch change.Action {
+			case ActionCreate:
+				return a.createUdf(def.Udfs[i], def)
+			case ActionUpdate:
+				return a.replaceUdf(def.Udfs[i], def)
+			default:
+				return a.deleteUdf(change.ID, def)
+			}
+		})
+	}
+}
+
+// PrintSummary prints a table of the results, followed by their counts
+func PrintSummary(w io.Writer, summary Summary) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tSTATUS\tERROR")
+	for _, result := range summary.Results {
+		fmt.Fprintf(tw, "%s\t%s\t%s", result.Change.Kind, result.Change.Name, result.Status)
+		if result.Err != nil {
+			fmt.Fprintf(tw, "\t%s", result.Err)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "Apply: %d created, %d updated, %d deleted, %d unchanged, %d failed, %d skipped.\n",
+		summary.Count(StatusCreated), summary.Count(StatusUpdated), summary.Count(StatusDeleted),
+		summary.Count(StatusUnchanged), summary.Count(StatusFailed), summary.Count(StatusSkipped))
+}
+
+// --- Database related
+
+func (a *applier) createDatabase(def CollectionDefinition) error {
+	var ops *cosmosapi.RequestOptions
+	if offer := def.DatabaseOffer; offer != nil && offer.Throughput > 0 {
+		ops = &cosmosapi.RequestOptions{cosmosapi.ReqOpOfferThroughput: strconv.Itoa(offer.Throughput)}
+	} else if offer != nil && offer.AutoscaleMaxThroughput > 0 {
+		ops = &cosmosapi.RequestOptions{cosmosapi.ReqOpOfferAutopilotSettings: cosmosapi.AutoscaleSettings(cosmosapi.OfferThroughput(offer.AutoscaleMaxThroughput))}
+	}
+	_, err := a.client.CreateDatabase(a.ctx, def.DatabaseID, ops)
+	return errors.Wrapf(err, "Could not create database '%s'", def.DatabaseID)
+}
+
+// --- Collection related
+
+func (a *applier) createCollection(def CollectionDefinition) error {
+	colCreateOpts := cosmosapi.CreateCollectionOptions{
+		Id:                def.CollectionID,
+		IndexingPolicy:    def.IndexingPolicy,
+		PartitionKey:      def.PartitionKey,
+		DefaultTimeToLive: def.DefaultTimeToLive,
+		OfferType:         cosmosapi.OfferType(def.Offer.Type),
+		OfferThroughput:   cosmosapi.OfferThroughput(def.Offer.Throughput),
+
+		AutoscaleMaxThroughput:      cosmosapi.OfferThroughput(def.Offer.AutoscaleMaxThroughput),
+		UniqueKeyPolicy:             def.UniqueKeyPolicy,
+		AnalyticalStorageTimeToLive: def.AnalyticalStorageTimeToLive,
+		ComputedProperties:          def.ComputedProperties,
+		GeospatialConfig:            def.GeospatialConfig,
+	}
+	_, err := a.client.CreateCollection(a.ctx, def.DatabaseID, colCreateOpts)
+	return errors.Wrapf(err, "Could not create collection '%s'", def.CollectionID)
+}
+
+func (a *applier) replaceCollection(def CollectionDefinition, existingCol *cosmosapi.Collection) error {
+	colReplaceOpts := cosmosapi.CollectionReplaceOptions{
+		Id:                def.CollectionID,
+		IndexingPolicy:    def.IndexingPolicy,
+		PartitionKey:      existingCol.PartitionKey,
+		DefaultTimeToLive: def.DefaultTimeToLive,
+		// Can not be changed, but must be given
+		UniqueKeyPolicy:             existingCol.UniqueKeyPolicy,
+		AnalyticalStorageTimeToLive: def.AnalyticalStorageTimeToLive,
+		ComputedProperties:          def.ComputedProperties,
+		GeospatialConfig:            def.GeospatialConfig,
+	}
+	// Not managed by the definition
+	if colReplaceOpts.IndexingPolicy == nil {
+		colReplaceOpts.IndexingPolicy = existingCol.IndexingPolicy
+	}
+	if colReplaceOpts.AnalyticalStorageTimeToLive == 0 {
+		colReplaceOpts.AnalyticalStorageTimeToLive = existingCol.AnalyticalStorageTimeToLive
+	}
+	if colReplaceOpts.ComputedProperties == nil {
+		colReplaceOpts.ComputedProperties = existingCol.ComputedProperties
+	}
+	if colReplaceOpts.GeospatialConfig == nil {
+		colReplaceOpts.GeospatialConfig = existingCol.GeospatialConfig
+	}
+	_, err := a.client.ReplaceCollection(a.ctx, def.DatabaseID, colReplaceOpts)
+	return errors.Wrapf(err, "Could not replace collection '%s'", def.CollectionID)
+}
+
+func (a *applier) deleteCollection(def CollectionDefinition) error {
+	err := a.client.DeleteCollection(a.ctx, def.DatabaseID, def.CollectionID)
+	return errors.Wrapf(err, "Could not delete collection '%s'", def.CollectionID)
+}
+
+// --- Triggers related
+
+func (a *applier) createTrigger(trigDef Trigger, def CollectionDefinition) error {
+	body, err := getJavaScriptBody(trigDef.Body, def.FilePath)
+	if err != nil {
+		return err
+	}
+	opts := cosmosapi.TriggerCreateOptions{
+		Id:        trigDef.ID,
+		Type:      cosmosapi.TriggerType(trigDef.TriggerType),
+		Operation: cosmosapi.TriggerOperation(trigDef.TriggerOperation),
+		Body:      body,
+	}
+	_, err = a.client.CreateTrigger(a.ctx, def.DatabaseID, def.CollectionID, opts)
+	return errors.Wrapf(err, "Could not create trigger '%s'", trigDef.ID)
+}
+
+func (a *applier) replaceTrigger(trigDef Trigger, def CollectionDefinition) error {
+	body, err := getJavaScriptBody(trigDef.Body, def.FilePath)
+	if err != nil {
+		return err
+	}
+	opts := cosmosapi.TriggerReplaceOptions{
+		Id:        trigDef.ID,
+		Type:      cosmosapi.TriggerType(trigDef.TriggerType),
+		Operation: cosmosapi.TriggerOperation(trigDef.TriggerOperation),
+		Body:      body,
+	}
+	_, err = a.client.ReplaceTrigger(a.ctx, def.DatabaseID, def.CollectionID, opts)
+	return errors.Wrapf(err, "Could not replace trigger '%s'", trigDef.ID)
+}
+
+func (a *applier) deleteTrigger(id string, def CollectionDefinition) error {
+	err := a.client.DeleteTrigger(a.ctx, def.DatabaseID, def.CollectionID, id)
+	return errors.Wrapf(err, "Could not delete trigger '%s'", id)
+}
+
+// --- Stored procedures and user defined functions related
+
+func (a *applier) createSproc(sprocDef Script, def CollectionDefinition) error {
+	body, err := getJavaScriptBody(sprocDef.Body, def.FilePath)
+	if err != nil {
+		return err
+	}
+	_, err = a.client.CreateStoredProcedure(a.ctx, def.DatabaseID, def.CollectionID, sprocDef.ID, body)
+	return errors.Wrapf(err, "Could not create stored procedure '%s'", sprocDef.ID)
+}
+
+func (a *applier) replaceSproc(sprocDef Script, def CollectionDefinition) error {
+	body, err := getJavaScriptBody(sprocDef.Body, def.FilePath)
+	if err != nil {
+		return err
+	}
+	_, err = a.client.ReplaceStoredProcedure(a.ctx, def.DatabaseID, def.CollectionID, sprocDef.ID, body)
+	return errors.Wrapf(err, "Could not replace stored procedure '%s'", sprocDef.ID)
+}
+
+func (a *applier) deleteSproc(id string, def CollectionDefinition) error {
+	err := a.client.DeleteStoredProcedure(a.ctx, def.DatabaseID, def.CollectionID, id)
+	return errors.Wrapf(err, "Could not delete stored procedure '%s'", id)
+}
+
+func (a *applier) createUdf(udfDef Script, def CollectionDefinition) error {
+	body, err := getJavaScriptBody(udfDef.Body, def.FilePath)
+	if err != nil {
+		return err
+	}
+	_, err = a.client.CreateUserDefinedFunction(a.ctx, def.DatabaseID, def.CollectionID, udfDef.ID, body)
+	return errors.Wrapf(err, "Could not create user defined function '%s'", udfDef.ID)
+}
+
+func (a *applier) replaceUdf(udfDef Script, def CollectionDefinition) error {
+	body, err := getJavaScriptBody(udfDef.Body, def.FilePath)
+	if err != nil {
+		return err
+	}
+	_, err = a.client.ReplaceUserDefinedFunction(a.ctx, def.DatabaseID, def.CollectionID, udfDef.ID, body)
+	return errors.Wrapf(err, "Could not replace user defined function '%s'", udfDef.ID)
+}
+
+func (a *applier) deleteUdf(id string, def CollectionDefinition) error {
+	err := a.client.DeleteUserDefinedFunction(a.ctx, def.DatabaseID, def.CollectionID, id)
+	return errors.Wrapf(err, "Could not delete user defined function '%s'", id)
+}
+
+// --- Offers related
+
+// replaceOffer changes an offer to the definition. Switching between manual and
+// autoscale throughput is a migration, after which the throughput picked by
+// Cosmos is replaced if it is not the one defined.
+func (a *applier) replaceOffer(offer OfferDefinition, off cosmosapi.Offer) error {
+	if offer.AutoscaleMaxThroughput > 0 && !off.IsAutoscale() {
+		migrated, err := a.client.MigrateOfferToAutoscale(a.ctx, off)
+		if err != nil {
+			return errors.Wrapf(err, "Could not migrate offer '%s' to autoscale", off.Id)
+		}
+		off = *migrated
+	} else if offer.Throughput > 0 && off.IsAutoscale() {
+		migrated, err := a.client.MigrateOfferToManual(a.ctx, off)
+		if err != nil {
+			return errors.Wrapf(err, "Could not migrate offer '%s' to manual throughput", off.Id)
+		}
+		off = *migrated
+	}
+	if len(diffOffer(offer, off)) == 0 {
+		return nil
+	}
+
+	offReplOpts := cosmosapi.OfferReplaceOptions{
+		Rid:              off.Rid,
+		OfferResourceId:  off.OfferResourceId,
+		Id:               off.Id,
+		OfferVersion:     off.OfferVersion,
+		ResourceSelfLink: off.Self,
+		OfferType:        off.OfferType,
+		Content:          off.Content,
+	}
+	if offer.Type != "" {
+		offReplOpts.OfferType = cosmosapi.OfferType(offer.Type)
+	}
+	if offer.Throughput > 0 {
+		offReplOpts.Content = cosmosapi.OfferThroughputContent{Throughput: cosmosapi.OfferThroughput(offer.Throughput)}
+	}
+	if offer.AutoscaleMaxThroughput > 0 {
+		offReplOpts.Content = cosmosapi.OfferThroughputContent{
+			OfferAutopilotSettings: &cosmosapi.OfferAutopilotSettings{MaxThroughput: cosmosapi.OfferThroughput(offer.AutoscaleMaxThroughput)},
+		}
+	}
+	_, err := a.client.ReplaceOffer(a.ctx, offReplOpts, nil)
+	return errors.Wrapf(err, "Could not replace offer '%s'", off.Id)
+}
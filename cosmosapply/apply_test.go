@@ -0,0 +1,70 @@
+package cosmosapply
+
+import (
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	body := TriggerBody{SourceLocation: "inline", InlineSource: "function f() {}"}
+	def := CollectionDefinition{DatabaseID: "db", CollectionID: "coll",
+		Triggers: []Trigger{{ID: "trigger", TriggerType: "Pre", TriggerOperation: "All", Body: body}},
+		Sprocs:   []Script{{ID: "sproc", Body: body}},
+	}
+	failing, other := def, def
+	failing.CollectionID = "failing"
+	other.DatabaseID = "other"
+	s := &accountServer{
+		databases:   map[string]bool{"db": true},
+		collections: map[string]cosmosapi.Collection{"db/coll": {Resource: cosmosapi.Resource{Id: "coll"}}},
+		failures: map[string]int{
+			"POST dbs/db/colls/coll/triggers": http.StatusBadRequest,
+			"POST dbs/db/colls":               http.StatusInternalServerError,
+		},
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	client := newTestClient(ts.URL)
+
+	plans, err := Plan(context.Background(), client, []CollectionDefinition{def, failing, other}, PruneOptions{})
+	require.NoError(t, err)
+	summary := Apply(context.Background(), client, plans, ApplyOptions{})
+	var statuses []ResultStatus
+	for _, result := range summary.Results {
+		statuses = append(statuses, result.Status)
+	}
+	assert.Equal(t, []ResultStatus{
+		StatusUnchanged, StatusFailed, StatusCreated, // db/coll
+		StatusFailed, StatusSkipped, StatusSkipped, // db/failing
+		StatusCreated, StatusCreated, StatusCreated, StatusCreated, // other/coll
+	}, statuses)
+	require.Len(t, summary.Errors(), 2)
+	assert.EqualError(t, summary.Errors()[0], "Could not create trigger 'trigger': "+cosmosapi.ErrInvalidRequest.Error())
+	assert.Equal(t, cosmosapi.ErrInternalError, errors.Cause(summary.Errors()[1]))
+
+	var out bytes.Buffer
+	PrintSummary(&out, summary)
+	assert.Contains(t, out.String(), "trigger     db/failing/trigger  skipped\n")
+	assert.Contains(t, out.String(), "Apply: 5 created, 0 updated, 0 deleted, 1 unchanged, 2 failed, 2 skipped.\n")
+
+	// Stop at the first failure
+	s.requests = nil
+	summary = Apply(context.Background(), client, plans, ApplyOptions{FailFast: true})
+	assert.Equal(t, []string{"POST dbs/db/colls/coll/triggers"}, s.requests)
+	assert.Equal(t, 1, summary.Count(StatusFailed))
+	assert.Equal(t, 8, summary.Count(StatusSkipped))
+
+	// Authorization errors always stop
+	s.requests = nil
+	s.failures["POST dbs/db/colls/coll/triggers"] = http.StatusUnauthorized
+	summary = Apply(context.Background(), client, plans, ApplyOptions{})
+	assert.Len(t, s.requests, 1)
+	assert.True(t, cosmosapi.IsAuthError(summary.Errors()[0]))
+}
@@ -0,0 +1,777 @@
+package cosmosapply
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- Definitions of collections, as read from definition files
+
+// CollectionDefinition is the desired state of a collection and its triggers,
+// stored procedures and user defined functions
+type CollectionDefinition struct {
+	// FilePath is the directory of the definition file. File names of
+	// JavaScript sources are relative to it.
+	FilePath          string          `json:"-"`
+	DatabaseID        string          `json:"databaseId"`
+	Count             int             `json:"count"`
+	CollectionID      string          `json:"collectionId"`
+	DefaultTimeToLive int             `json:"defaultTtl"`
+	Offer             OfferDefinition `json:"offer"`
+	// DatabaseOffer is the throughput shared by the collections of the
+	// database, which can only be provisioned when the database is created.
+	// It may be given in any of the definitions of collections in the database.
+	DatabaseOffer  *OfferDefinition          `json:"databaseOffer,omitempty"`
+	IndexingPolicy *cosmosapi.IndexingPolicy `json:"indexingPolicy,omitempty"`
+	PartitionKey   *cosmosapi.PartitionKey   `json:"partitionKey,omitempty"`
+	// UniqueKeyPolicy can only be set when the collection is created
+	UniqueKeyPolicy *cosmosapi.UniqueKeyPolicy `json:"uniqueKeyPolicy,omitempty"`
+	// AnalyticalStorageTimeToLive can only be enabled when the collection is
+	// created. 0 leaves it as it is.
+	AnalyticalStorageTimeToLive int `json:"analyticalStorageTtl,omitempty"`
+	// ComputedProperties and GeospatialConfig are left as they are if nil
+	ComputedProperties []cosmosapi.ComputedProperty `json:"computedProperties,omitempty"`
+	GeospatialConfig   *cosmosapi.GeospatialConfig  `json:"geospatialConfig,omitempty"`
+	Triggers           []Trigger                    `json:"triggers"`
+	Udfs               []Script                     `json:"udfs"`
+	Sprocs             []Script                     `json:"sprocs"`
+}
+
+// OfferDefinition is the throughput of a collection or database: a manual
+// throughput, a max throughput to scale automatically up to, or for
+// collections an offer type
+type OfferDefinition struct {
+	Throughput             int    `json:"throughput"`
+	AutoscaleMaxThroughput int    `json:"autoscaleMaxThroughput,omitempty"`
+	Type                   string `json:"type"`
+}
+
+type Trigger struct {
+	ID               string      `json:"id"`
+	TriggerType      string      `json:"triggerType"`
+	TriggerOperation string      `json:"triggerOperation"`
+	Body             TriggerBody `json:"body"`
+}
+
+// Script is a stored procedure or user defined function
+type Script struct {
+	ID   string      `json:"id"`
+	Body TriggerBody `json:"body"`
+}
+
+// TriggerBody is the JavaScript source of a trigger, stored procedure or user
+// defined function, either inline or in a file
+type TriggerBody struct {
+	SourceLocation string `json:"sourceLocation"`
+	InlineSource   string `json:"inlineSource,omitempty"`
+	FileName       string `json:"fileName,omitempty"`
+}
+
+// expandCount returns the definitions of the collections of a definition;
+// one per collection if Count is more than 1
+func expandCount(def CollectionDefinition) []CollectionDefinition {
+	if def.Count <= 1 {
+		return []CollectionDefinition{def}
+	}
+	var defs []CollectionDefinition
+	collectionIdBase := def.CollectionID
+	for i := 1; i <= def.Count; i++ {
+		def.CollectionID = fmt.Sprintf("%s-%d", collectionIdBase, i)
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+// getJavaScriptBody returns the source of a body, reading it from its file
+// relative to directory if it is not inline
+func getJavaScriptBody(body TriggerBody, directory string) (string, error) {
+	switch body.SourceLocation {
+	case "inline":
+		return body.InlineSource, nil
+	case "file":
+		absFilePath, _ := filepath.Abs(directory)
+		filePath := filepath.Join(absFilePath, body.FileName)
+		source, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return "", errors.Wrapf(err, "Could not read source file from '%s'", filePath)
+		}
+		return string(source), nil
+	default:
+		return "", errors.Errorf("Unknown source location '%s' found in script definition", body.SourceLocation)
+	}
+}
+
+// --- Loading and validation of definition files
+
+// DefinitionError is an error at a position of a definition file
+type DefinitionError struct {
+	File string
+	// Line is 0 if it is not known, like for the values of YAML files
+	Line    int
+	Path    string
+	Message string
+}
+
+func (e DefinitionError) Error() string {
+	position := e.File
+	if e.Line > 0 {
+		position += ":" + strconv.Itoa(e.Line)
+	}
+	if e.Path != "" {
+		return fmt.Sprintf("%s: %s: %s", position, e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", position, e.Message)
+}
+
+// DefinitionErrors are all the errors found in the definition files
+type DefinitionErrors []DefinitionError
+
+func (e DefinitionErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// LoadDefinitions reads and validates definition files, in JSON or,
+// if their extension is .yaml or .yml, in YAML. Variables like ${NAME} in
+// string values are replaced with the value of the environment variable NAME,
+// except in inline JavaScript sources. Integers and booleans may be given as
+// strings with variables, like "${THROUGHPUT}".
+// The error is a DefinitionErrors if the files are invalid.
+func LoadDefinitions(filePaths ...string) ([]CollectionDefinition, error) {
+	colDefs := make([]CollectionDefinition, 0, len(filePaths))
+	var errs DefinitionErrors
+	defined := map[string]bool{}
+	databaseOffers := map[string]OfferDefinition{}
+
+	for _, path := range filePaths {
+		// Read file from FS
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		colDef, lines, fileErrs := parseDefinitionFile(path, content, os.LookupEnv)
+		errs = append(errs, fileErrs...)
+		for i := range colDef {
+			// Set file path for all definitions. Used when looking up source.
+			colDef[i].FilePath = filepath.Dir(path)
+			for _, e := range checkSourceFiles(colDef[i], fmt.Sprintf("[%d]", i)) {
+				errs = append(errs, DefinitionError{File: path, Line: lineOf(lines, e.path), Path: e.path, Message: e.message})
+			}
+			for _, def := range expandCount(colDef[i]) {
+				name := def.DatabaseID + "/" + def.CollectionID
+				if defined[name] {
+					errs = append(errs, DefinitionError{File: path, Line: lineOf(lines, fmt.Sprintf("[%d]", i)), Path: fmt.Sprintf("[%d]", i),
+						Message: fmt.Sprintf("collection '%s' is defined more than once", name)})
+				}
+				defined[name] = true
+			}
+			if offer := colDef[i].DatabaseOffer; offer != nil {
+				offerPath := fmt.Sprintf("[%d].databaseOffer", i)
+				if existing, ok := databaseOffers[colDef[i].DatabaseID]; ok && existing != *offer {
+					errs = append(errs, DefinitionError{File: path, Line: lineOf(lines, offerPath), Path: offerPath,
+						Message: fmt.Sprintf("database '%s' has a different databaseOffer in another definition", colDef[i].DatabaseID)})
+				} else {
+					databaseOffers[colDef[i].DatabaseID] = *offer
+				}
+			}
+		}
+
+		colDefs = append(colDefs, colDef...)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return colDefs, nil
+}
+
+// parseDefinitionFile parses and validates the content of a definition file,
+// returning its definitions and, for JSON, the line of every path
+func parseDefinitionFile(path string, content []byte, lookupEnv func(string) (string, bool)) ([]CollectionDefinition, map[string]int, DefinitionErrors) {
+	fileError := func(line int, format string, a ...interface{}) DefinitionErrors {
+		return DefinitionErrors{{File: path, Line: line, Message: fmt.Sprintf(format, a...)}}
+	}
+
+	// Decode to generic values, to check them before decoding to the definitions
+	var value interface{}
+	var lines map[string]int
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var yamlValue interface{}
+		if err := yaml.Unmarshal(content, &yamlValue); err != nil {
+			return nil, nil, fileError(0, "%s", err)
+		}
+		var err error
+		if value, err = fromYAML(yamlValue); err != nil {
+			return nil, nil, fileError(0, "%s", err)
+		}
+	default:
+		if err := json.Unmarshal(content, &value); err != nil {
+			if syntaxErr, ok := err.(*json.SyntaxError); ok {
+				return nil, nil, fileError(lineAt(content, syntaxErr.Offset), "%s", err)
+			}
+			return nil, nil, fileError(0, "%s", err)
+		}
+		lines = jsonLines(content)
+	}
+
+	var errs DefinitionErrors
+	definitionsType := reflect.TypeOf([]CollectionDefinition{})
+	value, substitutionErrs := substituteVariables(value, definitionsType, "", lookupEnv)
+	for _, e := range substitutionErrs {
+		errs = append(errs, DefinitionError{File: path, Line: lineOf(lines, e.path), Path: e.path, Message: e.message})
+	}
+	if len(errs) > 0 {
+		return nil, lines, errs
+	}
+	for _, e := range checkFields(value, definitionsType, "") {
+		errs = append(errs, DefinitionError{File: path, Line: lineOf(lines, e.path), Path: e.path, Message: e.message})
+	}
+	if len(errs) > 0 {
+		return nil, lines, errs
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, lines, fileError(0, "%s", err)
+	}
+	var defs []CollectionDefinition
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, lines, fileError(0, "%s", err)
+	}
+	for i, def := range defs {
+		for _, e := range validateDefinition(def, fmt.Sprintf("[%d]", i)) {
+			errs = append(errs, DefinitionError{File: path, Line: lineOf(lines, e.path), Path: e.path, Message: e.message})
+		}
+	}
+	return defs, lines, errs
+}
+
+// --- Variable substitution
+
+var variablePattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// substituteVariables replaces the variables in the strings of a value decoded
+// from JSON or YAML, as checked by checkFields against the type t. The
+// JavaScript of inline sources is left as it is. Strings given for integer or
+// boolean fields are converted after the substitution, so that those can be
+// set by variables too.
+func substituteVariables(value interface{}, t reflect.Type, path string, lookupEnv func(string) (string, bool)) (interface{}, []fieldError) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var errs []fieldError
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return value, nil
+		}
+		fields := jsonFields(t)
+		for _, key := range sortedKeys(object) {
+			fieldType, ok := fields[key]
+			if !ok || (t == reflect.TypeOf(TriggerBody{}) && key == "inlineSource") {
+				continue
+			}
+			var fieldErrs []fieldError
+			object[key], fieldErrs = substituteVariables(object[key], fieldType, joinPath(path, key), lookupEnv)
+			errs = append(errs, fieldErrs...)
+		}
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			return value, nil
+		}
+		for i, element := range list {
+			var elementErrs []fieldError
+			list[i], elementErrs = substituteVariables(element, t.Elem(), fmt.Sprintf("%s[%d]", path, i), lookupEnv)
+			errs = append(errs, elementErrs...)
+		}
+	case reflect.String, reflect.Int, reflect.Int32, reflect.Int64, reflect.Bool:
+		text, ok := value.(string)
+		if !ok {
+			return value, nil
+		}
+		expanded, missing := expandVariables(text, lookupEnv)
+		for _, name := range missing {
+			errs = append(errs, fieldError{path: path, message: fmt.Sprintf("variable '%s' is not set", name)})
+		}
+		if t.Kind() == reflect.String || expanded == text {
+			return expanded, errs
+		}
+		// Left as a string if it can not be converted, for checkFields to report
+		if t.Kind() == reflect.Bool {
+			if b, err := strconv.ParseBool(expanded); err == nil {
+				return b, errs
+			}
+		} else if n, err := strconv.ParseInt(expanded, 10, 64); err == nil {
+			return float64(n), errs
+		}
+		return expanded, errs
+	}
+	return value, errs
+}
+
+// expandVariables replaces ${NAME} with the value of the variable NAME, or
+// with default if it is not set and written ${NAME:-default}. $$ is a $. The
+// names of the variables that are not set are returned.
+func expandVariables(text string, lookupEnv func(string) (string, bool)) (string, []string) {
+	var missing []string
+	expanded := variablePattern.ReplaceAllStringFunc(text, func(variable string) string {
+		if variable == "$$" {
+			return "$"
+		}
+		match := variablePattern.FindStringSubmatch(variable)
+		if value, ok := lookupEnv(match[1]); ok {
+			return value
+		}
+		if strings.Contains(variable, ":-") {
+			return match[3]
+		}
+		missing = append(missing, match[1])
+		return ""
+	})
+	return expanded, missing
+}
+
+// --- Checks of the structure of the values
+
+type fieldError struct {
+	path    string
+	message string
+}
+
+// checkFields checks that a value decoded from JSON or YAML only has the
+// fields of the type t, with values of the right types
+func checkFields(value interface{}, t reflect.Type, path string) []fieldError {
+	if value == nil {
+		return nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	wrongType := func(expected string) []fieldError {
+		return []fieldError{{path: path, message: fmt.Sprintf("expected %s, got %s", expected, describeValue(value))}}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return wrongType("an object")
+		}
+		fields := jsonFields(t)
+		var errs []fieldError
+		for _, key := range sortedKeys(object) {
+			fieldPath := joinPath(path, key)
+			fieldType, ok := fields[key]
+			if !ok {
+				errs = append(errs, fieldError{path: fieldPath, message: fmt.Sprintf("unknown field '%s'", key)})
+				continue
+			}
+			errs = append(errs, checkFields(object[key], fieldType, fieldPath)...)
+		}
+		return errs
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			return wrongType("a list")
+		}
+		var errs []fieldError
+		for i, element := range list {
+			errs = append(errs, checkFields(element, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return errs
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			return wrongType("a string")
+		}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		if number, ok := value.(float64); !ok || number != float64(int64(number)) {
+			return wrongType("an integer")
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			return wrongType("a boolean")
+		}
+	}
+	return nil
+}
+
+// jsonFields returns the types of the fields of a struct by their JSON names
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = t.Field(i).Type
+		}
+	}
+	return fields
+}
+
+func describeValue(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	case string:
+		return fmt.Sprintf("'%s'", value)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// fromYAML converts a value decoded from YAML to the types of a value decoded from JSON
+func fromYAML(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		object := map[string]interface{}{}
+		for key, element := range v {
+			name, ok := key.(string)
+			if !ok {
+				return nil, errors.Errorf("key %v is not a string", key)
+			}
+			converted, err := fromYAML(element)
+			if err != nil {
+				return nil, err
+			}
+			object[name] = converted
+		}
+		return object, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, element := range v {
+			converted, err := fromYAML(element)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = converted
+		}
+		return list, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	default:
+		return v, nil
+	}
+}
+
+// --- Validation of the values
+
+var (
+	triggerTypes      = []string{"Pre", "Post"}
+	triggerOperations = []string{"All", "Create", "Replace", "Delete"}
+	offerTypes        = []string{"S1", "S2", "S3"}
+	sourceLocations   = []string{"inline", "file"}
+	geospatialTypes   = []string{string(cosmosapi.GeospatialGeography), string(cosmosapi.GeospatialGeometry)}
+)
+
+const (
+	minThroughput  = 400
+	maxThroughput  = 1000000
+	throughputStep = 100
+
+	minAutoscaleThroughput  = 1000
+	autoscaleThroughputStep = 1000
+)
+
+// validateDefinition checks the values of a definition
+func validateDefinition(def CollectionDefinition, path string) []fieldError {
+	var errs []fieldError
+	fail := func(field, format string, a ...interface{}) {
+		errs = append(errs, fieldError{path: joinPath(path, field), message: fmt.Sprintf(format, a...)})
+	}
+
+	if def.DatabaseID == "" {
+		fail("databaseId", "is required")
+	}
+	if def.CollectionID == "" {
+		fail("collectionId", "is required")
+	}
+	if def.Count < 0 {
+		fail("count", "must not be negative; 0 or 1 defines the collection %s, and n > 1 the collections %s-1 to %s-n", def.CollectionID, def.CollectionID, def.CollectionID)
+	}
+	if def.DefaultTimeToLive < -1 {
+		fail("defaultTtl", "must be -1 (no default), 0 (off) or a number of seconds")
+	}
+
+	validateOffer(def.Offer, "offer", fail)
+	if def.DatabaseOffer != nil {
+		validateOffer(*def.DatabaseOffer, "databaseOffer", fail)
+		if def.DatabaseOffer.Type != "" {
+			fail("databaseOffer.type", "is not supported for databases")
+		} else if def.DatabaseOffer.Throughput == 0 && def.DatabaseOffer.AutoscaleMaxThroughput == 0 {
+			fail("databaseOffer", "must have a throughput or an autoscaleMaxThroughput")
+		}
+	}
+
+	if def.PartitionKey != nil {
+		if err := def.PartitionKey.Validate(); err != nil {
+			fail("partitionKey", "%s", err)
+		}
+	}
+	if def.UniqueKeyPolicy != nil {
+		for i, key := range def.UniqueKeyPolicy.UniqueKeys {
+			keyPath := fmt.Sprintf("uniqueKeyPolicy.uniqueKeys[%d].paths", i)
+			if len(key.Paths) == 0 {
+				fail(keyPath, "must have at least one path")
+			}
+			for j, p := range key.Paths {
+				if !strings.HasPrefix(p, "/") {
+					fail(fmt.Sprintf("%s[%d]", keyPath, j), "must start with /, got '%s'", p)
+				}
+			}
+		}
+	}
+	if def.AnalyticalStorageTimeToLive < -1 {
+		fail("analyticalStorageTtl", "must be -1 (no expiry) or a number of seconds")
+	}
+	names := map[string]bool{}
+	for i, property := range def.ComputedProperties {
+		propertyPath := fmt.Sprintf("computedProperties[%d]", i)
+		if property.Name == "" {
+			fail(propertyPath+".name", "is required")
+		} else if names[property.Name] {
+			fail(propertyPath+".name", "computed property '%s' is defined more than once", property.Name)
+		}
+		names[property.Name] = true
+		if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(property.Query)), "SELECT VALUE ") {
+			fail(propertyPath+".query", "must be a query like SELECT VALUE <expression> FROM c, got '%s'", property.Query)
+		}
+	}
+	if def.GeospatialConfig != nil && !oneOf(string(def.GeospatialConfig.Type), geospatialTypes) {
+		fail("geospatialConfig.type", "must be one of %s, got '%s'", strings.Join(geospatialTypes, ", "), def.GeospatialConfig.Type)
+	}
+
+	ids := map[string]bool{}
+	for i, trig := range def.Triggers {
+		triggerPath := fmt.Sprintf("triggers[%d]", i)
+		if !oneOf(trig.TriggerType, triggerTypes) {
+			fail(triggerPath+".triggerType", "must be one of %s, got '%s'", strings.Join(triggerTypes, ", "), trig.TriggerType)
+		}
+		if !oneOf(trig.TriggerOperation, triggerOperations) {
+			fail(triggerPath+".triggerOperation", "must be one of %s, got '%s'", strings.Join(triggerOperations, ", "), trig.TriggerOperation)
+		}
+		errs = append(errs, validateScript("trigger", trig.ID, trig.Body, ids, joinPath(path, triggerPath))...)
+	}
+	ids = map[string]bool{}
+	for i, sproc := range def.Sprocs {
+		errs = append(errs, validateScript("stored procedure", sproc.ID, sproc.Body, ids, joinPath(path, fmt.Sprintf("sprocs[%d]", i)))...)
+	}
+	ids = map[string]bool{}
+	for i, udf := range def.Udfs {
+		errs = append(errs, validateScript("user defined function", udf.ID, udf.Body, ids, joinPath(path, fmt.Sprintf("udfs[%d]", i)))...)
+	}
+	return errs
+}
+
+// validateOffer checks the values of an offer, at the given field
+func validateOffer(offer OfferDefinition, field string, fail func(field, format string, a ...interface{})) {
+	if t := offer.Throughput; t != 0 && (t < minThroughput || t > maxThroughput || t%throughputStep != 0) {
+		fail(field+".throughput", "must be a multiple of %d between %d and %d, got %d", throughputStep, minThroughput, maxThroughput, t)
+	}
+	if t := offer.AutoscaleMaxThroughput; t != 0 && (t < minAutoscaleThroughput || t > maxThroughput || t%autoscaleThroughputStep != 0) {
+		fail(field+".autoscaleMaxThroughput", "must be a multiple of %d between %d and %d, got %d", autoscaleThroughputStep, minAutoscaleThroughput, maxThroughput, t)
+	}
+	if offer.Throughput != 0 && offer.AutoscaleMaxThroughput != 0 {
+		fail(field, "can not have both a throughput and an autoscaleMaxThroughput")
+	}
+	if offer.Type != "" {
+		if !oneOf(offer.Type, offerTypes) {
+			fail(field+".type", "must be one of %s, got '%s'", strings.Join(offerTypes, ", "), offer.Type)
+		}
+		if offer.Throughput != 0 || offer.AutoscaleMaxThroughput != 0 {
+			fail(field, "can not have both a throughput and a type")
+		}
+	}
+}
+
+func validateScript(kind, id string, body TriggerBody, ids map[string]bool, path string) []fieldError {
+	var errs []fieldError
+	fail := func(field, format string, a ...interface{}) {
+		errs = append(errs, fieldError{path: joinPath(path, field), message: fmt.Sprintf(format, a...)})
+	}
+	if id == "" {
+		fail("id", "is required")
+	} else if ids[id] {
+		fail("id", "%s '%s' is defined more than once", kind, id)
+	}
+	ids[id] = true
+
+	switch body.SourceLocation {
+	case "inline":
+		if body.InlineSource == "" {
+			fail("body.inlineSource", "is required when sourceLocation is inline")
+		}
+	case "file":
+		if body.FileName == "" {
+			fail("body.fileName", "is required when sourceLocation is file")
+		}
+	default:
+		fail("body.sourceLocation", "must be one of %s, got '%s'", strings.Join(sourceLocations, ", "), body.SourceLocation)
+	}
+	return errs
+}
+
+// checkSourceFiles checks that the JavaScript source files of a definition can be read
+func checkSourceFiles(def CollectionDefinition, path string) []fieldError {
+	var errs []fieldError
+	check := func(body TriggerBody, bodyPath string) {
+		if body.SourceLocation != "file" || body.FileName == "" {
+			return
+		}
+		if _, err := getJavaScriptBody(body, def.FilePath); err != nil {
+			errs = append(errs, fieldError{path: joinPath(path, bodyPath+".body.fileName"), message: errors.Cause(err).Error()})
+		}
+	}
+	for i, trig := range def.Triggers {
+		check(trig.Body, fmt.Sprintf("triggers[%d]", i))
+	}
+	for i, sproc := range def.Sprocs {
+		check(sproc.Body, fmt.Sprintf("sprocs[%d]", i))
+	}
+	for i, udf := range def.Udfs {
+		check(udf.Body, fmt.Sprintf("udfs[%d]", i))
+	}
+	return errs
+}
+
+func oneOf(value string, values []string) bool {
+	for _, v := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// --- Lines of the values of definition files
+
+// lineAt returns the line of an offset in content
+func lineAt(content []byte, offset int64) int {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	return strings.Count(string(content[:offset]), "\n") + 1
+}
+
+// lineOf returns the line of a path, or of its closest parent with a known line
+func lineOf(lines map[string]int, path string) int {
+	for path != "" {
+		if line, ok := lines[path]; ok {
+			return line
+		}
+		if i := strings.LastIndexAny(path, ".["); i >= 0 {
+			path = path[:i]
+		} else {
+			path = ""
+		}
+	}
+	return 0
+}
+
+// jsonLines returns the line of every value of a valid JSON document by path,
+// like "[0].triggers[1].id"
+func jsonLines(content []byte) map[string]int {
+	reader := bytes.NewReader(content)
+	w := &jsonLineWalker{content: content, reader: reader, dec: json.NewDecoder(reader), lines: map[string]int{}}
+	if err := w.value(""); err != nil {
+		return nil
+	}
+	return w.lines
+}
+
+type jsonLineWalker struct {
+	content []byte
+	reader  *bytes.Reader
+	dec     *json.Decoder
+	lines   map[string]int
+}
+
+// offset returns the offset in content after the last token read, as
+// json.Decoder.InputOffset is not available before Go 1.14
+func (w *jsonLineWalker) offset() int {
+	buffered, _ := io.Copy(ioutil.Discard, w.dec.Buffered())
+	return len(w.content) - w.reader.Len() - int(buffered)
+}
+
+// nextLine returns the line of the next value
+func (w *jsonLineWalker) nextLine() int {
+	offset := w.offset()
+	for offset < len(w.content) && strings.IndexByte(" \t\r\n,", w.content[offset]) >= 0 {
+		offset++
+	}
+	return lineAt(w.content, int64(offset))
+}
+
+func (w *jsonLineWalker) value(path string) error {
+	token, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	switch token {
+	case json.Delim('{'):
+		for w.dec.More() {
+			if token, err = w.dec.Token(); err != nil {
+				return err
+			}
+			key, _ := token.(string)
+			keyPath := joinPath(path, key)
+			w.lines[keyPath] = lineAt(w.content, int64(w.offset()))
+			if err = w.value(keyPath); err != nil {
+				return err
+			}
+		}
+	case json.Delim('['):
+		for i := 0; w.dec.More(); i++ {
+			elementPath := fmt.Sprintf("%s[%d]", path, i)
+			w.lines[elementPath] = w.nextLine()
+			if err = w.value(elementPath); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+	// The closing delimiter
+	_, err = w.dec.Token()
+	return err
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,266 @@
+package cosmosapply
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func lookupEnv(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestYAMLDefinitions(t *testing.T) {
+	content, err := ioutil.ReadFile("test_data/variables.yaml")
+	require.NoError(t, err)
+	defs, _, errs := parseDefinitionFile("variables.yaml", content, lookupEnv(map[string]string{"DATABASE": "dev"}))
+	require.Empty(t, errs)
+	require.Len(t, defs, 1)
+	assert.Equal(t, "dev", defs[0].DatabaseID)
+	assert.Equal(t, 2, defs[0].Count)
+	assert.Equal(t, 400, defs[0].Offer.Throughput)
+	assert.Equal(t, []string{"/userId"}, defs[0].PartitionKey.Paths)
+	assert.Equal(t, "function validate() {\n}\n", defs[0].Triggers[0].Body.InlineSource)
+	assert.Equal(t, "exampleSproc.js", defs[0].Sprocs[0].Body.FileName)
+
+	defs, _, errs = parseDefinitionFile("variables.yaml", content, lookupEnv(map[string]string{"DATABASE": "prod", "THROUGHPUT": "4000"}))
+	require.Empty(t, errs)
+	assert.Equal(t, "prod", defs[0].DatabaseID)
+	assert.Equal(t, 4000, defs[0].Offer.Throughput)
+
+	_, _, errs = parseDefinitionFile("variables.yaml", content, lookupEnv(nil))
+	assert.EqualError(t, errs, "variables.yaml: [0].databaseId: variable 'DATABASE' is not set")
+}
+
+func TestExpandVariables(t *testing.T) {
+	expanded, missing := expandVariables(`${A} ${B:-b} ${C:-} $${A} $A ${D}`, lookupEnv(map[string]string{"A": "a"}))
+	assert.Equal(t, []string{"D"}, missing)
+	assert.Equal(t, `a b  ${A} $A `, expanded)
+}
+
+func TestSubstituteVariables(t *testing.T) {
+	content := `[
+  {
+    "databaseId": "${DATABASE}",
+    "collectionId": "coll",
+    "offer": {"throughput": "${THROUGHPUT}"},
+    "triggers": [
+      {
+        "id": "t",
+        "triggerType": "Pre",
+        "triggerOperation": "All",
+        "body": {"sourceLocation": "inline", "inlineSource": "function t() { return ` + "`${x} costs $$1`" + `; }"}
+      }
+    ]
+  }
+]`
+	env := map[string]string{"DATABASE": "a\"b\\c\n", "THROUGHPUT": "4000"}
+	defs, _, errs := parseDefinitionFile("defs.json", []byte(content), lookupEnv(env))
+	require.Empty(t, errs)
+	assert.Equal(t, "a\"b\\c\n", defs[0].DatabaseID)
+	assert.Equal(t, 4000, defs[0].Offer.Throughput)
+	assert.Equal(t, "function t() { return `${x} costs $$1`; }", defs[0].Triggers[0].Body.InlineSource)
+
+	env["THROUGHPUT"] = "many"
+	_, _, errs = parseDefinitionFile("defs.json", []byte(content), lookupEnv(env))
+	assert.EqualError(t, errs, "defs.json:5: [0].offer.throughput: expected an integer, got 'many'")
+}
+
+func TestDefinitionErrors(t *testing.T) {
+	content := `[
+  {
+    "databaseId": "db",
+    "collectionId": "coll",
+    "count": -1,
+    "offer": {"throughput": 450},
+    "partitionKey": {"paths": ["/id"], "kind": "Hash"},
+    "triggers": [
+      {
+        "id": "a",
+        "triggerType": "Before",
+        "triggerOperation": "Create",
+        "body": {"sourceLocation": "inline", "inlineSource": "function a() {}"}
+      }
+    ],
+    "sprocs": [
+      {"id": "s", "body": {"sourceLocation": "file"}},
+      {"id": "s", "body": {"sourceLocation": "file", "fileName": "s.js"}}
+    ]
+  }
+]`
+	_, _, errs := parseDefinitionFile("defs.json", []byte(content), lookupEnv(nil))
+	assert.Equal(t, []string{
+		"defs.json:5: [0].count: must not be negative; 0 or 1 defines the collection coll, and n > 1 the collections coll-1 to coll-n",
+		"defs.json:6: [0].offer.throughput: must be a multiple of 100 between 400 and 1000000, got 450",
+		"defs.json:11: [0].triggers[0].triggerType: must be one of Pre, Post, got 'Before'",
+		"defs.json:17: [0].sprocs[0].body.fileName: is required when sourceLocation is file",
+		"defs.json:18: [0].sprocs[1].id: stored procedure 's' is defined more than once",
+	}, strings.Split(errs.Error(), "\n"))
+
+	yamlContent := `- databaseId: db
+  collectionId: coll
+  offer:
+    througput: 400
+  triggers:
+    - id: a
+      triggerType: Pre
+      triggerOperation: Update
+      body: {sourceLocation: inline, inlineSource: "function a() {}"}
+`
+	_, _, errs = parseDefinitionFile("defs.yaml", []byte(yamlContent), lookupEnv(nil))
+	assert.EqualError(t, errs, "defs.yaml: [0].offer.througput: unknown field 'througput'")
+	_, _, errs = parseDefinitionFile("defs.yaml", []byte(strings.Replace(yamlContent, "througput", "throughput", 1)), lookupEnv(nil))
+	assert.EqualError(t, errs, "defs.yaml: [0].triggers[0].triggerOperation: must be one of All, Create, Replace, Delete, got 'Update'")
+
+	collectionContent := `[
+  {
+    "databaseId": "db",
+    "collectionId": "coll",
+    "uniqueKeyPolicy": {"uniqueKeys": [{"paths": []}, {"paths": ["email"]}]},
+    "analyticalStorageTtl": -2,
+    "computedProperties": [
+      {"name": "lowerName", "query": "SELECT VALUE LOWER(c.name) FROM c"},
+      {"name": "lowerName", "query": "SELECT LOWER(c.name) FROM c"}
+    ],
+    "geospatialConfig": {"type": "Flat"}
+  }
+]`
+	_, _, errs = parseDefinitionFile("defs.json", []byte(collectionContent), lookupEnv(nil))
+	assert.Equal(t, []string{
+		"defs.json:5: [0].uniqueKeyPolicy.uniqueKeys[0].paths: must have at least one path",
+		"defs.json:5: [0].uniqueKeyPolicy.uniqueKeys[1].paths[0]: must start with /, got 'email'",
+		"defs.json:6: [0].analyticalStorageTtl: must be -1 (no expiry) or a number of seconds",
+		"defs.json:9: [0].computedProperties[1].name: computed property 'lowerName' is defined more than once",
+		"defs.json:9: [0].computedProperties[1].query: must be a query like SELECT VALUE <expression> FROM c, got 'SELECT LOWER(c.name) FROM c'",
+		"defs.json:11: [0].geospatialConfig.type: must be one of Geography, Geometry, got 'Flat'",
+	}, strings.Split(errs.Error(), "\n"))
+
+	offerContent := `[
+  {
+    "databaseId": "db",
+    "collectionId": "coll",
+    "offer": {"throughput": 400, "autoscaleMaxThroughput": 1500},
+    "databaseOffer": {"type": "S1"}
+  }
+]`
+	_, _, errs = parseDefinitionFile("defs.json", []byte(offerContent), lookupEnv(nil))
+	assert.Equal(t, []string{
+		"defs.json:5: [0].offer.autoscaleMaxThroughput: must be a multiple of 1000 between 1000 and 1000000, got 1500",
+		"defs.json:5: [0].offer: can not have both a throughput and an autoscaleMaxThroughput",
+		"defs.json:6: [0].databaseOffer.type: is not supported for databases",
+	}, strings.Split(errs.Error(), "\n"))
+
+	_, _, errs = parseDefinitionFile("defs.json", []byte(`[{"databaseId": "db", "collectionId": 1}]`), lookupEnv(nil))
+	assert.EqualError(t, errs, "defs.json:1: [0].collectionId: expected a string, got 1")
+	_, _, errs = parseDefinitionFile("defs.json", []byte("[\n{\"databaseId\": \"db\",}]"), lookupEnv(nil))
+	assert.EqualError(t, errs, "defs.json:2: invalid character '}' looking for beginning of object key string")
+}
+
+func TestDuplicateCollections(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cosmosdb-apply")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(dir+"/a.json", []byte(`[{"databaseId": "db", "collectionId": "coll", "count": 2}]`), 0644))
+	require.NoError(t, ioutil.WriteFile(dir+"/b.yaml", []byte("- databaseId: db\n  collectionId: coll\n- databaseId: db\n  collectionId: coll-2\n"), 0644))
+
+	_, err = LoadDefinitions(dir+"/a.json", dir+"/b.yaml")
+	assert.EqualError(t, err, dir+"/b.yaml: [1]: collection 'db/coll-2' is defined more than once")
+}
+
+func TestConflictingDatabaseOffers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cosmosdb-apply")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	content := `[
+  {"databaseId": "db", "collectionId": "a", "databaseOffer": {"autoscaleMaxThroughput": 4000}},
+  {"databaseId": "db", "collectionId": "b"},
+  {"databaseId": "db", "collectionId": "c", "databaseOffer": {"autoscaleMaxThroughput": 4000}},
+  {"databaseId": "db", "collectionId": "d", "databaseOffer": {"throughput": 400}}
+]`
+	require.NoError(t, ioutil.WriteFile(dir+"/a.json", []byte(content), 0644))
+
+	_, err = LoadDefinitions(dir + "/a.json")
+	assert.EqualError(t, err, dir+"/a.json:5: [3].databaseOffer: database 'db' has a different databaseOffer in another definition")
+}
+
+func TestDefinitionLines(t *testing.T) {
+	assert.Equal(t, map[string]int{
+		"[0]":             2,
+		"[0].id":          2,
+		"[0].list":        3,
+		"[0].list[0]":     3,
+		"[0].list[1]":     3,
+		"[0].list[1].a":   3,
+		"[0].object":      4,
+		"[0].object.b":    5,
+		"[0].after":       7,
+		"[1]":             9,
+		"[1].quoted\"key": 9,
+	}, jsonLines([]byte(`[
+  {"id": "x",
+    "list": [1, {"a": "}"}],
+    "object": {
+      "b": []
+    },
+    "after": true
+  },
+  {"quoted\"key": 0}
+]`)))
+}
+
+// TestSchema checks that the JSON Schema has the fields of the definitions
+func TestSchema(t *testing.T) {
+	content, err := ioutil.ReadFile("schema.json")
+	require.NoError(t, err)
+	var schema map[string]interface{}
+	require.NoError(t, json.Unmarshal(content, &schema))
+	definitions := schema["definitions"].(map[string]interface{})
+
+	properties := func(name string) []string {
+		var names []string
+		for key := range definitions[name].(map[string]interface{})["properties"].(map[string]interface{}) {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+		return names
+	}
+	fields := func(value interface{}) []string {
+		var names []string
+		t := reflect.TypeOf(value)
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if name != "-" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	assert.Equal(t, fields(CollectionDefinition{}), properties("collection"))
+	assert.Equal(t, fields(OfferDefinition{}), properties("offer"))
+	assert.Equal(t, fields(Trigger{}), properties("trigger"))
+	assert.Equal(t, fields(Script{}), properties("script"))
+	assert.Equal(t, fields(TriggerBody{}), properties("body"))
+}
+
+func TestMissingSourceFile(t *testing.T) {
+	_, err := LoadDefinitions("test_data/missing_source.json")
+	assert.EqualError(t, err, "test_data/missing_source.json:7: [0].sprocs[0].body.fileName: open "+
+		filepath.Join(mustAbs(t, "test_data"), "missing.js")+": no such file or directory")
+}
+
+func mustAbs(t *testing.T, path string) string {
+	abs, err := filepath.Abs(path)
+	require.NoError(t, err)
+	return abs
+}
@@ -0,0 +1,164 @@
+package cosmosapply
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// --- Export: definition files from the current state of an account
+
+// Export writes one definition file per database, and the bodies of triggers,
+// stored procedures and user defined functions to files referred to by the
+// definitions. All databases are exported if dbNames is nil. It returns the
+// paths of the files written.
+func Export(ctx context.Context, client *cosmosapi.Client, outputDir string, dbNames []string) ([]string, error) {
+	if dbNames == nil {
+		databases, err := client.ListDatabases(ctx, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not list databases")
+		}
+		for _, db := range databases {
+			dbNames = append(dbNames, db.Id)
+		}
+	}
+	dbOffers, err := client.ListOffers(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not list offers")
+	}
+
+	var written []string
+	for _, dbName := range dbNames {
+		defs, sources, err := exportDatabase(ctx, client, dbName, dbOffers.Offers)
+		if err != nil {
+			return written, err
+		}
+		for fileName, source := range sources {
+			path := filepath.Join(outputDir, fileName)
+			if err := writeExportFile(path, []byte(source)); err != nil {
+				return written, err
+			}
+			written = append(written, path)
+		}
+		content, err := json.MarshalIndent(defs, "", "  ")
+		if err != nil {
+			return written, err
+		}
+		path := filepath.Join(outputDir, dbName+".json")
+		if err := writeExportFile(path, append(content, '\n')); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+func writeExportFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrapf(err, "Could not create directory for '%s'", path)
+	}
+	return errors.Wrapf(ioutil.WriteFile(path, content, 0644), "Could not write '%s'", path)
+}
+
+// exportDatabase returns the definitions of the collections of a database, and
+// the JavaScript sources they refer to by file name
+func exportDatabase(ctx context.Context, client *cosmosapi.Client, dbName string, offers []cosmosapi.Offer) ([]CollectionDefinition, map[string]string, error) {
+	defs := []CollectionDefinition{}
+	sources := map[string]string{}
+	// sourceFile returns a body referring to a file with the source, relative to the definition file
+	sourceFile := func(collectionId, kind, id, source string) TriggerBody {
+		fileName := filepath.ToSlash(filepath.Join(dbName, collectionId, kind, id+".js"))
+		sources[fileName] = source
+		return TriggerBody{SourceLocation: "file", FileName: fileName}
+	}
+
+	db, err := client.GetDatabase(ctx, dbName, nil)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Could not get database '%s'", dbName)
+	}
+	var databaseOffer *OfferDefinition
+	for _, off := range offers {
+		if off.OfferResourceId == db.Rid {
+			offer := offerDefinition(off)
+			databaseOffer = &offer
+		}
+	}
+
+	collections, err := listCollections(ctx, client, dbName)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, col := range collections {
+		collectionName := dbName + "/" + col.Id
+		def := CollectionDefinition{
+			DatabaseID:        dbName,
+			CollectionID:      col.Id,
+			DefaultTimeToLive: col.DefaultTimeToLive,
+			DatabaseOffer:     databaseOffer,
+			IndexingPolicy:    col.IndexingPolicy,
+			PartitionKey:      col.PartitionKey,
+			Triggers:          []Trigger{},
+			Udfs:              []Script{},
+			Sprocs:            []Script{},
+
+			UniqueKeyPolicy:             col.UniqueKeyPolicy,
+			AnalyticalStorageTimeToLive: col.AnalyticalStorageTimeToLive,
+			ComputedProperties:          col.ComputedProperties,
+			GeospatialConfig:            col.GeospatialConfig,
+		}
+		for _, off := range offers {
+			if off.OfferResourceId == col.Rid {
+				def.Offer = offerDefinition(off)
+			}
+		}
+
+		collectionTriggers, err := client.ListTriggers(ctx, dbName, col.Id)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Could not list triggers of collection '%s'", collectionName)
+		}
+		for _, trig := range collectionTriggers.Triggers {
+			def.Triggers = append(def.Triggers, Trigger{
+				ID:               trig.Id,
+				TriggerType:      string(trig.Type),
+				TriggerOperation: string(trig.Operation),
+				Body:             sourceFile(col.Id, "triggers", trig.Id, trig.Body),
+			})
+		}
+
+		sprocs, err := client.ListStoredProcedures(ctx, dbName, col.Id)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Could not list stored procedures of collection '%s'", collectionName)
+		}
+		for _, sproc := range sprocs.StoredProcedures {
+			def.Sprocs = append(def.Sprocs, Script{ID: sproc.Id, Body: sourceFile(col.Id, "sprocs", sproc.Id, sproc.Body)})
+		}
+
+		udfs, err := client.ListUserDefinedFunctions(ctx, dbName, col.Id)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Could not list user defined functions of collection '%s'", collectionName)
+		}
+		for _, udf := range udfs.UserDefinedFunctions {
+			def.Udfs = append(def.Udfs, Script{ID: udf.Id, Body: sourceFile(col.Id, "udfs", udf.Id, udf.Body)})
+		}
+
+		defs = append(defs, def)
+	}
+	return defs, sources, nil
+}
+
+// offerDefinition returns the definition of an existing offer
+func offerDefinition(off cosmosapi.Offer) OfferDefinition {
+	if off.IsAutoscale() {
+		return OfferDefinition{AutoscaleMaxThroughput: int(off.Content.OfferAutopilotSettings.MaxThroughput)}
+	}
+	offer := OfferDefinition{Throughput: int(off.Content.Throughput)}
+	// Offers with a throughput have the type Invalid
+	if off.OfferType != "Invalid" {
+		offer.Type = string(off.OfferType)
+	}
+	return offer
+}
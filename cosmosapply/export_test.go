@@ -0,0 +1,78 @@
+package cosmosapply
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	s := &accountServer{
+		databases: map[string]bool{"db": true, "empty": true},
+		collections: map[string]cosmosapi.Collection{
+			"db/coll": {
+				Resource:          cosmosapi.Resource{Id: "coll", Rid: "rid"},
+				PartitionKey:      &cosmosapi.PartitionKey{Paths: []string{"/id"}, Kind: "Hash"},
+				DefaultTimeToLive: -1,
+				IndexingPolicy: &cosmosapi.IndexingPolicy{
+					IndexingMode: "consistent",
+					Automatic:    true,
+					Included:     []cosmosapi.IncludedPath{{Path: "/*", Indexes: []cosmosapi.Index{{DataType: "String", Kind: "Range", Precision: -1}}}},
+					Excluded:     []cosmosapi.ExcludedPath{{Path: `/"_etag"/?`}},
+				},
+			},
+			"db/shared": {Resource: cosmosapi.Resource{Id: "shared", Rid: "shared"}},
+		},
+		offers: []cosmosapi.Offer{
+			{OfferResourceId: "rid", OfferType: "Invalid", Content: cosmosapi.OfferThroughputContent{Throughput: 800}},
+			{OfferResourceId: "db", OfferType: "Invalid", Content: cosmosapi.OfferThroughputContent{
+				Throughput: 400, OfferAutopilotSettings: &cosmosapi.OfferAutopilotSettings{MaxThroughput: 4000},
+			}},
+		},
+		triggers: map[string][]cosmosapi.Trigger{"db/coll": {
+			{Id: "validate", Type: "Pre", Operation: "All", Body: "function validate() {\n}\n"},
+		}},
+		sprocs: map[string][]cosmosapi.StoredProcedure{"db/coll": {{Resource: cosmosapi.Resource{Id: "sproc"}, Body: "function sproc() {}"}}},
+		udfs:   map[string][]cosmosapi.UDF{"db/shared": {{Resource: cosmosapi.Resource{Id: "udf"}, Body: "function udf() {}"}}},
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	client := newTestClient(ts.URL)
+
+	dir, err := ioutil.TempDir("", "cosmosdb-apply")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	written, err := Export(context.Background(), client, dir, nil)
+	require.NoError(t, err)
+	assert.Len(t, written, 5)
+	trigger, err := ioutil.ReadFile(filepath.Join(dir, "db", "coll", "triggers", "validate.js"))
+	require.NoError(t, err)
+	assert.Equal(t, "function validate() {\n}\n", string(trigger))
+
+	defs, err := LoadDefinitions(filepath.Join(dir, "db.json"))
+	require.NoError(t, err)
+	require.Len(t, defs, 2)
+	assert.Equal(t, "coll", defs[0].CollectionID)
+	assert.Equal(t, 800, defs[0].Offer.Throughput)
+	assert.Equal(t, "", defs[0].Offer.Type)
+	assert.Equal(t, TriggerBody{SourceLocation: "file", FileName: "db/coll/triggers/validate.js"}, defs[0].Triggers[0].Body)
+	assert.Equal(t, 0, defs[1].Offer.Throughput, "shared throughput")
+	assert.Equal(t, &OfferDefinition{AutoscaleMaxThroughput: 4000}, defs[1].DatabaseOffer)
+	empty, err := LoadDefinitions(filepath.Join(dir, "empty.json"))
+	require.NoError(t, err)
+	assert.Len(t, empty, 0)
+
+	// Round-trip
+	plans, err := Plan(context.Background(), client, defs, PruneOptions{Enabled: true, Collections: true})
+	require.NoError(t, err)
+	var out bytes.Buffer
+	assert.False(t, PrintPlan(&out, plans), out.String())
+}
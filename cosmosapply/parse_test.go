@@ -0,0 +1,37 @@
+package cosmosapply
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestWithAllFields(t *testing.T) {
+	cd, err := LoadDefinitions("test_data/all_fields.json")
+	require.NoError(t, err)
+	assert.NotNil(t, cd)
+	assert.Len(t, cd, 1)
+}
+
+func TestWithoutPartitionKey(t *testing.T) {
+	cd, err := LoadDefinitions("test_data/not_partition_key.json")
+	require.NoError(t, err)
+	assert.NotNil(t, cd)
+	assert.Len(t, cd, 1)
+}
+
+func TestWithoutIndexingPolicy(t *testing.T) {
+	cd, err := LoadDefinitions("test_data/not_indexing_policy.json")
+	require.NoError(t, err)
+	assert.NotNil(t, cd)
+	assert.Len(t, cd, 1)
+}
+
+func TestWithHierarchicalPartitionKey(t *testing.T) {
+	cd, err := LoadDefinitions("test_data/hierarchical_partition_key.json")
+	require.NoError(t, err)
+	assert.Len(t, cd, 1)
+	assert.Equal(t, "MultiHash", cd[0].PartitionKey.Kind)
+	assert.Equal(t, 2, cd[0].PartitionKey.Version)
+	assert.Equal(t, []string{"/tenant/id", "/userId", "/sessionId"}, cd[0].PartitionKey.Paths)
+}
@@ -0,0 +1,701 @@
+package cosmosapply
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// --- Plan: the differences between the definitions and the current state
+
+type ChangeAction string
+
+const (
+	ActionCreate    = ChangeAction("create")
+	ActionUpdate    = ChangeAction("update")
+	ActionUnchanged = ChangeAction("unchanged")
+	ActionDelete    = ChangeAction("delete")
+)
+
+// Symbols used when printing a plan, like Terraform does
+var actionSymbols = map[ChangeAction]string{
+	ActionCreate:    "+",
+	ActionUpdate:    "~",
+	ActionUnchanged: " ",
+	ActionDelete:    "-",
+}
+
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+	// Immutable is set if the field can not be changed without recreating the
+	// resource, which is never done
+	Immutable bool
+}
+
+type ResourceChange struct {
+	Kind string
+	// ID of the resource, and Name including the ids of its parents
+	ID     string
+	Name   string
+	Action ChangeAction
+	Fields []FieldChange
+}
+
+// CollectionPlan holds the changes needed for a collection definition, and
+// the current state they were computed from
+type CollectionPlan struct {
+	Definition CollectionDefinition
+	// Database is set if the database is created with the collection
+	Database *ResourceChange
+	// DatabaseOffer is set if the definition has a database offer and the
+	// database exists. It is only set in the first plan of the database.
+	DatabaseOffer *ResourceChange
+	Collection    ResourceChange
+	// Offer is set if the definition has an offer and the collection exists
+	Offer    *ResourceChange
+	Triggers []ResourceChange
+	Sprocs   []ResourceChange
+	Udfs     []ResourceChange
+
+	existingCollection    *cosmosapi.Collection
+	existingOffer         *cosmosapi.Offer
+	existingDatabaseOffer *cosmosapi.Offer
+}
+
+// Err returns an error if the plan changes fields of the collection that can
+// only be set when it is created
+func (p CollectionPlan) Err() error {
+	var immutable []string
+	for _, field := range p.Collection.Fields {
+		if field.Immutable {
+			immutable = append(immutable, field.Field)
+		}
+	}
+	if len(immutable) == 0 {
+		return nil
+	}
+	return errors.Errorf("Collection '%s' must be recreated to change %s, which can only be set when it is created",
+		p.Collection.Name, strings.Join(immutable, ", "))
+}
+
+// Changes returns all the changes of the plan, in the order they are applied
+func (p CollectionPlan) Changes() []ResourceChange {
+	var changes []ResourceChange
+	if p.Database != nil {
+		changes = append(changes, *p.Database)
+	}
+	if p.DatabaseOffer != nil {
+		changes = append(changes, *p.DatabaseOffer)
+	}
+	changes = append(changes, p.Collection)
+	if p.Offer != nil {
+		changes = append(changes, *p.Offer)
+	}
+	changes = append(changes, p.Triggers...)
+	changes = append(changes, p.Sprocs...)
+	return append(changes, p.Udfs...)
+}
+
+// PruneOptions tells which resources absent from the definitions are deleted
+type PruneOptions struct {
+	// Enabled deletes the triggers, stored procedures and user defined
+	// functions of the collections in the definitions
+	Enabled bool
+	// Collections also deletes the collections of the databases in the definitions
+	Collections bool
+	// Protected are patterns of names of resources never deleted, like
+	// db/collection or db/collection/trigger, as matched by path.Match
+	Protected []string
+}
+
+// isProtected returns whether a resource with the given name must not be deleted
+func (o PruneOptions) isProtected(name string) bool {
+	for _, pattern := range o.Protected {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Plan computes the changes needed to apply the definitions, expanding those
+// with a count to one plan per collection. With pruning of collections, the
+// plans deleting collections come last.
+func Plan(ctx context.Context, client *cosmosapi.Client, defs []CollectionDefinition, prune PruneOptions) ([]CollectionPlan, error) {
+	// We need to check three cases.
+	// 1: Added. In definition and not among existing collections.
+	// 2: Updated. In both places, but need to be replaced.
+	// 3. Removed. Not in definition, but among existing collections. Only with prune.
+
+	// The database offer may be given in any of the definitions of the database
+	databaseOffers := map[string]*OfferDefinition{}
+	for _, def := range defs {
+		if def.DatabaseOffer != nil && databaseOffers[def.DatabaseID] == nil {
+			databaseOffers[def.DatabaseID] = def.DatabaseOffer
+		}
+	}
+
+	p := newPlanner(ctx, client, prune)
+	var plans []CollectionPlan
+	for _, def := range defs {
+		def.DatabaseOffer = databaseOffers[def.DatabaseID]
+		for _, def := range expandCount(def) {
+			plan, err := p.plan(def)
+			if err != nil {
+				return nil, err
+			}
+			plans = append(plans, plan)
+		}
+	}
+	if prune.Enabled && prune.Collections {
+		deletions, err := p.planCollectionDeletions(plans)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, deletions...)
+	}
+	return plans, nil
+}
+
+// planner computes the plans of collection definitions. It remembers whether
+// the databases exist and which database offers are planned, so that those
+// are only created or replaced once.
+type planner struct {
+	ctx            context.Context
+	client         *cosmosapi.Client
+	prune          PruneOptions
+	databases      map[string]bool
+	databaseRids   map[string]string
+	databaseOffers map[string]bool
+	offers         []cosmosapi.Offer
+}
+
+func newPlanner(ctx context.Context, client *cosmosapi.Client, prune PruneOptions) *planner {
+	return &planner{ctx: ctx, client: client, prune: prune,
+		databases: map[string]bool{}, databaseRids: map[string]string{}, databaseOffers: map[string]bool{}}
+}
+
+func (p *planner) plan(def CollectionDefinition) (CollectionPlan, error) {
+	plan := CollectionPlan{Definition: def}
+	collectionName := def.DatabaseID + "/" + def.CollectionID
+
+	exists, known := p.databases[def.DatabaseID]
+	if !known {
+		db, err := p.client.GetDatabase(p.ctx, def.DatabaseID, nil)
+		if err != nil && errors.Cause(err) != cosmosapi.ErrNotFound {
+			return plan, errors.Wrapf(err, "Could not get database '%s'", def.DatabaseID)
+		}
+		if err != nil {
+			// NOTE: Database offers are created as a part of the database
+			plan.Database = &ResourceChange{Kind: "database", ID: def.DatabaseID, Name: def.DatabaseID, Action: ActionCreate, Fields: newDatabaseFields(def)}
+		} else {
+			p.databaseRids[def.DatabaseID] = db.Rid
+		}
+		exists = err == nil
+		p.databases[def.DatabaseID] = exists
+	}
+	if exists && def.DatabaseOffer != nil && !p.databaseOffers[def.DatabaseID] {
+		p.databaseOffers[def.DatabaseID] = true
+		var err error
+		if plan.existingDatabaseOffer, err = p.findOffer(p.databaseRids[def.DatabaseID]); err != nil {
+			return plan, err
+		}
+		if plan.existingDatabaseOffer == nil {
+			return plan, errors.Errorf("Database '%s' has no shared throughput, which can only be provisioned when it is created", def.DatabaseID)
+		}
+		offer := ResourceChange{Kind: "offer", ID: def.DatabaseID, Name: def.DatabaseID, Action: ActionUnchanged,
+			Fields: diffOffer(*def.DatabaseOffer, *plan.existingDatabaseOffer)}
+		if len(offer.Fields) > 0 {
+			offer.Action = ActionUpdate
+		}
+		plan.DatabaseOffer = &offer
+	}
+	if exists {
+		existing, err := p.client.GetCollection(p.ctx, def.DatabaseID, def.CollectionID)
+		if err != nil && errors.Cause(err) != cosmosapi.ErrNotFound {
+			return plan, errors.Wrapf(err, "Could not get collection '%s'", collectionName)
+		}
+		if err == nil {
+			plan.existingCollection = existing
+		}
+	}
+
+	if plan.existingCollection == nil {
+		// NOTE: Offers are created as a part of the collection
+		plan.Collection = ResourceChange{Kind: "collection", ID: def.CollectionID, Name: collectionName, Action: ActionCreate, Fields: newCollectionFields(def)}
+		for _, trigDef := range def.Triggers {
+			plan.Triggers = append(plan.Triggers, newResourceCreation("trigger", collectionName, trigDef.ID))
+		}
+		var err error
+		if plan.Sprocs, err = planScripts("sproc", collectionName, def.Sprocs, def.FilePath, nil); err != nil {
+			return plan, err
+		}
+		plan.Udfs, err = planScripts("udf", collectionName, def.Udfs, def.FilePath, nil)
+		return plan, err
+	}
+
+	plan.Collection = newResourceChange("collection", def.DatabaseID, def.CollectionID, diffCollection(def, plan.existingCollection))
+	if def.Offer != (OfferDefinition{}) {
+		var err error
+		if plan.existingOffer, err = p.findOffer(plan.existingCollection.Rid); err != nil {
+			return plan, err
+		}
+		if plan.existingOffer == nil {
+			return plan, errors.Errorf("Could not find the offer of collection '%s'", collectionName)
+		}
+		offer := newResourceChange("offer", def.DatabaseID, def.CollectionID, diffOffer(def.Offer, *plan.existingOffer))
+		plan.Offer = &offer
+	}
+
+	collectionTriggers, err := p.client.ListTriggers(p.ctx, def.DatabaseID, def.CollectionID)
+	if err != nil {
+		return plan, errors.Wrapf(err, "Could not list triggers of collection '%s'", collectionName)
+	}
+	var definedTriggers, existingTriggers []string
+	for _, trigDef := range def.Triggers {
+		definedTriggers = append(definedTriggers, trigDef.ID)
+		existing, trigFound := triggerExists(collectionTriggers.Triggers, trigDef.ID)
+		if !trigFound {
+			plan.Triggers = append(plan.Triggers, newResourceCreation("trigger", collectionName, trigDef.ID))
+			continue
+		}
+		fields, err := diffTrigger(trigDef, def.FilePath, *existing)
+		if err != nil {
+			return plan, err
+		}
+		plan.Triggers = append(plan.Triggers, newResourceChange("trigger", collectionName, trigDef.ID, fields))
+	}
+	for _, trig := range collectionTriggers.Triggers {
+		existingTriggers = append(existingTriggers, trig.Id)
+	}
+	plan.Triggers = append(plan.Triggers, p.planDeletions("trigger", collectionName, definedTriggers, existingTriggers)...)
+
+	sprocs, err := p.client.ListStoredProcedures(p.ctx, def.DatabaseID, def.CollectionID)
+	if err != nil {
+		return plan, errors.Wrapf(err, "Could not list stored procedures of collection '%s'", collectionName)
+	}
+	existingSprocs := map[string]string{}
+	for _, sproc := range sprocs.StoredProcedures {
+		existingSprocs[sproc.Id] = sproc.Body
+	}
+	if plan.Sprocs, err = planScripts("sproc", collectionName, def.Sprocs, def.FilePath, existingSprocs); err != nil {
+		return plan, err
+	}
+	plan.Sprocs = append(plan.Sprocs, p.planScriptDeletions("sproc", collectionName, def.Sprocs, existingSprocs)...)
+
+	udfs, err := p.client.ListUserDefinedFunctions(p.ctx, def.DatabaseID, def.CollectionID)
+	if err != nil {
+		return plan, errors.Wrapf(err, "Could not list user defined functions of collection '%s'", collectionName)
+	}
+	existingUdfs := map[string]string{}
+	for _, udf := range udfs.UserDefinedFunctions {
+		existingUdfs[udf.Id] = udf.Body
+	}
+	if plan.Udfs, err = planScripts("udf", collectionName, def.Udfs, def.FilePath, existingUdfs); err != nil {
+		return plan, err
+	}
+	plan.Udfs = append(plan.Udfs, p.planScriptDeletions("udf", collectionName, def.Udfs, existingUdfs)...)
+	return plan, nil
+}
+
+func triggerExists(triggers []cosmosapi.Trigger, triggerName string) (*cosmosapi.Trigger, bool) {
+	for _, c := range triggers {
+		if c.Id == triggerName {
+			return &c, true
+		}
+	}
+
+	return nil, false
+}
+
+// planDeletions returns the deletions of the existing resources of a parent
+// that are not defined, if pruning
+func (p *planner) planDeletions(kind, parentName string, defined, existing []string) []ResourceChange {
+	if !p.prune.Enabled {
+		return nil
+	}
+	isDefined := map[string]bool{}
+	for _, id := range defined {
+		isDefined[id] = true
+	}
+	var changes []ResourceChange
+	for _, id := range existing {
+		name := parentName + "/" + id
+		if !isDefined[id] && !p.prune.isProtected(name) {
+			changes = append(changes, ResourceChange{Kind: kind, ID: id, Name: name, Action: ActionDelete})
+		}
+	}
+	return changes
+}
+
+func (p *planner) planScriptDeletions(kind, collectionName string, scripts []Script, existing map[string]string) []ResourceChange {
+	var defined, existingIds []string
+	for _, s := range scripts {
+		defined = append(defined, s.ID)
+	}
+	for id := range existing {
+		existingIds = append(existingIds, id)
+	}
+	sort.Strings(existingIds)
+	return p.planDeletions(kind, collectionName, defined, existingIds)
+}
+
+// planCollectionDeletions returns plans deleting the collections of the
+// existing databases of the plans that are not in the plans
+func (p *planner) planCollectionDeletions(plans []CollectionPlan) ([]CollectionPlan, error) {
+	var databases []string
+	defined := map[string][]string{}
+	for _, plan := range plans {
+		def := plan.Definition
+		if _, ok := defined[def.DatabaseID]; !ok && p.databases[def.DatabaseID] {
+			databases = append(databases, def.DatabaseID)
+		}
+		defined[def.DatabaseID] = append(defined[def.DatabaseID], def.CollectionID)
+	}
+
+	var deletions []CollectionPlan
+	for _, dbName := range databases {
+		collections, err := listCollections(p.ctx, p.client, dbName)
+		if err != nil {
+			return nil, err
+		}
+		var existing []string
+		for _, collection := range collections {
+			existing = append(existing, collection.Id)
+		}
+		for _, change := range p.planDeletions("collection", dbName, defined[dbName], existing) {
+			def := CollectionDefinition{DatabaseID: dbName, CollectionID: change.ID}
+			deletions = append(deletions, CollectionPlan{Definition: def, Collection: change})
+		}
+	}
+	return deletions, nil
+}
+
+// listCollections returns all the collections of a database
+func listCollections(ctx context.Context, client *cosmosapi.Client, dbName string) ([]cosmosapi.Collection, error) {
+	var collections []cosmosapi.Collection
+	ops := cosmosapi.ListCollectionsOptions{}
+	for {
+		response, err := client.ListCollections(ctx, dbName, ops)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not list collections in database '%s'", dbName)
+		}
+		collections = append(collections, response.Collections.DocumentCollections...)
+		if ops.Continuation = response.Continuation; ops.Continuation == "" {
+			return collections, nil
+		}
+	}
+}
+
+// planScripts returns the changes of stored procedures or user defined
+// functions, given the bodies of the existing ones by id
+func planScripts(kind, collectionName string, scripts []Script, directory string, existing map[string]string) ([]ResourceChange, error) {
+	var changes []ResourceChange
+	for _, s := range scripts {
+		existingBody, found := existing[s.ID]
+		if !found {
+			changes = append(changes, newResourceCreation(kind, collectionName, s.ID))
+			continue
+		}
+		body, err := getJavaScriptBody(s.Body, directory)
+		if err != nil {
+			return nil, err
+		}
+		var fields []FieldChange
+		if body != existingBody {
+			fields = append(fields, FieldChange{Field: "body", Old: summarizeBody(existingBody), New: summarizeBody(body)})
+		}
+		changes = append(changes, newResourceChange(kind, collectionName, s.ID, fields))
+	}
+	return changes, nil
+}
+
+// findOffer returns the offer of the resource with the given resource id. Offers are listed once.
+func (p *planner) findOffer(resourceId string) (*cosmosapi.Offer, error) {
+	if p.offers == nil {
+		dbOffers, err := p.client.ListOffers(p.ctx, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not list offers")
+		}
+		p.offers = dbOffers.Offers
+	}
+	for i := range p.offers {
+		if p.offers[i].OfferResourceId == resourceId {
+			return &p.offers[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func newResourceCreation(kind, parentName, id string) ResourceChange {
+	return ResourceChange{Kind: kind, ID: id, Name: parentName + "/" + id, Action: ActionCreate}
+}
+
+func newResourceChange(kind, parentName, id string, fields []FieldChange) ResourceChange {
+	change := ResourceChange{Kind: kind, ID: id, Name: parentName + "/" + id, Action: ActionUnchanged, Fields: fields}
+	if len(fields) > 0 {
+		change.Action = ActionUpdate
+	}
+	return change
+}
+
+func newCollectionFields(def CollectionDefinition) []FieldChange {
+	var fields []FieldChange
+	add := func(field string, value interface{}) {
+		fields = append(fields, FieldChange{Field: field, New: formatValue(value)})
+	}
+	if def.PartitionKey != nil {
+		add("partitionKey", def.PartitionKey)
+	}
+	if def.IndexingPolicy != nil {
+		add("indexingPolicy", def.IndexingPolicy)
+	}
+	if def.DefaultTimeToLive != 0 {
+		add("defaultTtl", def.DefaultTimeToLive)
+	}
+	if def.UniqueKeyPolicy != nil {
+		add("uniqueKeyPolicy", def.UniqueKeyPolicy)
+	}
+	if def.AnalyticalStorageTimeToLive != 0 {
+		add("analyticalStorageTtl", def.AnalyticalStorageTimeToLive)
+	}
+	if def.ComputedProperties != nil {
+		add("computedProperties", def.ComputedProperties)
+	}
+	if def.GeospatialConfig != nil {
+		add("geospatialConfig.type", def.GeospatialConfig.Type)
+	}
+	if def.Offer.Throughput > 0 {
+		add("offer.throughput", def.Offer.Throughput)
+	}
+	if def.Offer.AutoscaleMaxThroughput > 0 {
+		add("offer.autoscaleMaxThroughput", def.Offer.AutoscaleMaxThroughput)
+	}
+	if def.Offer.Type != "" {
+		add("offer.type", def.Offer.Type)
+	}
+	return fields
+}
+
+func newDatabaseFields(def CollectionDefinition) []FieldChange {
+	var fields []FieldChange
+	if def.DatabaseOffer != nil && def.DatabaseOffer.Throughput > 0 {
+		fields = append(fields, FieldChange{Field: "offer.throughput", New: formatValue(def.DatabaseOffer.Throughput)})
+	}
+	if def.DatabaseOffer != nil && def.DatabaseOffer.AutoscaleMaxThroughput > 0 {
+		fields = append(fields, FieldChange{Field: "offer.autoscaleMaxThroughput", New: formatValue(def.DatabaseOffer.AutoscaleMaxThroughput)})
+	}
+	return fields
+}
+
+// diffCollection returns the changes of the replaceable properties of a collection
+func diffCollection(def CollectionDefinition, existing *cosmosapi.Collection) []FieldChange {
+	var fields []FieldChange
+	if def.PartitionKey != nil {
+		oldKey, newKey := formatValue(normalizePartitionKey(existing.PartitionKey)), formatValue(normalizePartitionKey(def.PartitionKey))
+		if oldKey != newKey {
+			fields = append(fields, FieldChange{Field: "partitionKey", Old: oldKey, New: newKey, Immutable: true})
+		}
+	}
+	if def.IndexingPolicy != nil {
+		var current cosmosapi.IndexingPolicy
+		if existing.IndexingPolicy != nil {
+			current = *existing.IndexingPolicy
+		}
+		oldPolicy, newPolicy := formatValue(normalizeIndexingPolicy(current)), formatValue(normalizeIndexingPolicy(*def.IndexingPolicy))
+		if oldPolicy != newPolicy {
+			fields = append(fields, FieldChange{Field: "indexingPolicy", Old: oldPolicy, New: newPolicy})
+		}
+	}
+	if def.DefaultTimeToLive != existing.DefaultTimeToLive {
+		fields = append(fields, FieldChange{Field: "defaultTtl", Old: formatValue(existing.DefaultTimeToLive), New: formatValue(def.DefaultTimeToLive)})
+	}
+	if def.UniqueKeyPolicy != nil {
+		oldPolicy, newPolicy := formatValue(normalizeUniqueKeyPolicy(existing.UniqueKeyPolicy)), formatValue(normalizeUniqueKeyPolicy(def.UniqueKeyPolicy))
+		if oldPolicy != newPolicy {
+			fields = append(fields, FieldChange{Field: "uniqueKeyPolicy", Old: oldPolicy, New: newPolicy, Immutable: true})
+		}
+	}
+	if ttl := def.AnalyticalStorageTimeToLive; ttl != 0 && ttl != existing.AnalyticalStorageTimeToLive {
+		// The analytical store can only be enabled when the collection is created
+		fields = append(fields, FieldChange{Field: "analyticalStorageTtl", Old: formatValue(existing.AnalyticalStorageTimeToLive), New: formatValue(ttl),
+			Immutable: existing.AnalyticalStorageTimeToLive == 0})
+	}
+	if def.ComputedProperties != nil {
+		oldProperties, newProperties := formatValue(normalizeComputedProperties(existing.ComputedProperties)), formatValue(normalizeComputedProperties(def.ComputedProperties))
+		if oldProperties != newProperties {
+			fields = append(fields, FieldChange{Field: "computedProperties", Old: oldProperties, New: newProperties})
+		}
+	}
+	if def.GeospatialConfig != nil && geospatialType(def.GeospatialConfig) != geospatialType(existing.GeospatialConfig) {
+		fields = append(fields, FieldChange{Field: "geospatialConfig.type", Old: formatValue(geospatialType(existing.GeospatialConfig)), New: formatValue(def.GeospatialConfig.Type)})
+	}
+	return fields
+}
+
+// normalizePartitionKey returns a copy of the key with the default kind and
+// version set, or nil for collections without a partition key
+func normalizePartitionKey(pk *cosmosapi.PartitionKey) *cosmosapi.PartitionKey {
+	if pk == nil || len(pk.Paths) == 0 {
+		return nil
+	}
+	normalized := *pk
+	if normalized.Kind == "" {
+		normalized.Kind = cosmosapi.PartitionKindHash
+	}
+	if normalized.Version == 0 {
+		normalized.Version = 1
+	}
+	return &normalized
+}
+
+// normalizeUniqueKeyPolicy returns the unique keys of a policy in the same order
+func normalizeUniqueKeyPolicy(p *cosmosapi.UniqueKeyPolicy) []cosmosapi.UniqueKey {
+	keys := []cosmosapi.UniqueKey{}
+	if p != nil {
+		keys = append(keys, p.UniqueKeys...)
+	}
+	sort.Slice(keys, func(i, j int) bool { return strings.Join(keys[i].Paths, ",") < strings.Join(keys[j].Paths, ",") })
+	return keys
+}
+
+func normalizeComputedProperties(properties []cosmosapi.ComputedProperty) []cosmosapi.ComputedProperty {
+	normalized := append([]cosmosapi.ComputedProperty{}, properties...)
+	sort.Slice(normalized, func(i, j int) bool { return normalized[i].Name < normalized[j].Name })
+	return normalized
+}
+
+// geospatialType returns the type of a config, which is Geography by default
+func geospatialType(config *cosmosapi.GeospatialConfig) cosmosapi.GeospatialType {
+	if config == nil || config.Type == "" {
+		return cosmosapi.GeospatialGeography
+	}
+	return config.Type
+}
+
+// normalizeIndexingPolicy returns a copy of the policy without the differences
+// between a policy as defined and as returned by Cosmos that do not matter
+func normalizeIndexingPolicy(p cosmosapi.IndexingPolicy) cosmosapi.IndexingPolicy {
+	p.IndexingMode = cosmosapi.IndexingMode(strings.ToLower(string(p.IndexingMode)))
+	if p.IndexingMode == "" {
+		p.IndexingMode = "consistent"
+	}
+	// The kind, data type and precision of indexes are ignored by Cosmos,
+	// which returns what it uses instead
+	included := make([]cosmosapi.IncludedPath, len(p.Included))
+	for i, path := range p.Included {
+		included[i] = cosmosapi.IncludedPath{Path: path.Path}
+	}
+	sort.Slice(included, func(i, j int) bool { return included[i].Path < included[j].Path })
+	p.Included = included
+	// Cosmos adds the system property _etag to the excluded paths
+	var excluded []cosmosapi.ExcludedPath
+	for _, path := range p.Excluded {
+		if path.Path != `/"_etag"/?` {
+			excluded = append(excluded, path)
+		}
+	}
+	sort.Slice(excluded, func(i, j int) bool { return excluded[i].Path < excluded[j].Path })
+	p.Excluded = excluded
+	composite := make([]cosmosapi.CompositeIndex, len(p.Composite))
+	for i, index := range p.Composite {
+		composite[i] = append(cosmosapi.CompositeIndex{}, index...)
+		for j := range composite[i] {
+			if composite[i][j].Order == "" {
+				composite[i][j].Order = cosmosapi.Ascending
+			}
+		}
+	}
+	p.Composite = composite
+	return p
+}
+
+// diffOffer returns the changes of an offer. Switching between manual and
+// autoscale throughput shows as the max throughput changing from or to null.
+func diffOffer(offer OfferDefinition, existing cosmosapi.Offer) []FieldChange {
+	var fields []FieldChange
+	existingMax := "null"
+	if existing.IsAutoscale() {
+		existingMax = formatValue(existing.Content.OfferAutopilotSettings.MaxThroughput)
+	}
+	if offer.Throughput > 0 {
+		if existing.IsAutoscale() {
+			fields = append(fields, FieldChange{Field: "autoscaleMaxThroughput", Old: existingMax, New: "null"})
+		}
+		if cosmosapi.OfferThroughput(offer.Throughput) != existing.Content.Throughput {
+			fields = append(fields, FieldChange{Field: "throughput", Old: formatValue(existing.Content.Throughput), New: formatValue(offer.Throughput)})
+		}
+	}
+	if offer.AutoscaleMaxThroughput > 0 && formatValue(offer.AutoscaleMaxThroughput) != existingMax {
+		fields = append(fields, FieldChange{Field: "autoscaleMaxThroughput", Old: existingMax, New: formatValue(offer.AutoscaleMaxThroughput)})
+	}
+	if offer.Type != "" && cosmosapi.OfferType(offer.Type) != existing.OfferType {
+		fields = append(fields, FieldChange{Field: "type", Old: formatValue(existing.OfferType), New: formatValue(offer.Type)})
+	}
+	return fields
+}
+
+func diffTrigger(trigDef Trigger, directory string, existing cosmosapi.Trigger) ([]FieldChange, error) {
+	var fields []FieldChange
+	if string(existing.Type) != trigDef.TriggerType {
+		fields = append(fields, FieldChange{Field: "triggerType", Old: formatValue(existing.Type), New: formatValue(trigDef.TriggerType)})
+	}
+	if string(existing.Operation) != trigDef.TriggerOperation {
+		fields = append(fields, FieldChange{Field: "triggerOperation", Old: formatValue(existing.Operation), New: formatValue(trigDef.TriggerOperation)})
+	}
+	body, err := getJavaScriptBody(trigDef.Body, directory)
+	if err != nil {
+		return nil, err
+	}
+	if body != existing.Body {
+		fields = append(fields, FieldChange{Field: "body", Old: summarizeBody(existing.Body), New: summarizeBody(body)})
+	}
+	return fields, nil
+}
+
+// summarizeBody describes a JavaScript body in a single line
+func summarizeBody(body string) string {
+	return fmt.Sprintf("(%d lines, %d bytes)", strings.Count(strings.TrimRight(body, "\n"), "\n")+1, len(body))
+}
+
+func formatValue(value interface{}) string {
+	b, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+// PrintPlan prints the changes of the plans, and returns whether there are any
+func PrintPlan(w io.Writer, plans []CollectionPlan) bool {
+	counts := map[ChangeAction]int{}
+	for _, plan := range plans {
+		for _, change := range plan.Changes() {
+			counts[change.Action]++
+			if change.Action == ActionUnchanged {
+				continue
+			}
+			fmt.Fprintf(w, "  %s %s %s\n", actionSymbols[change.Action], change.Kind, change.Name)
+			for _, field := range change.Fields {
+				if change.Action == ActionCreate {
+					fmt.Fprintf(w, "      %s: %s\n", field.Field, field.New)
+				} else if field.Immutable {
+					fmt.Fprintf(w, "      %s: %s -> %s (can only be set when created)\n", field.Field, field.Old, field.New)
+				} else {
+					fmt.Fprintf(w, "      %s: %s -> %s\n", field.Field, field.Old, field.New)
+				}
+			}
+		}
+	}
+	fmt.Fprintf(w, "Plan: %d to create, %d to update, %d to delete, %d unchanged.\n",
+		counts[ActionCreate], counts[ActionUpdate], counts[ActionDelete], counts[ActionUnchanged])
+	return counts[ActionCreate]+counts[ActionUpdate]+counts[ActionDelete] > 0
+}
@@ -0,0 +1,359 @@
+package cosmosapply
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// accountServer serves the collections, offers, triggers, stored procedures and user defined functions of an account.
+// Other requests than reads are recorded, and fail if they are in failures.
+type accountServer struct {
+	databases   map[string]bool
+	collections map[string]cosmosapi.Collection
+	offers      []cosmosapi.Offer
+	triggers    map[string][]cosmosapi.Trigger
+	sprocs      map[string][]cosmosapi.StoredProcedure
+	udfs        map[string][]cosmosapi.UDF
+
+	failures map[string]int
+	requests []string
+}
+
+func (s *accountServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	if r.Method != http.MethodGet {
+		request := r.Method + " " + path
+		s.requests = append(s.requests, request)
+		switch {
+		case s.failures[request] != 0:
+			w.WriteHeader(s.failures[request])
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPut:
+			// Replaced resources are returned as sent
+			io.Copy(w, r.Body)
+		default:
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("{}"))
+		}
+		return
+	}
+	switch {
+	case path == "dbs":
+		var databases []cosmosapi.Database
+		for name := range s.databases {
+			databases = append(databases, cosmosapi.Database{Resource: cosmosapi.Resource{Id: name, Rid: name}})
+		}
+		sort.Slice(databases, func(i, j int) bool { return databases[i].Id < databases[j].Id })
+		json.NewEncoder(w).Encode(map[string]interface{}{"Databases": databases})
+	case path == "offers":
+		json.NewEncoder(w).Encode(cosmosapi.Offers{Offers: s.offers})
+	case len(parts) == 3 && parts[2] == "colls":
+		var collections []cosmosapi.Collection
+		for name, collection := range s.collections {
+			if strings.HasPrefix(name, parts[1]+"/") {
+				collections = append(collections, collection)
+			}
+		}
+		sort.Slice(collections, func(i, j int) bool { return collections[i].Id < collections[j].Id })
+		json.NewEncoder(w).Encode(cosmosapi.DocumentCollection{DocumentCollections: collections})
+	case len(parts) == 2 && s.databases[parts[1]]:
+		json.NewEncoder(w).Encode(cosmosapi.Database{Resource: cosmosapi.Resource{Id: parts[1], Rid: parts[1]}})
+	case len(parts) == 4:
+		if collection, ok := s.collections[parts[1]+"/"+parts[3]]; ok {
+			json.NewEncoder(w).Encode(collection)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case len(parts) == 5 && parts[4] == "triggers":
+		json.NewEncoder(w).Encode(cosmosapi.CollectionTriggers{Triggers: s.triggers[parts[1]+"/"+parts[3]]})
+	case len(parts) == 5 && parts[4] == "sprocs":
+		json.NewEncoder(w).Encode(cosmosapi.StoredProcedures{StoredProcedures: s.sprocs[parts[1]+"/"+parts[3]]})
+	case len(parts) == 5 && parts[4] == "udfs":
+		json.NewEncoder(w).Encode(cosmosapi.UserDefinedFunctions{UserDefinedFunctions: s.udfs[parts[1]+"/"+parts[3]]})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestClient(url string) *cosmosapi.Client {
+	return cosmosapi.New(url, cosmosapi.Config{MasterKey: "YWJjZA=="}, nil, nil)
+}
+
+func TestPlan(t *testing.T) {
+	defs, err := LoadDefinitions("test_data/all_fields.json")
+	require.NoError(t, err)
+	require.Len(t, defs, 1)
+	def := defs[0]
+	trigger := def.Triggers[1]
+	triggerBody, err := getJavaScriptBody(trigger.Body, def.FilePath)
+	require.NoError(t, err)
+	sprocBody, err := getJavaScriptBody(def.Sprocs[0].Body, def.FilePath)
+	require.NoError(t, err)
+
+	s := &accountServer{
+		databases: map[string]bool{"someDatabase": true},
+		collections: map[string]cosmosapi.Collection{"someDatabase/someCollection": {
+			Resource:     cosmosapi.Resource{Id: "someCollection", Rid: "rid"},
+			PartitionKey: def.PartitionKey,
+			IndexingPolicy: &cosmosapi.IndexingPolicy{
+				IndexingMode: "Consistent",
+				Automatic:    true,
+				Included:     []cosmosapi.IncludedPath{{Path: "/*"}},
+				Excluded:     []cosmosapi.ExcludedPath{{Path: `/"_etag"/?`}},
+			},
+		}},
+		offers: []cosmosapi.Offer{{OfferResourceId: "rid", Content: cosmosapi.OfferThroughputContent{Throughput: 10000}}},
+		triggers: map[string][]cosmosapi.Trigger{"someDatabase/someCollection": {{
+			Id:        trigger.ID,
+			Body:      triggerBody,
+			Type:      cosmosapi.TriggerType(trigger.TriggerType),
+			Operation: cosmosapi.TriggerOperation(trigger.TriggerOperation),
+		}}},
+		sprocs: map[string][]cosmosapi.StoredProcedure{"someDatabase/someCollection": {{
+			Resource: cosmosapi.Resource{Id: "bulkDelete"},
+			Body:     sprocBody,
+		}}},
+		udfs: map[string][]cosmosapi.UDF{"someDatabase/someCollection": {{
+			Resource: cosmosapi.Resource{Id: "tax"},
+			Body:     "function tax(income) { return 0; }",
+		}}},
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	client := newTestClient(ts.URL)
+
+	plans, err := Plan(context.Background(), client, []CollectionDefinition{def}, PruneOptions{})
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+	changes := plans[0].Changes()
+	require.Len(t, changes, 6)
+	assert.Equal(t, ActionUnchanged, changes[0].Action, "collection")
+	assert.Equal(t, ActionUnchanged, changes[1].Action, "offer")
+	assert.Equal(t, ResourceChange{Kind: "trigger", ID: "postCreateSomething", Name: "someDatabase/someCollection/postCreateSomething", Action: ActionCreate}, changes[2])
+	assert.Equal(t, ActionUnchanged, changes[3].Action, "trigger from file")
+	assert.Equal(t, ActionUnchanged, changes[4].Action, "sproc from file")
+	assert.Equal(t, ResourceChange{Kind: "udf", ID: "tax", Name: "someDatabase/someCollection/tax", Action: ActionUpdate, Fields: []FieldChange{
+		{Field: "body", Old: "(1 lines, 34 bytes)", New: "(3 lines, 51 bytes)"},
+	}}, changes[5])
+
+	def.DefaultTimeToLive = 60
+	def.Offer.Throughput = 400
+	def.Count = 2
+	plans, err = Plan(context.Background(), client, []CollectionDefinition{def}, PruneOptions{})
+	require.NoError(t, err)
+	require.Len(t, plans, 2)
+	var out bytes.Buffer
+	assert.True(t, PrintPlan(&out, plans))
+	assert.Contains(t, out.String(), "  + collection someDatabase/someCollection-1\n")
+	assert.Contains(t, out.String(), "      defaultTtl: 60\n")
+	assert.Contains(t, out.String(), "Plan: 10 to create, 0 to update, 0 to delete, 0 unchanged.\n")
+
+	def.Count = 0
+	def.DatabaseID = "newDatabase"
+	plans, err = Plan(context.Background(), client, []CollectionDefinition{def, def}, PruneOptions{})
+	require.NoError(t, err)
+	out.Reset()
+	PrintPlan(&out, plans)
+	assert.Equal(t, 1, strings.Count(out.String(), "+ database newDatabase"), "database is created once")
+}
+
+func TestDiffCollection(t *testing.T) {
+	def := CollectionDefinition{
+		DefaultTimeToLive: -1,
+		IndexingPolicy: &cosmosapi.IndexingPolicy{
+			IndexingMode: "consistent",
+			Automatic:    true,
+			Included:     []cosmosapi.IncludedPath{{Path: "/b/?"}, {Path: "/a/?", Indexes: []cosmosapi.Index{{Kind: cosmosapi.Range}}}},
+			Excluded:     []cosmosapi.ExcludedPath{{Path: "/*"}},
+		},
+	}
+	existing := &cosmosapi.Collection{
+		DefaultTimeToLive: -1,
+		IndexingPolicy: &cosmosapi.IndexingPolicy{
+			IndexingMode: "Consistent",
+			Automatic:    true,
+			Included:     []cosmosapi.IncludedPath{{Path: "/a/?"}, {Path: "/b/?"}},
+			Excluded:     []cosmosapi.ExcludedPath{{Path: `/"_etag"/?`}, {Path: "/*"}},
+		},
+	}
+	assert.Empty(t, diffCollection(def, existing))
+
+	def.IndexingPolicy.Excluded = nil
+	def.DefaultTimeToLive = 0
+	fields := diffCollection(def, existing)
+	require.Len(t, fields, 2)
+	assert.Equal(t, "indexingPolicy", fields[0].Field)
+	assert.Equal(t, FieldChange{Field: "defaultTtl", Old: "-1", New: "0"}, fields[1])
+
+	def.IndexingPolicy = nil
+	assert.Len(t, diffCollection(def, existing), 1, "indexing policy is not managed")
+}
+
+func TestDiffCollectionCreationProperties(t *testing.T) {
+	def := CollectionDefinition{
+		CollectionID:    "coll",
+		UniqueKeyPolicy: &cosmosapi.UniqueKeyPolicy{UniqueKeys: []cosmosapi.UniqueKey{{Paths: []string{"/b"}}, {Paths: []string{"/a", "/c"}}}},
+		ComputedProperties: []cosmosapi.ComputedProperty{
+			{Name: "lowerName", Query: "SELECT VALUE LOWER(c.name) FROM c"},
+			{Name: "year", Query: "SELECT VALUE SUBSTRING(c.date, 0, 4) FROM c"},
+		},
+		GeospatialConfig: &cosmosapi.GeospatialConfig{Type: cosmosapi.GeospatialGeography},
+	}
+	existing := &cosmosapi.Collection{
+		UniqueKeyPolicy: &cosmosapi.UniqueKeyPolicy{UniqueKeys: []cosmosapi.UniqueKey{{Paths: []string{"/a", "/c"}}, {Paths: []string{"/b"}}}},
+		ComputedProperties: []cosmosapi.ComputedProperty{
+			{Name: "year", Query: "SELECT VALUE SUBSTRING(c.date, 0, 4) FROM c"},
+			{Name: "lowerName", Query: "SELECT VALUE LOWER(c.name) FROM c"},
+		},
+	}
+	assert.Empty(t, diffCollection(def, existing), "order and the default geospatial type do not matter")
+
+	def.UniqueKeyPolicy.UniqueKeys = def.UniqueKeyPolicy.UniqueKeys[1:]
+	def.AnalyticalStorageTimeToLive = -1
+	def.ComputedProperties = []cosmosapi.ComputedProperty{}
+	def.GeospatialConfig.Type = cosmosapi.GeospatialGeometry
+	fields := diffCollection(def, existing)
+	require.Len(t, fields, 4)
+	assert.Equal(t, "uniqueKeyPolicy", fields[0].Field)
+	assert.True(t, fields[0].Immutable)
+	assert.Equal(t, FieldChange{Field: "analyticalStorageTtl", Old: "0", New: "-1", Immutable: true}, fields[1])
+	assert.Equal(t, FieldChange{Field: "computedProperties", Old: fields[2].Old, New: "[]"}, fields[2])
+	assert.Equal(t, FieldChange{Field: "geospatialConfig.type", Old: `"Geography"`, New: `"Geometry"`}, fields[3])
+
+	plan := CollectionPlan{Collection: newResourceChange("collection", "db", "coll", fields)}
+	assert.EqualError(t, plan.Err(), "Collection 'db/coll' must be recreated to change uniqueKeyPolicy, analyticalStorageTtl, which can only be set when it is created")
+
+	// The ttl of an enabled analytical store can be changed
+	existing.AnalyticalStorageTimeToLive = -1
+	def.AnalyticalStorageTimeToLive = 3600
+	def.UniqueKeyPolicy, def.ComputedProperties, def.GeospatialConfig = nil, nil, nil
+	assert.Equal(t, []FieldChange{{Field: "analyticalStorageTtl", Old: "-1", New: "3600"}}, diffCollection(def, existing))
+
+	// The partition key can not be changed, but the kind and version have defaults
+	def.AnalyticalStorageTimeToLive = 0
+	existing.PartitionKey = &cosmosapi.PartitionKey{Paths: []string{"/id"}, Kind: cosmosapi.PartitionKindHash, Version: 1}
+	def.PartitionKey = &cosmosapi.PartitionKey{Paths: []string{"/id"}}
+	assert.Empty(t, diffCollection(def, existing))
+	def.PartitionKey = &cosmosapi.PartitionKey{Paths: []string{"/tenant"}}
+	fields = diffCollection(def, existing)
+	require.Len(t, fields, 1)
+	assert.Equal(t, FieldChange{Field: "partitionKey", Old: fields[0].Old, New: `{"paths":["/tenant"],"kind":"Hash","version":1}`, Immutable: true}, fields[0])
+	def.PartitionKey = &cosmosapi.PartitionKey{Paths: []string{"/id"}, Version: 2}
+	plan = CollectionPlan{Collection: newResourceChange("collection", "db", "coll", diffCollection(def, existing))}
+	assert.EqualError(t, plan.Err(), "Collection 'db/coll' must be recreated to change partitionKey, which can only be set when it is created")
+}
+
+func TestDiffOffer(t *testing.T) {
+	offer := OfferDefinition{Throughput: 1000}
+	existing := cosmosapi.Offer{OfferType: "Invalid", Content: cosmosapi.OfferThroughputContent{Throughput: 400}}
+	assert.Equal(t, []FieldChange{{Field: "throughput", Old: "400", New: "1000"}}, diffOffer(offer, existing))
+	existing.Content.Throughput = 1000
+	assert.Empty(t, diffOffer(offer, existing))
+
+	autoscale := OfferDefinition{AutoscaleMaxThroughput: 4000}
+	assert.Equal(t, []FieldChange{{Field: "autoscaleMaxThroughput", Old: "null", New: "4000"}}, diffOffer(autoscale, existing))
+	existing.Content.OfferAutopilotSettings = &cosmosapi.OfferAutopilotSettings{MaxThroughput: 4000}
+	assert.Empty(t, diffOffer(autoscale, existing))
+	assert.Equal(t, []FieldChange{{Field: "autoscaleMaxThroughput", Old: "4000", New: "null"}}, diffOffer(offer, existing))
+}
+
+func TestPlanDatabaseOffer(t *testing.T) {
+	shared := &OfferDefinition{AutoscaleMaxThroughput: 4000}
+	defs := []CollectionDefinition{
+		{DatabaseID: "db", CollectionID: "a"},
+		{DatabaseID: "db", CollectionID: "b", DatabaseOffer: shared},
+		{DatabaseID: "newDatabase", CollectionID: "c", DatabaseOffer: &OfferDefinition{Throughput: 400}},
+	}
+	s := &accountServer{
+		databases:   map[string]bool{"db": true},
+		collections: map[string]cosmosapi.Collection{"db/a": {Resource: cosmosapi.Resource{Id: "a"}}, "db/b": {Resource: cosmosapi.Resource{Id: "b"}}},
+		offers: []cosmosapi.Offer{{Resource: cosmosapi.Resource{Id: "offer", Rid: "offer"}, OfferResourceId: "db", OfferType: "Invalid",
+			Content: cosmosapi.OfferThroughputContent{Throughput: 400}}},
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	client := newTestClient(ts.URL)
+
+	plans, err := Plan(context.Background(), client, defs, PruneOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, plans[0].DatabaseOffer, "the database offer is planned with the first collection of the database")
+	assert.Equal(t, []FieldChange{{Field: "autoscaleMaxThroughput", Old: "null", New: "4000"}}, plans[0].DatabaseOffer.Fields)
+	assert.Nil(t, plans[1].DatabaseOffer)
+	assert.Equal(t, []FieldChange{{Field: "offer.throughput", New: "400"}}, plans[2].Database.Fields)
+	var out bytes.Buffer
+	PrintPlan(&out, plans)
+	assert.Contains(t, out.String(), "  ~ offer db\n      autoscaleMaxThroughput: null -> 4000\n")
+
+	// Migrating to autoscale returns an offer without settings here, so the max throughput is replaced afterwards
+	Apply(context.Background(), client, plans, ApplyOptions{})
+	assert.Equal(t, []string{"PUT offers/offer", "PUT offers/offer", "POST dbs", "POST dbs/newDatabase/colls"}, s.requests)
+
+	s.offers = nil
+	_, err = Plan(context.Background(), client, defs, PruneOptions{})
+	assert.EqualError(t, err, "Database 'db' has no shared throughput, which can only be provisioned when it is created")
+}
+
+func TestPlanPrune(t *testing.T) {
+	def := CollectionDefinition{DatabaseID: "db", CollectionID: "coll", Triggers: []Trigger{{
+		ID:          "kept",
+		TriggerType: "Pre",
+		Body:        TriggerBody{SourceLocation: "inline", InlineSource: "function kept() {}"},
+	}}}
+	s := &accountServer{
+		databases: map[string]bool{"db": true},
+		collections: map[string]cosmosapi.Collection{
+			"db/coll":      {Resource: cosmosapi.Resource{Id: "coll"}},
+			"db/old":       {Resource: cosmosapi.Resource{Id: "old"}},
+			"db/protected": {Resource: cosmosapi.Resource{Id: "protected"}},
+		},
+		triggers: map[string][]cosmosapi.Trigger{"db/coll": {
+			{Id: "kept", Type: "Pre", Body: "function kept() {}"},
+			{Id: "renamed"},
+			{Id: "manual"},
+		}},
+		sprocs: map[string][]cosmosapi.StoredProcedure{"db/coll": {{Resource: cosmosapi.Resource{Id: "sproc"}}}},
+		udfs:   map[string][]cosmosapi.UDF{"db/coll": {{Resource: cosmosapi.Resource{Id: "udf"}}}},
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	client := newTestClient(ts.URL)
+
+	plans, err := Plan(context.Background(), client, []CollectionDefinition{def}, PruneOptions{})
+	require.NoError(t, err)
+	var out bytes.Buffer
+	assert.False(t, PrintPlan(&out, plans), "nothing is deleted without prune")
+
+	prune := PruneOptions{Enabled: true, Protected: []string{"db/coll/man*", "db/protected"}}
+	plans, err = Plan(context.Background(), client, []CollectionDefinition{def}, prune)
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+	assert.Equal(t, []ResourceChange{
+		{Kind: "trigger", ID: "kept", Name: "db/coll/kept", Action: ActionUnchanged},
+		{Kind: "trigger", ID: "renamed", Name: "db/coll/renamed", Action: ActionDelete},
+	}, plans[0].Triggers)
+	assert.Equal(t, []ResourceChange{{Kind: "sproc", ID: "sproc", Name: "db/coll/sproc", Action: ActionDelete}}, plans[0].Sprocs)
+	assert.Equal(t, []ResourceChange{{Kind: "udf", ID: "udf", Name: "db/coll/udf", Action: ActionDelete}}, plans[0].Udfs)
+
+	prune.Collections = true
+	plans, err = Plan(context.Background(), client, []CollectionDefinition{def}, prune)
+	require.NoError(t, err)
+	require.Len(t, plans, 2)
+	assert.Equal(t, CollectionDefinition{DatabaseID: "db", CollectionID: "old"}, plans[1].Definition)
+	out.Reset()
+	assert.True(t, PrintPlan(&out, plans))
+	assert.Contains(t, out.String(), "  - collection db/old\n")
+	assert.Contains(t, out.String(), "  - trigger db/coll/renamed\n")
+	assert.Contains(t, out.String(), "Plan: 0 to create, 0 to update, 4 to delete, 2 unchanged.\n")
+}
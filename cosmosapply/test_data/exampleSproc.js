@@ -0,0 +1,15 @@
+function bulkDelete(ids) {
+    let collection = getContext().getCollection();
+    let deleted = 0;
+    ids.forEach(id => {
+        let accepted = collection.deleteDocument(collection.getAltLink() + "/docs/" + id, {}, err => {
+            if (err) {
+                throw err;
+            }
+        });
+        if (accepted) {
+            deleted++;
+        }
+    });
+    getContext().getResponse().setBody(deleted);
+}
@@ -0,0 +1,344 @@
+// Package cosmosscaler changes the throughput of collections and databases
+// over the day, following schedules and the usage observed by the clients. It
+// is the library behind cmd/cosmos-scaler.
+//
+// Scaling by usage needs the scaler to run in the same process as the clients,
+// with a Recorder as the Metrics of their cosmosapi.Config and as the Usage of
+// the scaler.
+package cosmosscaler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// Target is a collection, or a database with throughput shared by its
+// collections, to scale
+type Target struct {
+	DatabaseID string `json:"databaseId"`
+	// CollectionID is empty to scale the offer of the database
+	CollectionID string `json:"collectionId,omitempty"`
+	// Min and Max bound the throughput, or the max throughput of offers with
+	// autoscale. Max is not bounded if 0, and is rounded down to a multiple
+	// of 100, or 1000 with autoscale.
+	Min      int            `json:"min"`
+	Max      int            `json:"max"`
+	Schedule []ScheduleRule `json:"schedule,omitempty"`
+	Reactive *Reactive      `json:"reactive,omitempty"`
+}
+
+func (t Target) name() string {
+	if t.CollectionID == "" {
+		return t.DatabaseID
+	}
+	return t.DatabaseID + "/" + t.CollectionID
+}
+
+// Reactive scales a target by its usage. The throughput is raised above the
+// one of the schedule, or Min without a schedule, when needed by the usage.
+type Reactive struct {
+	// TargetUtilization is the fraction of the throughput the request units
+	// consumed should use, like 0.7
+	TargetUtilization float64 `json:"targetUtilization"`
+	// MaxThrottleRate is the fraction of requests being throttled above which
+	// the throughput is multiplied by ScaleUpFactor. Ignored if 0.
+	MaxThrottleRate float64 `json:"maxThrottleRate,omitempty"`
+	ScaleUpFactor   float64 `json:"scaleUpFactor,omitempty"`
+}
+
+type Options struct {
+	Targets []Target
+	// Usage is required to scale targets by usage, and is usually a Recorder
+	Usage UsageSource
+	// DryRun decides the changes without making them
+	DryRun bool
+	// ScaleDownDelay is how long after changing the throughput of a target it
+	// is not decreased, so that a spike does not make it go up and down
+	ScaleDownDelay time.Duration
+	// Location is the time zone of the schedules, UTC if nil
+	Location *time.Location
+	// Audit, if set, gets a line of JSON for every decision except those
+	// leaving a throughput unchanged
+	Audit io.Writer
+}
+
+type Action string
+
+const (
+	ActionUnchanged = Action("unchanged")
+	ActionReplaced  = Action("replaced")
+	ActionDryRun    = Action("dry-run")
+	// ActionDelayed is a decrease within the ScaleDownDelay of the last change
+	ActionDelayed = Action("delayed")
+	// ActionPending is a change not made because the last one is still pending
+	ActionPending = Action("pending")
+	ActionFailed  = Action("failed")
+)
+
+// Decision is the outcome of scaling a target once
+type Decision struct {
+	Time time.Time `json:"time"`
+	// Target is the name of the target, empty if listing the offers failed
+	Target    string `json:"target"`
+	Autoscale bool   `json:"autoscale,omitempty"`
+	Current   int    `json:"current"`
+	Desired   int    `json:"desired"`
+	Reason    string `json:"reason,omitempty"`
+	Action    Action `json:"action"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Scaler replaces the offers of the targets with the throughput decided by
+// their schedules and usage
+type Scaler struct {
+	client    *cosmosapi.Client
+	ops       Options
+	schedules []schedule
+	// resourceIds of the targets, by name
+	resourceIds map[string]string
+	// lastChange of the throughput of the targets, by name
+	lastChange map[string]time.Time
+}
+
+// New returns a scaler of the targets of the options, or an error if they are invalid
+func New(client *cosmosapi.Client, ops Options) (*Scaler, error) {
+	s := &Scaler{client: client, ops: ops, resourceIds: map[string]string{}, lastChange: map[string]time.Time{}}
+	if s.ops.Location == nil {
+		s.ops.Location = time.UTC
+	}
+	for _, t := range ops.Targets {
+		if err := validateTarget(t, ops.Usage != nil); err != nil {
+			return nil, err
+		}
+		schedule, err := parseSchedule(t.Schedule)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid schedule of '%s'", t.name())
+		}
+		s.schedules = append(s.schedules, schedule)
+	}
+	return s, nil
+}
+
+func validateTarget(t Target, hasUsage bool) error {
+	switch {
+	case t.DatabaseID == "":
+		return errors.New("Targets must have a databaseId")
+	case t.Min < 0 || (t.Max != 0 && t.Max < t.Min):
+		return errors.Errorf("The max throughput of '%s' must not be less than the min throughput", t.name())
+	case len(t.Schedule) == 0 && t.Reactive == nil:
+		return errors.Errorf("'%s' must have a schedule or be scaled by usage", t.name())
+	}
+	if r := t.Reactive; r != nil {
+		switch {
+		case !hasUsage:
+			return errors.Errorf("Scaling '%s' by usage needs the Usage option", t.name())
+		case r.TargetUtilization <= 0 || r.TargetUtilization > 1:
+			return errors.Errorf("The target utilization of '%s' must be above 0 and at most 1", t.name())
+		case r.MaxThrottleRate > 0 && r.ScaleUpFactor <= 1:
+			return errors.Errorf("The scale up factor of '%s' must be above 1", t.name())
+		}
+	}
+	return nil
+}
+
+// Run scales the targets every interval until the context is done. A round
+// that fails is retried at the next interval, unless the client is not
+// authorized.
+func (s *Scaler) Run(ctx context.Context, interval time.Duration) error {
+	for {
+		if _, err := s.Scale(ctx, time.Now()); err != nil && cosmosapi.IsAuthError(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Scale decides the throughput of every target at the given time, and
+// replaces the offers of those to change. It returns an error if the offers
+// could not be listed; the errors of the targets are in their decisions.
+func (s *Scaler) Scale(ctx context.Context, now time.Time) ([]Decision, error) {
+	offers, err := s.client.ListOffers(ctx, nil)
+	if err != nil {
+		err = errors.Wrap(err, "Could not list offers")
+		s.audit(Decision{Time: now, Action: ActionFailed, Error: err.Error()})
+		return nil, err
+	}
+	var decisions []Decision
+	for i := range s.ops.Targets {
+		decision := s.scaleTarget(ctx, i, now, offers.Offers)
+		s.audit(decision)
+		decisions = append(decisions, decision)
+	}
+	return decisions, nil
+}
+
+func (s *Scaler) audit(d Decision) {
+	if s.ops.Audit == nil || d.Action == ActionUnchanged {
+		return
+	}
+	json.NewEncoder(s.ops.Audit).Encode(d)
+}
+
+func (s *Scaler) scaleTarget(ctx context.Context, i int, now time.Time, offers []cosmosapi.Offer) Decision {
+	t := s.ops.Targets[i]
+	d := Decision{Time: now, Target: t.name(), Action: ActionFailed}
+	fail := func(err error) Decision {
+		d.Error = err.Error()
+		return d
+	}
+
+	resourceId, err := s.resourceId(ctx, t)
+	if err != nil {
+		return fail(err)
+	}
+	var offer *cosmosapi.Offer
+	for j := range offers {
+		if offers[j].OfferResourceId == resourceId {
+			offer = &offers[j]
+		}
+	}
+	if offer == nil {
+		return fail(errors.Errorf("Could not find the offer of '%s'", t.name()))
+	}
+	d.Autoscale = offer.IsAutoscale()
+	d.Current = int(offer.Content.Throughput)
+	if d.Autoscale {
+		d.Current = int(offer.Content.OfferAutopilotSettings.MaxThroughput)
+	}
+
+	var usage Usage
+	if t.Reactive != nil {
+		usage = s.ops.Usage.Take(t.DatabaseID, t.CollectionID)
+	}
+	d.Desired, d.Reason = s.desiredThroughput(i, d.Current, now, usage)
+	step := 100
+	if d.Autoscale {
+		step = 1000
+	}
+	// Round up to a throughput that can be provisioned, but not above the
+	// max, which may not be a multiple of the step
+	d.Desired = (d.Desired + step - 1) / step * step
+	if max := t.Max / step * step; t.Max > 0 && d.Desired > max {
+		d.Desired = max
+	}
+	if min := int(offer.MinThroughput()); d.Desired < min {
+		d.Desired = min
+		d.Reason += ", raised to the lowest possible"
+	}
+
+	switch {
+	case d.Desired == d.Current:
+		d.Action = ActionUnchanged
+		return d
+	case d.Desired < d.Current && now.Sub(s.lastChange[t.name()]) < s.ops.ScaleDownDelay:
+		d.Action = ActionDelayed
+		return d
+	}
+
+	// The offer listed does not tell if a replacement of it is pending
+	current, err := s.client.GetOffer(ctx, offer.Rid, nil)
+	if err != nil {
+		return fail(errors.Wrapf(err, "Could not get offer '%s'", offer.Id))
+	}
+	if current.ReplacePending {
+		d.Action = ActionPending
+		return d
+	}
+	if s.ops.DryRun {
+		d.Action = ActionDryRun
+		return d
+	}
+	if _, err := s.client.ReplaceOffer(ctx, replaceOptions(*current, d.Desired), nil); err != nil {
+		return fail(errors.Wrapf(err, "Could not replace offer '%s'", offer.Id))
+	}
+	s.lastChange[t.name()] = now
+	d.Action = ActionReplaced
+	return d
+}
+
+// desiredThroughput returns the throughput of a target by its schedule and
+// usage, within its bounds, and the reason for it
+func (s *Scaler) desiredThroughput(i, current int, now time.Time, usage Usage) (int, string) {
+	t := s.ops.Targets[i]
+	desired, reason := current, "no schedule rule matched"
+	if rule, ok := s.schedules[i].ruleAt(now.In(s.ops.Location)); ok {
+		desired, reason = rule.Throughput, fmt.Sprintf("schedule '%s'", rule.Cron)
+	} else if t.Reactive != nil {
+		desired, reason = t.Min, "min"
+	}
+
+	if r := t.Reactive; r != nil && usage.Attempts > 0 {
+		consumed := usage.RequestUnitsPerSecond()
+		if needed := int(math.Ceil(consumed / r.TargetUtilization)); needed > desired {
+			desired, reason = needed, fmt.Sprintf("%.0f RU/s consumed", consumed)
+		}
+		if rate := usage.ThrottleRate(); r.MaxThrottleRate > 0 && rate > r.MaxThrottleRate {
+			if up := int(math.Ceil(float64(current) * r.ScaleUpFactor)); up > desired {
+				desired, reason = up, fmt.Sprintf("%.1f%% of requests throttled", rate*100)
+			}
+		}
+	}
+
+	if t.Max > 0 && desired > t.Max {
+		desired, reason = t.Max, reason+", limited to max"
+	}
+	if desired < t.Min {
+		desired, reason = t.Min, reason+", raised to min"
+	}
+	return desired, reason
+}
+
+// resourceId returns the resource id of the collection or database of a
+// target, which is the one the offer refers to
+func (s *Scaler) resourceId(ctx context.Context, t Target) (string, error) {
+	if rid, ok := s.resourceIds[t.name()]; ok {
+		return rid, nil
+	}
+	var rid string
+	if t.CollectionID == "" {
+		db, err := s.client.GetDatabase(ctx, t.DatabaseID, nil)
+		if err != nil {
+			return "", errors.Wrapf(err, "Could not get database '%s'", t.DatabaseID)
+		}
+		rid = db.Rid
+	} else {
+		collection, err := s.client.GetCollection(ctx, t.DatabaseID, t.CollectionID)
+		if err != nil {
+			return "", errors.Wrapf(err, "Could not get collection '%s'", t.name())
+		}
+		rid = collection.Rid
+	}
+	s.resourceIds[t.name()] = rid
+	return rid, nil
+}
+
+// replaceOptions returns the options to replace an offer with a throughput,
+// or a max throughput if it has autoscale
+func replaceOptions(offer cosmosapi.Offer, throughput int) cosmosapi.OfferReplaceOptions {
+	ops := cosmosapi.OfferReplaceOptions{
+		Rid:              offer.Rid,
+		OfferResourceId:  offer.OfferResourceId,
+		Id:               offer.Id,
+		OfferVersion:     offer.OfferVersion,
+		ResourceSelfLink: offer.Self,
+		OfferType:        offer.OfferType,
+		Content:          cosmosapi.OfferThroughputContent{Throughput: cosmosapi.OfferThroughput(throughput)},
+	}
+	if offer.IsAutoscale() {
+		ops.Content = cosmosapi.OfferThroughputContent{
+			OfferAutopilotSettings: &cosmosapi.OfferAutopilotSettings{MaxThroughput: cosmosapi.OfferThroughput(throughput)},
+		}
+	}
+	return ops
+}
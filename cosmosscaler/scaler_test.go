@@ -0,0 +1,181 @@
+package cosmosscaler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/go-cosmosdb/cosmosapi"
+)
+
+// offerServer serves a database "db" with the collection "coll", and their
+// offers. Replaced offers are recorded.
+type offerServer struct {
+	offers   map[string]*cosmosapi.Offer
+	pending  bool
+	replaced []cosmosapi.OfferReplaceOptions
+}
+
+func (s *offerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case path == "dbs/db":
+		json.NewEncoder(w).Encode(cosmosapi.Database{Resource: cosmosapi.Resource{Id: "db", Rid: "dbRid"}})
+	case path == "dbs/db/colls/coll":
+		json.NewEncoder(w).Encode(cosmosapi.Collection{Resource: cosmosapi.Resource{Id: "coll", Rid: "collRid"}})
+	case path == "offers":
+		var offers cosmosapi.Offers
+		for _, offer := range s.offers {
+			offers.Offers = append(offers.Offers, *offer)
+		}
+		json.NewEncoder(w).Encode(offers)
+	case strings.HasPrefix(path, "offers/") && s.offers[path[len("offers/"):]] != nil:
+		offer := s.offers[path[len("offers/"):]]
+		if r.Method == http.MethodPut {
+			var ops cosmosapi.OfferReplaceOptions
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &ops)
+			s.replaced = append(s.replaced, ops)
+			offer.Content = ops.Content
+		}
+		if s.pending {
+			w.Header().Set(cosmosapi.HEADER_OFFER_REPLACE_PENDING, "true")
+		}
+		json.NewEncoder(w).Encode(offer)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newOffer(resourceId string, throughput cosmosapi.OfferThroughput) *cosmosapi.Offer {
+	return &cosmosapi.Offer{Resource: cosmosapi.Resource{Id: resourceId + "Offer", Rid: resourceId + "Offer"}, OfferResourceId: resourceId,
+		OfferType: "Invalid", Content: cosmosapi.OfferThroughputContent{Throughput: throughput}}
+}
+
+type fixedUsage map[string]Usage
+
+func (u fixedUsage) Take(database, collection string) Usage {
+	return u[database+"/"+collection]
+}
+
+func TestScale(t *testing.T) {
+	s := &offerServer{offers: map[string]*cosmosapi.Offer{"collRidOffer": newOffer("collRid", 400)}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	client := cosmosapi.New(ts.URL, cosmosapi.Config{MasterKey: "YWJjZA=="}, nil, nil)
+
+	var audit bytes.Buffer
+	scaler, err := New(client, Options{
+		Targets: []Target{{DatabaseID: "db", CollectionID: "coll", Min: 400, Max: 5000, Schedule: []ScheduleRule{
+			{Cron: "0 7 * * 1-5", Throughput: 4000},
+			{Cron: "0 20 * * *", Throughput: 1000},
+		}}},
+		ScaleDownDelay: time.Hour,
+		Audit:          &audit,
+	})
+	require.NoError(t, err)
+	morning := time.Date(2024, 1, 5, 7, 0, 0, 0, time.UTC)
+	scale := func(now time.Time) Decision {
+		decisions, err := scaler.Scale(context.Background(), now)
+		require.NoError(t, err)
+		require.Len(t, decisions, 1)
+		return decisions[0]
+	}
+
+	d := scale(morning)
+	assert.Equal(t, Decision{Time: morning, Target: "db/coll", Current: 400, Desired: 4000, Reason: "schedule '0 7 * * 1-5'", Action: ActionReplaced}, d)
+	require.Len(t, s.replaced, 1)
+	assert.Equal(t, cosmosapi.OfferThroughput(4000), s.replaced[0].Content.Throughput)
+	assert.Equal(t, "collRidOffer", s.replaced[0].Rid)
+	assert.Equal(t, ActionUnchanged, scale(morning.Add(time.Minute)).Action)
+
+	// Decreases wait for the scale down delay
+	evening := morning.Add(13 * time.Hour)
+	assert.Equal(t, ActionReplaced, scale(evening).Action)
+	s.offers["collRidOffer"].Content.Throughput = 6000
+	d = scale(evening.Add(time.Minute))
+	assert.Equal(t, ActionDelayed, d.Action)
+	assert.Equal(t, "schedule '0 20 * * *'", d.Reason)
+
+	// Changes wait for pending replacements
+	s.pending = true
+	assert.Equal(t, ActionPending, scale(evening.Add(2*time.Hour)).Action)
+	s.pending = false
+
+	// The throughput can not go below what Cosmos allows
+	s.offers["collRidOffer"].Content.OfferMinimumThroughputParameters = &cosmosapi.OfferMinimumThroughputParameters{MaxThroughputEverProvisioned: 150000}
+	d = scale(evening.Add(2 * time.Hour))
+	assert.Equal(t, 1500, d.Desired)
+	assert.Equal(t, "schedule '0 20 * * *', raised to the lowest possible", d.Reason)
+
+	lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+	assert.Len(t, lines, 5, "unchanged decisions are not audited")
+	assert.Contains(t, lines[0], `"target":"db/coll","current":400,"desired":4000,"reason":"schedule '0 7 * * 1-5'","action":"replaced"`)
+}
+
+func TestScaleByUsage(t *testing.T) {
+	autoscale := newOffer("dbRid", 400)
+	autoscale.Content.OfferAutopilotSettings = &cosmosapi.OfferAutopilotSettings{MaxThroughput: 4000}
+	s := &offerServer{offers: map[string]*cosmosapi.Offer{"dbRidOffer": autoscale, "collRidOffer": newOffer("collRid", 1000)}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	client := cosmosapi.New(ts.URL, cosmosapi.Config{MasterKey: "YWJjZA=="}, nil, nil)
+
+	usage := fixedUsage{
+		"db/":     {Attempts: 100, RequestCharge: 6000, Duration: time.Second},
+		"db/coll": {Attempts: 100, Throttled: 10, RequestCharge: 1000, Duration: time.Second},
+	}
+	reactive := &Reactive{TargetUtilization: 0.5, MaxThrottleRate: 0.05, ScaleUpFactor: 1.5}
+	scaler, err := New(client, Options{
+		Targets: []Target{
+			{DatabaseID: "db", Min: 1000, Max: 20000, Reactive: reactive},
+			{DatabaseID: "db", CollectionID: "coll", Min: 400, Max: 10000, Reactive: reactive},
+		},
+		Usage:  usage,
+		DryRun: true,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	decisions, err := scaler.Scale(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, []Decision{
+		{Time: now, Target: "db", Autoscale: true, Current: 4000, Desired: 12000, Reason: "6000 RU/s consumed", Action: ActionDryRun},
+		{Time: now, Target: "db/coll", Current: 1000, Desired: 2000, Reason: "1000 RU/s consumed", Action: ActionDryRun},
+	}, decisions)
+	assert.Empty(t, s.replaced)
+
+	usage["db/coll"] = Usage{Attempts: 100, Throttled: 10, RequestCharge: 100, Duration: time.Second}
+	decisions, err = scaler.Scale(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 1500, decisions[1].Desired)
+	assert.Equal(t, "10.0% of requests throttled", decisions[1].Reason)
+
+	usage["db/"] = Usage{Duration: time.Second}
+	decisions, err = scaler.Scale(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 1000, decisions[0].Desired)
+	assert.Equal(t, "min", decisions[0].Reason)
+
+	// The max of autoscale is rounded down to a multiple of 1000
+	scaler, err = New(client, Options{Targets: []Target{{DatabaseID: "db", Max: 4500, Reactive: reactive}}, Usage: usage, DryRun: true})
+	require.NoError(t, err)
+	usage["db/"] = Usage{Attempts: 100, RequestCharge: 6000, Duration: time.Second}
+	decisions, err = scaler.Scale(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 4000, decisions[0].Desired)
+	assert.Equal(t, ActionUnchanged, decisions[0].Action)
+
+	_, err = New(client, Options{Targets: []Target{{DatabaseID: "db", Reactive: reactive}}})
+	assert.EqualError(t, err, "Scaling 'db' by usage needs the Usage option")
+	_, err = New(client, Options{Targets: []Target{{DatabaseID: "db", Schedule: []ScheduleRule{{Cron: "0 7 * *"}}}}})
+	assert.EqualError(t, err, "Invalid schedule of 'db': Invalid cron '0 7 * *': expected 5 fields, got 4")
+}
@@ -0,0 +1,157 @@
+package cosmosscaler
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ScheduleRule sets the throughput of a target at the times matching Cron,
+// until another rule of the target matches. Cron has the five fields of a
+// crontab: minute, hour, day of month, month and day of week (0 or 7 is
+// Sunday), each being *, a number, a range like 1-5, a step like */15 or 8-18/2,
+// or a comma-separated list of these. Like in cron, a time matches if the
+// day of month or the day of week matches when both are restricted.
+type ScheduleRule struct {
+	Cron       string `json:"cron"`
+	Throughput int    `json:"throughput"`
+}
+
+// scheduleLookback is how far back the last matching time of a schedule is
+// looked for, which covers schedules that repeat weekly
+const scheduleLookback = 8 * 24 * time.Hour
+
+// cronSpec is a parsed Cron, with a bit set for every matching value of each field
+type cronSpec struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// Whether the day fields are *, as the days match if either of them
+	// matches when both are restricted
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// cronFields are the names and ranges of the fields of a Cron
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+func parseCron(cron string) (cronSpec, error) {
+	fields := strings.Fields(cron)
+	if len(fields) != len(cronFields) {
+		return cronSpec{}, errors.Errorf("Invalid cron '%s': expected %d fields, got %d", cron, len(cronFields), len(fields))
+	}
+	var bits [5]uint64
+	for i, field := range fields {
+		var err error
+		if bits[i], err = parseCronField(field, cronFields[i].min, cronFields[i].max); err != nil {
+			return cronSpec{}, errors.Wrapf(err, "Invalid %s in cron '%s'", cronFields[i].name, cron)
+		}
+	}
+	// Sunday is both 0 and 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return cronSpec{
+		minute: bits[0], hour: bits[1], dayOfMonth: bits[2], month: bits[3], dayOfWeek: bits[4],
+		anyDayOfMonth: fields[2] == "*", anyDayOfWeek: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField returns the values of a field as bits
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		valueRange, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			valueRange = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, errors.Errorf("invalid step in '%s'", part)
+			}
+		}
+		first, last := min, max
+		if valueRange != "*" {
+			bounds := strings.SplitN(valueRange, "-", 2)
+			var err error
+			if first, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.Errorf("invalid value in '%s'", part)
+			}
+			last = first
+			if len(bounds) == 2 {
+				if last, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.Errorf("invalid value in '%s'", part)
+				}
+			} else if step > 1 {
+				// Like 5/15, from 5 to the end
+				last = max
+			}
+		}
+		if first < min || last > max || first > last {
+			return 0, errors.Errorf("'%s' is not within %d-%d", part, min, max)
+		}
+		for value := first; value <= last; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+// matches returns whether the minute of t matches the spec
+func (c cronSpec) matches(t time.Time) bool {
+	has := func(bits uint64, value int) bool {
+		return bits&(1<<uint(value)) != 0
+	}
+	if !has(c.minute, t.Minute()) || !has(c.hour, t.Hour()) || !has(c.month, int(t.Month())) {
+		return false
+	}
+	dayOfMonth, dayOfWeek := has(c.dayOfMonth, t.Day()), has(c.dayOfWeek, int(t.Weekday()))
+	if c.anyDayOfMonth || c.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// schedule is the parsed rules of a target
+type schedule struct {
+	rules []ScheduleRule
+	specs []cronSpec
+}
+
+func parseSchedule(rules []ScheduleRule) (schedule, error) {
+	s := schedule{rules: rules}
+	for _, rule := range rules {
+		spec, err := parseCron(rule.Cron)
+		if err != nil {
+			return s, err
+		}
+		s.specs = append(s.specs, spec)
+	}
+	return s, nil
+}
+
+// ruleAt returns the rule that matched last at or before t, or false if none
+// did within the lookback. Of rules matching the same minute, the last one
+// wins.
+func (s schedule) ruleAt(t time.Time) (ScheduleRule, bool) {
+	if len(s.rules) == 0 {
+		return ScheduleRule{}, false
+	}
+	t = t.Truncate(time.Minute)
+	for since := time.Duration(0); since <= scheduleLookback; since += time.Minute {
+		minute := t.Add(-since)
+		for i := len(s.specs) - 1; i >= 0; i-- {
+			if s.specs[i].matches(minute) {
+				return s.rules[i], true
+			}
+		}
+	}
+	return ScheduleRule{}, false
+}
@@ -0,0 +1,60 @@
+package cosmosscaler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	spec, err := parseCron("*/15 8-18/2 * * 1-5")
+	require.NoError(t, err)
+	monday := time.Date(2024, 1, 1, 8, 30, 0, 0, time.UTC)
+	assert.True(t, spec.matches(monday))
+	assert.False(t, spec.matches(monday.Add(time.Minute)), "minute")
+	assert.False(t, spec.matches(monday.Add(time.Hour)), "hour 9")
+	assert.True(t, spec.matches(monday.Add(10*time.Hour)), "hour 18")
+	assert.False(t, spec.matches(monday.Add(5*24*time.Hour)), "saturday")
+
+	spec, err = parseCron("0 0 1,15 * 7")
+	require.NoError(t, err)
+	assert.True(t, spec.matches(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)), "day of month")
+	assert.True(t, spec.matches(time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)), "sunday, as 7")
+	assert.False(t, spec.matches(time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)))
+
+	for cron, message := range map[string]string{
+		"* * * *":     "Invalid cron '* * * *': expected 5 fields, got 4",
+		"60 * * * *":  "Invalid minute in cron '60 * * * *': '60' is not within 0-59",
+		"* 5-2 * * *": "Invalid hour in cron '* 5-2 * * *': '5-2' is not within 0-23",
+		"* * */0 * *": "Invalid day of month in cron '* * */0 * *': invalid step in '*/0'",
+		"* * * jan *": "Invalid month in cron '* * * jan *': invalid value in 'jan'",
+	} {
+		_, err := parseCron(cron)
+		assert.EqualError(t, err, message)
+	}
+}
+
+func TestScheduleRuleAt(t *testing.T) {
+	s, err := parseSchedule([]ScheduleRule{
+		{Cron: "0 7 * * 1-5", Throughput: 4000},
+		{Cron: "0 20 * * *", Throughput: 1000},
+	})
+	require.NoError(t, err)
+	friday := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	at := func(t time.Time) int {
+		rule, _ := s.ruleAt(t)
+		return rule.Throughput
+	}
+	assert.Equal(t, 1000, at(friday.Add(6*time.Hour+59*time.Minute)))
+	assert.Equal(t, 4000, at(friday.Add(7*time.Hour)))
+	assert.Equal(t, 4000, at(friday.Add(19*time.Hour+59*time.Minute+59*time.Second)))
+	assert.Equal(t, 1000, at(friday.Add(20*time.Hour)))
+	assert.Equal(t, 1000, at(friday.Add(2*24*time.Hour+12*time.Hour)), "sunday")
+
+	s, err = parseSchedule([]ScheduleRule{{Cron: "0 0 29 2 *", Throughput: 400}})
+	require.NoError(t, err)
+	_, ok := s.ruleAt(friday)
+	assert.False(t, ok, "not within the lookback")
+}
@@ -0,0 +1,131 @@
+package cosmosscaler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vippsas/go-cosmosdb/metrics"
+)
+
+// Usage is what was spent on a collection or database during a window of time
+type Usage struct {
+	// Attempts is the number of requests sent, of which Throttled were
+	// rejected because the provisioned throughput was exceeded
+	Attempts      int
+	Throttled     int
+	RequestCharge float64
+	Duration      time.Duration
+}
+
+// ThrottleRate returns the fraction of the attempts that were throttled
+func (u Usage) ThrottleRate() float64 {
+	if u.Attempts == 0 {
+		return 0
+	}
+	return float64(u.Throttled) / float64(u.Attempts)
+}
+
+// RequestUnitsPerSecond returns the average request units consumed per second
+func (u Usage) RequestUnitsPerSecond() float64 {
+	if u.Duration <= 0 {
+		return 0
+	}
+	return u.RequestCharge / u.Duration.Seconds()
+}
+
+// UsageSource gives the usage of collections to scale them by
+type UsageSource interface {
+	// Take returns the usage of a collection, or of all the collections of
+	// the database if collection is empty, since it was last taken
+	Take(database, collection string) Usage
+}
+
+// Recorder is a metrics.Metrics recording the usage of collections, for a
+// Scaler running in the same process as the clients. Set it as the Metrics of
+// the cosmosapi.Config of the clients.
+type Recorder struct {
+	// Next, if set, gets all the observations of the recorder
+	Next metrics.Metrics
+
+	mu    sync.Mutex
+	now   func() time.Time
+	start time.Time
+	// usage is the usage of every collection since the recorder was created,
+	// and taken the total usage of every key when it was last taken
+	usage map[collectionKey]*Usage
+	taken map[collectionKey]takenUsage
+}
+
+type takenUsage struct {
+	at    time.Time
+	total Usage
+}
+
+type collectionKey struct {
+	database, collection string
+}
+
+// NewRecorder returns a recorder passing observations on to next, which may be nil
+func NewRecorder(next metrics.Metrics) *Recorder {
+	return newRecorder(next, time.Now)
+}
+
+func newRecorder(next metrics.Metrics, now func() time.Time) *Recorder {
+	return &Recorder{Next: next, now: now, start: now(), usage: map[collectionKey]*Usage{}, taken: map[collectionKey]takenUsage{}}
+}
+
+func (r *Recorder) ObserveAttempt(a metrics.Attempt) {
+	metrics.OrNoop(r.Next).ObserveAttempt(a)
+	if a.Database == "" || a.Collection == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := collectionKey{a.Database, a.Collection}
+	usage := r.usage[key]
+	if usage == nil {
+		usage = &Usage{}
+		r.usage[key] = usage
+	}
+	usage.Attempts++
+	if a.Throttled() {
+		usage.Throttled++
+	}
+	usage.RequestCharge += a.RequestCharge
+}
+
+func (r *Recorder) ObserveOperation(o metrics.Operation) {
+	metrics.OrNoop(r.Next).ObserveOperation(o)
+}
+
+// Take returns the usage of a collection, or of all the collections of the
+// database if collection is empty, since it was last taken or the recorder
+// was created. Taking the usage of a database does not change the usage taken
+// of its collections, and the other way around.
+func (r *Recorder) Take(database, collection string) Usage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	var total Usage
+	for k, usage := range r.usage {
+		if k.database != database || (collection != "" && k.collection != collection) {
+			continue
+		}
+		total.Attempts += usage.Attempts
+		total.Throttled += usage.Throttled
+		total.RequestCharge += usage.RequestCharge
+	}
+	key := collectionKey{database, collection}
+	last, ok := r.taken[key]
+	if !ok {
+		last.at = r.start
+	}
+	r.taken[key] = takenUsage{at: now, total: total}
+
+	return Usage{
+		Attempts:      total.Attempts - last.total.Attempts,
+		Throttled:     total.Throttled - last.total.Throttled,
+		RequestCharge: total.RequestCharge - last.total.RequestCharge,
+		Duration:      now.Sub(last.at),
+	}
+}
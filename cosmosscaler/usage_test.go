@@ -0,0 +1,48 @@
+package cosmosscaler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vippsas/go-cosmosdb/metrics"
+)
+
+type countingMetrics struct {
+	attempts, operations int
+}
+
+func (m *countingMetrics) ObserveAttempt(a metrics.Attempt)     { m.attempts++ }
+func (m *countingMetrics) ObserveOperation(o metrics.Operation) { m.operations++ }
+
+func TestRecorder(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	next := &countingMetrics{}
+	r := newRecorder(next, func() time.Time { return now })
+	attempt := func(collection string, statusCode int, charge float64) {
+		r.ObserveAttempt(metrics.Attempt{Labels: metrics.Labels{Database: "db", Collection: collection}, StatusCode: statusCode, RequestCharge: charge})
+	}
+	attempt("a", 200, 10)
+	attempt("a", 429, 0)
+	attempt("b", 200, 50)
+	r.ObserveAttempt(metrics.Attempt{StatusCode: 200, RequestCharge: 1})
+	r.ObserveOperation(metrics.Operation{})
+	assert.Equal(t, &countingMetrics{attempts: 4, operations: 1}, next)
+
+	now = now.Add(10 * time.Second)
+	usage := r.Take("db", "a")
+	assert.Equal(t, Usage{Attempts: 2, Throttled: 1, RequestCharge: 10, Duration: 10 * time.Second}, usage)
+	assert.Equal(t, 0.5, usage.ThrottleRate())
+	assert.Equal(t, 1.0, usage.RequestUnitsPerSecond())
+
+	attempt("a", 200, 20)
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, Usage{Attempts: 4, Throttled: 1, RequestCharge: 80, Duration: 20 * time.Second}, r.Take("db", ""), "all collections")
+	assert.Equal(t, Usage{Attempts: 1, RequestCharge: 20, Duration: 10 * time.Second}, r.Take("db", "a"), "not taken with the database")
+
+	attempt("b", 200, 5)
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, Usage{Attempts: 1, RequestCharge: 5, Duration: 10 * time.Second}, r.Take("db", ""))
+	assert.Equal(t, Usage{Attempts: 2, RequestCharge: 55, Duration: 30 * time.Second}, r.Take("db", "b"))
+	assert.Equal(t, Usage{Duration: 10 * time.Second}, r.Take("db", "a"))
+}
@@ -0,0 +1,74 @@
+// The metrics package defines the sink used by cosmosapi to report request
+// charges, latencies and status codes of Cosmos DB requests. It has no
+// dependencies; see the metrics/prometheus module for a Prometheus exporter.
+package metrics
+
+import (
+	"time"
+)
+
+// Labels identifies what a request was for. Operation is the name of the
+// logical operation, e.g. "CreateDocument" or "QueryDocuments".
+type Labels struct {
+	Database   string
+	Collection string
+	Operation  string
+}
+
+// Attempt is a single HTTP round trip to Cosmos DB.
+type Attempt struct {
+	Labels
+	// Attempt starts at 1 and is incremented for every retry
+	Attempt int
+	// StatusCode is 0 if no response was received
+	StatusCode    int
+	RequestCharge float64
+	Latency       time.Duration
+}
+
+// Operation is a logical operation, including all its attempts and the
+// delays between them.
+type Operation struct {
+	Labels
+	Attempts int
+	// StatusCode of the last attempt, or 0 if no response was received
+	StatusCode    int
+	RequestCharge float64
+	Latency       time.Duration
+}
+
+// Throttled returns true if the attempt was rejected because the provisioned
+// throughput was exceeded.
+func (a Attempt) Throttled() bool {
+	return a.StatusCode == 429
+}
+
+// Retries returns the number of attempts after the first one.
+func (o Operation) Retries() int {
+	if o.Attempts == 0 {
+		return 0
+	}
+	return o.Attempts - 1
+}
+
+// Metrics receives observations from the client. Implementations must be
+// safe for concurrent use.
+type Metrics interface {
+	ObserveAttempt(a Attempt)
+	ObserveOperation(o Operation)
+}
+
+// Noop is a Metrics that discards all observations. It is used when no
+// metrics are configured.
+type Noop struct{}
+
+func (Noop) ObserveAttempt(a Attempt)     {}
+func (Noop) ObserveOperation(o Operation) {}
+
+// OrNoop returns m, or Noop if m is nil.
+func OrNoop(m Metrics) Metrics {
+	if m == nil {
+		return Noop{}
+	}
+	return m
+}
@@ -0,0 +1,14 @@
+module github.com/vippsas/go-cosmosdb/metrics/prometheus
+
+go 1.13
+
+require (
+	github.com/prometheus/client_golang v1.7.0
+	github.com/stretchr/testify v1.4.0
+	// The commit that added the metrics package
+	github.com/vippsas/go-cosmosdb v0.0.0-20261019060333-99d5616c4fda
+)
+
+// Builds against the checkout when developing in this repository. Ignored by
+// modules depending on this one, which get the version required above.
+replace github.com/vippsas/go-cosmosdb => ../..
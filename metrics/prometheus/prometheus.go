@@ -0,0 +1,151 @@
+// The prometheus package exports the metrics observed by cosmosapi.Client to
+// Prometheus. It is a separate module so that the core packages do not
+// depend on the Prometheus client library.
+//
+//	m := prometheus.New("myservice")
+//	prom.MustRegister(m)
+//	client := cosmosapi.New(url, cosmosapi.Config{MasterKey: key, Metrics: m}, nil, nil)
+package prometheus
+
+import (
+	"strconv"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/vippsas/go-cosmosdb/metrics"
+)
+
+var (
+	labelNames       = []string{"database", "collection", "operation"}
+	statusLabelNames = append(labelNames, "status_code")
+
+	// DefaultLatencyBuckets are the buckets of the latency histograms, in seconds
+	DefaultLatencyBuckets = []float64{.002, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// DefaultRequestChargeBuckets are the buckets of the request charge histogram, in RUs
+	DefaultRequestChargeBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000}
+)
+
+// Metrics implements metrics.Metrics and prometheus.Collector.
+type Metrics struct {
+	requestCharge     *prom.CounterVec
+	operationCharge   *prom.HistogramVec
+	operationDuration *prom.HistogramVec
+	attemptDuration   *prom.HistogramVec
+	responses         *prom.CounterVec
+	throttledAttempts *prom.CounterVec
+	retries           *prom.CounterVec
+	operations        *prom.CounterVec
+}
+
+var _ metrics.Metrics = &Metrics{}
+var _ prom.Collector = &Metrics{}
+
+// New creates the metrics with the given namespace. The result must be
+// registered with a prometheus.Registerer to be exported.
+func New(namespace string) *Metrics {
+	return &Metrics{
+		requestCharge: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cosmosdb",
+			Name:      "request_charge_total",
+			Help:      "Request units consumed, including retries.",
+		}, labelNames),
+		operationCharge: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "cosmosdb",
+			Name:      "operation_request_charge",
+			Help:      "Request units consumed per operation, including retries.",
+			Buckets:   DefaultRequestChargeBuckets,
+		}, labelNames),
+		operationDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "cosmosdb",
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of operations, including retries and backoff.",
+			Buckets:   DefaultLatencyBuckets,
+		}, statusLabelNames),
+		attemptDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "cosmosdb",
+			Name:      "attempt_duration_seconds",
+			Help:      "Duration of single HTTP round trips.",
+			Buckets:   DefaultLatencyBuckets,
+		}, labelNames),
+		responses: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cosmosdb",
+			Name:      "responses_total",
+			Help:      "Responses received, by status code. Status code 0 means no response was received.",
+		}, statusLabelNames),
+		throttledAttempts: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cosmosdb",
+			Name:      "throttled_total",
+			Help:      "Attempts rejected with 429 Too Many Requests.",
+		}, labelNames),
+		retries: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cosmosdb",
+			Name:      "retries_total",
+			Help:      "Attempts that were retries of a previous attempt.",
+		}, labelNames),
+		operations: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cosmosdb",
+			Name:      "operations_total",
+			Help:      "Operations completed, by status code of the last attempt.",
+		}, statusLabelNames),
+	}
+}
+
+func (m *Metrics) collectors() []prom.Collector {
+	return []prom.Collector{
+		m.requestCharge,
+		m.operationCharge,
+		m.operationDuration,
+		m.attemptDuration,
+		m.responses,
+		m.throttledAttempts,
+		m.retries,
+		m.operations,
+	}
+}
+
+func (m *Metrics) Describe(ch chan<- *prom.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+func (m *Metrics) Collect(ch chan<- prom.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (m *Metrics) ObserveAttempt(a metrics.Attempt) {
+	labels := labelValues(a.Labels)
+	m.requestCharge.WithLabelValues(labels...).Add(a.RequestCharge)
+	m.attemptDuration.WithLabelValues(labels...).Observe(a.Latency.Seconds())
+	m.responses.WithLabelValues(append(labels, statusCode(a.StatusCode))...).Inc()
+	if a.Throttled() {
+		m.throttledAttempts.WithLabelValues(labels...).Inc()
+	}
+	if a.Attempt > 1 {
+		m.retries.WithLabelValues(labels...).Inc()
+	}
+}
+
+func (m *Metrics) ObserveOperation(o metrics.Operation) {
+	labels := labelValues(o.Labels)
+	m.operationCharge.WithLabelValues(labels...).Observe(o.RequestCharge)
+	m.operationDuration.WithLabelValues(append(labels, statusCode(o.StatusCode))...).Observe(o.Latency.Seconds())
+	m.operations.WithLabelValues(append(labels, statusCode(o.StatusCode))...).Inc()
+}
+
+func labelValues(l metrics.Labels) []string {
+	return []string{l.Database, l.Collection, l.Operation}
+}
+
+func statusCode(code int) string {
+	return strconv.Itoa(code)
+}
@@ -0,0 +1,49 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/go-cosmosdb/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New("test")
+	registry := prom.NewPedanticRegistry()
+	require.NoError(t, registry.Register(m))
+
+	labels := metrics.Labels{Database: "db", Collection: "coll", Operation: "CreateDocument"}
+	m.ObserveAttempt(metrics.Attempt{Labels: labels, Attempt: 1, StatusCode: 429, RequestCharge: 1, Latency: time.Millisecond})
+	m.ObserveAttempt(metrics.Attempt{Labels: labels, Attempt: 2, StatusCode: 201, RequestCharge: 5.5, Latency: time.Millisecond})
+	m.ObserveOperation(metrics.Operation{Labels: labels, Attempts: 2, StatusCode: 201, RequestCharge: 6.5, Latency: time.Second})
+
+	expected := `
+# HELP test_cosmosdb_request_charge_total Request units consumed, including retries.
+# TYPE test_cosmosdb_request_charge_total counter
+test_cosmosdb_request_charge_total{collection="coll",database="db",operation="CreateDocument"} 6.5
+# HELP test_cosmosdb_responses_total Responses received, by status code. Status code 0 means no response was received.
+# TYPE test_cosmosdb_responses_total counter
+test_cosmosdb_responses_total{collection="coll",database="db",operation="CreateDocument",status_code="201"} 1
+test_cosmosdb_responses_total{collection="coll",database="db",operation="CreateDocument",status_code="429"} 1
+# HELP test_cosmosdb_retries_total Attempts that were retries of a previous attempt.
+# TYPE test_cosmosdb_retries_total counter
+test_cosmosdb_retries_total{collection="coll",database="db",operation="CreateDocument"} 1
+# HELP test_cosmosdb_throttled_total Attempts rejected with 429 Too Many Requests.
+# TYPE test_cosmosdb_throttled_total counter
+test_cosmosdb_throttled_total{collection="coll",database="db",operation="CreateDocument"} 1
+# HELP test_cosmosdb_operations_total Operations completed, by status code of the last attempt.
+# TYPE test_cosmosdb_operations_total counter
+test_cosmosdb_operations_total{collection="coll",database="db",operation="CreateDocument",status_code="201"} 1
+`
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"test_cosmosdb_request_charge_total",
+		"test_cosmosdb_responses_total",
+		"test_cosmosdb_retries_total",
+		"test_cosmosdb_throttled_total",
+		"test_cosmosdb_operations_total",
+	))
+}
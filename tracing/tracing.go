@@ -0,0 +1,78 @@
+// The tracing package defines the interface used by cosmosapi and cosmos to
+// report spans for Cosmos DB operations. It has no dependencies so that the
+// core packages stay dependency-light; to export the spans, implement Tracer
+// on top of the tracing library of your choice, e.g. OpenTelemetry:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, tracing.Span) {
+//	  ctx, span := t.Tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+//	  return ctx, otelSpan{span}
+//	}
+package tracing
+
+import (
+	"context"
+)
+
+// Attribute keys set on the spans. They follow the OpenTelemetry semantic
+// conventions for database clients where such exist.
+const (
+	DBSystem        = "db.system"
+	DBName          = "db.name"
+	DBCollection    = "db.cosmosdb.container"
+	DBOperation     = "db.operation"
+	StatusCode      = "db.cosmosdb.status_code"
+	SubStatusCode   = "db.cosmosdb.sub_status_code"
+	RequestCharge   = "db.cosmosdb.request_charge"
+	ActivityId      = "db.cosmosdb.activity_id"
+	Attempt         = "db.cosmosdb.attempt"
+	RetryDelay      = "db.cosmosdb.retry_delay_ms"
+	ConflictRetries = "db.cosmosdb.conflict_retries"
+)
+
+// DBSystemCosmosDB is the value of the DBSystem attribute
+const DBSystemCosmosDB = "cosmosdb"
+
+// Tracer starts spans. The returned context carries the span, so that spans
+// started from it become children of it.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single timed operation in a trace.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	// RecordError marks the span as failed
+	RecordError(err error)
+	End()
+}
+
+// Noop is a Tracer that does nothing. It is used when no tracer is configured.
+type Noop struct{}
+
+func (Noop) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) RecordError(err error)                      {}
+func (noopSpan) End()                                       {}
+
+// OrNoop returns t, or Noop if t is nil.
+func OrNoop(t Tracer) Tracer {
+	if t == nil {
+		return Noop{}
+	}
+	return t
+}
+
+// End records err on the span if it is non-nil and ends the span.
+func End(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}